@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// access log formats supported by APIGateway.AccessLogFormat
+const (
+	// AccessLogCommon NCSA Common Log Format
+	AccessLogCommon = "common"
+	// AccessLogCombined NCSA Combined Log Format, followed by the resolved "service/api"
+	AccessLogCombined = "combined"
+	// AccessLogJSON one JSON object per line with the resolved route, backend and duration
+	AccessLogJSON = "json"
+)
+
+// accessEntryKey is the context key of *accessEntry
+type accessEntryKey struct{}
+
+// accessEntry collect route information of one proxied request
+type accessEntry struct {
+	service string
+	api     string
+	backend string // host the request was proxied to, empty if not proxied
+}
+
+// jsonAccessLog is the line written by AccessLogJSON
+type jsonAccessLog struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"requestId,omitempty"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Service    string  `json:"service,omitempty"`
+	API        string  `json:"api,omitempty"`
+	Backend    string  `json:"backend,omitempty"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// responseRecorder wrap http.ResponseWriter to capture status and size
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+// WriteHeader record the status code
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Write record the response size
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += int64(n)
+	return n, err
+}
+
+// Flush keep streaming responses working through the recorder
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack let the proxy tunnel upgraded connections such as websocket through the recorder
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer: %T can not be hijacked", rec.ResponseWriter)
+	}
+	conn, rw, err := hijacker.Hijack()
+	// the proxy write 101 Switching Protocols on the connection itself
+	if err == nil && rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// writeAccessLog emit one access log line in the configured format
+func (gateway *APIGateway) writeAccessLog(r *http.Request, rec *responseRecorder, entry *accessEntry, start time.Time) {
+	var line string
+	switch gateway.AccessLogFormat {
+	case AccessLogCommon:
+		line = commonLogLine(r, rec, start)
+	case AccessLogCombined:
+		line = fmt.Sprintf("%v %q %q %q", commonLogLine(r, rec, start),
+			orDash(r.Referer()), orDash(r.UserAgent()), entry.service+"/"+entry.api)
+	case AccessLogJSON:
+		line = jsonLogLine(r, rec, entry, start)
+	default:
+		return
+	}
+	out := gateway.AccessLog
+	if out == nil {
+		out = os.Stdout
+	}
+	gateway.logMu.Lock()
+	defer gateway.logMu.Unlock()
+	fmt.Fprintln(out, line)
+}
+
+// commonLogLine format request as: host ident authuser [date] "request" status bytes
+func commonLogLine(r *http.Request, rec *responseRecorder, start time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	size := "-"
+	if rec.size > 0 {
+		size = strconv.FormatInt(rec.size, 10)
+	}
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return fmt.Sprintf("%v - %v [%v] \"%v %v %v\" %d %v", host, user,
+		start.Format("02/Jan/2006:15:04:05 -0700"), r.Method, r.RequestURI, r.Proto, status, size)
+}
+
+// jsonLogLine format request as a jsonAccessLog object
+func jsonLogLine(r *http.Request, rec *responseRecorder, entry *accessEntry, start time.Time) string {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	data, _ := json.Marshal(jsonAccessLog{
+		Time:       start.Format(time.RFC3339Nano),
+		RequestID:  requestID(r.Context()),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Service:    entry.service,
+		API:        entry.api,
+		Backend:    entry.backend,
+		Status:     status,
+		Bytes:      rec.size,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	})
+	return string(data)
+}
+
+// orDash return "-" for empty log fields
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogLine(t *testing.T) {
+	start := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+	tests := []struct {
+		name   string
+		format string
+		user   string
+		status int
+		body   string
+		want   string
+	}{
+		{
+			name:   "common",
+			format: AccessLogCommon,
+			user:   "frank",
+			status: http.StatusOK,
+			body:   "hello",
+			want:   `192.0.2.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /svc/api?x=1 HTTP/1.1" 200 5` + "\n",
+		},
+		{
+			name:   "common empty body",
+			format: AccessLogCommon,
+			status: http.StatusNoContent,
+			want:   `192.0.2.1 - - [10/Oct/2000:13:55:36 -0700] "GET /svc/api?x=1 HTTP/1.1" 204 -` + "\n",
+		},
+		{
+			name:   "combined",
+			format: AccessLogCombined,
+			status: http.StatusNotFound,
+			body:   "missing",
+			want: `192.0.2.1 - - [10/Oct/2000:13:55:36 -0700] "GET /svc/api?x=1 HTTP/1.1" 404 7 ` +
+				`"http://example.com/" "curl/7.64.1" "svc/api"` + "\n",
+		},
+		{
+			name:   "disabled",
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			gateway := newTestGateway(t)
+			gateway.AccessLogFormat = tt.format
+			gateway.AccessLog = &out
+			r := httptest.NewRequest(http.MethodGet, "/svc/api?x=1", nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, "secret")
+			}
+			if tt.format == AccessLogCombined {
+				r.Header.Set("Referer", "http://example.com/")
+				r.Header.Set("User-Agent", "curl/7.64.1")
+			}
+			rec := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+			rec.WriteHeader(tt.status)
+			rec.Write([]byte(tt.body))
+			gateway.writeAccessLog(r, rec, &accessEntry{service: "svc", api: "api"}, start)
+			if got := out.String(); got != tt.want {
+				t.Errorf("line\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONAccessLog(t *testing.T) {
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("hello"))
+	})
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		expected jsonAccessLog
+		minMs    float64
+	}{
+		{
+			name:     "proxied",
+			method:   http.MethodGet,
+			target:   "/svc/get",
+			expected: jsonAccessLog{Method: http.MethodGet, Path: "/svc/get", Service: "svc", API: "get", Backend: backend, Status: http.StatusOK, Bytes: 5},
+			minMs:    20,
+		},
+		{
+			name:     "backend error",
+			method:   http.MethodGet,
+			target:   "/svc/get?fail=1",
+			expected: jsonAccessLog{Method: http.MethodGet, Path: "/svc/get", Service: "svc", API: "get", Backend: backend, Status: http.StatusBadGateway},
+		},
+		{
+			name:     "unknown api",
+			method:   http.MethodGet,
+			target:   "/svc/missing",
+			expected: jsonAccessLog{Method: http.MethodGet, Path: "/svc/missing", Status: http.StatusNotFound},
+		},
+		{
+			name:     "method mismatch",
+			method:   http.MethodPost,
+			target:   "/svc/get",
+			expected: jsonAccessLog{Method: http.MethodPost, Path: "/svc/get", Service: "svc", API: "get", Status: http.StatusMethodNotAllowed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			gateway := newTestGateway(t)
+			gateway.AccessLogFormat = AccessLogJSON
+			gateway.AccessLog = &out
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+			serveProxy(gateway, httptest.NewRequest(tt.method, tt.target, nil))
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("got %d lines, want 1: %q", len(lines), out.String())
+			}
+			var line jsonAccessLog
+			if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+				t.Fatalf("line %q: %v", lines[0], err)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, line.Time); err != nil {
+				t.Errorf("time %q: %v", line.Time, err)
+			}
+			if line.DurationMs < tt.minMs {
+				t.Errorf("duration %vms, want at least %vms", line.DurationMs, tt.minMs)
+			}
+			if tt.expected.Bytes == 0 {
+				line.Bytes = 0 // error bodies are written by the gateway
+			}
+			line.Time, line.RequestID, line.DurationMs = "", "", 0
+			if line != tt.expected {
+				t.Errorf("line %+v, want %+v", line, tt.expected)
+			}
+		})
+	}
+}
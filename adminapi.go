@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// AdminAPIVersion is the version of read-only admin api served under /admin/api/{version}/
+const AdminAPIVersion = "v1"
+
+// adminEnvelope is the response body of every admin api endpoint
+type adminEnvelope struct {
+	APIVersion  string      `json:"apiVersion"`
+	Kind        string      `json:"kind"`
+	GeneratedAt time.Time   `json:"generatedAt"`
+	Data        interface{} `json:"data"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// AdminService is a registered service in admin api
+type AdminService struct {
+	Name    string   `json:"name"`
+	APIs    []string `json:"apis"`
+	Aliases []string `json:"aliases"`
+}
+
+// AdminAPI is a registered api in admin api, Service is the resolved service name
+// even if the api was registered through an alias
+type AdminAPI struct {
+	Service string `json:"service"`
+	*API
+}
+
+// adminAPI return the handler of read-only admin json api for dashboards
+func (gateway *APIGateway) adminAPI() http.Handler {
+	prefix := "/admin/api/" + AdminAPIVersion
+	endpoints := map[string]func() (string, interface{}, error){
+		"/services": gateway.adminServices,
+		"/apis":     gateway.adminAPIs,
+		"/health": func() (string, interface{}, error) {
+			return "BackendHealthList", gateway.health.snapshot(), nil
+		},
+		"/metrics": func() (string, interface{}, error) {
+			return "Metrics", gateway.stats(), nil
+		},
+		"/errors": func() (string, interface{}, error) {
+			return "ErrorList", gateway.errors.recent(), nil
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		envelope := adminEnvelope{APIVersion: AdminAPIVersion, GeneratedAt: time.Now()}
+		endpoint, exist := endpoints[r.URL.Path[len(prefix):]]
+		if !exist {
+			envelope.Kind, envelope.Error = "Error", "endpoint not found"
+			writeJSON(w, http.StatusNotFound, envelope)
+			return
+		}
+		if r.Method != http.MethodGet {
+			envelope.Kind, envelope.Error = "Error", "method not allowed"
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, envelope)
+			return
+		}
+		kind, data, err := endpoint()
+		if err != nil {
+			envelope.Kind, envelope.Error = "Error", err.Error()
+			writeJSON(w, http.StatusNotImplemented, envelope)
+			return
+		}
+		envelope.Kind, envelope.Data = kind, data
+		writeJSON(w, http.StatusOK, envelope)
+	})
+}
+
+// adminSnapshot return services and aliases of discovery
+func (gateway *APIGateway) adminSnapshot() ([]*Service, map[string]string, error) {
+	lister, ok := gateway.Discovery.(routeSnapshot)
+	if !ok {
+		return nil, nil, errDiscoveryNotListable
+	}
+	services, aliases := lister.snapshot()
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, aliases, nil
+}
+
+// adminServices list services with their api names and aliases
+func (gateway *APIGateway) adminServices() (string, interface{}, error) {
+	services, aliases, err := gateway.adminSnapshot()
+	if err != nil {
+		return "", nil, err
+	}
+	list := make([]AdminService, 0, len(services))
+	for _, service := range services {
+		item := AdminService{Name: service.Name, APIs: []string{}, Aliases: []string{}}
+		for name := range service.APIs {
+			item.APIs = append(item.APIs, name)
+		}
+		for alias := range aliases {
+			if resolveAlias(aliases, alias) == service.Name {
+				item.Aliases = append(item.Aliases, alias)
+			}
+		}
+		sort.Strings(item.APIs)
+		sort.Strings(item.Aliases)
+		list = append(list, item)
+	}
+	return "ServiceList", list, nil
+}
+
+// adminAPIs list apis of all services
+func (gateway *APIGateway) adminAPIs() (string, interface{}, error) {
+	services, _, err := gateway.adminSnapshot()
+	if err != nil {
+		return "", nil, err
+	}
+	list := []AdminAPI{}
+	for _, service := range services {
+		for _, api := range service.APIs {
+			list = append(list, AdminAPI{Service: service.Name, API: api})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Service != list[j].Service {
+			return list[i].Service < list[j].Service
+		}
+		return list[i].Name < list[j].Name
+	})
+	return "APIList", list, nil
+}
+
+// resolveAlias follow the alias chain of name in aliases
+func resolveAlias(aliases map[string]string, name string) string {
+	for i := 0; i <= len(aliases); i++ {
+		target, exist := aliases[name]
+		if !exist {
+			break
+		}
+		name = target
+	}
+	return name
+}
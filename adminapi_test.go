@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newAdminTestGateway return a gateway with a service, an alias and a failed request
+func newAdminTestGateway(t *testing.T) (*APIGateway, string) {
+	t.Helper()
+	gateway := newTestGateway(t)
+	down := freeAddr(t)
+	mustCreateService(t, gateway, newTestService("user",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: down, Path: "get"},
+		&API{Name: "create", HTTPMethod: http.MethodPost, Host: down, Path: "create"}))
+	if err := gateway.Discovery.CreateAlias("account", "user"); err != nil {
+		t.Fatalf("create alias: %v", err)
+	}
+	if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/user/get", nil)); rec.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, want 502", rec.Code)
+	}
+	return gateway, down
+}
+
+func TestAdminAPIEndpoints(t *testing.T) {
+	gateway, down := newAdminTestGateway(t)
+	tests := []struct {
+		path  string
+		kind  string
+		check func(t *testing.T, data json.RawMessage)
+	}{
+		{
+			path: "/services",
+			kind: "ServiceList",
+			check: func(t *testing.T, data json.RawMessage) {
+				var services []AdminService
+				mustDecode(t, data, &services)
+				want := []AdminService{{Name: "user", APIs: []string{"create", "get"}, Aliases: []string{"account"}}}
+				if !reflect.DeepEqual(services, want) {
+					t.Errorf("services %+v, want %+v", services, want)
+				}
+			},
+		},
+		{
+			path: "/apis",
+			kind: "APIList",
+			check: func(t *testing.T, data json.RawMessage) {
+				var apis []struct {
+					Service string `json:"service"`
+					Name    string `json:"name"`
+					Host    string `json:"host"`
+				}
+				mustDecode(t, data, &apis)
+				if len(apis) != 2 || apis[0].Name != "create" || apis[1].Name != "get" ||
+					apis[0].Service != "user" || apis[1].Host != down {
+					t.Errorf("apis %+v", apis)
+				}
+			},
+		},
+		{
+			path: "/health",
+			kind: "BackendHealthList",
+			check: func(t *testing.T, data json.RawMessage) {
+				var backends []BackendHealth
+				mustDecode(t, data, &backends)
+				if len(backends) != 1 || backends[0].Host != down || backends[0].Failures != 1 || backends[0].LastError == "" {
+					t.Errorf("backends %+v", backends)
+				}
+			},
+		},
+		{
+			path: "/metrics",
+			kind: "Metrics",
+			check: func(t *testing.T, data json.RawMessage) {
+				var metrics map[string]json.RawMessage
+				mustDecode(t, data, &metrics)
+				keys := make([]string, 0, len(metrics))
+				for key := range metrics {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				if want := []string{"breakers", "concurrency", "retryBudget", "shedRate"}; !reflect.DeepEqual(keys, want) {
+					t.Errorf("metrics keys %v, want %v", keys, want)
+				}
+			},
+		},
+		{
+			path: "/errors",
+			kind: "ErrorList",
+			check: func(t *testing.T, data json.RawMessage) {
+				var records []ErrorRecord
+				mustDecode(t, data, &records)
+				if len(records) != 1 || records[0].Status != http.StatusBadGateway || records[0].Path != "/user/get" || records[0].RequestID == "" {
+					t.Errorf("errors %+v", records)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := serveAdmin(gateway, http.MethodGet, "/admin/api/v1"+tt.path, "")
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("status %d %v: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+			}
+			envelope := decodeEnvelope(t, rec.Body.Bytes(), "apiVersion", "data", "generatedAt", "kind")
+			if string(envelope["apiVersion"]) != `"v1"` || string(envelope["kind"]) != `"`+tt.kind+`"` {
+				t.Errorf("envelope version %s kind %s, want v1 %v", envelope["apiVersion"], envelope["kind"], tt.kind)
+			}
+			tt.check(t, envelope["data"])
+		})
+	}
+}
+
+func TestAdminAPIErrors(t *testing.T) {
+	gateway := newTestGateway(t)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{name: "unknown endpoint", method: http.MethodGet, path: "/admin/api/v1/nope", status: http.StatusNotFound},
+		{name: "write method", method: http.MethodPost, path: "/admin/api/v1/services", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAdmin(gateway, tt.method, tt.path, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			envelope := decodeEnvelope(t, rec.Body.Bytes(), "apiVersion", "data", "error", "generatedAt", "kind")
+			if string(envelope["kind"]) != `"Error"` || string(envelope["data"]) != "null" {
+				t.Errorf("error envelope %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+// decodeEnvelope decode an admin api response and check it has exactly keys
+func decodeEnvelope(t *testing.T, body []byte, keys ...string) map[string]json.RawMessage {
+	t.Helper()
+	var envelope map[string]json.RawMessage
+	mustDecode(t, body, &envelope)
+	got := make([]string, 0, len(envelope))
+	for key := range envelope {
+		got = append(got, key)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("envelope keys %v, want %v", got, keys)
+	}
+	return envelope
+}
+
+// mustDecode unmarshal data into v or fail the test
+func mustDecode(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+}
@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// normalizeAllowedPaths validate AllowedPaths of service, entries are backend path prefixes
+func normalizeAllowedPaths(service *Service) error {
+	for i, prefix := range service.AllowedPaths {
+		prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+		if prefix == "" {
+			return fmt.Errorf("service: %v allowed path can not be empty", service.Name)
+		}
+		service.AllowedPaths[i] = prefix
+	}
+	return nil
+}
+
+// pathAllowed report whether backend path p (without leading '/') is under one of the
+// allowed prefixes, an empty allowlist allows every path
+func pathAllowed(allowed []string, p string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	// resolve dot segments so that "allowed/../internal" can not escape the prefix
+	cleaned := strings.TrimPrefix(path.Clean("/"+p), "/")
+	for _, prefix := range allowed {
+		if cleaned == prefix || strings.HasPrefix(cleaned, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllowedPath return error when api targets a backend path outside the service allowlist
+func checkAllowedPath(service *Service, api *API) error {
+	if apiPath, _ := api.backendPath(); !pathAllowed(service.AllowedPaths, apiPath) {
+		return fmt.Errorf("service: %v api: %v path: %v not in allowed paths %v",
+			service.Name, api.Name, api.Path, service.AllowedPaths)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPathAllowed(t *testing.T) {
+	allowed := []string{"user", "order/v2"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "user", want: true},
+		{path: "user/get", want: true},
+		{path: "order/v2/list", want: true},
+		{path: "order/v1/list", want: false},
+		{path: "users", want: false},
+		{path: "internal/admin", want: false},
+		{path: "user/../internal", want: false},
+		{path: "user/./get", want: true},
+		{path: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := pathAllowed(allowed, tt.path); got != tt.want {
+			t.Errorf("pathAllowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	if !pathAllowed(nil, "anything") {
+		t.Errorf("empty allowlist should allow every path")
+	}
+}
+
+func TestAllowedPathsRegistration(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "allowed", path: "user/get"},
+		{name: "allowed with leading slash", path: "/user/get"},
+		{name: "allowed with query", path: "order/v2/list?all=1"},
+		{name: "outside allowlist", path: "internal/admin", wantErr: true},
+		{name: "dot segments escape", path: "user/../internal", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := func() *API {
+				return &API{Name: "target", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: tt.path}
+			}
+			gateway := newTestGateway(t)
+			service := newTestService("svc")
+			service.AllowedPaths = []string{"/user/", "order/v2"}
+			mustCreateService(t, gateway, service)
+			target := api()
+			target.Service = "svc"
+			err := gateway.Discovery.CreateAPI(target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("create api error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "not in allowed paths") {
+				t.Errorf("error %q does not name the allowlist", err)
+			}
+			other := newTestService("other", api())
+			other.AllowedPaths = []string{"user", "order/v2"}
+			if err := gateway.Discovery.CreateService(other); (err != nil) != tt.wantErr {
+				t.Errorf("create service error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllowedPathsResolution(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "inside", path: "/svc/user/42", status: http.StatusOK},
+		{name: "remainder escapes", path: "/svc/user/..%2F..%2Finternal", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			service := newTestService("svc",
+				&API{Name: "user", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "user", CatchRemainder: true})
+			service.AllowedPaths = []string{"user"}
+			mustCreateService(t, gateway, service)
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
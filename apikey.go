@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// AuthAPIKey is the Auth mode checking keys registered for the service of the api
+const AuthAPIKey = "apikey"
+
+// DefaultAPIKeyHeader carry the api key of clients
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// minAPIKeyLength reject keys short enough to be guessed
+const minAPIKeyLength = 16
+
+// APIKey is a key granting clients access to the apikey apis of a service
+type APIKey struct {
+	Service string `json:"service"`        // service name
+	Key     string `json:"key"`            // secret key, at least 16 characters
+	Name    string `json:"name,omitempty"` // who the key is issued to
+}
+
+// hashAPIKey return the digest api keys are stored as, keys never stay in memory in clear
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey register key for the apikey apis of service
+func (c *cache) CreateAPIKey(key *APIKey) error {
+	if key == nil || key.Service == "" {
+		return fmt.Errorf("api key service can not be empty")
+	}
+	if len(key.Key) < minAPIKeyLength {
+		return fmt.Errorf("api key should have at least %d characters", minAPIKeyLength)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := c.resolve(key.Service)
+	if _, exist := c.store[name]; !exist {
+		return fmt.Errorf("service: %v %w", key.Service, ErrNotExist)
+	}
+	digest := hashAPIKey(key.Key)
+	if _, exist := c.apiKeys[name][digest]; exist {
+		return fmt.Errorf("service: %v api key %w", key.Service, ErrAlreadyExist)
+	}
+	if c.apiKeys[name] == nil {
+		c.apiKeys[name] = make(map[string]string)
+	}
+	c.apiKeys[name][digest] = key.Name
+	return nil
+}
+
+// DeleteAPIKey revoke key of service, other keys keep working
+func (c *cache) DeleteAPIKey(serviceName, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := c.resolve(serviceName)
+	digest := hashAPIKey(key)
+	if _, exist := c.apiKeys[name][digest]; !exist {
+		return fmt.Errorf("service: %v api key %w", serviceName, ErrNotExist)
+	}
+	delete(c.apiKeys[name], digest)
+	return nil
+}
+
+// ValidAPIKey report whether key is registered for service
+func (c *cache) ValidAPIKey(serviceName, key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, exist := c.apiKeys[c.resolve(serviceName)][hashAPIKey(key)]
+	return exist
+}
+
+// checkAPIKey verify the api key header of request against keys of service, the header
+// is not forwarded to backend
+func (gateway *APIGateway) checkAPIKey(r *http.Request, service *Service, auth *Auth) error {
+	key := r.Header.Get(auth.Header)
+	r.Header.Del(auth.Header)
+	if key == "" {
+		return fmt.Errorf("%w: missing api key", errUnauthorized)
+	}
+	if !gateway.Discovery.ValidAPIKey(service.Name, key) {
+		return fmt.Errorf("%w: api key not registered", errUnauthorized)
+	}
+	return nil
+}
+
+// CreateAPIKey handle http request to register api key of service
+func (gateway *APIGateway) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	data, ok := readAdminBody(w, r)
+	if !ok {
+		return
+	}
+	var key APIKey
+	err := json.Unmarshal(data, &key)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+		return
+	}
+	err = gateway.Discovery.CreateAPIKey(&key)
+	if err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("create api key failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, adminResult{Result: "success"})
+}
+
+// DeleteAPIKey handle http request to revoke api key of service, the body is
+// {"service": ..., "key": ...}
+func (gateway *APIGateway) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodPost+", "+http.MethodDelete)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	data, ok := readAdminBody(w, r)
+	if !ok {
+		return
+	}
+	var key APIKey
+	err := json.Unmarshal(data, &key)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+		return
+	}
+	err = gateway.Discovery.DeleteAPIKey(key.Service, key.Key)
+	if err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("delete api key failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, adminResult{Result: "success"})
+}
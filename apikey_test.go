@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	aliceKey = "alice-0123456789abcdef"
+	bobKey   = "bob-0123456789abcdef"
+	orderKey = "order-0123456789abcdef"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string // auth header, empty use the default
+		key     string // sent by the client, empty send none
+		revoke  string // key revoked before the request
+		status  int
+		service string
+	}{
+		{name: "valid key", key: aliceKey, status: http.StatusOK},
+		{name: "other valid key", key: bobKey, status: http.StatusOK},
+		{name: "invalid key", key: "mallory-0123456789abcdef", status: http.StatusUnauthorized},
+		{name: "missing key", status: http.StatusUnauthorized},
+		{name: "key of another service", key: orderKey, status: http.StatusUnauthorized},
+		{name: "revoked key", key: aliceKey, revoke: aliceKey, status: http.StatusUnauthorized},
+		{name: "revoking another key", key: bobKey, revoke: aliceKey, status: http.StatusOK},
+		{name: "custom header", header: "X-Token", key: aliceKey, status: http.StatusOK},
+		{name: "through alias", key: aliceKey, service: "account", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.Header.Get(DefaultAPIKeyHeader)+r.Header.Get("X-Token"))
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway,
+				newTestService("users", &API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get",
+					Auth: &Auth{Mode: AuthAPIKey, Header: tt.header}}),
+				newTestService("orders", &API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+			if err := gateway.Discovery.CreateAlias("account", "users"); err != nil {
+				t.Fatalf("create alias: %v", err)
+			}
+			for _, body := range []string{
+				`{"service":"users","key":"` + aliceKey + `","name":"alice"}`,
+				`{"service":"users","key":"` + bobKey + `","name":"bob"}`,
+				`{"service":"orders","key":"` + orderKey + `"}`,
+			} {
+				if rec := serveAdmin(gateway, http.MethodPost, "/createAPIKey", body); rec.Code != http.StatusCreated {
+					t.Fatalf("create api key status %d: %s", rec.Code, rec.Body.String())
+				}
+			}
+			if tt.revoke != "" {
+				rec := serveAdmin(gateway, http.MethodDelete, "/deleteAPIKey", `{"service":"users","key":"`+tt.revoke+`"}`)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("delete api key status %d: %s", rec.Code, rec.Body.String())
+				}
+			}
+			service := tt.service
+			if service == "" {
+				service = "users"
+			}
+			req := httptest.NewRequest(http.MethodGet, "/"+service+"/get", nil)
+			if tt.key != "" {
+				header := tt.header
+				if header == "" {
+					header = DefaultAPIKeyHeader
+				}
+				req.Header.Set(header, tt.key)
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK && rec.Body.String() != "" {
+				t.Errorf("api key %q forwarded to backend", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIKeyAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{name: "create", method: http.MethodPost, target: "/createAPIKey", body: `{"service":"users","key":"` + bobKey + `"}`, status: http.StatusCreated},
+		{name: "create duplicate", method: http.MethodPost, target: "/createAPIKey", body: `{"service":"users","key":"` + aliceKey + `"}`, status: http.StatusConflict},
+		{name: "create short key", method: http.MethodPost, target: "/createAPIKey", body: `{"service":"users","key":"short"}`, status: http.StatusBadRequest},
+		{name: "create without service", method: http.MethodPost, target: "/createAPIKey", body: `{"key":"` + bobKey + `"}`, status: http.StatusBadRequest},
+		{name: "create for unknown service", method: http.MethodPost, target: "/createAPIKey", body: `{"service":"nope","key":"` + bobKey + `"}`, status: http.StatusNotFound},
+		{name: "create malformed", method: http.MethodPost, target: "/createAPIKey", body: `{"service":`, status: http.StatusBadRequest},
+		{name: "create wrong method", method: http.MethodGet, target: "/createAPIKey", status: http.StatusMethodNotAllowed},
+		{name: "delete", method: http.MethodPost, target: "/deleteAPIKey", body: `{"service":"users","key":"` + aliceKey + `"}`, status: http.StatusOK},
+		{name: "delete unknown key", method: http.MethodDelete, target: "/deleteAPIKey", body: `{"service":"users","key":"` + bobKey + `"}`, status: http.StatusNotFound},
+		{name: "delete wrong method", method: http.MethodGet, target: "/deleteAPIKey", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("users",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get", Auth: &Auth{Mode: AuthAPIKey}}))
+			if err := gateway.Discovery.CreateAPIKey(&APIKey{Service: "users", Key: aliceKey}); err != nil {
+				t.Fatalf("create api key: %v", err)
+			}
+			rec := serveAdmin(gateway, tt.method, tt.target, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			var body adminResult
+			mustDecode(t, rec.Body.Bytes(), &body)
+			if ok := rec.Code < http.StatusBadRequest; ok != (body.Result == "success") || ok == (body.Error != "") {
+				t.Errorf("body %q for status %d", rec.Body.String(), rec.Code)
+			}
+		})
+	}
+}
+
+func TestAPIKeyStage(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []string
+		status int // of a request with a valid key once one with an invalid key was answered
+	}{
+		{name: "default authenticate first", status: http.StatusOK},
+		{name: "rate limit before auth", stages: []string{StageRateLimit, StageAuth, StageConcurrency}, status: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			if tt.stages != nil {
+				if err := gateway.SetPipeline(tt.stages); err != nil {
+					t.Fatalf("set pipeline: %v", err)
+				}
+			}
+			mustCreateService(t, gateway, newTestService("users", &API{
+				Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "users"), Path: "get",
+				Auth: &Auth{Mode: AuthAPIKey}, RateLimit: 0.001, Burst: 1,
+			}))
+			if rec := serveAdmin(gateway, http.MethodPost, "/createAPIKey", `{"service":"users","key":"`+aliceKey+`"}`); rec.Code != http.StatusCreated {
+				t.Fatalf("create api key status %d: %s", rec.Code, rec.Body.String())
+			}
+			for _, key := range []string{"mallory-0123456789abcdef", aliceKey} {
+				req := httptest.NewRequest(http.MethodGet, "/users/get", nil)
+				req.Header.Set(DefaultAPIKeyHeader, key)
+				rec := serveProxy(gateway, req)
+				status := tt.status
+				if key != aliceKey {
+					status = http.StatusUnauthorized
+				}
+				if rec.Code != status {
+					t.Errorf("key %v status %d, want %d: %s", key, rec.Code, status, rec.Body.String())
+				}
+			}
+			if err := gateway.SetPipeline([]string{StageRateLimit, StageConcurrency}); err == nil {
+				t.Errorf("pipeline without auth accepted for an api key protected api")
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package gateway
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthJWT is the Auth mode verifying JWT bearer tokens
+const AuthJWT = "jwt"
+
+// DefaultClaimHeaders forward the subject of verified tokens to backends
+var DefaultClaimHeaders = map[string]string{"sub": "X-User-Id"}
+
+// Auth require clients of an api to authenticate, apis without Auth are public
+type Auth struct {
+	Mode string `json:"mode"` // jwt or apikey
+	// Header carry the key in apikey mode, default X-API-Key
+	Header    string `json:"header,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"` // HS256, HS384, HS512 or RS256 in jwt mode
+	// Secret is the HMAC key of HS algorithms
+	Secret string `json:"secret,omitempty"`
+	// PublicKeyFile is the PEM public key (or certificate) of RS256
+	PublicKeyFile string `json:"publicKeyFile,omitempty"`
+	Issuer        string `json:"issuer,omitempty"`   // required iss claim when set
+	Audience      string `json:"audience,omitempty"` // required aud claim when set
+	// RequiredClaims must be present in tokens with these string values
+	RequiredClaims map[string]string `json:"requiredClaims,omitempty"`
+	// ClaimHeaders forward verified claims to backend as headers, default sub as X-User-Id,
+	// the same headers sent by clients are always dropped
+	ClaimHeaders map[string]string `json:"claimHeaders,omitempty"`
+	// LeewaySeconds tolerate clock skew checking exp and nbf
+	LeewaySeconds int `json:"leewaySeconds,omitempty"`
+
+	hash      crypto.Hash    // hash of Algorithm
+	publicKey *rsa.PublicKey // loaded from PublicKeyFile
+}
+
+// errUnauthorized tell the client its credentials are missing or invalid
+var errUnauthorized = errors.New("unauthorized")
+
+// normalizeAuth validate the auth of api and load its key
+func normalizeAuth(api *API) error {
+	auth := api.Auth
+	if auth == nil {
+		return nil
+	}
+	switch auth.Mode {
+	case AuthAPIKey:
+		if auth.Header == "" {
+			auth.Header = DefaultAPIKeyHeader
+		}
+		return nil
+	case AuthJWT:
+	default:
+		return fmt.Errorf("api: %v auth mode: %q unsupported, should be %v or %v", api.Name, auth.Mode, AuthJWT, AuthAPIKey)
+	}
+	if auth.LeewaySeconds < 0 {
+		return fmt.Errorf("api: %v auth leewaySeconds can not be negative", api.Name)
+	}
+	switch auth.Algorithm {
+	case "HS256", "HS384", "HS512":
+		if auth.Secret == "" {
+			return fmt.Errorf("api: %v auth %v require secret", api.Name, auth.Algorithm)
+		}
+		auth.hash = map[string]crypto.Hash{"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512}[auth.Algorithm]
+	case "RS256":
+		key, err := loadRSAPublicKey(auth.PublicKeyFile)
+		if err != nil {
+			return fmt.Errorf("api: %v auth %v", api.Name, err)
+		}
+		auth.hash = crypto.SHA256
+		auth.publicKey = key
+	default:
+		return fmt.Errorf("api: %v auth algorithm: %q unsupported, should be HS256, HS384, HS512 or RS256", api.Name, auth.Algorithm)
+	}
+	if auth.ClaimHeaders == nil {
+		auth.ClaimHeaders = make(map[string]string, len(DefaultClaimHeaders))
+		for claim, header := range DefaultClaimHeaders {
+			auth.ClaimHeaders[claim] = header
+		}
+	}
+	return nil
+}
+
+// redactedSecret replace the HMAC secret when auth is listed by admin endpoints
+const redactedSecret = "REDACTED"
+
+// MarshalJSON implements json.Marshaler, the secret never leaves the gateway
+func (auth Auth) MarshalJSON() ([]byte, error) {
+	type plain Auth
+	if auth.Secret != "" {
+		auth.Secret = redactedSecret
+	}
+	return json.Marshal(plain(auth))
+}
+
+// loadRSAPublicKey read a PEM public key or certificate
+func loadRSAPublicKey(file string) (*rsa.PublicKey, error) {
+	if file == "" {
+		return nil, fmt.Errorf("require publicKeyFile")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read public key failed: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key file: %v is not PEM", file)
+	}
+	var key interface{}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate failed: %v", err)
+		}
+		key = cert.PublicKey
+	} else if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("parse public key failed: %v", err)
+		}
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key file: %v is not RSA", file)
+	}
+	return rsaKey, nil
+}
+
+// authenticate verify the credentials of request when api requires auth, write 401 and
+// return false when they are missing or invalid
+func (gateway *APIGateway) authenticate(w http.ResponseWriter, r *http.Request, service *Service, api *API) bool {
+	auth := api.Auth
+	if auth == nil {
+		return true
+	}
+	if auth.Mode == AuthAPIKey {
+		if err := gateway.checkAPIKey(r, service, auth); err != nil {
+			gateway.logger().Infof("request: %v api: %v api key rejected: %v", requestID(r.Context()), api.Name, err)
+			gateway.writeError(w, r, http.StatusUnauthorized, "invalid api key")
+			return false
+		}
+		return true
+	}
+	return gateway.checkBearer(w, r, api)
+}
+
+// checkBearer verify the bearer token of request, forward its claims to backend, write
+// 401 and return false when it is missing or invalid
+func (gateway *APIGateway) checkBearer(w http.ResponseWriter, r *http.Request, api *API) bool {
+	auth := api.Auth
+	for _, header := range auth.ClaimHeaders {
+		r.Header.Del(header)
+	}
+	token := r.Header.Get("Authorization")
+	if len(token) < len("Bearer ") || !strings.EqualFold(token[:len("Bearer ")], "Bearer ") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+api.Name+`"`)
+		gateway.writeError(w, r, http.StatusUnauthorized, "missing bearer token")
+		return false
+	}
+	claims, err := auth.verify(strings.TrimSpace(token[len("Bearer "):]), time.Now())
+	if err != nil {
+		gateway.logger().Infof("request: %v api: %v token rejected: %v", requestID(r.Context()), api.Name, err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+api.Name+`", error="invalid_token"`)
+		gateway.writeError(w, r, http.StatusUnauthorized, "invalid bearer token")
+		return false
+	}
+	for claim, header := range auth.ClaimHeaders {
+		if value, ok := claimString(claims[claim]); ok {
+			r.Header.Set(header, value)
+		}
+	}
+	return true
+}
+
+// verify check signature, time window and required claims of a compact JWT, return its claims
+func (auth *Auth) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", errUnauthorized)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header %v", errUnauthorized, err)
+	}
+	// never let the token choose the algorithm, e.g. none or HS256 signed with the RSA public key
+	if header.Alg != auth.Algorithm {
+		return nil, fmt.Errorf("%w: algorithm: %q not accepted", errUnauthorized, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature %v", errUnauthorized, err)
+	}
+	if err := auth.verifySignature(parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims %v", errUnauthorized, err)
+	}
+	leeway := time.Duration(auth.LeewaySeconds) * time.Second
+	if exp, ok := claims["exp"].(float64); ok && now.Add(-leeway).After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("%w: token expired", errUnauthorized)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: token not valid yet", errUnauthorized)
+	}
+	if auth.Issuer != "" && claims["iss"] != auth.Issuer {
+		return nil, fmt.Errorf("%w: issuer: %v not accepted", errUnauthorized, claims["iss"])
+	}
+	if auth.Audience != "" && !hasAudience(claims["aud"], auth.Audience) {
+		return nil, fmt.Errorf("%w: audience: %v not accepted", errUnauthorized, claims["aud"])
+	}
+	for claim, want := range auth.RequiredClaims {
+		if value, ok := claimString(claims[claim]); !ok || value != want {
+			return nil, fmt.Errorf("%w: claim: %v should be %v", errUnauthorized, claim, want)
+		}
+	}
+	return claims, nil
+}
+
+// verifySignature check signature of the signing input with the configured key
+func (auth *Auth) verifySignature(input string, signature []byte) error {
+	if auth.publicKey != nil {
+		digest := sha256.Sum256([]byte(input))
+		if err := rsa.VerifyPKCS1v15(auth.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: signature mismatch", errUnauthorized)
+		}
+		return nil
+	}
+	var newHash func() hash.Hash
+	switch auth.hash {
+	case crypto.SHA384:
+		newHash = sha512.New384
+	case crypto.SHA512:
+		newHash = sha512.New
+	default:
+		newHash = sha256.New
+	}
+	mac := hmac.New(newHash, []byte(auth.Secret))
+	mac.Write([]byte(input))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return fmt.Errorf("%w: signature mismatch", errUnauthorized)
+	}
+	return nil
+}
+
+// decodeSegment decode a base64url json segment of JWT into v
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// hasAudience report whether aud claim, a string or an array, contains audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString format a scalar claim as header value
+func claimString(claim interface{}) (string, bool) {
+	switch claim := claim.(type) {
+	case string:
+		return claim, true
+	case float64:
+		return strconv.FormatFloat(claim, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(claim), true
+	}
+	return "", false
+}
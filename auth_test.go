@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "test-secret"
+
+// signJWT return a compact token of claims with header alg, signed by sign
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, sign func(input []byte) []byte) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+// hs256 sign with HMAC SHA-256 of secret
+func hs256(secret string) func([]byte) []byte {
+	return func(input []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+// rs256 sign with RSA SHA-256 of key
+func rs256(t *testing.T, key *rsa.PrivateKey) func([]byte) []byte {
+	return func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return signature
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicKeyFile := writeTestFile(t, tempDir(t), "jwt.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "42", "iss": "issuer", "exp": now + 60}
+	expired := map[string]interface{}{"sub": "42", "iss": "issuer", "exp": now - 60}
+	hsAuth := func() *Auth {
+		return &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret, Issuer: "issuer"}
+	}
+	tests := []struct {
+		name   string
+		auth   *Auth
+		token  string // sent as bearer token, empty send no Authorization header
+		spoof  bool   // the client send X-User-Id itself
+		status int
+		userID string // X-User-Id received by the backend
+	}{
+		{name: "public api", spoof: true, status: http.StatusOK, userID: "spoofed"},
+		{name: "valid token", auth: hsAuth(), token: signJWT(t, "HS256", valid, hs256(testJWTSecret)), status: http.StatusOK, userID: "42"},
+		{name: "spoofed claim header replaced", auth: hsAuth(), token: signJWT(t, "HS256", valid, hs256(testJWTSecret)), spoof: true, status: http.StatusOK, userID: "42"},
+		{name: "missing header", auth: hsAuth(), status: http.StatusUnauthorized},
+		{name: "expired token", auth: hsAuth(), token: signJWT(t, "HS256", expired, hs256(testJWTSecret)), status: http.StatusUnauthorized},
+		{
+			name:   "expired within leeway",
+			auth:   &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret, LeewaySeconds: 120},
+			token:  signJWT(t, "HS256", expired, hs256(testJWTSecret)),
+			status: http.StatusOK,
+			userID: "42",
+		},
+		{name: "wrong secret", auth: hsAuth(), token: signJWT(t, "HS256", valid, hs256("other")), status: http.StatusUnauthorized},
+		{name: "algorithm none", auth: hsAuth(), token: signJWT(t, "none", valid, func([]byte) []byte { return nil }), status: http.StatusUnauthorized},
+		{name: "malformed token", auth: hsAuth(), token: "not.a-token", status: http.StatusUnauthorized},
+		{
+			name:   "wrong issuer",
+			auth:   hsAuth(),
+			token:  signJWT(t, "HS256", map[string]interface{}{"sub": "42", "iss": "other"}, hs256(testJWTSecret)),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "required claim missing",
+			auth:   &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret, RequiredClaims: map[string]string{"role": "admin"}},
+			token:  signJWT(t, "HS256", valid, hs256(testJWTSecret)),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "rs256",
+			auth:   &Auth{Mode: AuthJWT, Algorithm: "RS256", PublicKeyFile: publicKeyFile},
+			token:  signJWT(t, "RS256", valid, rs256(t, key)),
+			status: http.StatusOK,
+			userID: "42",
+		},
+		{
+			name:   "hs256 signed with the rsa public key",
+			auth:   &Auth{Mode: AuthJWT, Algorithm: "RS256", PublicKeyFile: publicKeyFile},
+			token:  signJWT(t, "HS256", valid, hs256(string(der))),
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.Header.Get("X-User-Id"))
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "me", HTTPMethod: http.MethodGet, Host: backend, Path: "me", Auth: tt.auth}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/me", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.spoof {
+				req.Header.Set("X-User-Id", "spoofed")
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusUnauthorized {
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Errorf("401 without WWW-Authenticate")
+				}
+				return
+			}
+			if rec.Body.String() != tt.userID {
+				t.Errorf("backend got X-User-Id %q, want %q", rec.Body.String(), tt.userID)
+			}
+		})
+	}
+}
+
+func TestJWTAuthRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		auth *Auth
+	}{
+		{name: "unknown mode", auth: &Auth{Mode: "basic"}},
+		{name: "unknown algorithm", auth: &Auth{Mode: AuthJWT, Algorithm: "none"}},
+		{name: "hmac without secret", auth: &Auth{Mode: AuthJWT, Algorithm: "HS256"}},
+		{name: "rsa without key", auth: &Auth{Mode: AuthJWT, Algorithm: "RS256"}},
+		{name: "rsa key missing", auth: &Auth{Mode: AuthJWT, Algorithm: "RS256", PublicKeyFile: "/nonexistent/key.pem"}},
+		{name: "negative leeway", auth: &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret, LeewaySeconds: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("svc",
+				&API{Name: "me", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "me", Auth: tt.auth}))
+			if err == nil {
+				t.Errorf("invalid auth accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"fmt"
+	"sync"
+)
+
+// roundRobin spread requests of an api over its hosts in proportion to their weights
+// with smooth weighted round-robin, safe for concurrent use
+type roundRobin struct {
+	mu      sync.Mutex
+	current []int // running weight of each host
+}
+
+// normalizeHosts merge Host into Hosts, a non-empty Host alone is a single host list and
+// Host is kept as the first host for code still reading it, Weights parallel Hosts
+func normalizeHosts(api *API) error {
+	if len(api.Hosts) == 0 && api.Host != "" {
+		api.Hosts = []string{api.Host}
+	}
+	for _, host := range api.Hosts {
+		if err := validateHost(host); err != nil {
+			return fmt.Errorf("api: %v %v", api.Name, err)
+		}
+	}
+	if len(api.Weights) > 0 {
+		if api.ConsulService != "" {
+			return fmt.Errorf("api: %v weights can not apply to hosts resolved from consul", api.Name)
+		}
+		if len(api.Weights) != len(api.Hosts) {
+			return fmt.Errorf("api: %v has %d weights for %d hosts", api.Name, len(api.Weights), len(api.Hosts))
+		}
+		total := 0
+		for i, weight := range api.Weights {
+			if weight < 0 {
+				return fmt.Errorf("api: %v host: %v weight: %v can not be negative", api.Name, api.Hosts[i], weight)
+			}
+			total += weight
+		}
+		// all zero weights would silently blackhole traffic
+		if total == 0 {
+			return fmt.Errorf("api: %v at least one host should have positive weight", api.Name)
+		}
+	}
+	if len(api.Hosts) > 0 {
+		api.Host = api.Hosts[0]
+	}
+	api.balancer = &roundRobin{current: make([]int, len(api.Hosts))}
+	return nil
+}
+
+// hostWeight return the weight of the i-th host of api, equal weights when Weights is empty
+func hostWeight(api *API, i int) int {
+	if len(api.Weights) == 0 {
+		return 1
+	}
+	return api.Weights[i]
+}
+
+// roundRobinHost pick the next healthy host of api by weight, when every host is unhealthy
+// they are all candidates so that requests still probe them, peek leave the balancer as is
+func (gateway *APIGateway) roundRobinHost(api *API, peek bool) string {
+	if len(api.Hosts) == 0 || api.balancer == nil {
+		return api.Host
+	}
+	host := gateway.pickHost(api, api.balancer, api.Hosts, func(i int) int { return hostWeight(api, i) }, true, peek)
+	if host == "" {
+		return api.Host
+	}
+	return host
+}
+
+// pickHost pick the next healthy host of hosts by weight with smooth weighted round-robin,
+// when every host is unhealthy they are all candidates if anyHealth, otherwise none is
+// picked, peek return the host without moving the balancer on
+func (gateway *APIGateway) pickHost(api *API, balancer *roundRobin, hosts []string, weight func(i int) int, anyHealth, peek bool) string {
+	healthy := make([]bool, len(hosts))
+	anyHealthy := false
+	for i, host := range hosts {
+		healthy[i] = weight(i) > 0 && gateway.health.healthy(api, host)
+		anyHealthy = anyHealthy || healthy[i]
+	}
+	if !anyHealthy && !anyHealth {
+		return ""
+	}
+	balancer.mu.Lock()
+	defer balancer.mu.Unlock()
+	current := balancer.current
+	if peek {
+		current = append([]int(nil), current...)
+	}
+	// every candidate gains its weight, the leader is picked and pays back the total
+	best, total := -1, 0
+	for i := range hosts {
+		w := weight(i)
+		if w == 0 || (anyHealthy && !healthy[i]) {
+			continue
+		}
+		current[i] += w
+		total += w
+		if best < 0 || current[i] > current[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	current[best] -= total
+	return hosts[best]
+}
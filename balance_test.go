@@ -0,0 +1,172 @@
+package gateway
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRoundRobinHosts(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string // legacy single host, a backend name
+		hosts    []string
+		requests int
+		want     map[string]int
+	}{
+		{name: "three hosts", hosts: []string{"a", "b", "c"}, requests: 9, want: map[string]int{"a": 3, "b": 3, "c": 3}},
+		{name: "single host", host: "a", requests: 9, want: map[string]int{"a": 9}},
+		{name: "hosts override host", host: "a", hosts: []string{"b", "c"}, requests: 4, want: map[string]int{"b": 2, "c": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends := map[string]string{}
+			for _, name := range []string{"a", "b", "c"} {
+				backends[name] = namedBackend(t, name)
+			}
+			api := &API{Name: "get", HTTPMethod: http.MethodGet, Path: "get"}
+			if tt.host != "" {
+				api.Host = backends[tt.host]
+			}
+			for _, name := range tt.hosts {
+				api.Hosts = append(api.Hosts, backends[name])
+			}
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", api))
+			got := map[string]int{}
+			var previous string
+			for i := 0; i < tt.requests; i++ {
+				rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil))
+				body, _ := ioutil.ReadAll(rec.Body)
+				if len(tt.want) > 1 && string(body) == previous {
+					t.Errorf("request %d sent to %v twice in a row", i, previous)
+				}
+				previous = string(body)
+				got[previous]++
+			}
+			for name, n := range tt.want {
+				if got[name] != n {
+					t.Errorf("distribution %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedHosts(t *testing.T) {
+	hosts := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}
+	tests := []struct {
+		name    string
+		hosts   []string
+		weights []int
+		want    []int // share of every host in percent
+	}{
+		{name: "canary", hosts: hosts[:2], weights: []int{90, 10}, want: []int{90, 10}},
+		{name: "equal when empty", hosts: hosts, want: []int{33, 33, 33}},
+		{name: "zero weight never picked", hosts: hosts, weights: []int{3, 0, 1}, want: []int{75, 0, 25}},
+	}
+	const picks = 1000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			api := &API{Name: "get", Hosts: tt.hosts, Weights: tt.weights}
+			if err := normalizeHosts(api); err != nil {
+				t.Fatalf("normalize hosts: %v", err)
+			}
+			got := map[string]int{}
+			for i := 0; i < picks; i++ {
+				got[gateway.roundRobinHost(api, false)]++
+			}
+			for i, host := range tt.hosts {
+				share := got[host] * 100 / picks
+				if share < tt.want[i]-2 || share > tt.want[i]+2 {
+					t.Errorf("host %v got %d%%, want about %d%%", host, share, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWeightsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		api     API
+		wantErr bool
+	}{
+		{name: "no weights", api: API{Hosts: []string{"a:80", "b:80"}}},
+		{name: "parallel weights", api: API{Hosts: []string{"a:80", "b:80"}, Weights: []int{9, 1}}},
+		{name: "zero weight", api: API{Hosts: []string{"a:80", "b:80"}, Weights: []int{1, 0}}},
+		{name: "fewer weights", api: API{Hosts: []string{"a:80", "b:80"}, Weights: []int{1}}, wantErr: true},
+		{name: "more weights", api: API{Hosts: []string{"a:80"}, Weights: []int{1, 1}}, wantErr: true},
+		{name: "negative weight", api: API{Hosts: []string{"a:80", "b:80"}, Weights: []int{2, -1}}, wantErr: true},
+		{name: "all zero", api: API{Hosts: []string{"a:80", "b:80"}, Weights: []int{0, 0}}, wantErr: true},
+		{name: "consul hosts", api: API{ConsulService: "user", Weights: []int{1}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := tt.api
+			api.Name = "get"
+			if err := normalizeHosts(&api); (err != nil) != tt.wantErr {
+				t.Errorf("normalize hosts error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEjectionExpiry(t *testing.T) {
+	const ejection = 50 * time.Millisecond
+	hosts := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	failing := hosts[1]
+	gateway := newTestGateway(t)
+	api := &API{Name: "get", Hosts: hosts}
+	if err := normalizeHosts(api); err != nil {
+		t.Fatalf("normalize hosts: %v", err)
+	}
+	observe := func(err error) {
+		if err != nil {
+			gateway.health.observe(failing, nil, err, time.Minute, ejection)
+			return
+		}
+		gateway.health.observe(failing, &http.Response{StatusCode: http.StatusOK}, nil, time.Minute, ejection)
+	}
+	refused := errors.New("connection refused")
+	tests := []struct {
+		name   string
+		before func() // run before picking hosts
+		picked bool   // the failing host get some of the requests
+	}{
+		{name: "healthy", picked: true},
+		{name: "ejected", before: func() {
+			for i := 0; i < unhealthyAfter; i++ {
+				observe(refused)
+			}
+		}},
+		{name: "tried again once ejection elapsed", before: func() { time.Sleep(2 * ejection) }, picked: true},
+		{name: "ejected again by one failure", before: func() { observe(refused) }},
+		{name: "restored by one success", before: func() {
+			time.Sleep(2 * ejection)
+			observe(nil)
+		}, picked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.before != nil {
+				tt.before()
+			}
+			picked := false
+			for i := 0; i < 4; i++ {
+				picked = picked || gateway.roundRobinHost(api, false) == failing
+			}
+			if picked != tt.picked {
+				t.Errorf("failing host picked %v, want %v", picked, tt.picked)
+			}
+		})
+	}
+	if state := hostState(&gateway.health, failing); state != BackendHealthy {
+		t.Errorf("state %v after a success, want healthy", state)
+	}
+}
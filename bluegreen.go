@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Colors of the backend pools of blue-green apis
+const (
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+)
+
+// blueGreen report whether api routes to one of two backend pools
+func blueGreen(api *API) bool {
+	return len(api.BlueHosts) > 0 || len(api.GreenHosts) > 0
+}
+
+// normalizeColors validate the pools of a blue-green api and make the active pool its Hosts,
+// ActiveColor default to blue
+func normalizeColors(api *API) error {
+	if !blueGreen(api) {
+		if api.ActiveColor != "" {
+			return fmt.Errorf("api: %v activeColor requires blueHosts and greenHosts", api.Name)
+		}
+		return nil
+	}
+	if len(api.BlueHosts) == 0 || len(api.GreenHosts) == 0 {
+		return fmt.Errorf("api: %v blueHosts and greenHosts should be both set", api.Name)
+	}
+	if api.ConsulService != "" || len(api.Weights) > 0 {
+		return fmt.Errorf("api: %v blue-green pools can not have consulService or weights", api.Name)
+	}
+	api.ActiveColor = strings.ToLower(strings.TrimSpace(api.ActiveColor))
+	switch api.ActiveColor {
+	case "":
+		api.ActiveColor = ColorBlue
+	case ColorBlue, ColorGreen:
+	default:
+		return fmt.Errorf("api: %v activeColor: %q unsupported, should be %v or %v", api.Name, api.ActiveColor, ColorBlue, ColorGreen)
+	}
+	// Hosts always follow the active pool
+	pool := api.BlueHosts
+	if api.ActiveColor == ColorGreen {
+		pool = api.GreenHosts
+	}
+	api.Host, api.Hosts = "", append([]string(nil), pool...)
+	return nil
+}
+
+// SwitchColor route the blue-green api of serviceName to the pool of color, or to the other
+// pool when color is empty, return the color active afterwards, requests already resolved
+// keep their pool while limiter and breaker state is shared
+func (c *cache) SwitchColor(serviceName, apiName, color string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := c.resolve(serviceName)
+	service, exist := c.store[name]
+	if !exist {
+		return "", fmt.Errorf("service: %v %w", serviceName, ErrNotExist)
+	}
+	api, exist := service.APIs[apiName]
+	if !exist {
+		return "", fmt.Errorf("service: %v, api: %v %w", serviceName, apiName, ErrNotExist)
+	}
+	if !blueGreen(api) {
+		return "", fmt.Errorf("api: %v has no blue-green pools", apiName)
+	}
+	updated := *api
+	updated.ActiveColor = strings.ToLower(strings.TrimSpace(color))
+	if updated.ActiveColor == "" {
+		updated.ActiveColor = ColorGreen
+		if api.ActiveColor == ColorGreen {
+			updated.ActiveColor = ColorBlue
+		}
+	}
+	if err := normalizeColors(&updated); err != nil {
+		return "", err
+	}
+	if err := normalizeHosts(&updated); err != nil {
+		return "", err
+	}
+	// responses of the other pool are not served anymore
+	if updated.responses != nil {
+		updated.responses = newResponseCache(updated.responses.ttl)
+	}
+	c.store[name] = withAPI(service, apiName, &updated)
+	return updated.ActiveColor, nil
+}
+
+// SwitchColor implements Discovery
+func (d *RedisDiscovery) SwitchColor(serviceName, apiName, color string) (string, error) {
+	var active string
+	err := d.update(func(c *cache) error {
+		var err error
+		active, err = c.SwitchColor(serviceName, apiName, color)
+		return err
+	})
+	return active, err
+}
+
+// switchColorResult is the response of SwitchColor
+type switchColorResult struct {
+	Result      string `json:"result"`
+	ActiveColor string `json:"activeColor"`
+}
+
+// SwitchColor handle http request to flip the pool a blue-green api routes to, the body is
+// {"service": ..., "name": ..., "color": ...}, an empty color toggle the pool
+func (gateway *APIGateway) SwitchColor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	data, ok := readAdminBody(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Service string `json:"service"`
+		Name    string `json:"name"`
+		Color   string `json:"color"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+		return
+	}
+	active, err := gateway.Discovery.SwitchColor(body.Service, body.Name, body.Color)
+	if err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("switch color failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, switchColorResult{Result: "success", ActiveColor: active})
+}
@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// newBlueGreenGateway return a gateway with blue-green api svc/get and plain api svc/plain
+func newBlueGreenGateway(t *testing.T) *APIGateway {
+	t.Helper()
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{
+			Name:       "get",
+			HTTPMethod: http.MethodGet,
+			BlueHosts:  []string{namedBackend(t, ColorBlue), namedBackend(t, ColorBlue)},
+			GreenHosts: []string{namedBackend(t, ColorGreen)},
+			Path:       "get",
+		},
+		&API{Name: "plain", HTTPMethod: http.MethodGet, Host: namedBackend(t, "plain"), Path: "plain"}))
+	return gateway
+}
+
+func TestSwitchColor(t *testing.T) {
+	gateway := newBlueGreenGateway(t)
+	for i := 0; i < 4; i++ {
+		if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil)); rec.Body.String() != ColorBlue {
+			t.Fatalf("before switch served by %q, want blue", rec.Body.String())
+		}
+	}
+	// steps run in order on the same gateway
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		served string // pool serving requests afterwards
+	}{
+		{name: "toggle to green", method: http.MethodPost, body: `{"service":"svc","name":"get"}`, status: http.StatusOK, served: ColorGreen},
+		{name: "explicit blue", method: http.MethodPost, body: `{"service":"svc","name":"get","color":"blue"}`, status: http.StatusOK, served: ColorBlue},
+		{name: "explicit blue again", method: http.MethodPost, body: `{"service":"svc","name":"get","color":"BLUE"}`, status: http.StatusOK, served: ColorBlue},
+		{name: "toggle back to green", method: http.MethodPost, body: `{"service":"svc","name":"get","color":""}`, status: http.StatusOK, served: ColorGreen},
+		{name: "unknown color", method: http.MethodPost, body: `{"service":"svc","name":"get","color":"red"}`, status: http.StatusBadRequest, served: ColorGreen},
+		{name: "unknown api", method: http.MethodPost, body: `{"service":"svc","name":"nope"}`, status: http.StatusNotFound, served: ColorGreen},
+		{name: "unknown service", method: http.MethodPost, body: `{"service":"nope","name":"get"}`, status: http.StatusNotFound, served: ColorGreen},
+		{name: "api without pools", method: http.MethodPost, body: `{"service":"svc","name":"plain"}`, status: http.StatusBadRequest, served: ColorGreen},
+		{name: "malformed", method: http.MethodPost, body: `{"service":`, status: http.StatusBadRequest, served: ColorGreen},
+		{name: "wrong method", method: http.MethodGet, status: http.StatusMethodNotAllowed, served: ColorGreen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAdmin(gateway, tt.method, "/switchColor", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				var result switchColorResult
+				mustDecode(t, rec.Body.Bytes(), &result)
+				if result.ActiveColor != tt.served {
+					t.Errorf("active color %q, want %q", result.ActiveColor, tt.served)
+				}
+			}
+			for i := 0; i < 4; i++ {
+				if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil)); rec.Body.String() != tt.served {
+					t.Fatalf("served by %q, want %q", rec.Body.String(), tt.served)
+				}
+			}
+		})
+	}
+}
+
+func TestSwitchColorWhileProxying(t *testing.T) {
+	gateway := newBlueGreenGateway(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil))
+				if body := rec.Body.String(); body != ColorBlue && body != ColorGreen {
+					t.Errorf("served %d %q", rec.Code, body)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := gateway.Discovery.SwitchColor("svc", "get", ""); err != nil {
+			t.Fatalf("switch color: %v", err)
+		}
+	}
+	wg.Wait()
+	// an even number of toggles leave blue active
+	if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil)); rec.Body.String() != ColorBlue {
+		t.Errorf("served by %q after switches, want blue", rec.Body.String())
+	}
+}
+
+func TestBlueGreenValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		api    *API
+		active string // expected active color, empty when rejected
+	}{
+		{name: "default blue", api: &API{BlueHosts: []string{"10.0.0.1:80"}, GreenHosts: []string{"10.0.0.2:80"}}, active: ColorBlue},
+		{name: "green", api: &API{BlueHosts: []string{"10.0.0.1:80"}, GreenHosts: []string{"10.0.0.2:80"}, ActiveColor: " Green "}, active: ColorGreen},
+		{name: "blue only", api: &API{BlueHosts: []string{"10.0.0.1:80"}}},
+		{name: "unknown color", api: &API{BlueHosts: []string{"10.0.0.1:80"}, GreenHosts: []string{"10.0.0.2:80"}, ActiveColor: "red"}},
+		{name: "color without pools", api: &API{Host: "10.0.0.1:80", ActiveColor: ColorBlue}},
+		{name: "weights", api: &API{BlueHosts: []string{"10.0.0.1:80"}, GreenHosts: []string{"10.0.0.2:80"}, Weights: []int{1}}},
+		{name: "invalid host", api: &API{BlueHosts: []string{"http://10.0.0.1"}, GreenHosts: []string{"10.0.0.2:80"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.api.Name, tt.api.HTTPMethod, tt.api.Path = "get", http.MethodGet, "get"
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("svc", tt.api))
+			if tt.active == "" {
+				if err == nil {
+					t.Errorf("invalid blue-green api accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("create service: %v", err)
+			}
+			if tt.api.ActiveColor != tt.active {
+				t.Errorf("active color %q, want %q", tt.api.ActiveColor, tt.active)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultOpenDuration is how long an open breaker fail requests fast before probing
+const DefaultOpenDuration = 5 * time.Second
+
+// States of a circuit breaker
+const (
+	BreakerClosed   = "closed"    // requests flow, failures are counted
+	BreakerOpen     = "open"      // requests fail fast until the open duration elapses
+	BreakerHalfOpen = "half-open" // one probe request decide whether to close or reopen
+)
+
+// circuitBreaker stop sending requests to the backends of an api after threshold
+// consecutive failures, after the open duration one probe is let through
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	open      time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	trips     int64
+}
+
+// BreakerStats is the state of a circuit breaker reported by /stats
+type BreakerStats struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	Trips               int64  `json:"trips"`
+}
+
+func newCircuitBreaker(threshold int, open time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, open: open, state: BreakerClosed}
+}
+
+// allow report whether a request may go through, the wait until the next probe is
+// returned when rejected
+func (b *circuitBreaker) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if wait := b.openedAt.Add(b.open).Sub(now); wait > 0 {
+			return false, wait
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true, 0
+	case BreakerHalfOpen:
+		// only one probe at a time, its outcome arrives shortly
+		if b.probing {
+			return false, 0
+		}
+		b.probing = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// record the outcome of a request let through, return whether the breaker tripped
+func (b *circuitBreaker) record(failed bool, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if failed {
+			b.state = BreakerOpen
+			b.openedAt = now
+			b.trips++
+			return true
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return false
+	}
+	if !failed {
+		b.failures = 0
+		return false
+	}
+	b.failures++
+	if b.state == BreakerClosed && b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = now
+		b.trips++
+		return true
+	}
+	return false
+}
+
+// release end a request let through without recording its outcome, a probe is then let
+// through again by the next request
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *circuitBreaker) stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{State: b.state, ConsecutiveFailures: b.failures, Trips: b.trips}
+}
+
+// normalizeBreaker validate the breaker settings of api and build its breaker
+func normalizeBreaker(api *API) error {
+	if api.FailureThreshold < 0 || api.OpenDurationMs < 0 {
+		return fmt.Errorf("api: %v failureThreshold and openDurationMs can not be negative", api.Name)
+	}
+	api.breaker = nil
+	if api.FailureThreshold > 0 {
+		open := DefaultOpenDuration
+		if api.OpenDurationMs > 0 {
+			open = time.Duration(api.OpenDurationMs) * time.Millisecond
+		}
+		api.breaker = newCircuitBreaker(api.FailureThreshold, open)
+	}
+	return nil
+}
+
+// allowBreaker consult the api circuit breaker, write 503 and return false while it is
+// open, done record the outcome of the proxied request from its response status, it must
+// be called even when proxying panics, aborted then tell the response was cut short
+func (gateway *APIGateway) allowBreaker(w http.ResponseWriter, r *http.Request, api *API) (bool, func(status int, aborted bool)) {
+	breaker := api.breaker
+	if breaker == nil {
+		return true, func(int, bool) {}
+	}
+	ok, wait := breaker.allow(time.Now())
+	if !ok {
+		gateway.throttle(w, r, http.StatusServiceUnavailable, fmt.Sprintf("api: %v circuit breaker open", api.Name), wait)
+		return false, func(int, bool) {}
+	}
+	return true, func(status int, aborted bool) {
+		// a client giving up says nothing about the backend
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			breaker.release()
+			return
+		}
+		failed := aborted || status >= http.StatusInternalServerError
+		if breaker.record(failed, time.Now()) {
+			gateway.logger().Warnf("api: %v circuit breaker open for %v", api.Name, breaker.open)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	const open = 100 * time.Millisecond
+	type step struct {
+		at      time.Duration // since the breaker was created
+		allowed bool
+		failed  bool // outcome recorded when allowed
+		state   string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "successes reset failures",
+			steps: []step{
+				{allowed: true, failed: true, state: BreakerClosed},
+				{allowed: true, failed: true, state: BreakerClosed},
+				{allowed: true, state: BreakerClosed},
+				{allowed: true, failed: true, state: BreakerClosed},
+				{allowed: true, failed: true, state: BreakerClosed},
+			},
+		},
+		{
+			name: "open then recover",
+			steps: []step{
+				{allowed: true, failed: true, state: BreakerClosed},
+				{allowed: true, failed: true, state: BreakerClosed},
+				{allowed: true, failed: true, state: BreakerOpen},
+				{at: open / 2, state: BreakerOpen},
+				{at: open, allowed: true, state: BreakerClosed},
+				{at: open, allowed: true, state: BreakerClosed},
+			},
+		},
+		{
+			name: "failed probe reopen",
+			steps: []step{
+				{allowed: true, failed: true, state: BreakerClosed},
+				{allowed: true, failed: true, state: BreakerClosed},
+				{allowed: true, failed: true, state: BreakerOpen},
+				{at: open, allowed: true, failed: true, state: BreakerOpen},
+				{at: open + open/2, state: BreakerOpen},
+				{at: 2 * open, allowed: true, state: BreakerClosed},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := newCircuitBreaker(3, open)
+			start := time.Now()
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				allowed, _ := breaker.allow(now)
+				if allowed != s.allowed {
+					t.Fatalf("step %d allowed %v, want %v", i, allowed, s.allowed)
+				}
+				if allowed {
+					breaker.record(s.failed, now)
+				}
+				if state := breaker.stats().State; state != s.state {
+					t.Fatalf("step %d state %v, want %v", i, state, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		canceled bool // the client give up on the probe
+		state    string
+		next     bool // another probe is let through
+	}{
+		{name: "probe in progress", state: BreakerHalfOpen},
+		{name: "probe canceled by client", canceled: true, state: BreakerHalfOpen, next: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			api := &API{Name: "get", FailureThreshold: 1, OpenDurationMs: 1}
+			if err := normalizeBreaker(api); err != nil {
+				t.Fatalf("normalize breaker: %v", err)
+			}
+			api.breaker.record(true, time.Now())
+			time.Sleep(2 * time.Millisecond)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := httptest.NewRequest(http.MethodGet, "/svc/get", nil).WithContext(ctx)
+			allowed, done := gateway.allowBreaker(httptest.NewRecorder(), r, api)
+			if !allowed {
+				t.Fatalf("probe rejected once the open duration elapsed")
+			}
+			if tt.canceled {
+				cancel()
+				done(http.StatusBadGateway, true)
+			}
+			if state := api.breaker.stats().State; state != tt.state {
+				t.Errorf("state %v, want %v", state, tt.state)
+			}
+			if next, _ := api.breaker.allow(time.Now()); next != tt.next {
+				t.Errorf("next probe allowed %v, want %v", next, tt.next)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProxy(t *testing.T) {
+	var failing, hits int32 = 1, 0
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch atomic.LoadInt32(&failing) {
+		case 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 2:
+			// the declared body is cut short, the gateway abort the client response
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			fmt.Fprint(buf, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\npartial")
+			buf.Flush()
+			conn.Close()
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	})
+	gateway := newTestGateway(t)
+	gateway.EjectionPeriod = 0
+	mustCreateService(t, gateway, newTestService("svc", &API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get",
+		FailureThreshold: 2, OpenDurationMs: 100}))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+	// a request failing on a reused connection would be sent again by the client
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	tests := []struct {
+		name    string
+		failing int32 // 1 answer 500, 2 abort the body, 0 succeed
+		sleep   time.Duration
+		status  int // zero when the response is aborted
+		proxied bool
+	}{
+		{name: "first failure", failing: 1, status: http.StatusInternalServerError, proxied: true},
+		{name: "aborted response count as failure", failing: 2, proxied: true},
+		{name: "open fail fast", failing: 0, status: http.StatusServiceUnavailable},
+		{name: "probe after open duration", failing: 0, sleep: 150 * time.Millisecond, status: http.StatusOK, proxied: true},
+		{name: "closed again", failing: 1, status: http.StatusInternalServerError, proxied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			time.Sleep(tt.sleep)
+			atomic.StoreInt32(&failing, tt.failing)
+			before := atomic.LoadInt32(&hits)
+			resp, err := client.Get(server.URL + "/svc/get")
+			if err == nil {
+				_, err = ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if tt.status == 0 {
+				if err == nil {
+					t.Errorf("cut short response completed with %d", resp.StatusCode)
+				}
+			} else if err != nil {
+				t.Fatalf("get: %v", err)
+			} else if resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if proxied := atomic.LoadInt32(&hits) > before; proxied != tt.proxied {
+				t.Errorf("proxied %v, want %v", proxied, tt.proxied)
+			}
+		})
+	}
+}
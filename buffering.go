@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// ResponseStreamed copy backend response to client as it arrives, lower latency but
+	// only requests failed before any response can be retried
+	ResponseStreamed = "streamed"
+	// ResponseBuffered read backend response entirely before sending it to client, so that
+	// 502/503/504 responses can be retried and size limits are checked before anything is sent
+	ResponseBuffered = "buffered"
+)
+
+// validateResponseMode check response mode value, empty use the gateway default
+func validateResponseMode(mode string) error {
+	switch mode {
+	case "", ResponseStreamed, ResponseBuffered:
+		return nil
+	}
+	return fmt.Errorf("response mode: %v unsupported, should be %v or %v", mode, ResponseStreamed, ResponseBuffered)
+}
+
+// bufferResponse report whether the response of route is buffered
+func (gateway *APIGateway) bufferResponse(rt *route) bool {
+	if rt.streaming() {
+		return false
+	}
+	if rt.api.ResponseMode != "" {
+		return rt.api.ResponseMode == ResponseBuffered
+	}
+	return gateway.ResponseMode == ResponseBuffered
+}
+
+// readResponse replace response body with an in-memory copy, at most limit+1 bytes
+// are read when limit is set which is enough for limitResponse to reject it
+func readResponse(resp *http.Response, limit int64) error {
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return wrapDecompressError(resp, err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	// the client gets trailers only on a chunked response
+	if len(resp.Trailer) == 0 {
+		resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	return nil
+}
+
+// retryableResponse report whether a buffered response of api can be replaced by a retry,
+// its RetryStatuses or by default 502/503/504
+func retryableResponse(api *API, resp *http.Response) bool {
+	if len(api.RetryStatuses) > 0 {
+		for _, status := range api.RetryStatuses {
+			if resp.StatusCode == status {
+				return true
+			}
+		}
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
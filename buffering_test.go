@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResponseModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		global string
+		api    string
+		status int
+		hits   int64
+		length string // Content-Length of the successful response, empty when streamed
+	}{
+		{name: "streamed by default", status: http.StatusOK, hits: 2},
+		{name: "buffered globally", global: ResponseBuffered, status: http.StatusOK, hits: 2, length: "2"},
+		{name: "buffered per api", api: ResponseBuffered, status: http.StatusOK, hits: 2, length: "2"},
+		{name: "api streams over global buffering", global: ResponseBuffered, api: ResponseStreamed, status: http.StatusOK, hits: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int64
+			// the first attempt fails after headers, the next succeeds with a chunked body
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt64(&hits, 1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.(http.Flusher).Flush()
+				fmt.Fprint(w, "ok")
+			})
+			gateway := newTestGateway(t)
+			gateway.ResponseMode = tt.global
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get",
+				Retries: 1, RetryBackoffMs: 1, ResponseMode: tt.api,
+			}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil))
+			if rec.Code != tt.status || atomic.LoadInt64(&hits) != tt.hits {
+				t.Fatalf("status %d after %d attempts, want %d after %d", rec.Code, hits, tt.status, tt.hits)
+			}
+			if got := rec.Header().Get("Content-Length"); tt.status == http.StatusOK && got != tt.length {
+				t.Errorf("Content-Length %q, want %q", got, tt.length)
+			}
+		})
+	}
+}
+
+func TestBufferResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		global string
+		api    *API
+		want   bool
+	}{
+		{name: "default", api: &API{}, want: false},
+		{name: "global", global: ResponseBuffered, api: &API{}, want: true},
+		{name: "api", api: &API{ResponseMode: ResponseBuffered}, want: true},
+		{name: "streaming api never buffered", global: ResponseBuffered, api: &API{Streaming: true}, want: false},
+	}
+	for _, tt := range tests {
+		gateway := newTestGateway(t)
+		gateway.ResponseMode = tt.global
+		if got := gateway.bufferResponse(&route{api: tt.api}); got != tt.want {
+			t.Errorf("%v: buffered %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResponseModeRejected(t *testing.T) {
+	gateway := newTestGateway(t)
+	err := gateway.Discovery.CreateService(newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get", ResponseMode: "eager"}))
+	if err == nil {
+		t.Errorf("unsupported response mode accepted")
+	}
+}
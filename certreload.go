@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCertReloadInterval is how often certificate files are checked for change
+const DefaultCertReloadInterval = 30 * time.Second
+
+// CertReloader serve the latest certificate loaded from cert/key files, files are
+// reloaded when changed so certificates can be rotated without restart
+type CertReloader struct {
+	certFile  string
+	keyFile   string
+	cert      atomic.Value // *tls.Certificate
+	mu        sync.Mutex
+	modTime   time.Time
+	lastCheck int64 // unix nano of last lazy check
+	// Logger receive reload outcomes, nil use the standard logger
+	Logger Logger
+}
+
+// NewCertReloader load certificate from certFile and keyFile
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate, handshakes in progress keep
+// the certificate they got while new handshakes use the reloaded one
+func (reloader *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return reloader.cert.Load().(*tls.Certificate), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate for upstream mTLS,
+// the files are checked lazily at most every DefaultCertReloadInterval
+func (reloader *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&reloader.lastCheck)
+	if now-last >= int64(DefaultCertReloadInterval) && atomic.CompareAndSwapInt64(&reloader.lastCheck, last, now) {
+		if reloaded, err := reloader.Reload(); err != nil {
+			orDefaultLogger(reloader.Logger).Errorf("reload client certificate: %v failed, keep using the old one: %v", reloader.certFile, err)
+		} else if reloaded {
+			orDefaultLogger(reloader.Logger).Infof("client certificate: %v reloaded", reloader.certFile)
+		}
+	}
+	return reloader.cert.Load().(*tls.Certificate), nil
+}
+
+// TLSConfig return tls config serving the reloaded certificate
+func (reloader *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: reloader.GetCertificate}
+}
+
+// Reload load the certificate again if the files changed since last load, report whether
+// a new certificate is in use, the old one stays in use when loading fails
+func (reloader *CertReloader) Reload() (bool, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	modTime, err := latestModTime(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if reloader.cert.Load() != nil && !modTime.After(reloader.modTime) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, err
+	}
+	reloader.cert.Store(&cert)
+	reloader.modTime = modTime
+	return true, nil
+}
+
+// Watch reload certificate files every interval until ctx is done
+func (reloader *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultCertReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := reloader.Reload()
+			if err != nil {
+				orDefaultLogger(reloader.Logger).Errorf("reload certificate: %v failed, keep using the old one: %v", reloader.certFile, err)
+			} else if reloaded {
+				orDefaultLogger(reloader.Logger).Infof("certificate: %v reloaded", reloader.certFile)
+			}
+		}
+	}
+}
+
+// latestModTime return the latest modification time of files
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// servedCommonName handshake with addr and return the common name of the certificate served
+func servedCommonName(t *testing.T, addr string) (string, *tls.Conn) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, conn
+}
+
+func TestCertReloaderSwap(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	dir := tempDir(t)
+	_, certV1, keyV1 := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "v1"}})
+	_, certV2, keyV2 := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "v2"}})
+	certFile := writeTestFile(t, dir, "tls.crt", certV1)
+	keyFile := writeTestFile(t, dir, "tls.key", keyV1)
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("new reloader: %v", err)
+	}
+	reloader.Logger = discardLogger
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	tlsListener := tls.NewListener(listener, reloader.TLSConfig())
+	go func() {
+		for {
+			conn, err := tlsListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				buf := make([]byte, 1)
+				conn.Read(buf)
+				conn.Close()
+			}()
+		}
+	}()
+	addr := listener.Addr().String()
+
+	name, old := servedCommonName(t, addr)
+	defer old.Close()
+	if name != "v1" {
+		t.Fatalf("served %v, want v1", name)
+	}
+	// certificates replaced with a later modification time, as a rotation does
+	steps := []struct {
+		name     string
+		cert     []byte
+		key      []byte
+		reloaded bool
+		wantErr  bool
+		want     string
+	}{
+		{name: "unchanged", want: "v1"},
+		{name: "rotated", cert: certV2, key: keyV2, reloaded: true, want: "v2"},
+		{name: "mismatched pair kept old", cert: certV1, key: keyV2, wantErr: true, want: "v2"},
+	}
+	modTime := time.Now()
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.cert != nil {
+				modTime = modTime.Add(time.Second)
+				writeTestFile(t, dir, "tls.crt", step.cert)
+				writeTestFile(t, dir, "tls.key", step.key)
+				os.Chtimes(certFile, modTime, modTime)
+				os.Chtimes(keyFile, modTime, modTime)
+			}
+			reloaded, err := reloader.Reload()
+			if reloaded != step.reloaded || (err != nil) != step.wantErr {
+				t.Fatalf("reload %v %v, want %v with error %v", reloaded, err, step.reloaded, step.wantErr)
+			}
+			name, conn := servedCommonName(t, addr)
+			conn.Close()
+			if name != step.want {
+				t.Errorf("new connection served %v, want %v", name, step.want)
+			}
+		})
+	}
+	// the connection established before the swap keeps its certificate
+	if name := old.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "v1" {
+		t.Errorf("established connection switched to %v", name)
+	}
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// ClientCertHeaders name the headers carrying verified client certificate details to backends,
+// empty name disable forwarding of that field
+type ClientCertHeaders struct {
+	Subject     string // certificate subject distinguished name
+	Issuer      string // issuer distinguished name
+	SAN         string // subject alternative names, e.g. DNS:a.example.com,URI:spiffe://x
+	Fingerprint string // hex encoded sha256 of the certificate
+}
+
+// DefaultClientCertHeaders return the default header names of client certificate details
+func DefaultClientCertHeaders() *ClientCertHeaders {
+	return &ClientCertHeaders{
+		Subject:     "X-Client-Cert-Subject",
+		Issuer:      "X-Client-Cert-Issuer",
+		SAN:         "X-Client-Cert-SAN",
+		Fingerprint: "X-Client-Cert-Fingerprint",
+	}
+}
+
+// forward strip client supplied certificate headers of req, then set the details
+// of the verified client certificate
+func (h *ClientCertHeaders) forward(req *http.Request) {
+	if h == nil {
+		return
+	}
+	fields := []struct {
+		name  string
+		value func(cert *x509.Certificate) string
+	}{
+		{h.Subject, func(cert *x509.Certificate) string { return cert.Subject.String() }},
+		{h.Issuer, func(cert *x509.Certificate) string { return cert.Issuer.String() }},
+		{h.SAN, certSAN},
+		{h.Fingerprint, func(cert *x509.Certificate) string {
+			sum := sha256.Sum256(cert.Raw)
+			return hex.EncodeToString(sum[:])
+		}},
+	}
+	// only certificates verified by the listener are trusted
+	var cert *x509.Certificate
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.PeerCertificates) > 0 {
+		cert = req.TLS.PeerCertificates[0]
+	}
+	for _, field := range fields {
+		if field.name == "" {
+			continue
+		}
+		req.Header.Del(field.name)
+		if cert != nil {
+			req.Header.Set(field.name, field.value(cert))
+		}
+	}
+}
+
+// certSAN join subject alternative names of cert
+func certSAN(cert *x509.Certificate) string {
+	var names []string
+	for _, name := range cert.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	return strings.Join(names, ",")
+}
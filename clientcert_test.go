@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA issue certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCA create a self-signed certificate authority named name
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create ca: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue sign a certificate for template, usable by servers and clients, return it with
+// its PEM certificate and key
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("issue certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("key pair: %v", err)
+	}
+	return pair, certPEM, keyPEM
+}
+
+// pool return a cert pool trusting ca
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// tempDir create a directory removed with the test
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "go-gateway-test")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeTestFile write data to name in dir, return its path
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write %v: %v", path, err)
+	}
+	return path
+}
+
+// certEchoBackend answer with the client certificate headers it received
+func certEchoBackend(t *testing.T) string {
+	t.Helper()
+	return newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		h := DefaultClientCertHeaders()
+		fmt.Fprintf(w, "%v|%v|%v|%v", r.Header.Get(h.Subject), r.Header.Get(h.Issuer),
+			r.Header.Get(h.SAN), r.Header.Get(h.Fingerprint))
+	})
+}
+
+func TestClientCertHeaders(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	spiffe, _ := url.Parse("spiffe://example.org/client")
+	client, _, _ := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client", Organization: []string{"acme"}},
+		DNSNames:    []string{"client.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:        []*url.URL{spiffe},
+	})
+	leaf, _ := x509.ParseCertificate(client.Certificate[0])
+	sum := sha256.Sum256(leaf.Raw)
+	verified := fmt.Sprintf("CN=client,O=acme|CN=test ca|DNS:client.example.com,IP:10.0.0.1,URI:spiffe://example.org/client|%v",
+		hex.EncodeToString(sum[:]))
+	verifiedState := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}, VerifiedChains: [][]*x509.Certificate{{leaf, ca.cert}}}
+	unverifiedState := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	spoofed := map[string]string{
+		"X-Client-Cert-Subject":     "CN=admin",
+		"X-Client-Cert-Issuer":      "CN=evil ca",
+		"X-Client-Cert-SAN":         "DNS:admin",
+		"X-Client-Cert-Fingerprint": "00",
+	}
+	tests := []struct {
+		name     string
+		state    *tls.ConnectionState
+		spoofed  bool
+		headers  *ClientCertHeaders
+		expected string
+	}{
+		{name: "verified", state: verifiedState, headers: DefaultClientCertHeaders(), expected: verified},
+		{name: "verified over spoofed", state: verifiedState, spoofed: true, headers: DefaultClientCertHeaders(), expected: verified},
+		{name: "spoofed over plain http", spoofed: true, headers: DefaultClientCertHeaders(), expected: "|||"},
+		{name: "spoofed with unverified cert", state: unverifiedState, spoofed: true, headers: DefaultClientCertHeaders(), expected: "|||"},
+		{name: "spoofed over tls without cert", state: &tls.ConnectionState{}, spoofed: true, headers: DefaultClientCertHeaders(), expected: "|||"},
+		{
+			name:     "subject only",
+			state:    verifiedState,
+			headers:  &ClientCertHeaders{Subject: "X-Client-Cert-Subject"},
+			expected: "CN=client,O=acme|||",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			gateway.ClientCertHeaders = tt.headers
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "whoami", HTTPMethod: http.MethodGet, Host: certEchoBackend(t), Path: "whoami"}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/whoami", nil)
+			req.TLS = tt.state
+			if tt.spoofed {
+				for k, v := range spoofed {
+					req.Header.Set(k, v)
+				}
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Body.String() != tt.expected {
+				t.Errorf("backend got %q, want %q", rec.Body.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestClientCAListener(t *testing.T) {
+	ca := newTestCA(t, "client ca")
+	other := newTestCA(t, "other ca")
+	dir := tempDir(t)
+	_, serverCert, serverKey := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}})
+	trusted, _, _ := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client"}})
+	untrusted, _, _ := other.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}})
+
+	gateway := newTestGateway(t, WithProxyAddr("127.0.0.1:0"))
+	gateway.TLSCertFile = writeTestFile(t, dir, "server.crt", serverCert)
+	gateway.TLSKeyFile = writeTestFile(t, dir, "server.key", serverKey)
+	gateway.TLSClientCAFile = writeTestFile(t, dir, "ca.crt", ca.pem)
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "whoami", HTTPMethod: http.MethodGet, Host: certEchoBackend(t), Path: "whoami"}))
+	errs := make(chan error, 1)
+	go func() { errs <- gateway.RunProxy() }()
+	addr := waitAddr(t, gateway.ProxyAddr)
+	defer func() {
+		gateway.Shutdown(context.Background())
+		<-errs
+	}()
+
+	tests := []struct {
+		name    string
+		cert    *tls.Certificate // presented whatever CAs the gateway asks for
+		subject string
+		wantErr bool
+	}{
+		{name: "trusted client cert", cert: &trusted, subject: "CN=client"},
+		{name: "no client cert", subject: ""},
+		{name: "untrusted client cert", cert: &untrusted, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &tls.Config{RootCAs: ca.pool()}
+			if tt.cert != nil {
+				config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return tt.cert, nil
+				}
+			}
+			transport := &http.Transport{TLSClientConfig: config}
+			defer transport.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: transport}).Get(fmt.Sprintf("https://%v/svc/whoami", addr))
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("untrusted client certificate accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if subject := strings.SplitN(string(body), "|", 2)[0]; resp.StatusCode != http.StatusOK || subject != tt.subject {
+				t.Errorf("got %d %q, want subject %q", resp.StatusCode, body, tt.subject)
+			}
+		})
+	}
+}
+
+func TestClientCAWithoutCertificate(t *testing.T) {
+	gateway := newTestGateway(t, WithProxyAddr("127.0.0.1:0"))
+	gateway.TLSClientCAFile = writeTestFile(t, tempDir(t), "ca.crt", newTestCA(t, "ca").pem)
+	if err := gateway.RunProxy(); err == nil {
+		t.Errorf("client ca without a certificate accepted")
+	}
+}
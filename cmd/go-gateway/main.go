@@ -0,0 +1,218 @@
+// Command go-gateway run the api gateway standalone, import the module root
+// package to embed the gateway in another binary
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	gateway "github.com/PualrDwade/go-gateway"
+)
+
+func main() {
+	reusePort := flag.Bool("reuseport", false, "bind listeners with SO_REUSEPORT for zero-downtime restarts (linux only)")
+	idempotent := flag.Bool("idempotent", false, "re-registering identical service or api succeeds instead of failing")
+	tlsCert := flag.String("tls-cert", "", "certificate file to serve the proxy over https, reloaded on change")
+	tlsKey := flag.String("tls-key", "", "private key file of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA file verifying client certificates of -tls-cert, forwarded to backends as X-Client-Cert-* headers")
+	serverTLSCert := flag.String("server-tls-cert", "", "certificate file to serve the native api server over https, reloaded on change")
+	serverTLSKey := flag.String("server-tls-key", "", "private key file of -server-tls-cert")
+	latencySLA := flag.Duration("latency-sla", 0, "shed load with 503 while recent p99 latency exceeds it, 0 disable")
+	serverAddr := flag.String("server-addr", gateway.DefaultServerListenAddr, "listen address of native api server, :0 pick a random port")
+	proxyAddr := flag.String("proxy-addr", gateway.DefaultProxyListenAddr, "listen address of proxy, :0 pick a random port")
+	verboseNotFound := flag.Bool("verbose-404", false, "tell unknown service from unknown api in 404 response details")
+	shutdownTimeout := flag.Duration("shutdown-timeout", gateway.DefaultShutdownTimeout, "force close connections still open after it on shutdown, 0 wait forever")
+	retryBudget := flag.Float64("retry-budget", gateway.DefaultRetryBudget, "max fraction of requests that may be retries across the gateway")
+	responseMode := flag.String("response-mode", gateway.ResponseStreamed, "streamed or buffered, buffered read backend response entirely before sending it")
+	pipeline := flag.String("pipeline", strings.Join(gateway.DefaultPipeline, ","), "comma separated order of stages run on resolved requests")
+	answerOptions := flag.Bool("answer-options", false, "answer OPTIONS requests with allowed methods instead of proxying them")
+	kubeIngress := flag.Bool("kube-ingress", false, "serve routes of Kubernetes ingresses, using in-cluster config unless -kubeconfig is set")
+	kubeconfig := flag.String("kubeconfig", "", "kubeconfig in JSON form (kubectl config view --minify --flatten -o json) for -kube-ingress")
+	drainPeriod := flag.Duration("drain-period", gateway.DefaultDrainPeriod, "let in-flight requests of a backend turned unhealthy complete within it before canceling them")
+	ejectionPeriod := flag.Duration("ejection-period", gateway.DefaultEjectionPeriod, "avoid a backend turned unhealthy for it before requests try it again")
+	duplicateWindow := flag.Duration("duplicate-window", 0, "log requests repeating method, uri and -fingerprint-headers of the same client within it, 0 disable")
+	fingerprintHeaders := flag.String("fingerprint-headers", "", "comma separated headers included in request fingerprint")
+	retryAfter := flag.Duration("retry-after", gateway.DefaultRetryAfter, "Retry-After advertised on overload 503 responses")
+	streamIdleTimeout := flag.Duration("stream-idle-timeout", gateway.DefaultStreamIdleTimeout, "close streaming connections idle for it, 0 never")
+	maxBodyBytes := flag.Int64("max-body-bytes", 0, "max client request body size, services and apis may override it, 0 unlimited")
+	config := flag.String("config", "", "json file of services with their apis registered at startup")
+	catchRemainder := flag.Bool("catch-remainder", false, "append path after /{service}/{api} to the backend path of every api")
+	compress := flag.Bool("compress", false, "gzip responses of every api for clients accepting gzip")
+	compressMinBytes := flag.Int64("compress-min-bytes", gateway.DefaultCompressMinBytes, "smallest response body gzipped, 0 gzip any size")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated addresses or CIDRs of proxies whose X-Forwarded-For is honored")
+	maxConcurrent := flag.Int("max-concurrent", 0, "max in-flight requests across all apis, on top of api maxConcurrent, 0 unlimited")
+	queueTimeout := flag.Duration("queue-timeout", 0, "wait up to it for a free slot when -max-concurrent is reached, 0 reject at once")
+	maxIdleConns := flag.Int("max-idle-conns", gateway.DefaultMaxIdleConns, "max idle connections kept to all backends, 0 no limit")
+	maxIdleConnsPerHost := flag.Int("max-idle-conns-per-host", gateway.DefaultMaxIdleConnsPerHost, "max idle connections kept to each backend")
+	idleConnTimeout := flag.Duration("idle-conn-timeout", gateway.DefaultIdleConnTimeout, "close idle backend connections after it, 0 never")
+	logLevel := flag.String("log-level", gateway.LevelInfo.String(), "lowest level logged: debug, info, warn or error")
+	accessLog := flag.String("access-log", "", "access log format written to stdout: common, combined or json, empty disable")
+	consulAddr := flag.String("consul-addr", "", "Consul http address resolving hosts of apis with consulService, token read from CONSUL_HTTP_TOKEN")
+	consulInterval := flag.Duration("consul-interval", gateway.DefaultConsulInterval, "how often hosts are refreshed from Consul")
+	redisAddr := flag.String("redis-addr", "", "redis address storing the registry shared by gateways, password read from REDIS_PASSWORD")
+	redisDB := flag.Int("redis-db", 0, "redis database of -redis-addr")
+	redisPrefix := flag.String("redis-prefix", gateway.DefaultRedisPrefix, "prefix of the registry keys in redis")
+	redisInterval := flag.Duration("redis-interval", gateway.DefaultRedisInterval, "how often changes made by other gateways are picked up from redis")
+	flag.Parse()
+	if *responseMode != gateway.ResponseStreamed && *responseMode != gateway.ResponseBuffered {
+		log.Fatalf("response mode: %v unsupported, should be %v or %v", *responseMode, gateway.ResponseStreamed, gateway.ResponseBuffered)
+	}
+	if *maxBodyBytes < 0 || *maxBodyBytes > gateway.MaxBodyBytesCeiling {
+		log.Fatalf("max body bytes: %v should be within [0, %v]", *maxBodyBytes, gateway.MaxBodyBytesCeiling)
+	}
+	// each of them replaces the registry, only one can serve it
+	discoveries := 0
+	for _, selected := range []bool{*kubeIngress, *redisAddr != "", *consulAddr != ""} {
+		if selected {
+			discoveries++
+		}
+	}
+	if discoveries > 1 {
+		log.Fatal("-kube-ingress, -redis-addr and -consul-addr select different discoveries, set at most one")
+	}
+	level, err := gateway.ParseLogLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := gateway.NewStdLogger(nil, level)
+	cacheOptions := []gateway.CacheOption{gateway.WithCacheLogger(logger)}
+	if *idempotent {
+		cacheOptions = append(cacheOptions, gateway.WithIdempotentCreate())
+	}
+	discovery := gateway.NewCacheDiscovery(cacheOptions...)
+	if *kubeIngress {
+		config, err := gateway.InClusterKubeConfig()
+		if *kubeconfig != "" {
+			config, err = gateway.LoadKubeConfig(*kubeconfig)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		kube, err := gateway.NewKubeDiscovery(config, cacheOptions...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go kube.Watch(context.Background())
+		discovery = kube
+	}
+	if *redisAddr != "" {
+		client := gateway.NewRedisClient(*redisAddr, os.Getenv("REDIS_PASSWORD"), *redisDB)
+		redis, err := gateway.NewRedisDiscovery(client, *redisPrefix, cacheOptions...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go redis.Watch(context.Background(), *redisInterval)
+		discovery = redis
+	}
+	var consul *gateway.ConsulDiscovery
+	if *consulAddr != "" {
+		var err error
+		consul, err = gateway.NewConsulDiscovery(*consulAddr, os.Getenv("CONSUL_HTTP_TOKEN"), cacheOptions...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		discovery = consul
+	}
+	apigateway := gateway.NewAPIGateWay(
+		gateway.WithServerAddr(*serverAddr),
+		gateway.WithProxyAddr(*proxyAddr),
+		gateway.WithDiscovery(discovery),
+		gateway.WithLogger(logger),
+	)
+	apigateway.ReusePort = *reusePort
+	apigateway.LatencySLA = *latencySLA
+	apigateway.RetryBudget = *retryBudget
+	apigateway.DrainPeriod = *drainPeriod
+	apigateway.EjectionPeriod = *ejectionPeriod
+	apigateway.RetryAfter = *retryAfter
+	apigateway.StreamIdleTimeout = *streamIdleTimeout
+	apigateway.MaxBodyBytes = *maxBodyBytes
+	apigateway.DuplicateWindow = *duplicateWindow
+	if *fingerprintHeaders != "" {
+		apigateway.FingerprintHeaders = strings.Split(*fingerprintHeaders, ",")
+	}
+	apigateway.ResponseMode = *responseMode
+	apigateway.VerboseNotFound = *verboseNotFound
+	apigateway.CatchRemainder = *catchRemainder
+	apigateway.AnswerOptions = *answerOptions
+	apigateway.AccessLogFormat = *accessLog
+	apigateway.Compress = *compress
+	apigateway.CompressMinBytes = *compressMinBytes
+	apigateway.MaxConcurrent = *maxConcurrent
+	apigateway.QueueTimeout = *queueTimeout
+	apigateway.MaxIdleConns = *maxIdleConns
+	apigateway.MaxIdleConnsPerHost = *maxIdleConnsPerHost
+	apigateway.IdleConnTimeout = *idleConnTimeout
+	apigateway.TLSCertFile = *tlsCert
+	apigateway.TLSKeyFile = *tlsKey
+	apigateway.TLSClientCAFile = *tlsClientCA
+	apigateway.ServerTLSCertFile = *serverTLSCert
+	apigateway.ServerTLSKeyFile = *serverTLSKey
+	if *trustedProxies != "" {
+		if err := apigateway.SetTrustedProxies(strings.Split(*trustedProxies, ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := apigateway.SetPipeline(strings.Split(*pipeline, ",")); err != nil {
+		log.Fatal(err)
+	}
+	if *config != "" {
+		if err := apigateway.LoadConfig(*config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if consul != nil {
+		// started after the config is loaded so that its apis are resolved at once
+		go consul.Watch(context.Background(), *consulInterval)
+	}
+	if err := apigateway.StartHealthChecks(context.Background()); err != nil {
+		logger.Warnf("health checks disabled: %v", err)
+	}
+	// readyz answer ok once both listeners below are bound
+	apigateway.SetReady(true)
+	go func() {
+		if err := apigateway.RunProxy(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	go func() {
+		if err := apigateway.RunServer(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signalChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if *config == "" {
+			logger.Warnf("got SIGHUP without -config, nothing to reload")
+			continue
+		}
+		if err := apigateway.ReloadConfig(*config); err != nil {
+			logger.Errorf("reload failed: %v", err)
+			continue
+		}
+		logger.Infof("config: %v reloaded", *config)
+		if consul != nil {
+			// reloaded apis resolve their hosts at once instead of on the next tick
+			go consul.Refresh(context.Background())
+		}
+	}
+	logger.Infof("got os shutdown signal, shutting down go-gateway server gracefully...")
+	ctx := context.Background()
+	if *shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *shutdownTimeout)
+		defer cancel()
+	}
+	if err := apigateway.Shutdown(ctx); err != nil {
+		logger.Errorf("shutdown failed: %v", err)
+	}
+}
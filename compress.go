@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsGzip report whether the Accept-Encoding of header allows gzip
+func acceptsGzip(header http.Header) bool {
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			fields := strings.Split(part, ";")
+			coding := strings.ToLower(strings.TrimSpace(fields[0]))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			// gzip;q=0 explicitly refuse it
+			refused := false
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+					refused = err == nil && q == 0
+				}
+			}
+			return !refused
+		}
+	}
+	return false
+}
+
+// DefaultCompressMinBytes is the smallest response body gzipped, gzip barely shrinks
+// smaller ones
+const DefaultCompressMinBytes = 1024
+
+// compressedTypes are media types already compressed, gzip only costs cpu on them
+var compressedTypes = []string{
+	"image/", "video/", "audio/", "font/woff", "application/zip", "application/gzip",
+	"application/x-gzip", "application/zstd", "application/x-7z-compressed",
+	"application/x-rar-compressed", "application/x-bzip2", "application/pdf",
+}
+
+// compressible report whether the body of resp is worth gzipping, bodies of unknown
+// length are
+func (gateway *APIGateway) compressible(resp *http.Response) bool {
+	if resp.ContentLength >= 0 && resp.ContentLength < gateway.CompressMinBytes {
+		return false
+	}
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	// svg is text
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		return true
+	}
+	for _, prefix := range compressedTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressResponse gzip the backend response of apis with Compress, or of every api when
+// the gateway Compress, when the client accepts gzip, the backend did not encode it already
+// and the body is compressible
+func (gateway *APIGateway) compressResponse(resp *http.Response) {
+	rt := routeOf(resp.Request.Context())
+	if rt == nil || !(rt.api.Compress || gateway.Compress) || rt.streaming() {
+		return
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.Request.Method == http.MethodHead ||
+		!bodyAllowed(resp.StatusCode) || !gateway.compressible(resp) {
+		return
+	}
+	resp.Header.Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(resp.Request.Header) {
+		return
+	}
+	body := resp.Body
+	reader, writer := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(writer)
+		_, err := io.Copy(gz, body)
+		if err == nil {
+			err = gz.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{reader, closerFunc(func() error {
+		reader.Close()
+		return body.Close()
+	})}
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+}
+
+// closerFunc adapt a function to io.Closer
+type closerFunc func() error
+
+// Close implements io.Closer
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// bodyAllowed report whether a response with status may have a body
+func bodyAllowed(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressAPI(t *testing.T) {
+	large := strings.Repeat(`{"name":"ann"},`, 200)
+	tests := []struct {
+		name           string
+		compress       bool
+		globalCompress bool
+		method         string
+		acceptEncoding string
+		contentType    string
+		encoding       string // Content-Encoding set by the backend
+		body           string
+		gzipped        bool
+		vary           bool
+	}{
+		{name: "client accepts gzip", compress: true, acceptEncoding: "gzip, deflate", body: large, gzipped: true, vary: true},
+		{name: "client accepts any", compress: true, acceptEncoding: "*", body: large, gzipped: true, vary: true},
+		{name: "client does not accept gzip", compress: true, body: large, vary: true},
+		{name: "client refuses gzip", compress: true, acceptEncoding: "gzip;q=0, identity", body: large, vary: true},
+		{name: "api not compressed", acceptEncoding: "gzip", body: large},
+		{name: "gateway compresses every api", globalCompress: true, acceptEncoding: "gzip", body: large, gzipped: true, vary: true},
+		{name: "small body", compress: true, acceptEncoding: "gzip", body: "{}"},
+		{name: "already compressed type", compress: true, acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "svg compressed", compress: true, acceptEncoding: "gzip", contentType: "image/svg+xml", body: large, gzipped: true, vary: true},
+		{name: "encoded by backend", compress: true, acceptEncoding: "gzip", encoding: "br", body: large},
+		{name: "head", compress: true, method: http.MethodHead, acceptEncoding: "gzip", body: large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				contentType := tt.contentType
+				if contentType == "" {
+					contentType = "application/json"
+				}
+				w.Header().Set("Content-Type", contentType)
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write([]byte(tt.body))
+			})
+			gateway := newTestGateway(t)
+			gateway.Compress = tt.globalCompress
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethods: []string{http.MethodGet, http.MethodHead}, Host: backend, Path: "get", Compress: tt.compress}))
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/svc/get", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+			if vary := strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding"); vary != tt.vary {
+				t.Errorf("Vary %q, want Accept-Encoding %v", rec.Header().Get("Vary"), tt.vary)
+			}
+			if gzipped := rec.Header().Get("Content-Encoding") == "gzip"; gzipped != tt.gzipped {
+				t.Fatalf("Content-Encoding %q, want gzip %v", rec.Header().Get("Content-Encoding"), tt.gzipped)
+			}
+			want := tt.body
+			if method == http.MethodHead {
+				want = ""
+			}
+			body := rec.Body.String()
+			if tt.gzipped {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("gzip: %v", err)
+				}
+				data, err := ioutil.ReadAll(zr)
+				if err != nil {
+					t.Fatalf("gunzip: %v", err)
+				}
+				body = string(data)
+			}
+			if body != want {
+				t.Errorf("body %q, want %q", body, want)
+			}
+		})
+	}
+}
+
+func TestCompressMinBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		minBytes int64
+		body     string
+		chunked  bool
+		gzipped  bool
+	}{
+		{name: "below default", minBytes: DefaultCompressMinBytes, body: strings.Repeat("a", DefaultCompressMinBytes-1)},
+		{name: "at default", minBytes: DefaultCompressMinBytes, body: strings.Repeat("a", DefaultCompressMinBytes), gzipped: true},
+		{name: "lowered threshold", minBytes: 10, body: strings.Repeat("a", 10), gzipped: true},
+		{name: "raised threshold", minBytes: 2000, body: strings.Repeat("a", 1999)},
+		{name: "unknown length", minBytes: 4096, body: "small", chunked: true, gzipped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(tt.body))
+				if tt.chunked {
+					w.(http.Flusher).Flush()
+				}
+			})
+			gateway := newTestGateway(t)
+			gateway.Compress = true
+			gateway.CompressMinBytes = tt.minBytes
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := serveProxy(gateway, req)
+			if gzipped := rec.Header().Get("Content-Encoding") == "gzip"; gzipped != tt.gzipped {
+				t.Errorf("Content-Encoding %q, want gzip %v", rec.Header().Get("Content-Encoding"), tt.gzipped)
+			}
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       bool
+	}{
+		{acceptEncoding: "gzip", expected: true},
+		{acceptEncoding: "GZIP", expected: true},
+		{acceptEncoding: "br, gzip;q=0.5", expected: true},
+		{acceptEncoding: "*;q=0.1", expected: true},
+		{acceptEncoding: "gzip;q=0"},
+		{acceptEncoding: "br, deflate"},
+		{acceptEncoding: "identity"},
+		{acceptEncoding: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			header := http.Header{}
+			if tt.acceptEncoding != "" {
+				header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			if got := acceptsGzip(header); got != tt.expected {
+				t.Errorf("accepts gzip %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
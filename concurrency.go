@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// concurrencyLimiter bound in-flight requests of an api, excess requests wait in
+// queue up to the queue timeout before rejected
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	queueDepth  int64 // requests currently waiting
+	queued      int64 // requests which waited in queue
+	queueWaitNs int64 // total wait time of queued requests
+	rejected    int64 // requests rejected because limit reached
+}
+
+// ConcurrencyStats is a snapshot of an api concurrency limiter
+type ConcurrencyStats struct {
+	MaxConcurrent  int     `json:"maxConcurrent"`
+	InFlight       int     `json:"inFlight"`
+	QueueDepth     int64   `json:"queueDepth"`
+	QueuedTotal    int64   `json:"queuedTotal"`
+	QueueWaitMs    float64 `json:"queueWaitMsTotal"`
+	QueueWaitAvgMs float64 `json:"queueWaitMsAvg"`
+	RejectedTotal  int64   `json:"rejectedTotal"`
+	QueueTimeoutMs int64   `json:"queueTimeoutMs"`
+}
+
+// newConcurrencyLimiter create limiter allowing max in-flight requests
+func newConcurrencyLimiter(max int, queueTimeout time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, max), queueTimeout: queueTimeout}
+}
+
+// acquire take a slot, waiting in queue if configured, report whether the slot is taken
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.queueTimeout <= 0 {
+		atomic.AddInt64(&l.rejected, 1)
+		return false
+	}
+	start := time.Now()
+	atomic.AddInt64(&l.queueDepth, 1)
+	defer func() {
+		atomic.AddInt64(&l.queueDepth, -1)
+		atomic.AddInt64(&l.queued, 1)
+		atomic.AddInt64(&l.queueWaitNs, int64(time.Since(start)))
+	}()
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	atomic.AddInt64(&l.rejected, 1)
+	return false
+}
+
+// release give back the slot taken by acquire
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
+
+// stats return the limiter snapshot
+func (l *concurrencyLimiter) stats() ConcurrencyStats {
+	queued := atomic.LoadInt64(&l.queued)
+	waitMs := float64(atomic.LoadInt64(&l.queueWaitNs)) / float64(time.Millisecond)
+	stats := ConcurrencyStats{
+		MaxConcurrent:  cap(l.slots),
+		InFlight:       len(l.slots),
+		QueueDepth:     atomic.LoadInt64(&l.queueDepth),
+		QueuedTotal:    queued,
+		QueueWaitMs:    waitMs,
+		RejectedTotal:  atomic.LoadInt64(&l.rejected),
+		QueueTimeoutMs: int64(l.queueTimeout / time.Millisecond),
+	}
+	if queued > 0 {
+		stats.QueueWaitAvgMs = waitMs / float64(queued)
+	}
+	return stats
+}
+
+// globalConcurrency return the limiter shared by all apis, nil when MaxConcurrent is zero
+func (gateway *APIGateway) globalConcurrency() *concurrencyLimiter {
+	if gateway.MaxConcurrent <= 0 {
+		return nil
+	}
+	gateway.concurrencyOnce.Do(func() {
+		gateway.concurrency = newConcurrencyLimiter(gateway.MaxConcurrent, gateway.QueueTimeout)
+	})
+	return gateway.concurrency
+}
+
+// acquireConcurrency take a slot of the gateway limiter then of the api limiter, write 503
+// and return false when either limit is reached, release give the slots back
+func (gateway *APIGateway) acquireConcurrency(w http.ResponseWriter, r *http.Request, api *API) (ok bool, release func()) {
+	global := gateway.globalConcurrency()
+	if global != nil && !global.acquire(r) {
+		gateway.throttle(w, r, http.StatusServiceUnavailable, "gateway too many concurrent requests", 0)
+		return false, nil
+	}
+	if api.concurrency != nil && !api.concurrency.acquire(r) {
+		if global != nil {
+			global.release()
+		}
+		gateway.throttle(w, r, http.StatusServiceUnavailable, fmt.Sprintf("api: %v too many concurrent requests", api.Name), 0)
+		return false, nil
+	}
+	return true, func() {
+		if api.concurrency != nil {
+			api.concurrency.release()
+		}
+		if global != nil {
+			global.release()
+		}
+	}
+}
+
+// Stats handle http request to show runtime stats of the gateway
+func (gateway *APIGateway) Stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, gateway.stats())
+}
+
+// stats collect runtime stats of load shedding, retry budget and concurrency limiters, the
+// gateway limiter is keyed "*"
+func (gateway *APIGateway) stats() map[string]interface{} {
+	concurrency := make(map[string]ConcurrencyStats)
+	breakers := make(map[string]BreakerStats)
+	if lister, ok := gateway.Discovery.(routeSnapshot); ok {
+		services, _ := lister.snapshot()
+		for _, service := range services {
+			for _, api := range service.APIs {
+				if api.concurrency != nil {
+					concurrency[service.Name+"/"+api.Name] = api.concurrency.stats()
+				}
+				if api.breaker != nil {
+					breakers[service.Name+"/"+api.Name] = api.breaker.stats()
+				}
+			}
+		}
+	}
+	if global := gateway.globalConcurrency(); global != nil {
+		concurrency["*"] = global.stats()
+	}
+	return map[string]interface{}{
+		"shedRate":    gateway.ShedRate(),
+		"concurrency": concurrency,
+		"breakers":    breakers,
+		"retryBudget": gateway.retryBudget().stats(),
+	}
+}
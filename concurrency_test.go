@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiConcurrency read the concurrency stats of service/api from /stats
+func apiConcurrency(t *testing.T, gateway *APIGateway, key string) ConcurrencyStats {
+	t.Helper()
+	rec := serveAdmin(gateway, http.MethodGet, "/stats", "")
+	var stats struct {
+		Concurrency map[string]ConcurrencyStats `json:"concurrency"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode stats %q: %v", rec.Body.String(), err)
+	}
+	return stats.Concurrency[key]
+}
+
+func TestConcurrencyQueueMetrics(t *testing.T) {
+	tests := []struct {
+		name         string
+		queueTimeout int
+		clients      int
+		depth        int64 // queue depth once all clients arrived
+		statuses     map[int]int
+		queued       int64
+		rejected     int64
+	}{
+		{
+			name:         "queued until released",
+			queueTimeout: 5000,
+			clients:      3,
+			depth:        2,
+			statuses:     map[int]int{http.StatusOK: 3},
+			queued:       2,
+		},
+		{
+			name:     "rejected without queue",
+			clients:  3,
+			statuses: map[int]int{http.StatusOK: 1, http.StatusServiceUnavailable: 2},
+			rejected: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrived := make(chan struct{}, tt.clients)
+			unblock := make(chan struct{})
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				arrived <- struct{}{}
+				<-unblock
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name: "slow", HTTPMethod: http.MethodGet, Host: backend, Path: "slow",
+				MaxConcurrent: 1, QueueTimeoutMs: tt.queueTimeout,
+			}))
+			var mu sync.Mutex
+			statuses := make(map[int]int)
+			var wg sync.WaitGroup
+			send := func() {
+				defer wg.Done()
+				rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/slow", nil))
+				mu.Lock()
+				statuses[rec.Code]++
+				mu.Unlock()
+			}
+			wg.Add(1)
+			go send()
+			<-arrived
+			for i := 1; i < tt.clients; i++ {
+				wg.Add(1)
+				go send()
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				stats := apiConcurrency(t, gateway, "svc/slow")
+				if stats.InFlight == 1 && stats.QueueDepth == tt.depth && stats.RejectedTotal == tt.rejected {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("stats under load %+v, want queue depth %d rejected %d", stats, tt.depth, tt.rejected)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			close(unblock)
+			wg.Wait()
+			stats := apiConcurrency(t, gateway, "svc/slow")
+			if stats.InFlight != 0 || stats.QueueDepth != 0 || stats.QueuedTotal != tt.queued || stats.RejectedTotal != tt.rejected {
+				t.Errorf("stats after load %+v, want queued %d rejected %d", stats, tt.queued, tt.rejected)
+			}
+			if tt.queued > 0 && stats.QueueWaitMs <= 0 {
+				t.Errorf("queue wait not recorded: %+v", stats)
+			}
+			for status, count := range tt.statuses {
+				if statuses[status] != count {
+					t.Errorf("statuses %v, want %v", statuses, tt.statuses)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestStatsMethodNotAllowed(t *testing.T) {
+	rec := serveAdmin(newTestGateway(t), http.MethodDelete, "/stats", "")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("got %d Allow %q, want 405 Allow GET", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestGlobalConcurrency(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxConcurrent int
+		queueTimeout  time.Duration
+		status        int // of a request to another api while the limit is saturated
+		waits         bool
+	}{
+		{name: "unlimited", status: http.StatusOK},
+		{name: "rejected without queue", maxConcurrent: 1, status: http.StatusServiceUnavailable},
+		{name: "queued until released", maxConcurrent: 1, queueTimeout: 5 * time.Second, status: http.StatusOK, waits: true},
+		{name: "queue timeout", maxConcurrent: 1, queueTimeout: 50 * time.Millisecond, status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrived := make(chan struct{}, 1)
+			unblock := make(chan struct{})
+			slow := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				arrived <- struct{}{}
+				<-unblock
+			})
+			gateway := newTestGateway(t)
+			gateway.MaxConcurrent, gateway.QueueTimeout = tt.maxConcurrent, tt.queueTimeout
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "slow", HTTPMethod: http.MethodGet, Host: slow, Path: "slow"},
+				&API{Name: "fast", HTTPMethod: http.MethodGet, Host: namedBackend(t, "fast"), Path: "fast"}))
+			done := make(chan int, 1)
+			go func() { done <- serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/slow", nil)).Code }()
+			<-arrived
+			statuses := make(chan int, 1)
+			go func() { statuses <- serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/fast", nil)).Code }()
+			if tt.waits {
+				select {
+				case status := <-statuses:
+					t.Fatalf("queued request answered %d before a slot was free", status)
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(unblock)
+			}
+			if status := <-statuses; status != tt.status {
+				t.Errorf("status %d, want %d", status, tt.status)
+			}
+			if !tt.waits {
+				close(unblock)
+			}
+			if status := <-done; status != http.StatusOK {
+				t.Errorf("slow request status %d, want 200", status)
+			}
+			stats := apiConcurrency(t, gateway, "*")
+			if tt.maxConcurrent > 0 && (stats.MaxConcurrent != tt.maxConcurrent || stats.InFlight != 0) {
+				t.Errorf("gateway stats %+v, want limit %d and nothing in flight", stats, tt.maxConcurrent)
+			}
+		})
+	}
+}
+
+func TestGlobalSlotReleasedOnAPIReject(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-unblock
+	})
+	gateway := newTestGateway(t)
+	gateway.MaxConcurrent = 2
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "slow", HTTPMethod: http.MethodGet, Host: backend, Path: "slow", MaxConcurrent: 1}))
+	done := make(chan int, 1)
+	go func() { done <- serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/slow", nil)).Code }()
+	<-arrived
+	defer func() {
+		close(unblock)
+		<-done
+	}()
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "first rejected by api limit", status: http.StatusServiceUnavailable},
+		{name: "second rejected by api limit", status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/slow", nil)); rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+			if stats := apiConcurrency(t, gateway, "*"); stats.InFlight != 1 || stats.RejectedTotal != 0 {
+				t.Errorf("gateway stats %+v, want only the slow request in flight", stats)
+			}
+		})
+	}
+}
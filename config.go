@@ -0,0 +1,201 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// readConfig parse a json file holding an array of Service objects with their nested apis,
+// the first malformed entry fails the read with its position and name
+func readConfig(path string) ([]*Service, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v read failed: %v", path, err)
+	}
+	var services []*Service
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, fmt.Errorf("config: %v malformed: %v", path, err)
+	}
+	seen := make(map[string]bool, len(services))
+	for i, service := range services {
+		if service == nil {
+			return nil, fmt.Errorf("config: %v service[%d] can not be null", path, i)
+		}
+		if seen[service.Name] {
+			return nil, fmt.Errorf("config: %v service[%d] %v duplicated", path, i, service.Name)
+		}
+		seen[service.Name] = true
+		for name, api := range service.APIs {
+			if api == nil {
+				return nil, fmt.Errorf("config: %v service[%d] %v api: %v can not be null", path, i, service.Name, name)
+			}
+			// the map key name the api, the enclosing service own it
+			if api.Name == "" {
+				api.Name = name
+			}
+			if api.Service == "" {
+				api.Service = service.Name
+			}
+			if api.Name != name || api.Service != service.Name {
+				return nil, fmt.Errorf("config: %v service[%d] %v api: %v declare name: %v of service: %v",
+					path, i, service.Name, name, api.Name, api.Service)
+			}
+		}
+	}
+	return services, nil
+}
+
+// LoadConfig register the services of a json file holding an array of Service objects with
+// their nested apis, the whole file is validated first and nothing is registered when any
+// entry is invalid, the first invalid one is reported with its position and name
+func (gateway *APIGateway) LoadConfig(path string) error {
+	services, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	creator, ok := gateway.Discovery.(serviceCreator)
+	if !ok {
+		return fmt.Errorf("config: %v load not supported by discovery", path)
+	}
+	gateway.configMu.Lock()
+	defer gateway.configMu.Unlock()
+	if i, err := creator.createServices(services); err != nil {
+		if i < 0 {
+			return fmt.Errorf("config: %v not loaded: %v", path, err)
+		}
+		return fmt.Errorf("config: %v service[%d] %v invalid, nothing loaded: %v", path, i, services[i].Name, err)
+	}
+	if gateway.configServices == nil {
+		gateway.configServices = make(map[string]bool, len(services))
+	}
+	for _, service := range services {
+		gateway.configServices[service.Name] = true
+	}
+	return nil
+}
+
+// serviceCreator is implemented by discoveries able to register a set of services at once
+type serviceCreator interface {
+	// createServices register services as CreateService does, nothing changes when any
+	// service is invalid, the index of the first invalid one is returned with the error,
+	// -1 when the failure is not caused by a service
+	createServices(services []*Service) (int, error)
+}
+
+// createServices implements serviceCreator
+func (c *cache) createServices(services []*Service) (int, error) {
+	for i, service := range services {
+		if err := c.prepareService(service); err != nil {
+			return i, err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, service := range services {
+		if existing, exist := c.store[service.Name]; exist && !(c.idempotent && sameService(existing, service)) {
+			return i, fmt.Errorf("service: %v %w", service.Name, ErrAlreadyExist)
+		}
+		if _, exist := c.aliases[service.Name]; exist {
+			return i, fmt.Errorf("service: %v collides with existing alias", service.Name)
+		}
+	}
+	for _, service := range services {
+		if _, exist := c.store[service.Name]; exist {
+			continue
+		}
+		c.store[service.Name] = service
+	}
+	return 0, nil
+}
+
+// createServices implements serviceCreator
+func (d *RedisDiscovery) createServices(services []*Service) (int, error) {
+	index := -1
+	err := d.update(func(c *cache) error {
+		var err error
+		index, err = c.createServices(services)
+		return err
+	})
+	return index, err
+}
+
+// serviceReplacer is implemented by discoveries able to replace a set of services at once
+type serviceReplacer interface {
+	// replaceServices register services in place of the owned ones, owned services missing
+	// from services are deleted, nothing changes when any service is invalid
+	replaceServices(owned map[string]bool, services []*Service) error
+}
+
+// ReloadConfig replace the services registered from config files with the ones of path,
+// services missing from it are deleted while the ones created through the native api are
+// kept, the whole file is validated first and nothing changes when it is invalid, routes
+// whose definition did not change keep their limiter, breaker and cache state
+func (gateway *APIGateway) ReloadConfig(path string) error {
+	services, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	replacer, ok := gateway.Discovery.(serviceReplacer)
+	if !ok {
+		return fmt.Errorf("config: %v reload not supported by discovery", path)
+	}
+	gateway.configMu.Lock()
+	defer gateway.configMu.Unlock()
+	if err := replacer.replaceServices(gateway.configServices, services); err != nil {
+		return fmt.Errorf("config: %v invalid, keep the loaded one: %v", path, err)
+	}
+	gateway.configServices = make(map[string]bool, len(services))
+	for _, service := range services {
+		gateway.configServices[service.Name] = true
+	}
+	return nil
+}
+
+// replaceServices implements serviceReplacer
+func (c *cache) replaceServices(owned map[string]bool, services []*Service) error {
+	fresh := make(map[string]*Service, len(services))
+	for _, service := range services {
+		if err := c.prepareService(service); err != nil {
+			return err
+		}
+		fresh[service.Name] = service
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, service := range fresh {
+		if _, exist := c.aliases[name]; exist {
+			return fmt.Errorf("service: %v collides with existing alias", name)
+		}
+		if existing, exist := c.store[name]; exist && !owned[name] && !sameService(existing, service) {
+			return fmt.Errorf("service: %v %w", name, ErrAlreadyExist)
+		}
+	}
+	for name := range owned {
+		if _, exist := fresh[name]; exist {
+			continue
+		}
+		if _, exist := c.store[name]; !exist {
+			continue
+		}
+		for alias := range c.aliases {
+			if c.resolve(alias) == name {
+				delete(c.aliases, alias)
+			}
+		}
+		delete(c.store, name)
+		delete(c.apiKeys, name)
+	}
+	for name, service := range fresh {
+		if existing, exist := c.store[name]; exist && sameService(existing, service) {
+			continue
+		}
+		c.store[name] = service
+	}
+	return nil
+}
+
+// replaceServices implements serviceReplacer
+func (d *RedisDiscovery) replaceServices(owned map[string]bool, services []*Service) error {
+	return d.update(func(c *cache) error { return c.replaceServices(owned, services) })
+}
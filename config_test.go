@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	user := namedBackend(t, "user")
+	order := namedBackend(t, "order")
+	valid := fmt.Sprintf(`[
+		{"name": "user", "apis": {"get": {"httpMethod": "GET", "host": %q, "path": "get"}}},
+		{"name": "order", "apis": {"list": {"name": "list", "service": "order", "httpMethod": "GET", "host": %q, "path": "list"}}}
+	]`, user, order)
+	tests := []struct {
+		name    string
+		config  string // written to the config file, empty leave it missing
+		err     string // expected in the error, empty for success
+		preload bool   // service order is registered before loading
+	}{
+		{name: "valid", config: valid},
+		{name: "missing file", err: "read failed"},
+		{name: "malformed", config: `[{"name": "user",`, err: "malformed"},
+		{name: "not an array", config: `{"name": "user"}`, err: "malformed"},
+		{name: "null service", config: `[null]`, err: "service[0] can not be null"},
+		{name: "duplicated service", config: `[{"name": "user"}, {"name": "user"}]`, err: "service[1] user duplicated"},
+		{name: "null api", config: `[{"name": "user", "apis": {"get": null}}]`, err: "service[0] user api: get can not be null"},
+		{
+			name:   "api named differently",
+			config: fmt.Sprintf(`[{"name": "user", "apis": {"get": {"name": "list", "httpMethod": "GET", "host": %q, "path": "get"}}}]`, user),
+			err:    "service[0] user api: get declare name: list",
+		},
+		{
+			name: "invalid api",
+			config: fmt.Sprintf(`[
+				{"name": "user", "apis": {"get": {"httpMethod": "GET", "host": %q, "path": "get"}}},
+				{"name": "order", "apis": {"list": {"httpMethod": "FETCH", "host": %q, "path": "list"}}}
+			]`, user, order),
+			err: "service[1] order invalid, nothing loaded",
+		},
+		{name: "existing service", config: valid, preload: true, err: "service[1] order invalid, nothing loaded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			path := filepath.Join(dir, "services.json")
+			if tt.config != "" {
+				writeTestFile(t, dir, "services.json", []byte(tt.config))
+			}
+			gateway := newTestGateway(t)
+			if tt.preload {
+				mustCreateService(t, gateway, newTestService("order",
+					&API{Name: "list", HTTPMethod: http.MethodGet, Host: order, Path: "list"}))
+			}
+			err := gateway.LoadConfig(path)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("load config: %v", err)
+				}
+				for _, route := range []string{"user/get", "order/list"} {
+					rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/"+route, nil))
+					if want := strings.Split(route, "/")[0]; rec.Body.String() != want {
+						t.Errorf("%v answered %d %q, want %q", route, rec.Code, rec.Body.String(), want)
+					}
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("load config error %v, want %q", err, tt.err)
+			}
+			if _, err := gateway.Discovery.GetService("user"); err == nil {
+				t.Errorf("service user registered from an invalid config")
+			}
+		})
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	user := namedBackend(t, "user")
+	order := namedBackend(t, "order")
+	secured := func(secret string) string {
+		return fmt.Sprintf(`{"httpMethod": "GET", "host": %q, "path": "me", "auth": {"mode": "jwt", "algorithm": "HS256", "secret": %q}}`, user, secret)
+	}
+	initial := fmt.Sprintf(`[
+		{"name": "user", "apis": {"get": {"httpMethod": "GET", "host": %q, "path": "get"}, "me": %v}},
+		{"name": "order", "apis": {"list": {"httpMethod": "GET", "host": %q, "path": "list"}}}
+	]`, user, secured(testJWTSecret), order)
+	oldToken := signJWT(t, "HS256", map[string]interface{}{"sub": "42"}, hs256(testJWTSecret))
+	newToken := signJWT(t, "HS256", map[string]interface{}{"sub": "42"}, hs256("rotated"))
+	type check struct {
+		path   string
+		token  string
+		status int
+	}
+	unchanged := []check{
+		{path: "/user/get", status: http.StatusOK},
+		{path: "/user/me", token: oldToken, status: http.StatusOK},
+		{path: "/order/list", status: http.StatusOK},
+		{path: "/native/get", status: http.StatusOK},
+	}
+	tests := []struct {
+		name   string
+		config string
+		err    string // expected in the error, empty for success
+		checks []check
+	}{
+		{name: "same config", config: initial, checks: unchanged},
+		{
+			name: "api added and service removed",
+			config: fmt.Sprintf(`[{"name": "user", "apis": {"get": {"httpMethod": "GET", "host": %q, "path": "get"},
+				"list": {"httpMethod": "GET", "host": %q, "path": "list"}}}]`, user, user),
+			checks: []check{
+				{path: "/user/list", status: http.StatusOK},
+				{path: "/user/me", status: http.StatusNotFound},
+				{path: "/order/list", status: http.StatusNotFound},
+				{path: "/native/get", status: http.StatusOK},
+			},
+		},
+		{
+			name:   "secret rotated",
+			config: fmt.Sprintf(`[{"name": "user", "apis": {"me": %v}}]`, secured("rotated")),
+			checks: []check{
+				{path: "/user/me", token: oldToken, status: http.StatusUnauthorized},
+				{path: "/user/me", token: newToken, status: http.StatusOK},
+			},
+		},
+		{name: "malformed", config: `[{"name": "user",`, err: "malformed", checks: unchanged},
+		{
+			name:   "invalid api",
+			config: fmt.Sprintf(`[{"name": "user", "apis": {"get": {"httpMethod": "FETCH", "host": %q, "path": "get"}}}]`, user),
+			err:    "keep the loaded one",
+			checks: unchanged,
+		},
+		{
+			name:   "native service redefined",
+			config: fmt.Sprintf(`[{"name": "native", "apis": {"get": {"httpMethod": "POST", "host": %q, "path": "get"}}}]`, user),
+			err:    "keep the loaded one",
+			checks: unchanged,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("native",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "native"), Path: "get"}))
+			if err := gateway.LoadConfig(writeTestFile(t, dir, "initial.json", []byte(initial))); err != nil {
+				t.Fatalf("load config: %v", err)
+			}
+			err := gateway.ReloadConfig(writeTestFile(t, dir, "reload.json", []byte(tt.config)))
+			if tt.err == "" && err != nil {
+				t.Fatalf("reload config: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("reload config error %v, want %q", err, tt.err)
+			}
+			for _, c := range tt.checks {
+				req := httptest.NewRequest(http.MethodGet, c.path, nil)
+				if c.token != "" {
+					req.Header.Set("Authorization", "Bearer "+c.token)
+				}
+				if rec := serveProxy(gateway, req); rec.Code != c.status {
+					t.Errorf("%v answered %d, want %d: %s", c.path, rec.Code, c.status, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestReloadConfigUnsupported(t *testing.T) {
+	gateway := newTestGateway(t, WithDiscovery(&stubDiscovery{}))
+	path := writeTestFile(t, tempDir(t), "services.json", []byte(`[]`))
+	if err := gateway.ReloadConfig(path); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("reload error %v, want not supported", err)
+	}
+}
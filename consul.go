@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultConsulInterval is how often ConsulDiscovery refresh the hosts of apis
+const DefaultConsulInterval = 10 * time.Second
+
+// consulHealthEntry is the subset of an entry of Consul /v1/health/service used to build hosts
+type consulHealthEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+// ConsulDiscovery is a Discovery resolving the hosts of apis having ConsulService from the
+// instances of that Consul service passing their health checks, other apis are served as
+// registered
+type ConsulDiscovery struct {
+	*cache
+	address string // Consul http api address, e.g. http://127.0.0.1:8500
+	token   string // ACL token, empty when ACLs are disabled
+	client  *http.Client
+}
+
+// NewConsulDiscovery create discovery querying the Consul agent at address with token,
+// hosts are resolved once Watch runs
+func NewConsulDiscovery(address, token string, opts ...CacheOption) (*ConsulDiscovery, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("consul address: %q invalid", address)
+	}
+	return &ConsulDiscovery{
+		cache:   NewCacheDiscovery(opts...).(*cache),
+		address: strings.TrimSuffix(u.String(), "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Watch refresh hosts every interval until ctx is done, failures are logged and the
+// hosts last resolved are kept
+func (d *ConsulDiscovery) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultConsulInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Refresh(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Errorf("consul refresh failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// consulAPI identify an api resolved from Consul
+type consulAPI struct {
+	service string
+	api     string
+	consul  string
+}
+
+// Refresh query Consul once and replace the hosts of apis having ConsulService, each Consul
+// service is queried once, apis of services failing to be queried keep their hosts
+func (d *ConsulDiscovery) Refresh(ctx context.Context) error {
+	var apis []consulAPI
+	d.mu.RLock()
+	for serviceName, service := range d.store {
+		for apiName, api := range service.APIs {
+			if api.ConsulService != "" {
+				apis = append(apis, consulAPI{service: serviceName, api: apiName, consul: api.ConsulService})
+			}
+		}
+	}
+	d.mu.RUnlock()
+	resolved := make(map[string][]string)
+	var firstErr error
+	for _, a := range apis {
+		if _, done := resolved[a.consul]; done {
+			continue
+		}
+		hosts, err := d.healthyHosts(ctx, a.consul)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		resolved[a.consul] = hosts
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, a := range apis {
+		hosts, ok := resolved[a.consul]
+		if !ok {
+			continue
+		}
+		service, exist := d.store[a.service]
+		if !exist {
+			continue
+		}
+		api, exist := service.APIs[a.api]
+		// the api may have changed while Consul was queried
+		if !exist || api.ConsulService != a.consul || equalHosts(api.Hosts, hosts) {
+			continue
+		}
+		// requests in flight keep the service and api they resolved, limiter and breaker state is shared
+		updated := *api
+		updated.Host, updated.Hosts = "", hosts
+		if err := normalizeHosts(&updated); err != nil {
+			d.logger.Warnf("consul service: %v hosts of api: %v rejected: %v", a.consul, a.api, err)
+			continue
+		}
+		d.store[a.service] = withAPI(service, a.api, &updated)
+		d.logger.Infof("consul service: %v api: %v hosts: %v", a.consul, a.api, hosts)
+	}
+	return firstErr
+}
+
+// healthyHosts return the sorted host:port of the instances of Consul service name passing
+// all their health checks
+func (d *ConsulDiscovery) healthyHosts(ctx context.Context, name string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, d.address+"/v1/health/service/"+url.PathEscape(name)+"?passing=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if d.token != "" {
+		req.Header.Set("X-Consul-Token", d.token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("consul service: %v query failed: %v", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul service: %v query failed: %v", name, resp.Status)
+	}
+	var entries []consulHealthEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("consul service: %v response malformed: %v", name, err)
+	}
+	hosts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// instances registered without address listen on their node address
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		if address == "" || entry.Service.Port <= 0 {
+			continue
+		}
+		hosts = append(hosts, net.JoinHostPort(address, strconv.Itoa(entry.Service.Port)))
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// equalHosts report whether a and b hold the same hosts in the same order
+func equalHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
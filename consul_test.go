@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// consulInstance is an instance registered in the mocked Consul
+type consulInstance struct {
+	node    string
+	address string
+	port    int
+	status  string // aggregated check status: passing, warning or critical
+}
+
+// consulServer mock the Consul health api, filtering instances on ?passing as Consul does
+type consulServer struct {
+	mu        sync.Mutex
+	instances map[string][]consulInstance
+	broken    map[string]string // service name to the raw body answered with status 500, or malformed json with 200
+	token     string
+}
+
+func (s *consulServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = r.Header.Get("X-Consul-Token")
+	name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+	if body, ok := s.broken[name]; ok {
+		if body == "" {
+			http.Error(w, "rpc error", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(body))
+		return
+	}
+	_, passing := r.URL.Query()["passing"]
+	entries := []interface{}{}
+	for _, instance := range s.instances[name] {
+		if passing && instance.status != "passing" {
+			continue
+		}
+		entries = append(entries, map[string]interface{}{
+			"Node":    map[string]interface{}{"Node": "node", "Address": instance.node},
+			"Service": map[string]interface{}{"Service": name, "Address": instance.address, "Port": instance.port},
+			"Checks":  []interface{}{map[string]interface{}{"Status": instance.status}},
+		})
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+// newTestConsul start a mocked Consul and a discovery querying it with token
+func newTestConsul(t *testing.T, token string) (*consulServer, *ConsulDiscovery) {
+	t.Helper()
+	consul := &consulServer{instances: make(map[string][]consulInstance), broken: make(map[string]string)}
+	server := httptest.NewServer(consul)
+	t.Cleanup(server.Close)
+	d, err := NewConsulDiscovery(server.URL, token, WithCacheLogger(discardLogger))
+	if err != nil {
+		t.Fatalf("consul discovery: %v", err)
+	}
+	return consul, d
+}
+
+func TestConsulHealthyHosts(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances []consulInstance
+		broken    string
+		expected  []string
+		wantErr   bool
+	}{
+		{
+			name: "passing only",
+			instances: []consulInstance{
+				{address: "10.0.0.2", port: 8080, status: "passing"},
+				{address: "10.0.0.3", port: 8080, status: "critical"},
+				{address: "10.0.0.1", port: 8080, status: "passing"},
+				{address: "10.0.0.4", port: 8080, status: "warning"},
+			},
+			expected: []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+		},
+		{
+			name:      "node address fallback",
+			instances: []consulInstance{{node: "10.0.1.1", port: 9000, status: "passing"}},
+			expected:  []string{"10.0.1.1:9000"},
+		},
+		{
+			name:      "ipv6",
+			instances: []consulInstance{{address: "fd00::1", port: 80, status: "passing"}},
+			expected:  []string{"[fd00::1]:80"},
+		},
+		{
+			name: "incomplete instances skipped",
+			instances: []consulInstance{
+				{address: "10.0.0.1", status: "passing"},
+				{port: 80, status: "passing"},
+			},
+			expected: []string{},
+		},
+		{name: "none passing", instances: []consulInstance{{address: "10.0.0.1", port: 80, status: "critical"}}, expected: []string{}},
+		{name: "unknown service", expected: []string{}},
+		{name: "server error", broken: "", wantErr: true},
+		{name: "malformed", broken: "{", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consul, d := newTestConsul(t, "secret")
+			consul.instances["web"] = tt.instances
+			if tt.wantErr {
+				consul.broken["web"] = tt.broken
+			}
+			hosts, err := d.healthyHosts(context.Background(), "web")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(hosts, tt.expected) {
+				t.Errorf("hosts %q, want %q", hosts, tt.expected)
+			}
+			if consul.token != "secret" {
+				t.Errorf("token %q, want secret", consul.token)
+			}
+		})
+	}
+}
+
+func TestConsulRefresh(t *testing.T) {
+	consul, d := newTestConsul(t, "")
+	consul.instances["web"] = []consulInstance{
+		{address: "10.0.0.1", port: 80, status: "passing"},
+		{address: "10.0.0.2", port: 80, status: "critical"},
+	}
+	if err := d.CreateService(newTestService("svc",
+		&API{Name: "consul", HTTPMethod: http.MethodGet, ConsulService: "web", Path: "get"},
+		&API{Name: "static", HTTPMethod: http.MethodGet, Host: "10.9.9.9:80", Path: "get"})); err != nil {
+		t.Fatalf("create service without host: %v", err)
+	}
+	tests := []struct {
+		name      string
+		instances []consulInstance
+		broken    bool
+		wantErr   bool
+		expected  []string
+	}{
+		{name: "resolved", expected: []string{"10.0.0.1:80"}},
+		{
+			name: "instance recovered",
+			instances: []consulInstance{
+				{address: "10.0.0.1", port: 80, status: "passing"},
+				{address: "10.0.0.2", port: 80, status: "passing"},
+			},
+			expected: []string{"10.0.0.1:80", "10.0.0.2:80"},
+		},
+		{name: "failure keep hosts", broken: true, wantErr: true, expected: []string{"10.0.0.1:80", "10.0.0.2:80"}},
+		{
+			name:      "instance failing",
+			instances: []consulInstance{{address: "10.0.0.2", port: 80, status: "passing"}, {address: "10.0.0.1", port: 80, status: "critical"}},
+			expected:  []string{"10.0.0.2:80"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consul.mu.Lock()
+			if tt.instances != nil {
+				consul.instances["web"] = tt.instances
+			}
+			delete(consul.broken, "web")
+			if tt.broken {
+				consul.broken["web"] = ""
+			}
+			consul.mu.Unlock()
+			if err := d.Refresh(context.Background()); (err != nil) != tt.wantErr {
+				t.Fatalf("refresh error %v, want error %v", err, tt.wantErr)
+			}
+			service, err := d.GetService("svc")
+			if err != nil {
+				t.Fatalf("get service: %v", err)
+			}
+			if hosts := service.APIs["consul"].Hosts; !reflect.DeepEqual(hosts, tt.expected) {
+				t.Errorf("hosts %q, want %q", hosts, tt.expected)
+			}
+			if host := service.APIs["static"].Host; host != "10.9.9.9:80" {
+				t.Errorf("static api host %q changed", host)
+			}
+		})
+	}
+}
+
+func TestNewConsulDiscovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		expected string
+		wantErr  bool
+	}{
+		{name: "host port", address: "127.0.0.1:8500", expected: "http://127.0.0.1:8500"},
+		{name: "url", address: "https://consul.example.com/", expected: "https://consul.example.com"},
+		{name: "empty", address: "", wantErr: true},
+		{name: "malformed", address: "http://%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewConsulDiscovery(tt.address, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && d.address != tt.expected {
+				t.Errorf("address %q, want %q", d.address, tt.expected)
+			}
+		})
+	}
+}
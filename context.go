@@ -0,0 +1,51 @@
+package gateway
+
+import "context"
+
+// contextKey is the type of context keys set by the gateway, it is compared by pointer
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string {
+	return "gateway context value " + k.name
+}
+
+var (
+	// ServiceContextKey carry the resolved *Service in request context
+	ServiceContextKey = &contextKey{"service"}
+	// APIContextKey carry the resolved *API in request context
+	APIContextKey = &contextKey{"api"}
+	// BackendContextKey carry the chosen backend host (string) in request context
+	BackendContextKey = &contextKey{"backend"}
+)
+
+// withRoute store the resolved route in ctx for the proxy and downstream middleware
+func withRoute(ctx context.Context, rt *route) context.Context {
+	ctx = context.WithValue(ctx, routeKey{}, rt)
+	ctx = context.WithValue(ctx, ServiceContextKey, rt.service)
+	ctx = context.WithValue(ctx, APIContextKey, rt.api)
+	return context.WithValue(ctx, BackendContextKey, rt.backend)
+}
+
+// ServiceFromContext return the service the request resolved to, nil before resolution
+func ServiceFromContext(ctx context.Context) *Service {
+	service, _ := ctx.Value(ServiceContextKey).(*Service)
+	return service
+}
+
+// APIFromContext return the api the request resolved to, nil before resolution
+func APIFromContext(ctx context.Context) *API {
+	api, _ := ctx.Value(APIContextKey).(*API)
+	return api
+}
+
+// BackendFromContext return the backend host chosen for the request, the one of the last
+// attempt when retried, empty before resolution
+func BackendFromContext(ctx context.Context) string {
+	if rt := routeOf(ctx); rt != nil && rt.backend != "" {
+		return rt.backend
+	}
+	backend, _ := ctx.Value(BackendContextKey).(string)
+	return backend
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// roundTripFunc adapt a func to http.RoundTripper
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// routeValues is the route metadata read from a request context
+type routeValues struct {
+	service string
+	api     string
+	backend string
+}
+
+func readRouteValues(ctx context.Context) routeValues {
+	var v routeValues
+	if service := ServiceFromContext(ctx); service != nil {
+		v.service = service.Name
+	}
+	if api := APIFromContext(ctx); api != nil {
+		v.api = api.Name
+	}
+	v.backend = BackendFromContext(ctx)
+	return v
+}
+
+func TestRouteContextValues(t *testing.T) {
+	if got := readRouteValues(context.Background()); got != (routeValues{}) {
+		t.Fatalf("values before resolution: %+v", got)
+	}
+	tests := []struct {
+		name  string
+		setup func(gateway *APIGateway, api *API, seen func(ctx context.Context))
+	}{
+		{
+			name: "request transformer",
+			setup: func(gateway *APIGateway, api *API, seen func(ctx context.Context)) {
+				WithRequestTransformer("inspect", func(r *http.Request, body []byte) ([]byte, error) {
+					seen(r.Context())
+					return body, nil
+				})(gateway)
+				api.RequestTransformers = []string{"inspect"}
+			},
+		},
+		{
+			name: "response transformer",
+			setup: func(gateway *APIGateway, api *API, seen func(ctx context.Context)) {
+				WithResponseTransformer("inspect", func(resp *http.Response, body []byte) ([]byte, error) {
+					seen(resp.Request.Context())
+					return body, nil
+				})(gateway)
+				api.ResponseTransformers = []string{"inspect"}
+			},
+		},
+		{
+			name: "transport",
+			setup: func(gateway *APIGateway, api *API, seen func(ctx context.Context)) {
+				gateway.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					seen(req.Context())
+					return http.DefaultTransport.RoundTrip(req)
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := namedBackend(t, "ok")
+			gateway := newTestGateway(t)
+			api := &API{Name: "create", HTTPMethod: http.MethodPost, Host: backend, Path: "create"}
+			var got []routeValues
+			tt.setup(gateway, api, func(ctx context.Context) { got = append(got, readRouteValues(ctx)) })
+			mustCreateService(t, gateway, newTestService("user", api))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodPost, "/user/create", bytes.NewReader([]byte("{}"))))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			want := routeValues{service: "user", api: "create", backend: backend}
+			if len(got) != 1 || got[0] != want {
+				t.Errorf("values %+v, want [%+v]", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS let browsers on other origins call an api, preflights are answered by the gateway
+type CORS struct {
+	// AllowedOrigins are the origins allowed, * allow any
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// AllowedMethods answered to preflights, default the api method
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	// AllowedHeaders answered to preflights, default the headers the preflight asks for
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string `json:"exposedHeaders,omitempty"`
+	// MaxAgeSeconds let browsers cache preflight results, zero leave it to the browser
+	MaxAgeSeconds int `json:"maxAgeSeconds,omitempty"`
+	// AllowCredentials let requests carry cookies, the origin is echoed instead of *
+	AllowCredentials bool `json:"allowCredentials,omitempty"`
+}
+
+// normalizeCORS validate the cors of api
+func normalizeCORS(api *API) error {
+	cors := api.CORS
+	if cors == nil {
+		return nil
+	}
+	if len(cors.AllowedOrigins) == 0 {
+		return fmt.Errorf("api: %v cors allowedOrigins can not be empty", api.Name)
+	}
+	if cors.MaxAgeSeconds < 0 {
+		return fmt.Errorf("api: %v cors maxAgeSeconds can not be negative", api.Name)
+	}
+	for i, method := range cors.AllowedMethods {
+		cors.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	return nil
+}
+
+// allowOrigin return the Access-Control-Allow-Origin value for origin, empty when not allowed
+func (cors *CORS) allowOrigin(origin string) string {
+	for _, allowed := range cors.AllowedOrigins {
+		if allowed == "*" {
+			// credentials are never allowed with a literal *
+			if cors.AllowCredentials {
+				return origin
+			}
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// isPreflight report whether r is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// handleCORS add CORS headers for allowed origins of api and answer preflights, return
+// whether the request is answered, requests of other origins go on without CORS headers
+// so that browsers block them
+func (gateway *APIGateway) handleCORS(w http.ResponseWriter, r *http.Request, api *API) bool {
+	cors := api.CORS
+	origin := r.Header.Get("Origin")
+	if cors == nil || origin == "" {
+		return false
+	}
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	allowed := cors.allowOrigin(origin)
+	preflight := isPreflight(r)
+	if allowed == "" {
+		if preflight {
+			gateway.writeError(w, r, http.StatusForbidden, fmt.Sprintf("origin: %v not allowed", origin))
+			return true
+		}
+		return false
+	}
+	header.Set("Access-Control-Allow-Origin", allowed)
+	if cors.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(cors.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+		}
+		return false
+	}
+	methods := cors.AllowedMethods
+	if len(methods) == 0 {
+		methods = api.methods
+	}
+	if len(methods) > 0 {
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	}
+	if len(cors.AllowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+	if cors.MaxAgeSeconds > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAgeSeconds))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// dropBackendCORS remove CORS headers of backend response when the gateway answers CORS
+// for the api, duplicated Access-Control-Allow-Origin values make browsers reject it
+func dropBackendCORS(resp *http.Response) {
+	rt := routeOf(resp.Request.Context())
+	if rt == nil || rt.api.CORS == nil {
+		return
+	}
+	for key := range resp.Header {
+		if strings.HasPrefix(key, "Access-Control-") {
+			resp.Header.Del(key)
+		}
+	}
+}
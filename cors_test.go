@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	listed := &CORS{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		ExposedHeaders: []string{"X-Total"},
+		MaxAgeSeconds:  600,
+	}
+	tests := []struct {
+		name      string
+		cors      *CORS
+		method    string
+		origin    string
+		preflight bool
+		status    int
+		proxied   bool
+		headers   map[string]string // expected response headers, empty value expect none
+	}{
+		{
+			name: "preflight", cors: listed, method: http.MethodOptions, origin: "https://app.example.com", preflight: true,
+			status: http.StatusNoContent,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET",
+				"Access-Control-Allow-Headers": "Content-Type, Authorization",
+				"Access-Control-Max-Age":       "600",
+				"Vary":                         "Origin",
+			},
+		},
+		{
+			name: "preflight of other origin", cors: listed, method: http.MethodOptions, origin: "https://evil.example.com", preflight: true,
+			status:  http.StatusForbidden,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "simple get", cors: listed, method: http.MethodGet, origin: "https://app.example.com",
+			status: http.StatusOK, proxied: true,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example.com",
+				"Access-Control-Expose-Headers": "X-Total",
+				"Access-Control-Allow-Methods":  "",
+			},
+		},
+		{
+			name: "simple get of other origin", cors: listed, method: http.MethodGet, origin: "https://evil.example.com",
+			status: http.StatusOK, proxied: true,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "wildcard", cors: &CORS{AllowedOrigins: []string{"*"}}, method: http.MethodGet, origin: "https://any.example.com",
+			status: http.StatusOK, proxied: true,
+			headers: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+		{
+			name: "wildcard with credentials echo origin", cors: &CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method: http.MethodGet, origin: "https://any.example.com", status: http.StatusOK, proxied: true,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":      "https://any.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name: "preflight asked headers echoed", cors: &CORS{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"get", "post"}},
+			method: http.MethodOptions, origin: "https://any.example.com", preflight: true, status: http.StatusNoContent,
+			headers: map[string]string{
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "X-Custom",
+			},
+		},
+		{
+			name: "no cors config", method: http.MethodGet, origin: "https://app.example.com", status: http.StatusOK, proxied: true,
+			headers: map[string]string{"Access-Control-Allow-Origin": "backend"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				w.Header().Set("Access-Control-Allow-Origin", "backend")
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get", CORS: tt.cors}))
+			req := httptest.NewRequest(tt.method, "/svc/get", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+				req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if proxied := atomic.LoadInt32(&hits) > 0; proxied != tt.proxied {
+				t.Errorf("proxied %v, want %v", proxied, tt.proxied)
+			}
+			for key, want := range tt.headers {
+				if got := rec.Header().Get(key); got != want {
+					t.Errorf("%v %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		cors *CORS
+	}{
+		{name: "no origins", cors: &CORS{}},
+		{name: "negative max age", cors: &CORS{AllowedOrigins: []string{"*"}, MaxAgeSeconds: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get", CORS: tt.cors}))
+			if err == nil {
+				t.Errorf("invalid cors accepted")
+			}
+		})
+	}
+}
+
+func TestCORSStage(t *testing.T) {
+	tests := []struct {
+		name      string
+		stages    []string
+		preflight bool
+		status    int
+		allowed   bool // Access-Control-Allow-Origin is set
+	}{
+		{name: "default preflight answered before auth", preflight: true, status: http.StatusNoContent, allowed: true},
+		{name: "default rejection readable by the browser", status: http.StatusUnauthorized, allowed: true},
+		{name: "auth before cors preflight", stages: []string{StageAuth, StageCORS, StageRateLimit, StageConcurrency}, preflight: true, status: http.StatusUnauthorized},
+		{name: "auth before cors rejection", stages: []string{StageAuth, StageCORS, StageRateLimit, StageConcurrency}, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			if tt.stages != nil {
+				if err := gateway.SetPipeline(tt.stages); err != nil {
+					t.Fatalf("set pipeline: %v", err)
+				}
+			}
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "get",
+				CORS: &CORS{AllowedOrigins: []string{"https://app.example.com"}},
+				Auth: &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret},
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+			if tt.preflight {
+				req = httptest.NewRequest(http.MethodOptions, "/svc/get", nil)
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			req.Header.Set("Origin", "https://app.example.com")
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if allowed := rec.Header().Get("Access-Control-Allow-Origin") != ""; allowed != tt.allowed {
+				t.Errorf("origin allowed %v, want %v", allowed, tt.allowed)
+			}
+			if err := gateway.SetPipeline([]string{StageAuth, StageRateLimit, StageConcurrency}); err == nil {
+				t.Errorf("pipeline without cors accepted for an api with cors")
+			}
+		})
+	}
+}
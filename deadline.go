@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDeadlineHeader carry the remaining request budget in milliseconds
+const DefaultDeadlineHeader = "X-Request-Deadline-Ms"
+
+// grpcTimeoutUnits map grpc-timeout unit to duration
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// clientDeadline apply the budget sent by client in deadline header or grpc-timeout to request
+// context, a deadline header that can not be parsed is not forwarded to backends
+func (gateway *APIGateway) clientDeadline(r *http.Request) (*http.Request, context.CancelFunc) {
+	var budget time.Duration
+	if gateway.DeadlineHeader != "" {
+		if ms, err := strconv.ParseInt(r.Header.Get(gateway.DeadlineHeader), 10, 64); err == nil && ms > 0 {
+			budget = time.Duration(ms) * time.Millisecond
+		} else {
+			r.Header.Del(gateway.DeadlineHeader)
+		}
+	}
+	if timeout, ok := parseGRPCTimeout(r.Header.Get("grpc-timeout")); ok && (budget == 0 || timeout < budget) {
+		budget = timeout
+	}
+	if budget == 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	return r.WithContext(ctx), cancel
+}
+
+// apiTimeout bound request context with the api timeout, a shorter client deadline is kept
+func apiTimeout(r *http.Request, rt *route) (*http.Request, context.CancelFunc) {
+	if rt.api.TimeoutMs <= 0 || rt.streaming() {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(rt.api.TimeoutMs)*time.Millisecond)
+	return r.WithContext(ctx), cancel
+}
+
+// propagateDeadline forward the remaining budget of request context to backend
+func (gateway *APIGateway) propagateDeadline(req *http.Request) {
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		return
+	}
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if gateway.DeadlineHeader != "" {
+		req.Header.Set(gateway.DeadlineHeader, strconv.FormatInt(int64(remaining/time.Millisecond), 10))
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		req.Header.Set("grpc-timeout", strconv.FormatInt(int64(remaining/time.Millisecond), 10)+"m")
+	}
+}
+
+// parseGRPCTimeout parse grpc-timeout value such as 100m or 5S
+func parseGRPCTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	unit, ok := grpcTimeoutUnits[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadlinePropagation(t *testing.T) {
+	tests := []struct {
+		name           string
+		deadlineHeader string
+		header         map[string]string
+		timeoutMs      int
+		wantMs         int64 // upper bound of the propagated budget, zero expect none
+		wantGRPC       bool
+	}{
+		{name: "no deadline", deadlineHeader: DefaultDeadlineHeader},
+		{name: "client header", deadlineHeader: DefaultDeadlineHeader, header: map[string]string{DefaultDeadlineHeader: "2000"}, wantMs: 2000},
+		{name: "invalid header", deadlineHeader: DefaultDeadlineHeader, header: map[string]string{DefaultDeadlineHeader: "soon"}},
+		{
+			name:           "grpc timeout shorter",
+			deadlineHeader: DefaultDeadlineHeader,
+			header:         map[string]string{DefaultDeadlineHeader: "2000", "grpc-timeout": "500m", "Content-Type": "application/grpc"},
+			wantMs:         500,
+			wantGRPC:       true,
+		},
+		{name: "api timeout shorter", deadlineHeader: DefaultDeadlineHeader, header: map[string]string{DefaultDeadlineHeader: "2000"}, timeoutMs: 300, wantMs: 300},
+		{name: "api timeout only", deadlineHeader: DefaultDeadlineHeader, timeoutMs: 300, wantMs: 300},
+		{name: "header disabled", header: map[string]string{"X-Request-Deadline-Ms": "2000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%v|%v", r.Header.Get(DefaultDeadlineHeader), r.Header.Get("grpc-timeout"))
+			})
+			gateway := newTestGateway(t)
+			gateway.DeadlineHeader = tt.deadlineHeader
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "api", HTTPMethod: http.MethodGet, Host: backend, Path: "api", TimeoutMs: tt.timeoutMs}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/api", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := serveProxy(gateway, req)
+			got := strings.SplitN(rec.Body.String(), "|", 2)
+			if len(got) != 2 {
+				t.Fatalf("backend answered %d %q", rec.Code, rec.Body.String())
+			}
+			if tt.wantMs == 0 {
+				// a disabled header is not the gateway's, it pass through as any header
+				want := ""
+				if tt.deadlineHeader == "" {
+					want = tt.header[DefaultDeadlineHeader]
+				}
+				if got[0] != want {
+					t.Errorf("deadline header %q, want %q", got[0], want)
+				}
+				return
+			}
+			ms, err := strconv.ParseInt(got[0], 10, 64)
+			if err != nil {
+				t.Fatalf("deadline header %q: %v", got[0], err)
+			}
+			// the budget spent in the gateway is small but never negative
+			if ms > tt.wantMs || ms < tt.wantMs-int64(time.Second/time.Millisecond) {
+				t.Errorf("deadline %dms, want at most %dms", ms, tt.wantMs)
+			}
+			if tt.wantGRPC != (got[1] != "") {
+				t.Errorf("grpc-timeout %q, want propagated %v", got[1], tt.wantGRPC)
+			}
+		})
+	}
+}
+
+func TestAPITimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		timeoutMs int
+		delay     time.Duration
+		status    int
+	}{
+		{name: "backend within timeout", timeoutMs: 500, status: http.StatusOK},
+		{name: "backend slower than timeout", timeoutMs: 50, delay: 300 * time.Millisecond, status: http.StatusGatewayTimeout},
+		{name: "no timeout", delay: 100 * time.Millisecond, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+					fmt.Fprint(w, "done")
+				case <-r.Context().Done():
+				}
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "api", HTTPMethod: http.MethodGet, Host: backend, Path: "api", TimeoutMs: tt.timeoutMs}))
+			start := time.Now()
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/api", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusGatewayTimeout && time.Since(start) >= tt.delay {
+				t.Errorf("504 after %v, the backend answered by then", time.Since(start))
+			}
+			if tt.status == http.StatusOK && rec.Body.String() != "done" {
+				t.Errorf("body %q, want done", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "100m", want: 100 * time.Millisecond, ok: true},
+		{value: "5S", want: 5 * time.Second, ok: true},
+		{value: "1H", want: time.Hour, ok: true},
+		{value: "250u", want: 250 * time.Microsecond, ok: true},
+		{value: "m"},
+		{value: "10x"},
+		{value: "0S"},
+		{value: "-1S"},
+		{value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseGRPCTimeout(tt.value)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %v %v, want %v %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// errCorruptEncoding is returned when a compressed backend response can not be decoded
+var errCorruptEncoding = errors.New("backend sent corrupt compressed response")
+
+// decompressError report whether err comes from decoding a gzip stream
+func decompressError(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) || errors.As(err, &corrupt)
+}
+
+// wrapDecompressError mark decoding errors of a response the transport decompressed
+func wrapDecompressError(resp *http.Response, err error) error {
+	if resp.Uncompressed && decompressError(err) {
+		return fmt.Errorf("%w: %v", errCorruptEncoding, err)
+	}
+	return err
+}
+
+// checkEncoding decode the start of a response the transport decompressed, so that a
+// corrupt gzip header is answered with 502 before anything is sent to the client
+func checkEncoding(resp *http.Response) error {
+	if !resp.Uncompressed {
+		return nil
+	}
+	reader := bufio.NewReader(resp.Body)
+	if _, err := reader.Peek(1); err != nil && err != io.EOF {
+		resp.Body.Close()
+		return wrapDecompressError(resp, err)
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{reader, resp.Body}
+	return nil
+}
+
+// modifyResponse check backend response before it is copied to the client
+func (gateway *APIGateway) modifyResponse(resp *http.Response) error {
+	if err := checkEncoding(resp); err != nil {
+		return err
+	}
+	if err := gateway.limitResponse(resp); err != nil {
+		return err
+	}
+	if err := gateway.transformResponse(resp); err != nil {
+		return err
+	}
+	cacheResponse(resp)
+	gateway.prepareResponse(resp)
+	watchIdle(resp)
+	return nil
+}
+
+// prepareResponse turn a backend response, fresh or cached, into the client response
+func (gateway *APIGateway) prepareResponse(resp *http.Response) {
+	gateway.compressResponse(resp)
+	dropBackendCORS(resp)
+	gateway.dropBackendRequestID(resp)
+	rewriteResponseHeaders(resp)
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipped return data compressed with gzip
+func gzipped(data string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestCorruptGzipResponse(t *testing.T) {
+	valid := gzipped(strings.Repeat("hello ", 100))
+	badChecksum := append([]byte(nil), valid...)
+	badChecksum[len(badChecksum)-8] ^= 0xff
+	tests := []struct {
+		name        string
+		body        []byte
+		buffered    bool
+		transform   bool
+		clientGzip  bool // the client accepts gzip, the body is passed through undecoded
+		status      int
+		wantMessage string
+		wantBody    string
+	}{
+		{name: "valid", body: valid, status: http.StatusOK, wantBody: strings.Repeat("hello ", 100)},
+		{name: "corrupt header", body: []byte("definitely not gzip"), status: http.StatusBadGateway, wantMessage: errCorruptEncoding.Error()},
+		{name: "bad checksum buffered", body: badChecksum, buffered: true, status: http.StatusBadGateway, wantMessage: errCorruptEncoding.Error()},
+		{name: "bad checksum transformed", body: badChecksum, transform: true, status: http.StatusBadGateway, wantMessage: errCorruptEncoding.Error()},
+		{name: "passed through to gzip client", body: []byte("definitely not gzip"), clientGzip: true, status: http.StatusOK, wantBody: "definitely not gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(tt.body)
+			})
+			api := &API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}
+			options := []Option{}
+			if tt.buffered {
+				api.ResponseMode = ResponseBuffered
+			}
+			if tt.transform {
+				options = append(options, WithResponseTransformer("identity", func(resp *http.Response, body []byte) ([]byte, error) {
+					return body, nil
+				}))
+				api.ResponseTransformers = []string{"identity"}
+			}
+			gateway := newTestGateway(t, options...)
+			mustCreateService(t, gateway, newTestService("svc", api))
+			req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+			if tt.clientGzip {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %q", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				if rec.Body.String() != tt.wantBody {
+					t.Errorf("body %q, want %q", rec.Body.String(), tt.wantBody)
+				}
+				return
+			}
+			var resp struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("error body %q: %v", rec.Body.String(), err)
+			}
+			if tt.wantMessage != "" && resp.Error != tt.wantMessage {
+				t.Errorf("error %q, want %q", resp.Error, tt.wantMessage)
+			}
+		})
+	}
+}
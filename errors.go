@@ -0,0 +1,277 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRequestIDHeader carry the request correlation id
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// DefaultRetryAfter is advertised to clients throttled for generic overload
+const DefaultRetryAfter = time.Second
+
+// DefaultErrorIDField is the field of error response body carrying the request id
+const DefaultErrorIDField = "requestId"
+
+// errUnknownService and errUnknownAPI tell why a request path does not resolve to a route
+var (
+	errUnknownService = errors.New("unknown service")
+	errUnknownAPI     = errors.New("unknown api")
+)
+
+// errDiscoveryNotListable is returned when discovery can not enumerate services
+var errDiscoveryNotListable = errors.New("discovery can not list routes")
+
+// maxRecentErrors bound the gateway errors kept for the admin api
+const maxRecentErrors = 100
+
+// ErrorRecord is a gateway error response sent to a client
+type ErrorRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Message   string    `json:"message"`
+}
+
+// errorLog keep the most recent gateway errors in a ring
+type errorLog struct {
+	mu      sync.Mutex
+	records []ErrorRecord
+	next    int
+}
+
+// add record an error, overwriting the oldest one when full
+func (l *errorLog) add(record ErrorRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) < maxRecentErrors {
+		l.records = append(l.records, record)
+		return
+	}
+	l.records[l.next] = record
+	l.next = (l.next + 1) % maxRecentErrors
+}
+
+// recent return recorded errors, newest first
+func (l *errorLog) recent() []ErrorRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	records := make([]ErrorRecord, 0, len(l.records))
+	for i := len(l.records) - 1; i >= 0; i-- {
+		records = append(records, l.records[(l.next+i)%len(l.records)])
+	}
+	return records
+}
+
+// requestIDKey is the context key of request id
+type requestIDKey struct{}
+
+// newRequestID generate a random (version 4) uuid, the error of the random source is
+// returned along an id that is still well formed
+func newRequestID() (string, error) {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), err
+}
+
+// maxRequestIDLength bound request ids accepted from clients
+const maxRequestIDLength = 128
+
+// validRequestID report whether a client request id is short printable ascii, other ids are
+// replaced so that clients can not forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID reuse the request id sent by client or generate a new one
+func (gateway *APIGateway) withRequestID(r *http.Request) *http.Request {
+	id := ""
+	if gateway.RequestIDHeader != "" {
+		id = r.Header.Get(gateway.RequestIDHeader)
+	}
+	if !validRequestID(id) {
+		var err error
+		if id, err = newRequestID(); err != nil {
+			gateway.logger().Errorf("generate request id failed: %v", err)
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// echoRequestID set the request id on the response so that every answer, proxied or
+// not, can be tied back to the logs
+func (gateway *APIGateway) echoRequestID(w http.ResponseWriter, r *http.Request) {
+	if gateway.RequestIDHeader != "" {
+		w.Header().Set(gateway.RequestIDHeader, requestID(r.Context()))
+	}
+}
+
+// forwardRequestID send the request id to backend, replacing an invalid one of the client
+func (gateway *APIGateway) forwardRequestID(req *http.Request) {
+	if gateway.RequestIDHeader != "" {
+		req.Header.Set(gateway.RequestIDHeader, requestID(req.Context()))
+	}
+}
+
+// dropBackendRequestID remove the request id echoed by backend, the gateway already set it
+func (gateway *APIGateway) dropBackendRequestID(resp *http.Response) {
+	if gateway.RequestIDHeader != "" {
+		resp.Header.Del(gateway.RequestIDHeader)
+	}
+}
+
+// requestID return the request id stored in ctx
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ErrorResponse is an error answered by the gateway itself rather than a backend
+type ErrorResponse struct {
+	Status    int
+	Message   string
+	Details   []string // optional
+	RequestID string
+}
+
+// ErrorFormatter render the body of gateway error responses with its content type
+type ErrorFormatter func(e *ErrorResponse) (contentType string, body []byte)
+
+// formatError render e with the gateway ErrorFormatter, by default as json
+// {"error": message, "status": status, "details": details} with the request id in ErrorIDField
+func (gateway *APIGateway) formatError(e *ErrorResponse) (string, []byte) {
+	if gateway.ErrorFormatter != nil {
+		return gateway.ErrorFormatter(e)
+	}
+	body := map[string]interface{}{"error": e.Message, "status": e.Status}
+	if len(e.Details) > 0 {
+		body["details"] = e.Details
+	}
+	if e.RequestID != "" && gateway.ErrorIDField != "" {
+		body[gateway.ErrorIDField] = e.RequestID
+	}
+	data, _ := json.Marshal(body)
+	return "application/json", append(data, '\n')
+}
+
+// writeError write gateway error response, the request id is set in both header and body
+// so that clients can reference it and it ties back to the logs
+func (gateway *APIGateway) writeError(w http.ResponseWriter, r *http.Request, status int, message string, details ...string) {
+	id := requestID(r.Context())
+	if id != "" && gateway.RequestIDHeader != "" {
+		w.Header().Set(gateway.RequestIDHeader, id)
+	}
+	if status >= http.StatusInternalServerError {
+		// proxy errors come with the upstream request, record the path the client asked for
+		path := r.URL.Path
+		if rt := routeOf(r.Context()); rt != nil && rt.path != "" {
+			path = rt.path
+		}
+		gateway.logger().Errorf("request: %v %v %v failed with %d: %v", id, r.Method, path, status, message)
+		gateway.errors.add(ErrorRecord{
+			Time:      time.Now(),
+			RequestID: id,
+			Method:    r.Method,
+			Path:      path,
+			Status:    status,
+			Message:   message,
+		})
+	}
+	contentType, body := gateway.formatError(&ErrorResponse{Status: status, Message: message, Details: details, RequestID: id})
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// throttle write a 429 or 503 response advertising Retry-After, every throttling response
+// goes through it so clients always get a back off hint, retryAfter is the wait known by
+// the throttling subsystem, zero use the gateway RetryAfter
+func (gateway *APIGateway) throttle(w http.ResponseWriter, r *http.Request, status int, message string, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = gateway.RetryAfter
+	}
+	// whole seconds, rounded up so clients never retry too early, at least one
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	gateway.writeError(w, r, status, message)
+}
+
+// proxyError handle error of proxying to backend
+func (gateway *APIGateway) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	gateway.logger().Warnf("request: %v proxy error: %v", requestID(r.Context()), err)
+	if errors.Is(err, errRequestTooLarge) {
+		gateway.writeError(w, r, http.StatusRequestEntityTooLarge, errRequestTooLarge.Error())
+		return
+	}
+	// clients giving up are not backend errors
+	if rt := routeOf(r.Context()); rt != nil && !errors.Is(err, context.Canceled) {
+		gateway.metrics.backendError(rt.service.Name, rt.api.Name)
+	}
+	if errors.Is(err, errResponseTooLarge) {
+		gateway.writeError(w, r, http.StatusBadGateway, errResponseTooLarge.Error())
+		return
+	}
+	if errors.Is(err, errTransformFailed) {
+		gateway.writeError(w, r, http.StatusBadGateway, errTransformFailed.Error())
+		return
+	}
+	if errors.Is(err, errCorruptEncoding) {
+		gateway.writeError(w, r, http.StatusBadGateway, errCorruptEncoding.Error())
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		gateway.writeError(w, r, http.StatusGatewayTimeout, "backend timeout")
+		return
+	}
+	gateway.writeError(w, r, http.StatusBadGateway, "backend unavailable")
+}
+
+// routeNotFound write 404 for request path not resolved to a route, the miss reason is
+// only put in details when VerboseNotFound is enabled
+func (gateway *APIGateway) routeNotFound(w http.ResponseWriter, r *http.Request, err error) {
+	gateway.logger().Infof("request: %v %v", requestID(r.Context()), err)
+	message := fmt.Sprintf("path: %v not found", r.URL.Path)
+	if !gateway.VerboseNotFound {
+		gateway.writeError(w, r, http.StatusNotFound, message)
+		return
+	}
+	// the path format is /{service}/{api}/... when it resolves far enough to miss
+	segments := strings.SplitN(r.URL.Path, "/", 4)
+	switch {
+	case errors.Is(err, errUnknownService) && len(segments) >= 3:
+		message = fmt.Sprintf("service: %v not found", segments[1])
+		gateway.writeError(w, r, http.StatusNotFound, message, errUnknownService.Error())
+	case errors.Is(err, errUnknownService):
+		gateway.writeError(w, r, http.StatusNotFound, message, errUnknownService.Error())
+	case errors.Is(err, errUnknownAPI):
+		message = fmt.Sprintf("service: %v api: %v not found", segments[1], segments[2])
+		gateway.writeError(w, r, http.StatusNotFound, message, errUnknownAPI.Error())
+	default:
+		gateway.writeError(w, r, http.StatusNotFound, message)
+	}
+}
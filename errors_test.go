@@ -0,0 +1,368 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordLogger keep the lines logged through it with their level
+type recordLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordLogger) record(level LogLevel, format string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level.String()+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Debugf(format string, args ...interface{}) { l.record(LevelDebug, format, args) }
+func (l *recordLogger) Infof(format string, args ...interface{})  { l.record(LevelInfo, format, args) }
+func (l *recordLogger) Warnf(format string, args ...interface{})  { l.record(LevelWarn, format, args) }
+func (l *recordLogger) Errorf(format string, args ...interface{}) { l.record(LevelError, format, args) }
+
+// find return the first line of level containing substr
+func (l *recordLogger) find(level LogLevel, substr string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, level.String()+" ") && strings.Contains(line, substr) {
+			return line, true
+		}
+	}
+	return "", false
+}
+
+func TestErrorCorrelationID(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		path     string
+		status   int
+	}{
+		{name: "backend unreachable", path: "/svc/down", status: http.StatusBadGateway},
+		{name: "client id kept", clientID: "client-chosen-id", path: "/svc/down", status: http.StatusBadGateway},
+		{name: "forged id replaced", clientID: "bad\nid", path: "/svc/down", status: http.StatusBadGateway},
+		{name: "transform not registered", path: "/svc/transform", status: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordLogger{}
+			gateway := newTestGateway(t, WithLogger(logger))
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "down", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "down"},
+				&API{Name: "transform", HTTPMethod: http.MethodGet, Host: namedBackend(t, "{}"), Path: "t", ResponseTransformers: []string{"missing"}}))
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.clientID != "" {
+				req.Header.Set(DefaultRequestIDHeader, tt.clientID)
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			id := rec.Header().Get(DefaultRequestIDHeader)
+			if id == "" {
+				t.Fatalf("response without request id header")
+			}
+			if tt.clientID != "" && validRequestID(tt.clientID) != (id == tt.clientID) {
+				t.Errorf("request id %q for client id %q", id, tt.clientID)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("error body %q: %v", rec.Body.String(), err)
+			}
+			if body[DefaultErrorIDField] != id {
+				t.Errorf("body id %v, want %v", body[DefaultErrorIDField], id)
+			}
+			if _, ok := logger.find(LevelError, id); !ok {
+				t.Errorf("request id %v not logged at error level: %q", id, logger.lines)
+			}
+			if records := gateway.errors.recent(); len(records) != 1 || records[0].RequestID != id {
+				t.Errorf("recent errors %+v, want one with id %v", records, id)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		name     string
+		header   string // RequestIDHeader of the gateway
+		clientID string
+		expected string // empty expect a generated uuid
+	}{
+		{name: "generated", header: DefaultRequestIDHeader},
+		{name: "client id preserved", header: DefaultRequestIDHeader, clientID: "abc-123", expected: "abc-123"},
+		{name: "oversized id replaced", header: DefaultRequestIDHeader, clientID: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "custom header", header: "X-Correlation-Id", clientID: "abc-123", expected: "abc-123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 1)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header.Get(tt.header)
+				w.Header().Set(tt.header, "backend-id")
+			})
+			gateway := newTestGateway(t)
+			gateway.RequestIDHeader = tt.header
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+			if tt.clientID != "" {
+				req.Header.Set(tt.header, tt.clientID)
+			}
+			rec := serveProxy(gateway, req)
+			id := rec.Header().Get(tt.header)
+			if tt.expected != "" && id != tt.expected {
+				t.Errorf("request id %q, want %q", id, tt.expected)
+			}
+			if tt.expected == "" && !uuid.MatchString(id) {
+				t.Errorf("generated request id %q, want a uuid", id)
+			}
+			if got := <-received; got != id {
+				t.Errorf("backend got request id %q, want %q", got, id)
+			}
+			if ids := rec.Header()[http.CanonicalHeaderKey(tt.header)]; len(ids) != 1 {
+				t.Errorf("response request ids %q, want one", ids)
+			}
+		})
+	}
+}
+
+func TestRequestIDUnique(t *testing.T) {
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "get"}))
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil)).Header().Get(DefaultRequestIDHeader)
+		if seen[id] {
+			t.Fatalf("request id %q generated twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestRequestIDDisabled(t *testing.T) {
+	received := make(chan string, 1)
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(DefaultRequestIDHeader)
+	})
+	gateway := newTestGateway(t)
+	gateway.RequestIDHeader = ""
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+	req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+	req.Header.Set(DefaultRequestIDHeader, "client-id")
+	rec := serveProxy(gateway, req)
+	if got := <-received; got != "client-id" {
+		t.Errorf("backend got %q, want the client header untouched", got)
+	}
+	if id := rec.Header().Get(DefaultRequestIDHeader); id != "" {
+		t.Errorf("response request id %q, want none", id)
+	}
+}
+
+func TestCustomErrorIDField(t *testing.T) {
+	gateway := newTestGateway(t)
+	gateway.ErrorIDField = "traceId"
+	rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/missing/api", nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q: %v", rec.Body.String(), err)
+	}
+	if id := rec.Header().Get(DefaultRequestIDHeader); id == "" || body["traceId"] != id {
+		t.Errorf("body %v, want traceId %q", body, id)
+	}
+}
+
+func TestErrorFormatter(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{name: "not found", method: http.MethodGet, target: "/nope/get", status: http.StatusNotFound},
+		{name: "method not allowed", method: http.MethodDelete, target: "/svc/get", status: http.StatusMethodNotAllowed},
+		{name: "body too large", method: http.MethodGet, target: "/svc/get", body: strings.Repeat("x", 65), status: http.StatusRequestEntityTooLarge},
+		{name: "rate limited", method: http.MethodGet, target: "/svc/limited", status: http.StatusTooManyRequests},
+		{name: "backend down", method: http.MethodGet, target: "/svc/down", status: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var formatted []*ErrorResponse
+			gateway := newTestGateway(t)
+			gateway.ErrorFormatter = func(e *ErrorResponse) (string, []byte) {
+				formatted = append(formatted, e)
+				return "application/xml", []byte(fmt.Sprintf("<error code=%q request=%q>%v</error>", strconv.Itoa(e.Status), e.RequestID, e.Message))
+			}
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "get", MaxBodyBytes: 64},
+				&API{Name: "limited", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "get", RateLimit: 1, Burst: 1},
+				&API{Name: "down", HTTPMethod: http.MethodGet, Host: freeAddr(t), Path: "down"}))
+			if tt.status == http.StatusTooManyRequests {
+				serveProxy(gateway, httptest.NewRequest(tt.method, tt.target, nil))
+				formatted = nil
+			}
+			rec := serveProxy(gateway, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if len(formatted) != 1 || formatted[0].Status != tt.status || formatted[0].Message == "" {
+				t.Fatalf("formatter called with %+v, want once with status %d", formatted, tt.status)
+			}
+			id := rec.Header().Get(DefaultRequestIDHeader)
+			want := fmt.Sprintf("<error code=\"%d\" request=%q>%v</error>", tt.status, id, formatted[0].Message)
+			if rec.Header().Get("Content-Type") != "application/xml" || rec.Body.String() != want {
+				t.Errorf("got %v %q, want application/xml %q", rec.Header().Get("Content-Type"), rec.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestDefaultErrorFormat(t *testing.T) {
+	gateway := newTestGateway(t)
+	gateway.ErrorIDField = ""
+	rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/nope/get", nil))
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", contentType)
+	}
+	if got, want := rec.Body.String(), `{"error":"path: /nope/get not found","status":404}`+"\n"; got != want {
+		t.Errorf("body %q, want %q", got, want)
+	}
+}
+
+func TestNotFoundMissTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		verbose bool
+		path    string
+		error   string
+		details []string
+	}{
+		{name: "unknown service", verbose: true, path: "/nope/get", error: "service: nope not found", details: []string{"unknown service"}},
+		{name: "unknown api", verbose: true, path: "/user/nope", error: "service: user api: nope not found", details: []string{"unknown api"}},
+		{name: "malformed path", verbose: true, path: "/user", error: "path: /user not found", details: []string{"unknown service"}},
+		{name: "remainder not caught", verbose: true, path: "/user/get/1", error: "service: user api: get not found", details: []string{"unknown api"}},
+		{name: "quiet unknown service", path: "/nope/get", error: "path: /nope/get not found"},
+		{name: "quiet unknown api", path: "/user/nope", error: "path: /user/nope not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			gateway.VerboseNotFound = tt.verbose
+			mustCreateService(t, gateway, newTestService("user",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get"}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("status %d, want 404", rec.Code)
+			}
+			var resp struct {
+				Error   string   `json:"error"`
+				Details []string `json:"details"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body.String(), err)
+			}
+			if resp.Error != tt.error || strings.Join(resp.Details, ",") != strings.Join(tt.details, ",") {
+				t.Errorf("got %q %q, want %q %q", resp.Error, resp.Details, tt.error, tt.details)
+			}
+		})
+	}
+}
+
+func TestNotFoundNotProxied(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		hits   int32
+	}{
+		{name: "resolved", path: "/user/get", status: http.StatusOK, hits: 1},
+		{name: "unknown service", path: "/nope/get", status: http.StatusNotFound},
+		{name: "known service unknown api", path: "/user/nope", status: http.StatusNotFound},
+		{name: "no api", path: "/user", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if got := atomic.LoadInt32(&hits); got != tt.hits {
+				t.Errorf("backend hit %d times, want %d", got, tt.hits)
+			}
+			if tt.status == http.StatusNotFound && !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+				t.Errorf("content type %q, want json", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestThrottleRetryAfter(t *testing.T) {
+	// fill take the only slot of the gateway or api limiter
+	apiFull := func(gateway *APIGateway, api *API) { api.concurrency.slots <- struct{}{} }
+	gatewayFull := func(gateway *APIGateway, api *API) { gateway.globalConcurrency().slots <- struct{}{} }
+	tests := []struct {
+		name       string
+		api        API
+		maxGlobal  int
+		retryAfter time.Duration // gateway default
+		fill       func(gateway *APIGateway, api *API)
+		prime      int // failed requests sent before the throttled one
+		status     int
+		want       string
+	}{
+		{name: "rate limit reset", api: API{RateLimit: 0.5, Burst: 1}, prime: 1, status: http.StatusTooManyRequests, want: "2"},
+		{name: "rate limit rounded up", api: API{RateLimit: 4, Burst: 1}, prime: 1, status: http.StatusTooManyRequests, want: "1"},
+		{name: "breaker cooldown", api: API{FailureThreshold: 1, OpenDurationMs: 10000}, prime: 1, status: http.StatusServiceUnavailable, want: "10"},
+		{name: "api concurrency", api: API{MaxConcurrent: 1}, retryAfter: 3 * time.Second, fill: apiFull, status: http.StatusServiceUnavailable, want: "3"},
+		{name: "gateway concurrency", maxGlobal: 1, retryAfter: 2500 * time.Millisecond, fill: gatewayFull, status: http.StatusServiceUnavailable, want: "3"},
+		{name: "default at least a second", api: API{MaxConcurrent: 1}, retryAfter: time.Millisecond, fill: apiFull, status: http.StatusServiceUnavailable, want: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+			gateway := newTestGateway(t)
+			gateway.MaxConcurrent = tt.maxGlobal
+			if tt.retryAfter > 0 {
+				gateway.RetryAfter = tt.retryAfter
+			}
+			api := tt.api
+			api.Name, api.HTTPMethod, api.Host, api.Path = "get", http.MethodGet, backend, "get"
+			mustCreateService(t, gateway, newTestService("svc", &api))
+			if tt.fill != nil {
+				tt.fill(gateway, &api)
+			}
+			for i := 0; i < tt.prime; i++ {
+				serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil))
+			}
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil))
+			if rec.Code != tt.status || rec.Header().Get("Retry-After") != tt.want {
+				t.Errorf("got %d Retry-After %q, want %d %q", rec.Code, rec.Header().Get("Retry-After"), tt.status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package gateway_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	gateway "github.com/PualrDwade/go-gateway"
+)
+
+// Embed the gateway into a custom mux: the proxy serves the root and the native api is
+// mounted under /admin, routes are registered through Discovery directly
+func Example_embedding() {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "backend %v %v", r.Method, r.URL.Path)
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	g := gateway.NewAPIGateWay()
+	g.Discovery.CreateService(&gateway.Service{Name: "userService", APIs: map[string]*gateway.API{}})
+	g.Discovery.CreateAPI(&gateway.API{Name: "createUser", Service: "userService", HTTPMethod: "POST", Host: host, Path: "user/createUser"})
+
+	mux := http.NewServeMux()
+	mux.Handle("/", g)
+	mux.Handle("/admin/", http.StripPrefix("/admin", g.ServerHandler()))
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "own handler")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, _ := http.Post(server.URL+"/userService/createUser", "application/json", nil)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fmt.Println(resp.StatusCode, string(body))
+
+	resp, _ = http.Get(server.URL + "/admin/healthz")
+	resp.Body.Close()
+	fmt.Println(resp.StatusCode)
+
+	resp, _ = http.Get(server.URL + "/hello")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fmt.Println(resp.StatusCode, string(body))
+	// Output:
+	// 200 backend POST /user/createUser
+	// 200
+	// 200 own handler
+}
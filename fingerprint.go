@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxFingerprints bound the fingerprints remembered for duplicate detection
+const maxFingerprints = 10000
+
+// fingerprintEntry remember when a client last sent a fingerprint
+type fingerprintEntry struct {
+	key  string
+	seen time.Time
+}
+
+// duplicateDetector remember request fingerprints per client for a window, FIFO ordered
+// so expired entries are evicted from the front
+type duplicateDetector struct {
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	entries []fingerprintEntry
+}
+
+// check record key seen at now, return how long ago the same key was seen within
+// window, zero when it was not
+func (d *duplicateDetector) check(key string, now time.Time, window time.Duration) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen == nil {
+		d.seen = make(map[string]time.Time)
+	}
+	// evict expired and overflowing entries, stale queue entries of refreshed keys are skipped
+	for len(d.entries) > 0 && (now.Sub(d.entries[0].seen) > window || len(d.entries) >= maxFingerprints) {
+		front := d.entries[0]
+		d.entries = d.entries[1:]
+		if d.seen[front.key].Equal(front.seen) {
+			delete(d.seen, front.key)
+		}
+	}
+	last, exist := d.seen[key]
+	d.seen[key] = now
+	d.entries = append(d.entries, fingerprintEntry{key: key, seen: now})
+	if exist && now.Sub(last) <= window {
+		return now.Sub(last)
+	}
+	return 0
+}
+
+// requestFingerprint hash method, path, query and the FingerprintHeaders of request
+func (gateway *APIGateway) requestFingerprint(r *http.Request) string {
+	h := fnv.New64a()
+	h.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n"))
+	for _, name := range gateway.FingerprintHeaders {
+		for _, value := range r.Header.Values(name) {
+			h.Write([]byte(name + ":" + value + "\n"))
+		}
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// logDuplicate log requests repeating the fingerprint of a request from the same client
+// within DuplicateWindow, detection is disabled when DuplicateWindow is zero
+func (gateway *APIGateway) logDuplicate(r *http.Request) {
+	if gateway.DuplicateWindow <= 0 {
+		return
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	fingerprint := gateway.requestFingerprint(r)
+	if ago := gateway.duplicates.check(client+" "+fingerprint, time.Now(), gateway.DuplicateWindow); ago > 0 {
+		gateway.logger().Warnf("request: %v duplicate %v %v fingerprint: %v from client: %v, last seen %v ago",
+			requestID(r.Context()), r.Method, r.URL.Path, fingerprint, client, ago)
+	}
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDuplicateDetectorWindow(t *testing.T) {
+	const window = time.Second
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		key  string
+		at   time.Duration // since start
+		ago  time.Duration // reported, zero when not a duplicate
+	}{
+		{name: "first", key: "a", at: 0},
+		{name: "repeated within window", key: "a", at: 300 * time.Millisecond, ago: 300 * time.Millisecond},
+		{name: "other key", key: "b", at: 400 * time.Millisecond},
+		{name: "measured from last sighting", key: "a", at: 1200 * time.Millisecond, ago: 900 * time.Millisecond},
+		{name: "after window", key: "b", at: 1500 * time.Millisecond},
+		{name: "expired", key: "a", at: 5 * time.Second},
+	}
+	d := &duplicateDetector{}
+	for _, tt := range tests {
+		if ago := d.check(tt.key, start.Add(tt.at), window); ago != tt.ago {
+			t.Errorf("%v: ago %v, want %v", tt.name, ago, tt.ago)
+		}
+	}
+	// expired entries are evicted
+	if len(d.seen) != 1 || len(d.entries) != 1 {
+		t.Errorf("%d fingerprints and %d entries remembered, want 1", len(d.seen), len(d.entries))
+	}
+}
+
+func TestDuplicateDetectorBounded(t *testing.T) {
+	d := &duplicateDetector{}
+	now := time.Now()
+	for i := 0; i < maxFingerprints+100; i++ {
+		d.check(fmt.Sprint(i), now, time.Hour)
+	}
+	if len(d.seen) > maxFingerprints || len(d.entries) > maxFingerprints {
+		t.Errorf("%d fingerprints and %d entries remembered, bound %d", len(d.seen), len(d.entries), maxFingerprints)
+	}
+	// the newest is still remembered
+	if ago := d.check(fmt.Sprint(maxFingerprints+99), now.Add(time.Second), time.Hour); ago != time.Second {
+		t.Errorf("newest fingerprint forgotten")
+	}
+}
+
+func TestLogDuplicate(t *testing.T) {
+	request := func(remoteAddr, path, token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", token)
+		req.Header.Set("X-Request-Start", time.Now().String())
+		return req
+	}
+	tests := []struct {
+		name      string
+		window    time.Duration
+		second    *http.Request
+		duplicate bool
+	}{
+		{name: "same client and request", window: time.Minute, second: request("192.0.2.1:2000", "/svc/get?id=1", "a"), duplicate: true},
+		{name: "other client port", window: time.Minute, second: request("192.0.2.1:3000", "/svc/get?id=1", "a"), duplicate: true},
+		{name: "other client", window: time.Minute, second: request("192.0.2.2:1000", "/svc/get?id=1", "a")},
+		{name: "other query", window: time.Minute, second: request("192.0.2.1:1000", "/svc/get?id=2", "a")},
+		{name: "other fingerprint header", window: time.Minute, second: request("192.0.2.1:1000", "/svc/get?id=1", "b")},
+		{name: "disabled", second: request("192.0.2.1:1000", "/svc/get?id=1", "a")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordLogger{}
+			gateway := newTestGateway(t, WithLogger(logger))
+			gateway.DuplicateWindow = tt.window
+			gateway.FingerprintHeaders = []string{"Authorization"}
+			gateway.logDuplicate(request("192.0.2.1:1000", "/svc/get?id=1", "a"))
+			gateway.logDuplicate(tt.second)
+			if _, logged := logger.find(LevelWarn, "duplicate GET /svc/get"); logged != tt.duplicate {
+				t.Errorf("duplicate logged %v, want %v: %q", logged, tt.duplicate, logger.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,1464 @@
+// Package gateway implements an api gateway proxying /{service}/{api} to registered
+// backends, it can run standalone (cmd/go-gateway) or be embedded as an http.Handler
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Service define the api collections
+type Service struct {
+	Name string          `json:"name"`
+	APIs map[string]*API `json:"apis"`
+	// UserAgent override gateway User-Agent for upstream requests of this service
+	UserAgent *UserAgent `json:"userAgent,omitempty"`
+	// UpstreamTLS present client certificate (mTLS) and verify backends of this service
+	UpstreamTLS *UpstreamTLS `json:"upstreamTLS,omitempty"`
+	// AllowedPaths restrict backend paths apis of this service may target to these prefixes,
+	// empty allow any path
+	AllowedPaths []string `json:"allowedPaths,omitempty"`
+	// MaxResponseBytes bound backend response body size of apis, zero means unlimited
+	MaxResponseBytes int64 `json:"maxResponseBytes,omitempty"`
+	// MaxBodyBytes bound client request body size of apis, override gateway limit, zero use gateway limit
+	MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+
+	transport http.RoundTripper // dedicated transport built from UpstreamTLS
+}
+
+// API define the api object
+type API struct {
+	Name       string `json:"name"`       // api name
+	Service    string `json:"service"`    // service name
+	Protocol   string `json:"protocol"`   // http or https, empty use gateway default
+	HTTPMethod string `json:"httpMethod"` // http method
+	Host       string `json:"host"`       // ip:port or domain
+	Path       string `json:"path"`       // request path
+	// HTTPMethods are more methods accepted besides HTTPMethod, both empty accept any method
+	HTTPMethods []string `json:"httpMethods,omitempty"`
+	// Hosts share requests in round-robin, a non-empty Host alone is a single host list
+	Hosts []string `json:"hosts,omitempty"`
+	// Weights parallel Hosts sharing requests in proportion, empty weigh hosts equally, zero never picked
+	Weights []int `json:"weights,omitempty"`
+	// ConsulService resolve Hosts from the healthy instances of this Consul service with
+	// ConsulDiscovery, Host and Hosts then only serve until the first refresh
+	ConsulService string `json:"consulService,omitempty"`
+	// BlueHosts and GreenHosts are two backend pools, Hosts follow the one of ActiveColor
+	// (blue or green, default blue) which SwitchColor flips without re-registering
+	BlueHosts   []string `json:"blueHosts,omitempty"`
+	GreenHosts  []string `json:"greenHosts,omitempty"`
+	ActiveColor string   `json:"activeColor,omitempty"`
+	// Auth require clients to authenticate, nil keep the api public
+	Auth *Auth `json:"auth,omitempty"`
+	// RequestHeaders set headers on requests to backend, an empty value remove the header
+	RequestHeaders map[string]string `json:"requestHeaders,omitempty"`
+	// ResponseHeaders set headers on backend responses, an empty value remove the header
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	// CORS let browsers on the allowed origins call the api, preflights are answered by the gateway
+	CORS *CORS `json:"cors,omitempty"`
+	// HealthCheck actively probe every backend host, hosts failing it are skipped
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+	// RequestSchema validate request body before proxying, inline JSON Schema or schema file path
+	RequestSchema json.RawMessage `json:"requestSchema,omitempty"`
+	RateLimit     float64         `json:"rateLimit,omitempty"` // sustained requests per second, zero means unlimited
+	Burst         int             `json:"burst,omitempty"`     // max requests allowed at once, default rateLimit
+	// RegionHosts map client region to preferred backend hosts shared in round-robin,
+	// fallback to Hosts when none of them is healthy
+	RegionHosts map[string][]string `json:"regionHosts,omitempty"`
+	// Backends are extra tagged hosts, with TagRouting requests carrying tags prefer the
+	// healthy backends having all of them, otherwise Host is used
+	Backends   []Backend `json:"backends,omitempty"`
+	TagRouting bool      `json:"tagRouting,omitempty"`
+	// CatchRemainder append path after /{servicename}/{apiname} to the backend path,
+	// otherwise such requests get 404
+	CatchRemainder bool `json:"catchRemainder,omitempty"`
+	// RequestTransformers and ResponseTransformers name registered transformers run in
+	// order on request bodies and backend response bodies, see WithRequestTransformer
+	RequestTransformers  []string `json:"requestTransformers,omitempty"`
+	ResponseTransformers []string `json:"responseTransformers,omitempty"`
+	// Sticky route each client to the same host instead of round-robin, nil disable it
+	Sticky *Sticky `json:"sticky,omitempty"`
+	// MirrorHost receive a copy of every request in the background, its responses are
+	// discarded and its failures logged, requests with bodies over 1MB are not mirrored
+	MirrorHost string `json:"mirrorHost,omitempty"`
+	// MaxConcurrent bound in-flight requests of this api, zero means unlimited
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	// QueueTimeoutMs wait up to it for a free slot when MaxConcurrent is reached, zero reject at once
+	QueueTimeoutMs int `json:"queueTimeoutMs,omitempty"`
+	// MaxResponseBytes bound backend response body size, override service limit, zero use service limit
+	MaxResponseBytes int64 `json:"maxResponseBytes,omitempty"`
+	// MaxBodyBytes bound client request body size, override service limit, zero use service limit
+	MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+	// TruncateResponse truncate and log over-large backend responses instead of aborting them
+	TruncateResponse bool `json:"truncateResponse,omitempty"`
+	// Retries retry idempotent requests without body when the backend can not be reached or
+	// answer a retry status, on another healthy host when there is one, bounded by the
+	// gateway retry budget
+	Retries int `json:"retries,omitempty"`
+	// RetryBackoffMs is the base of exponential backoff with jitter between retries, default 50
+	RetryBackoffMs int `json:"retryBackoffMs,omitempty"`
+	// RetryStatuses are the backend statuses retried, default 502, 503 and 504
+	RetryStatuses []int `json:"retryStatuses,omitempty"`
+	// RetryNonIdempotent also retry POST and PATCH requests, only for backends deduplicating them
+	RetryNonIdempotent bool `json:"retryNonIdempotent,omitempty"`
+	// FailureThreshold open the circuit breaker after these consecutive 5xx or failed
+	// requests, requests then fail fast with 503, zero disable the breaker
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+	// OpenDurationMs keep the breaker open before a probe request is let through, default 5000
+	OpenDurationMs int `json:"openDurationMs,omitempty"`
+	// ResponseMode is streamed or buffered, empty use the gateway ResponseMode
+	ResponseMode string `json:"responseMode,omitempty"`
+	// Compress gzip backend responses for clients accepting gzip, when the backend does not
+	Compress bool `json:"compress,omitempty"`
+	// Streaming flush backend response to client immediately, never size limited
+	Streaming bool `json:"streaming,omitempty"`
+	// TimeoutMs bound the whole proxied request, 504 once it elapses before the backend
+	// responds, zero means no timeout, streaming apis are bounded by IdleTimeoutMs instead
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+	// IdleTimeoutMs close a streaming connection no data flowed through for it, zero use the
+	// gateway StreamIdleTimeout, the total duration of a stream is never bounded
+	IdleTimeoutMs int `json:"idleTimeoutMs,omitempty"`
+	// AnswerContinue answer Expect: 100-continue at the gateway instead of forwarding it,
+	// for backends which never send 100 Continue
+	AnswerContinue bool `json:"answerContinue,omitempty"`
+	// CacheTTLSeconds serve 200 responses of GET requests from memory for it, keyed by request
+	// uri and shared by all clients, zero disable caching, responses to requests carrying
+	// credentials (Authorization or Auth of the api) are only shared when marked public
+	CacheTTLSeconds int `json:"cacheTTLSeconds,omitempty"`
+	// RewriteRules rewrite the backend path with the first rule matching it, e.g. pattern
+	// ^/v1/(.*)$ with replacement /internal/$1
+	RewriteRules []RewriteRule `json:"rewriteRules,omitempty"`
+	// AllowIPs restrict clients to these addresses or CIDRs, empty allow any client
+	AllowIPs []string `json:"allowIPs,omitempty"`
+	// DenyIPs reject clients from these addresses or CIDRs, even when allowed
+	DenyIPs []string `json:"denyIPs,omitempty"`
+
+	methods     []string            // HTTPMethod and HTTPMethods upper-cased, empty accept any
+	schema      *jsonSchema         // compiled RequestSchema
+	limiter     *tokenBucket        // token bucket built from RateLimit and Burst
+	concurrency *concurrencyLimiter // in-flight limiter built from MaxConcurrent
+	balancer    *roundRobin         // weighted round-robin state over Hosts
+	breaker     *circuitBreaker     // circuit breaker built from FailureThreshold
+	responses   *responseCache      // response cache built from CacheTTLSeconds
+	rewrites    []pathRewrite       // compiled RewriteRules
+	allowNets   []*net.IPNet        // parsed AllowIPs
+	denyNets    []*net.IPNet        // parsed DenyIPs
+
+	regionBalancers map[string]*roundRobin // round-robin state over the hosts of each region
+}
+
+// Discovery discovery the service by service name
+type Discovery interface {
+	// GetService get service by serviceName
+	GetService(serviceName string) (*Service, error)
+	// CreateService create new service
+	CreateService(service *Service) error
+	// CreateAPI create api object for given serviceName
+	CreateAPI(api *API) error
+	// CreateAlias map alias name to an existing service (or alias)
+	CreateAlias(alias, target string) error
+	// DeleteService remove service with its apis and the aliases leading to it
+	DeleteService(serviceName string) error
+	// UpdateAPI replace an existing api of its service
+	UpdateAPI(api *API) error
+	// DeleteAPI remove api of given serviceName
+	DeleteAPI(serviceName, apiName string) error
+	// ListServices return a snapshot copy of all services
+	ListServices() []*Service
+	// CreateAPIKey register key for the apikey apis of its service
+	CreateAPIKey(key *APIKey) error
+	// DeleteAPIKey revoke key of service
+	DeleteAPIKey(serviceName, key string) error
+	// ValidAPIKey report whether key is registered for service
+	ValidAPIKey(serviceName, key string) bool
+	// SwitchColor route a blue-green api to the pool of color, empty toggle it, return the
+	// active color
+	SwitchColor(serviceName, apiName, color string) (string, error)
+}
+
+// Errors wrapped by Discovery implementations, the message names what is missing or taken
+var (
+	ErrNotExist     = errors.New("not exist")
+	ErrAlreadyExist = errors.New("already exist")
+)
+
+// Alias define an alternative route name for a service
+type Alias struct {
+	Alias  string `json:"alias"`  // alias name
+	Target string `json:"target"` // service name or another alias
+}
+
+// cache implements Discovery interface used local store
+type cache struct {
+	store      map[string]*Service
+	aliases    map[string]string
+	apiKeys    map[string]map[string]string // service name to api key digest to key name
+	mu         sync.RWMutex
+	idempotent bool
+	logger     Logger
+}
+
+// CacheOption configure the cache discovery
+type CacheOption func(c *cache)
+
+// WithIdempotentCreate make re-registering an identical service or api a no-op success,
+// only conflicting definitions are rejected, default creation is strict
+func WithIdempotentCreate() CacheOption {
+	return func(c *cache) {
+		c.idempotent = true
+	}
+}
+
+// NewCacheDiscovery return cache implements fot Discovery
+func NewCacheDiscovery(opts ...CacheOption) Discovery {
+	c := &cache{
+		store:   make(map[string]*Service),
+		aliases: make(map[string]string),
+		apiKeys: make(map[string]map[string]string),
+		mu:      sync.RWMutex{},
+		logger:  defaultLogger,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// GetService get service by serviceName, aliases are resolved transparently
+func (c *cache) GetService(serviceName string) (*Service, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	service, exist := c.store[c.resolve(serviceName)]
+	if !exist {
+		return nil, fmt.Errorf("service: %v %w", serviceName, ErrNotExist)
+	}
+	return service, nil
+}
+
+// resolve follow the alias chain of name, must be called with lock held
+func (c *cache) resolve(name string) string {
+	// aliases never form cycles, the bound only guards against bugs
+	for i := 0; i <= len(c.aliases); i++ {
+		target, exist := c.aliases[name]
+		if !exist {
+			return name
+		}
+		name = target
+	}
+	return name
+}
+
+// CreateAlias map alias name to an existing service (or alias)
+func (c *cache) CreateAlias(alias, target string) error {
+	if alias == "" || target == "" {
+		return fmt.Errorf("alias and target can not be empty")
+	}
+	if alias == target {
+		return fmt.Errorf("alias: %v can not point to itself", alias)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// alias can not shadow a real service
+	if _, exist := c.store[alias]; exist {
+		return fmt.Errorf("alias: %v collides with existing service", alias)
+	}
+	// walk the target chain to make sure it ends in a service without looping back
+	name := target
+	for {
+		if name == alias {
+			return fmt.Errorf("alias: %v -> %v would create a cycle", alias, target)
+		}
+		next, exist := c.aliases[name]
+		if !exist {
+			break
+		}
+		name = next
+	}
+	if _, exist := c.store[name]; !exist {
+		return fmt.Errorf("service: %v %w", target, ErrNotExist)
+	}
+	c.aliases[alias] = target
+	return nil
+}
+
+// CreateService create new service
+func (c *cache) CreateService(service *Service) error {
+	if err := c.prepareService(service); err != nil {
+		return err
+	}
+	// not allow duplicate service with samename
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	existService, exist := c.store[service.Name]
+	if exist {
+		if c.idempotent && sameService(existService, service) {
+			return nil
+		}
+		return fmt.Errorf("service: %v %w", service.Name, ErrAlreadyExist)
+	}
+	if _, exist = c.aliases[service.Name]; exist {
+		return fmt.Errorf("service: %v collides with existing alias", service.Name)
+	}
+	// add to cache store
+	c.store[service.Name] = service
+	return nil
+}
+
+// prepareService validate service with its apis and build their runtime state
+func (c *cache) prepareService(service *Service) error {
+	if service == nil || service.Name == "" {
+		return fmt.Errorf("service can not be empty")
+	}
+	if service.UserAgent != nil {
+		if err := service.UserAgent.validate(); err != nil {
+			return fmt.Errorf("service: %v %v", service.Name, err)
+		}
+	}
+	if service.MaxResponseBytes < 0 {
+		return fmt.Errorf("service: %v maxResponseBytes can not be negative", service.Name)
+	}
+	if err := validateMaxBodyBytes(service.MaxBodyBytes); err != nil {
+		return fmt.Errorf("service: %v %v", service.Name, err)
+	}
+	if err := normalizeAllowedPaths(service); err != nil {
+		return err
+	}
+	// services registered without apis get them later through CreateAPI
+	if service.APIs == nil {
+		service.APIs = make(map[string]*API)
+	}
+	for name, api := range service.APIs {
+		if api == nil {
+			return fmt.Errorf("service: %v api: %v can not be null", service.Name, name)
+		}
+		if err := normalizeAPI(api); err != nil {
+			return err
+		}
+		if err := checkAllowedPath(service, api); err != nil {
+			return err
+		}
+	}
+	if service.UpstreamTLS != nil {
+		transport, err := service.UpstreamTLS.newTransport(c.logger)
+		if err != nil {
+			return fmt.Errorf("service: %v upstream tls invalid: %v", service.Name, err)
+		}
+		service.transport = transport
+	}
+	return nil
+}
+
+// sameService report whether two services have the same definition, the auth secrets of
+// their apis included so that a changed secret is not taken for the same service
+func sameService(a, b *Service) bool {
+	da, err := encodeService(a)
+	if err != nil {
+		return false
+	}
+	db, err := encodeService(b)
+	return err == nil && da == db
+}
+
+// sameAPI report whether two apis have the same definition, auth secrets included
+func sameAPI(a, b *API) bool {
+	da, err := encodeAPI(a)
+	if err != nil {
+		return false
+	}
+	db, err := encodeAPI(b)
+	return err == nil && da == db
+}
+
+// apiMethods are the http methods an api may be registered with
+var apiMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// knownMethod report whether method is one of apiMethods, ignoring case
+func knownMethod(method string) bool {
+	for _, known := range apiMethods {
+		if strings.EqualFold(method, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAPI check the routing fields of api, so that malformed apis are rejected when
+// registered instead of failing at proxy time, errors name the offending field
+func validateAPI(api *API) error {
+	switch strings.ToLower(strings.TrimSpace(api.Protocol)) {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("api: %v protocol: %q unsupported, should be http or https", api.Name, api.Protocol)
+	}
+	if api.HTTPMethod != "" && !knownMethod(api.HTTPMethod) {
+		return fmt.Errorf("api: %v httpMethod: %q unsupported, should be one of %v", api.Name, api.HTTPMethod, strings.Join(apiMethods, ", "))
+	}
+	for _, method := range api.HTTPMethods {
+		if !knownMethod(method) {
+			return fmt.Errorf("api: %v httpMethods: %q unsupported, should be one of %v", api.Name, method, strings.Join(apiMethods, ", "))
+		}
+	}
+	// hosts of consul apis are resolved later
+	if api.Host == "" && len(api.Hosts) == 0 && api.ConsulService == "" && !blueGreen(api) {
+		return fmt.Errorf("api: %v host can not be empty", api.Name)
+	}
+	if api.Host != "" {
+		if err := validateHost(api.Host); err != nil {
+			return fmt.Errorf("api: %v %v", api.Name, err)
+		}
+	}
+	if api.Path == "" {
+		return fmt.Errorf("api: %v path can not be empty", api.Name)
+	}
+	return nil
+}
+
+// normalizeAPI validate and normalize api fields before stored
+func normalizeAPI(api *API) error {
+	if err := validateAPI(api); err != nil {
+		return err
+	}
+	api.Protocol = strings.ToLower(strings.TrimSpace(api.Protocol))
+	api.methods = acceptedMethods(api)
+	schema, err := compileRequestSchema(api.RequestSchema)
+	if err != nil {
+		return fmt.Errorf("api: %v request schema invalid: %v", api.Name, err)
+	}
+	api.schema = schema
+	if err := normalizeRegionHosts(api); err != nil {
+		return err
+	}
+	if api.RateLimit < 0 || api.Burst < 0 {
+		return fmt.Errorf("api: %v rateLimit and burst can not be negative", api.Name)
+	}
+	api.limiter = nil
+	if api.RateLimit > 0 {
+		api.limiter = newTokenBucket(api.RateLimit, api.Burst)
+	}
+	if api.MaxConcurrent < 0 || api.QueueTimeoutMs < 0 {
+		return fmt.Errorf("api: %v maxConcurrent and queueTimeoutMs can not be negative", api.Name)
+	}
+	api.concurrency = nil
+	if api.MaxConcurrent > 0 {
+		api.concurrency = newConcurrencyLimiter(api.MaxConcurrent, time.Duration(api.QueueTimeoutMs)*time.Millisecond)
+	}
+	if err := validateResponseMode(api.ResponseMode); err != nil {
+		return fmt.Errorf("api: %v %v", api.Name, err)
+	}
+	if api.Streaming && api.ResponseMode == ResponseBuffered {
+		return fmt.Errorf("api: %v streaming api can not be buffered", api.Name)
+	}
+	if api.TimeoutMs < 0 {
+		return fmt.Errorf("api: %v timeoutMs can not be negative", api.Name)
+	}
+	if api.TimeoutMs > 0 && api.Streaming {
+		return fmt.Errorf("api: %v timeoutMs does not apply to streaming api, use idleTimeoutMs", api.Name)
+	}
+	if api.IdleTimeoutMs < 0 {
+		return fmt.Errorf("api: %v idleTimeoutMs can not be negative", api.Name)
+	}
+	if api.IdleTimeoutMs > 0 && !api.Streaming {
+		return fmt.Errorf("api: %v idleTimeoutMs only apply to streaming api", api.Name)
+	}
+	if err := normalizeColors(api); err != nil {
+		return err
+	}
+	if err := normalizeHosts(api); err != nil {
+		return err
+	}
+	if err := normalizeHealthCheck(api); err != nil {
+		return err
+	}
+	if err := normalizeBreaker(api); err != nil {
+		return err
+	}
+	if err := normalizeResponseCache(api); err != nil {
+		return err
+	}
+	if err := normalizeAuth(api); err != nil {
+		return err
+	}
+	if err := normalizeCORS(api); err != nil {
+		return err
+	}
+	if err := normalizeIPFilter(api); err != nil {
+		return err
+	}
+	if err := normalizeRewrites(api); err != nil {
+		return err
+	}
+	if err := normalizeHeaders(api); err != nil {
+		return err
+	}
+	if err := validateBackends(api); err != nil {
+		return err
+	}
+	if err := normalizeMirror(api); err != nil {
+		return err
+	}
+	if err := normalizeSticky(api); err != nil {
+		return err
+	}
+	if err := normalizeTransformers(api); err != nil {
+		return err
+	}
+	if api.Retries < 0 || api.RetryBackoffMs < 0 {
+		return fmt.Errorf("api: %v retries and retryBackoffMs can not be negative", api.Name)
+	}
+	for _, status := range api.RetryStatuses {
+		if status < http.StatusBadRequest || status > 599 {
+			return fmt.Errorf("api: %v retry status: %v should be a 4xx or 5xx status", api.Name, status)
+		}
+	}
+	if api.MaxResponseBytes < 0 {
+		return fmt.Errorf("api: %v maxResponseBytes can not be negative", api.Name)
+	}
+	if err := validateMaxBodyBytes(api.MaxBodyBytes); err != nil {
+		return fmt.Errorf("api: %v %v", api.Name, err)
+	}
+	return nil
+}
+
+// CreateAPI create api object for given serviceName
+func (c *cache) CreateAPI(api *API) error {
+	if api == nil || api.Name == "" {
+		return fmt.Errorf("api can not be empty")
+	}
+	serviceName := api.Service
+	if serviceName == "" {
+		return fmt.Errorf("service name can not be empty")
+	}
+	if err := normalizeAPI(api); err != nil {
+		return err
+	}
+	// not allow duplicate service with samename
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := c.resolve(serviceName)
+	service, exist := c.store[name]
+	if !exist {
+		return fmt.Errorf("service: %v %w", serviceName, ErrNotExist)
+	}
+	existAPI, exist := service.APIs[api.Name]
+	if exist {
+		if c.idempotent && sameAPI(existAPI, api) {
+			return nil
+		}
+		return fmt.Errorf("service: %v, api: %v %w", serviceName, api.Name, ErrAlreadyExist)
+	}
+	if err := checkAllowedPath(service, api); err != nil {
+		return err
+	}
+	// add api to cache store
+	c.store[name] = withAPI(service, api.Name, api)
+	return nil
+}
+
+// withAPI return a copy of service with api stored as name, or without name when api is
+// nil, requests read the apis of the service they resolved without lock, so registered
+// services are replaced instead of modified
+func withAPI(service *Service, name string, api *API) *Service {
+	updated := *service
+	updated.APIs = make(map[string]*API, len(service.APIs)+1)
+	for n, a := range service.APIs {
+		updated.APIs[n] = a
+	}
+	if api == nil {
+		delete(updated.APIs, name)
+	} else {
+		updated.APIs[name] = api
+	}
+	return &updated
+}
+
+// UpdateAPI replace the existing api of the same name in its service, requests resolved
+// afterwards use the new definition while in-flight ones complete with the old one
+func (c *cache) UpdateAPI(api *API) error {
+	if api == nil || api.Name == "" {
+		return fmt.Errorf("api can not be empty")
+	}
+	serviceName := api.Service
+	if serviceName == "" {
+		return fmt.Errorf("service name can not be empty")
+	}
+	if err := normalizeAPI(api); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := c.resolve(serviceName)
+	service, exist := c.store[name]
+	if !exist {
+		return fmt.Errorf("service: %v %w", serviceName, ErrNotExist)
+	}
+	if _, exist := service.APIs[api.Name]; !exist {
+		return fmt.Errorf("service: %v, api: %v %w", serviceName, api.Name, ErrNotExist)
+	}
+	if err := checkAllowedPath(service, api); err != nil {
+		return err
+	}
+	c.store[name] = withAPI(service, api.Name, api)
+	return nil
+}
+
+// DeleteAPI remove api of given serviceName
+func (c *cache) DeleteAPI(serviceName, apiName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := c.resolve(serviceName)
+	service, exist := c.store[name]
+	if !exist {
+		return fmt.Errorf("service: %v %w", serviceName, ErrNotExist)
+	}
+	if _, exist := service.APIs[apiName]; !exist {
+		return fmt.Errorf("service: %v, api: %v %w", serviceName, apiName, ErrNotExist)
+	}
+	c.store[name] = withAPI(service, apiName, nil)
+	return nil
+}
+
+// DeleteService remove service with its apis, aliases leading to it are removed as well
+// so that they do not dangle
+func (c *cache) DeleteService(serviceName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exist := c.store[serviceName]; !exist {
+		return fmt.Errorf("service: %v %w", serviceName, ErrNotExist)
+	}
+	for alias := range c.aliases {
+		if c.resolve(alias) == serviceName {
+			delete(c.aliases, alias)
+		}
+	}
+	delete(c.store, serviceName)
+	delete(c.apiKeys, serviceName)
+	return nil
+}
+
+// APIGateway control the access to backend service and apis
+type APIGateway struct {
+	inFlight int64 // proxied requests in progress, first field for 64-bit atomic alignment
+	// Discovery resolve services and apis, register routes through it directly when embedded
+	Discovery Discovery
+	// DefaultScheme used for apis registered without protocol
+	DefaultScheme string
+	// AutoHTTPS use https for apis without protocol whose backend port is 443
+	AutoHTTPS bool
+	// UserAgent set on upstream requests, services can override it
+	UserAgent *UserAgent
+	// DeadlineHeader carry request deadline from client and to backend in milliseconds,
+	// empty disable the header while grpc-timeout is still honored
+	DeadlineHeader string
+	// ClientCertHeaders forward verified client certificate details to backends,
+	// client supplied values of these headers are always stripped
+	ClientCertHeaders *ClientCertHeaders
+	// Tracer start a span around every proxied request and propagate its context to
+	// backends, nil disable tracing
+	Tracer Tracer
+	// LatencySLA shed a fraction of new requests with 503 while recent p99 latency
+	// exceeds it, zero disable load shedding
+	LatencySLA  time.Duration
+	loadShedder *loadShedder
+	shedOnce    sync.Once
+	// Compress gzip responses of every api for clients accepting gzip, apis may also set
+	// their own Compress
+	Compress bool
+	// CompressMinBytes is the smallest response body gzipped, zero gzip any size
+	CompressMinBytes int64
+	// ResponseMode is streamed or buffered for apis without their own mode, empty means streamed
+	ResponseMode string
+	// transformers apis refer to by name, set by WithRequestTransformer and WithResponseTransformer
+	requestTransformers  map[string]RequestTransformer
+	responseTransformers map[string]ResponseTransformer
+	// Transport reach backends of services without upstreamTLS, nil build one pooling
+	// connections as MaxIdleConns, MaxIdleConnsPerHost and IdleConnTimeout say, the
+	// settings are read on the first request
+	Transport           http.RoundTripper
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	transport           http.RoundTripper
+	transportOnce       sync.Once
+	proxy               *httputil.ReverseProxy
+	streamingProxy      *httputil.ReverseProxy
+	proxyOnce           sync.Once
+	mirrors             *httputil.ReverseProxy // sends copies of requests to MirrorHost
+	mirrorSlots         chan struct{}
+	mirrorOnce          sync.Once
+	// MaxConcurrent bound in-flight requests across all apis, on top of the limits of each
+	// api, zero means unlimited, QueueTimeout wait up to it for a free slot, zero reject at
+	// once, both are read on the first request
+	MaxConcurrent   int
+	QueueTimeout    time.Duration
+	concurrency     *concurrencyLimiter
+	concurrencyOnce sync.Once
+	// RetryBudget is the fraction of requests across the gateway that may be retries,
+	// retries are skipped once it is used up
+	RetryBudget float64
+	retries     *retryBudget
+	retryOnce   sync.Once
+	health      healthTracker // passive health of backends
+	// DrainPeriod let in-flight requests of a backend turned unhealthy complete before
+	// they are canceled, new requests avoid it at once, zero cancel them at once
+	DrainPeriod time.Duration
+	// EjectionPeriod is how long new requests avoid a backend turned unhealthy, afterwards
+	// they try it again, a failure eject it for another period and a success restore it
+	EjectionPeriod time.Duration
+	errors         errorLog // recent gateway errors
+	metrics        requestMetrics
+	// RegionHeader carry client region used to select RegionHosts of api
+	RegionHeader string
+	// TagHeader carry request tags matched against api Backends tags, empty disable tag routing
+	TagHeader string
+	// GeoIP resolve client region when RegionHeader is absent, optional
+	GeoIP          GeoIPFunc
+	trustedProxies []*net.IPNet // proxies whose X-Forwarded-For is honored, see SetTrustedProxies
+	// RequestIDHeader carry request correlation id, generated when client does not send one
+	RequestIDHeader string
+	// MaxBodyBytes bound client request body size, services and apis may override it, zero means unlimited
+	MaxBodyBytes int64
+	// StreamIdleTimeout close streaming connections no data flowed through for it, zero never
+	StreamIdleTimeout time.Duration
+	// RetryAfter is advertised on 429/503 responses whose subsystem knows no better wait
+	RetryAfter time.Duration
+	// ErrorIDField is the field of error response body carrying the request id, empty omit it
+	ErrorIDField string
+	// ErrorFormatter render the body of gateway error responses, nil answer json
+	ErrorFormatter ErrorFormatter
+	// TLSCertFile and TLSKeyFile serve the proxy over https when set, the files are
+	// watched and reloaded on change
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCAFile verify client certificates presented to the https proxy against the
+	// CAs of this PEM file, so that ClientCertHeaders can forward them, clients without
+	// certificate are still served
+	TLSClientCAFile string
+	// ServerTLSCertFile and ServerTLSKeyFile serve the native api server over https when
+	// set, reloaded on change as the proxy ones
+	ServerTLSCertFile string
+	ServerTLSKeyFile  string
+	// AnswerOptions answer OPTIONS requests at the gateway with the allowed methods
+	// instead of proxying them, for infrastructure probes
+	AnswerOptions bool
+	// DuplicateWindow log requests repeating the fingerprint (method, uri and FingerprintHeaders)
+	// of a request from the same client within it, zero disable the detection
+	DuplicateWindow    time.Duration
+	FingerprintHeaders []string
+	duplicates         duplicateDetector
+	// VerboseNotFound tell unknown service from unknown api in 404 response details,
+	// it reveals route structure so keep it off for public gateways
+	VerboseNotFound bool
+	// CatchRemainder append extra path segments to backend path for all apis
+	CatchRemainder bool
+	// ReusePort bind listeners with SO_REUSEPORT (linux only) so a new process
+	// can take over the ports while this one drains
+	ReusePort bool
+	// Logger receive the logs of the gateway, nil use the standard logger at info level
+	Logger Logger
+	// AccessLogFormat select access log format: common, combined or json, empty disable access log
+	AccessLogFormat string
+	// AccessLog receive access log lines, default os.Stdout
+	AccessLog io.Writer
+	logMu     sync.Mutex
+	// ServerListenAddr and ProxyListenAddr are the addresses RunServer and RunProxy
+	// bind, use ":0" to get a randomly assigned port
+	ServerListenAddr string
+	ProxyListenAddr  string
+	addrMu           sync.RWMutex
+	serverAddr       net.Addr
+	proxyAddr        net.Addr
+	serversMu        sync.Mutex
+	servers          []*http.Server
+	ready            int32 // 1 once SetReady(true), see Ready
+	configMu         sync.Mutex
+	configServices   map[string]bool // services registered by LoadConfig, see ReloadConfig
+	pipeline         []pipelineStage // stages run on resolved requests, see SetPipeline
+	pipelineNames    []string
+	pipelineOmitted  []string // stages left out which some apis may rely on
+}
+
+// DefaultServerListenAddr is the default address of native api server
+const DefaultServerListenAddr = ":9000"
+
+// DefaultProxyListenAddr is the default address of proxy
+const DefaultProxyListenAddr = ":9001"
+
+// Option configure the gateway created by NewAPIGateWay
+type Option func(gateway *APIGateway)
+
+// WithDiscovery resolve services and apis through discovery instead of the cache discovery
+func WithDiscovery(discovery Discovery) Option {
+	return func(gateway *APIGateway) {
+		gateway.Discovery = discovery
+	}
+}
+
+// WithServerAddr bind native api server to addr instead of DefaultServerListenAddr
+func WithServerAddr(addr string) Option {
+	return func(gateway *APIGateway) {
+		gateway.ServerListenAddr = addr
+	}
+}
+
+// WithProxyAddr bind proxy to addr instead of DefaultProxyListenAddr
+func WithProxyAddr(addr string) Option {
+	return func(gateway *APIGateway) {
+		gateway.ProxyListenAddr = addr
+	}
+}
+
+// NewAPIGateWay create instructed api gateway to handle user request, the cache discovery
+// is used and the default addresses are bound unless opts say otherwise
+func NewAPIGateWay(opts ...Option) *APIGateway {
+	gateway := &APIGateway{
+		DefaultScheme:       "http",
+		DeadlineHeader:      DefaultDeadlineHeader,
+		ClientCertHeaders:   DefaultClientCertHeaders(),
+		RegionHeader:        DefaultRegionHeader,
+		TagHeader:           DefaultTagHeader,
+		RequestIDHeader:     DefaultRequestIDHeader,
+		ErrorIDField:        DefaultErrorIDField,
+		RetryAfter:          DefaultRetryAfter,
+		StreamIdleTimeout:   DefaultStreamIdleTimeout,
+		ServerListenAddr:    DefaultServerListenAddr,
+		ProxyListenAddr:     DefaultProxyListenAddr,
+		RetryBudget:         DefaultRetryBudget,
+		DrainPeriod:         DefaultDrainPeriod,
+		EjectionPeriod:      DefaultEjectionPeriod,
+		CompressMinBytes:    DefaultCompressMinBytes,
+		MaxIdleConns:        DefaultMaxIdleConns,
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     DefaultIdleConnTimeout,
+	}
+	// the default pipeline is always valid
+	gateway.SetPipeline(DefaultPipeline)
+	for _, opt := range opts {
+		opt(gateway)
+	}
+	if gateway.Discovery == nil {
+		gateway.Discovery = NewCacheDiscovery()
+	}
+	return gateway
+}
+
+// director point the upstream request to the backend of the route resolved by ServeHTTP
+func (gateway *APIGateway) director(req *http.Request) {
+	rt := routeOf(req.Context())
+	if rt == nil {
+		gateway.logger().Errorf("request: %v path: %v not resolved", requestID(req.Context()), req.URL.Path)
+		return
+	}
+	service, api := rt.service, rt.api
+	gateway.logger().Debugf("request: %v service name: %v, api name: %v", requestID(req.Context()), service.Name, api.Name)
+	if entry, ok := req.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.service = service.Name
+		entry.api = api.Name
+		entry.backend = rt.backend
+	}
+	gateway.backendURL(req.URL, rt)
+	if service.UserAgent != nil {
+		service.UserAgent.apply(req.Header)
+	} else {
+		gateway.UserAgent.apply(req.Header)
+	}
+	gateway.propagateDeadline(req)
+	gateway.forwardRequestID(req)
+	gateway.tracer().Inject(req.Context(), req.Header)
+	gateway.ClientCertHeaders.forward(req)
+	// before the Host may be rewritten
+	gateway.forwardedHeaders(req)
+	rewriteRequestHeaders(req, api)
+	// the transport then ask for gzip itself and decode it for the response transformers
+	if len(api.ResponseTransformers) > 0 {
+		req.Header.Del("Accept-Encoding")
+	}
+	// by default Expect is forwarded, the transport waits for the backend 100 Continue before
+	// sending the body and the client gets its own 100 Continue once the body is read
+	if api.AnswerContinue {
+		req.Header.Del("Expect")
+	}
+	bindRequestTrailer(req)
+}
+
+// backendURL point u, the url of a request resolved to rt, to the backend of rt
+func (gateway *APIGateway) backendURL(u *url.URL, rt *route) {
+	api := rt.api
+	u.Scheme = gateway.backendScheme(api, rt.backend)
+	u.Host = rt.backend
+	escaped := u.EscapedPath()
+	apiPath, apiQuery := api.backendPath()
+	u.Path = "/" + apiPath
+	u.RawPath = ""
+	// the query fixed by api path goes first, the client query string is kept as is
+	if apiQuery != "" && u.RawQuery != "" {
+		u.RawQuery = apiQuery + "&" + u.RawQuery
+	} else if apiQuery != "" {
+		u.RawQuery = apiQuery
+	}
+	if gateway.catchRemainder(api) {
+		u.Path += rt.remainder
+		// keep escaping of the remainder such as %2F
+		if segments := strings.SplitN(escaped, "/", 4); len(segments) == 4 {
+			if rawPath := "/" + apiPath + "/" + segments[3]; rawPath != u.Path {
+				if unescaped, err := url.PathUnescape(rawPath); err == nil && unescaped == u.Path {
+					u.RawPath = rawPath
+				}
+			}
+		}
+	}
+	if rewritten := rewritePath(api, u.Path); rewritten != u.Path {
+		u.Path = rewritten
+		u.RawPath = ""
+	}
+}
+
+// routeKey is the context key of *route
+type routeKey struct{}
+
+// route is the service and api a request resolved to
+type route struct {
+	service   *Service
+	api       *API
+	path      string // path requested by the client
+	remainder string // path after /{servicename}/{apiname}, empty if none
+	backend   string // backend host chosen for the request
+	cacheKey  string // key the response is cached by, empty if not cached
+	upgrade   bool   // the client ask to switch protocols, the connection is tunneled
+	// the request carry credentials, its response is only cached when marked public
+	cacheCredentials bool
+	// the backend answered with server-sent events, set once the response headers arrive
+	eventStream bool
+}
+
+// routeOf return the route stored in ctx, nil if not resolved
+func routeOf(ctx context.Context) *route {
+	rt, _ := ctx.Value(routeKey{}).(*route)
+	return rt
+}
+
+// lookup find service and api by request path just as: /{servicename}/{apiname}[/remainder]
+func (gateway *APIGateway) lookup(reqPath string) (*route, error) {
+	pathArray := strings.SplitN(reqPath, "/", 4)
+	if len(pathArray) < 3 || pathArray[0] != "" {
+		return nil, fmt.Errorf("%w: request path: %v format error", errUnknownService, reqPath)
+	}
+	serviceName := pathArray[1]
+	apiName := pathArray[2]
+	remainder := ""
+	if len(pathArray) == 4 {
+		remainder = "/" + pathArray[3]
+	}
+	// use service discovery
+	service, err := gateway.Discovery.GetService(serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: use discovery to get service failed: %v", errUnknownService, err)
+	}
+	// reorgnize request to true api backend
+	api, exist := service.APIs[apiName]
+	if !exist {
+		return nil, fmt.Errorf("%w: service: %v not has api: %v", errUnknownAPI, serviceName, apiName)
+	}
+	return &route{service: service, api: api, path: reqPath, remainder: remainder}, nil
+}
+
+// backendPath split the api path into the backend path and the query it may fix,
+// e.g. search?type=user, leading slashes are dropped so that user and /user are the same
+func (api *API) backendPath() (string, string) {
+	p := strings.TrimLeft(api.Path, "/")
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+// catchRemainder report whether extra path segments are appended to the backend path of api,
+// otherwise requests with extra segments are rejected
+func (gateway *APIGateway) catchRemainder(api *API) bool {
+	return gateway.CatchRemainder || api.CatchRemainder
+}
+
+// backendScheme return the scheme used to reach api backend host
+func (gateway *APIGateway) backendScheme(api *API, host string) string {
+	if api.Protocol != "" {
+		return api.Protocol
+	}
+	if gateway.AutoHTTPS {
+		if _, port, err := net.SplitHostPort(host); err == nil && port == "443" {
+			return "https"
+		}
+	}
+	if gateway.DefaultScheme != "" {
+		return gateway.DefaultScheme
+	}
+	return "http"
+}
+
+// ServeHTTP use gateway as a handler
+func (gateway *APIGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&gateway.inFlight, 1)
+	defer atomic.AddInt64(&gateway.inFlight, -1)
+	gateway.legacyRequest(w, r)
+	rec := &responseRecorder{ResponseWriter: w}
+	entry := &accessEntry{}
+	// the request id is assigned first so that access log lines carry it
+	r = gateway.withRequestID(r)
+	gateway.echoRequestID(rec, r)
+	r = r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry))
+	defer gateway.writeAccessLog(r, rec, entry, start)
+	defer gateway.countRequest(rec, entry)
+	r, cancel := gateway.clientDeadline(withTraceID(r))
+	defer cancel()
+	gateway.logDuplicate(r)
+	if gateway.answerOptions(rec, r) {
+		return
+	}
+	if gateway.shedLoad(rec, r) {
+		return
+	}
+	rt, err := gateway.lookup(r.URL.Path)
+	if err != nil {
+		gateway.routeNotFound(rec, r, err)
+		return
+	}
+	api := rt.api
+	entry.service, entry.api = rt.service.Name, api.Name
+	if rt.remainder != "" && !gateway.catchRemainder(api) {
+		gateway.routeNotFound(rec, r, fmt.Errorf("%w: api: %v does not catch remainder: %v", errUnknownAPI, api.Name, rt.remainder))
+		return
+	}
+	// remainders and rewrites may lead the backend path out of the allowed paths
+	if apiPath, _ := api.backendPath(); (rt.remainder != "" || len(api.rewrites) > 0) &&
+		!pathAllowed(rt.service.AllowedPaths, strings.TrimPrefix(rewritePath(api, "/"+apiPath+rt.remainder), "/")) {
+		gateway.writeError(rec, r, http.StatusForbidden, fmt.Sprintf("path: %v not allowed", r.URL.Path))
+		return
+	}
+	if !gateway.checkMethod(rec, r, api) {
+		return
+	}
+	if !gateway.limitRequest(rec, r, rt) {
+		return
+	}
+	r = r.WithContext(withRoute(r.Context(), rt))
+	ok, done := gateway.runPipeline(rec, r, rt)
+	defer done()
+	if !ok {
+		return
+	}
+	// only requests let through by the pipeline are pinned to a backend
+	rt.upgrade = isUpgrade(r)
+	rt.backend = gateway.stickyBackend(rec, r, api)
+	if rt.backend == "" {
+		gateway.throttle(rec, r, http.StatusServiceUnavailable, fmt.Sprintf("api: %v has no backend", api.Name), 0)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), BackendContextKey, rt.backend))
+	if !gateway.transformRequest(rec, r, rt) {
+		return
+	}
+	if gateway.serveCached(rec, r, rt) {
+		return
+	}
+	allowed, recordOutcome := gateway.allowBreaker(rec, r, api)
+	if !allowed {
+		return
+	}
+	// the proxy panic with http.ErrAbortHandler when the response can not be copied
+	aborted := true
+	defer func() { recordOutcome(rec.status, aborted) }()
+	r, cancelTimeout := apiTimeout(r, rt)
+	defer cancelTimeout()
+	r, stopIdle := gateway.withIdleTimeout(r, rt)
+	defer stopIdle()
+	r = gateway.mirror(r, rt)
+	forwardRequestTrailer(r)
+	r, span := gateway.startSpan(r, rt)
+	gateway.reverseProxy(rt).ServeHTTP(rec, r)
+	aborted = false
+	endSpan(span, rt, rec.status)
+	gateway.metrics.observe(rt.service.Name, api.Name, time.Since(start), TraceIDFromContext(r.Context()))
+	// long-lived streams say nothing about backend latency
+	if shedder := gateway.shedder(); shedder != nil && !rt.streaming() {
+		shedder.observe(time.Since(start))
+	}
+}
+
+// writeJSON write v as json response body with status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// legacyRequest tolerate HTTP/1.0 clients, they may omit Host header and
+// expect the connection to be closed unless keep-alive is asked explicitly
+func (gateway *APIGateway) legacyRequest(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoAtLeast(1, 1) {
+		return
+	}
+	// routing only depends on the path, an empty Host lets the proxy
+	// fallback to the backend host when building the upstream request
+	if r.Host == "" {
+		gateway.logger().Debugf("HTTP/1.0 request: %v without Host header", r.URL.Path)
+	}
+	if !headerHasToken(r.Header, "Connection", "keep-alive") {
+		r.Close = true
+		w.Header().Set("Connection", "close")
+	}
+}
+
+// headerHasToken report whether comma separated header key contains token
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, value := range header[key] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ServerHandler return the handler of native api for service/api operations,
+// the gateway itself is the proxy handler
+func (gateway *APIGateway) ServerHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/createService", gateway.CreateService)
+	mux.HandleFunc("/createAPI", gateway.CreateAPI)
+	mux.HandleFunc("/createAlias", gateway.CreateAlias)
+	mux.HandleFunc("/deleteService", gateway.DeleteService)
+	mux.HandleFunc("/listServices", gateway.ListServices)
+	mux.HandleFunc("/createAPIKey", gateway.CreateAPIKey)
+	mux.HandleFunc("/deleteAPIKey", gateway.DeleteAPIKey)
+	mux.HandleFunc("/getService", gateway.GetService)
+	mux.HandleFunc("/updateAPI", gateway.UpdateAPI)
+	mux.HandleFunc("/deleteAPI", gateway.DeleteAPI)
+	mux.HandleFunc("/switchColor", gateway.SwitchColor)
+	mux.HandleFunc("/routes", gateway.Routes)
+	mux.HandleFunc("/routeTest", gateway.RouteTest)
+	mux.HandleFunc("/stats", gateway.Stats)
+	mux.HandleFunc("/metrics", gateway.Metrics)
+	mux.HandleFunc("/healthz", gateway.Healthz)
+	mux.HandleFunc("/readyz", gateway.Readyz)
+	mux.Handle("/admin/api/v1/", gateway.adminAPI())
+	return mux
+}
+
+// RunServer start to provide native api for service/api operations
+func (gateway *APIGateway) RunServer() error {
+	listener, err := gateway.listen(gateway.ServerListenAddr)
+	if err != nil {
+		return err
+	}
+	gateway.addrMu.Lock()
+	gateway.serverAddr = listener.Addr()
+	gateway.addrMu.Unlock()
+	listener, err = gateway.withTLS(listener, gateway.ServerTLSCertFile, gateway.ServerTLSKeyFile, "", "gateway server")
+	if err != nil {
+		return err
+	}
+	return gateway.serve(listener, gateway.ServerHandler())
+}
+
+// RunProxy start to reserve proxy user request
+func (gateway *APIGateway) RunProxy() error {
+	listener, err := gateway.listen(gateway.ProxyListenAddr)
+	if err != nil {
+		return err
+	}
+	gateway.addrMu.Lock()
+	gateway.proxyAddr = listener.Addr()
+	gateway.addrMu.Unlock()
+	listener, err = gateway.withTLS(listener, gateway.TLSCertFile, gateway.TLSKeyFile, gateway.TLSClientCAFile, "gateway proxy")
+	if err != nil {
+		return err
+	}
+	return gateway.serve(listener, gateway)
+}
+
+// withTLS wrap listener to terminate https with the certificate reloaded from certFile and
+// keyFile, client certificates are verified against clientCAFile when set, listener is
+// returned as is when certFile is empty, name is logged with the url
+func (gateway *APIGateway) withTLS(listener net.Listener, certFile, keyFile, clientCAFile, name string) (net.Listener, error) {
+	if certFile == "" {
+		if clientCAFile != "" {
+			listener.Close()
+			return nil, fmt.Errorf("%v: client ca file: %v requires a certificate", name, clientCAFile)
+		}
+		gateway.logger().Infof("%v started at http://%v", name, listener.Addr())
+		return listener, nil
+	}
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	config := reloader.TLSConfig()
+	if clientCAFile != "" {
+		if config.ClientCAs, err = loadCertPool(clientCAFile); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	reloader.Logger = gateway.logger()
+	go reloader.Watch(context.Background(), DefaultCertReloadInterval)
+	gateway.logger().Infof("%v started at https://%v", name, listener.Addr())
+	return tls.NewListener(listener, config), nil
+}
+
+// ServerAddr return the address native api server listens on, nil before RunServer binds it
+func (gateway *APIGateway) ServerAddr() net.Addr {
+	gateway.addrMu.RLock()
+	defer gateway.addrMu.RUnlock()
+	return gateway.serverAddr
+}
+
+// ProxyAddr return the address proxy listens on, nil before RunProxy binds it
+func (gateway *APIGateway) ProxyAddr() net.Addr {
+	gateway.addrMu.RLock()
+	defer gateway.addrMu.RUnlock()
+	return gateway.proxyAddr
+}
+
+// listen create tcp listener on addr, enable SO_REUSEPORT when configured
+func (gateway *APIGateway) listen(addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if gateway.ReusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// CreateService handle http request to register service
+func (gateway *APIGateway) CreateService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	data, ok := readAdminBody(w, r)
+	if !ok {
+		return
+	}
+	var service Service
+	err := json.Unmarshal(data, &service)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+		return
+	}
+	err = gateway.Discovery.CreateService(&service)
+	if err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("create service failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, adminResult{Result: "success"})
+}
+
+// adminResult is the json body answered by admin handlers
+type adminResult struct {
+	Result string `json:"result,omitempty"` // success
+	Error  string `json:"error,omitempty"`
+}
+
+// writeAdminError answer an admin request failing with status
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, adminResult{Error: message})
+}
+
+// maxAdminBodyBytes bound the body of admin requests, service definitions with inline
+// schemas stay far below it
+const maxAdminBodyBytes = 4 << 20
+
+// readAdminBody read the body of an admin request, answer 413 when it exceeds
+// maxAdminBodyBytes and 400 when it can not be read, return false once answered
+func readAdminBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAdminBodyBytes))
+	if err != nil && len(data) == maxAdminBodyBytes {
+		writeAdminError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body larger than %d bytes", maxAdminBodyBytes))
+		return nil, false
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("read request body failed: %v", err))
+		return nil, false
+	}
+	return data, true
+}
+
+// registryErrorStatus map a Discovery error to its http status, other errors are invalid definitions
+func registryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrAlreadyExist):
+		return http.StatusConflict
+	case errors.Is(err, ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, errRegistryUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// CreateAPI handle http request to register service api
+func (gateway *APIGateway) CreateAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	data, ok := readAdminBody(w, r)
+	if !ok {
+		return
+	}
+	var api API
+	err := json.Unmarshal(data, &api)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+		return
+	}
+	// discoveries other than the cache may not validate apis
+	if err := validateAPI(&api); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid api: %v", err))
+		return
+	}
+	err = gateway.Discovery.CreateAPI(&api)
+	if err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("create api failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, adminResult{Result: "success"})
+}
+
+// CreateAlias handle http request to register service alias
+func (gateway *APIGateway) CreateAlias(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	data, ok := readAdminBody(w, r)
+	if !ok {
+		return
+	}
+	var alias Alias
+	err := json.Unmarshal(data, &alias)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+		return
+	}
+	err = gateway.Discovery.CreateAlias(alias.Alias, alias.Target)
+	if err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("create alias failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, adminResult{Result: "success"})
+}
+
+// DeleteService handle http request to remove service, the name is given by query
+// parameter name with DELETE or by json body {"name": ...} with POST
+func (gateway *APIGateway) DeleteService(w http.ResponseWriter, r *http.Request) {
+	var name string
+	switch r.Method {
+	case http.MethodDelete:
+		name = r.URL.Query().Get("name")
+	case http.MethodPost:
+		data, ok := readAdminBody(w, r)
+		if !ok {
+			return
+		}
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+			return
+		}
+		name = body.Name
+	default:
+		w.Header().Set("Allow", http.MethodDelete+", "+http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	if err := gateway.Discovery.DeleteService(name); err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("delete service failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, adminResult{Result: "success"})
+}
+
+// UpdateAPI handle http request to replace registered service api
+func (gateway *APIGateway) UpdateAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPost+", "+http.MethodPut)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	data, ok := readAdminBody(w, r)
+	if !ok {
+		return
+	}
+	var api API
+	err := json.Unmarshal(data, &api)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+		return
+	}
+	if err := validateAPI(&api); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid api: %v", err))
+		return
+	}
+	err = gateway.Discovery.UpdateAPI(&api)
+	if err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("update api failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, adminResult{Result: "success"})
+}
+
+// DeleteAPI handle http request to remove service api, the names are given by query
+// parameters service and name with DELETE or by json body {"service": ..., "name": ...} with POST
+func (gateway *APIGateway) DeleteAPI(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Service string `json:"service"`
+		Name    string `json:"name"`
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		body.Service = r.URL.Query().Get("service")
+		body.Name = r.URL.Query().Get("name")
+	case http.MethodPost:
+		data, ok := readAdminBody(w, r)
+		if !ok {
+			return
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unmarshal request body failed: %v", err))
+			return
+		}
+	default:
+		w.Header().Set("Allow", http.MethodDelete+", "+http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	if err := gateway.Discovery.DeleteAPI(body.Service, body.Name); err != nil {
+		writeAdminError(w, registryErrorStatus(err), fmt.Sprintf("delete api failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, adminResult{Result: "success"})
+}
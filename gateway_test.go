@@ -0,0 +1,1364 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// discardLogger drop the logs of gateways and discoveries under test
+var discardLogger = NewStdLogger(log.New(ioutil.Discard, "", 0), LevelDebug)
+
+// newTestGateway return a gateway logging nowhere, configured by opts
+func newTestGateway(t *testing.T, opts ...Option) *APIGateway {
+	t.Helper()
+	defaults := []Option{
+		WithLogger(discardLogger),
+		WithDiscovery(NewCacheDiscovery(WithCacheLogger(discardLogger))),
+	}
+	return NewAPIGateWay(append(defaults, opts...)...)
+}
+
+// newTestService return service name owning apis
+func newTestService(name string, apis ...*API) *Service {
+	service := &Service{Name: name, APIs: make(map[string]*API)}
+	for _, api := range apis {
+		api.Service = name
+		service.APIs[api.Name] = api
+	}
+	return service
+}
+
+// mustCreateService register services or fail the test
+func mustCreateService(t *testing.T, gateway *APIGateway, services ...*Service) {
+	t.Helper()
+	for _, service := range services {
+		if err := gateway.Discovery.CreateService(service); err != nil {
+			t.Fatalf("create service: %v", err)
+		}
+	}
+}
+
+// newBackend start a backend served by handler for the duration of the test, return its
+// host:port
+func newBackend(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+// namedBackend start a backend answering every request with name
+func namedBackend(t *testing.T, name string) string {
+	t.Helper()
+	return newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	})
+}
+
+// serveProxy send req through the proxy of gateway
+func serveProxy(gateway *APIGateway, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	gateway.ServeHTTP(rec, req)
+	return rec
+}
+
+// serveAdmin send a request with body to the native api of gateway
+func serveAdmin(gateway *APIGateway, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	gateway.ServerHandler().ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+// freeAddr return a loopback address with a port nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// waitAddr poll addr until the gateway has bound its listener
+func waitAddr(t *testing.T, addr func() net.Addr) net.Addr {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if bound := addr(); bound != nil {
+			return bound
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("listener not bound in time")
+	return nil
+}
+
+func TestListenAddrOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		option func(addr string) Option
+		run    func(gateway *APIGateway) error
+		addr   func(gateway *APIGateway) net.Addr
+		path   string
+		status int
+	}{
+		{
+			name:   "server",
+			option: WithServerAddr,
+			run:    (*APIGateway).RunServer,
+			addr:   (*APIGateway).ServerAddr,
+			path:   "/healthz",
+			status: http.StatusOK,
+		},
+		{
+			name:   "proxy",
+			option: WithProxyAddr,
+			run:    (*APIGateway).RunProxy,
+			addr:   (*APIGateway).ProxyAddr,
+			path:   "/unknown/api",
+			status: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := freeAddr(t)
+			gateway := NewAPIGateWay(tt.option(want))
+			errs := make(chan error, 1)
+			go func() { errs <- tt.run(gateway) }()
+			if got := waitAddr(t, func() net.Addr { return tt.addr(gateway) }).String(); got != want {
+				t.Fatalf("listen on %v, want %v", got, want)
+			}
+			resp, err := http.Get(fmt.Sprintf("http://%v%v", want, tt.path))
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if err := gateway.Shutdown(context.Background()); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatalf("run: %v", err)
+			}
+		})
+	}
+}
+
+func TestRandomPorts(t *testing.T) {
+	gateway := newTestGateway(t, WithServerAddr("127.0.0.1:0"), WithProxyAddr("127.0.0.1:0"))
+	if gateway.ServerAddr() != nil || gateway.ProxyAddr() != nil {
+		t.Fatalf("addresses known before listening")
+	}
+	errs := make(chan error, 2)
+	go func() { errs <- gateway.RunServer() }()
+	go func() { errs <- gateway.RunProxy() }()
+	server := waitAddr(t, gateway.ServerAddr)
+	proxy := waitAddr(t, gateway.ProxyAddr)
+	defer func() {
+		if err := gateway.Shutdown(context.Background()); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := <-errs; err != nil {
+				t.Errorf("run: %v", err)
+			}
+		}
+	}()
+	for _, addr := range []net.Addr{server, proxy} {
+		if addr.(*net.TCPAddr).Port == 0 {
+			t.Fatalf("port of %v not resolved", addr)
+		}
+	}
+	body := fmt.Sprintf(`{"name":"svc","apis":{"hello":{"name":"hello","service":"svc","httpMethod":"GET","host":%q,"path":"hello"}}}`, namedBackend(t, "hello"))
+	resp, err := http.Post(fmt.Sprintf("http://%v/createService", server), "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create service status %d", resp.StatusCode)
+	}
+	resp, err = http.Get(fmt.Sprintf("http://%v/svc/hello", proxy))
+	if err != nil {
+		t.Fatalf("proxy: %v", err)
+	}
+	defer resp.Body.Close()
+	got, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(got) != "hello" {
+		t.Errorf("got %d %q, want 200 %q", resp.StatusCode, got, "hello")
+	}
+}
+
+func TestTLSListeners(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	dir := tempDir(t)
+	_, certPEM, keyPEM := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}})
+	certFile := writeTestFile(t, dir, "gateway.crt", certPEM)
+	keyFile := writeTestFile(t, dir, "gateway.key", keyPEM)
+	gateway := newTestGateway(t, WithServerAddr("127.0.0.1:0"), WithProxyAddr("127.0.0.1:0"))
+	gateway.TLSCertFile, gateway.TLSKeyFile = certFile, keyFile
+	gateway.ServerTLSCertFile, gateway.ServerTLSKeyFile = certFile, keyFile
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "hello", HTTPMethod: http.MethodGet, Host: namedBackend(t, "hello"), Path: "hello"}))
+	errs := make(chan error, 2)
+	go func() { errs <- gateway.RunServer() }()
+	go func() { errs <- gateway.RunProxy() }()
+	server := waitAddr(t, gateway.ServerAddr)
+	proxy := waitAddr(t, gateway.ProxyAddr)
+	defer func() {
+		gateway.Shutdown(context.Background())
+		<-errs
+		<-errs
+	}()
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool()}}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		body   string // expected prefix of the body
+	}{
+		{name: "proxy over https", url: fmt.Sprintf("https://%v/svc/hello", proxy), status: http.StatusOK, body: "hello"},
+		{name: "server over https", url: fmt.Sprintf("https://%v/getService?name=svc", server), status: http.StatusOK, body: "{"},
+		{name: "plain http to proxy", url: fmt.Sprintf("http://%v/svc/hello", proxy), status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(tt.url)
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status || !strings.HasPrefix(string(body), tt.body) {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestTLSListenerRejected(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	dir := tempDir(t)
+	_, certPEM, _ := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}})
+	certFile := writeTestFile(t, dir, "gateway.crt", certPEM)
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+	}{
+		{name: "missing certificate", certFile: filepath.Join(dir, "missing.crt"), keyFile: filepath.Join(dir, "missing.key")},
+		{name: "missing key", certFile: certFile, keyFile: filepath.Join(dir, "missing.key")},
+		{name: "key not matching", certFile: certFile, keyFile: certFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t, WithServerAddr("127.0.0.1:0"), WithProxyAddr("127.0.0.1:0"))
+			gateway.TLSCertFile, gateway.TLSKeyFile = tt.certFile, tt.keyFile
+			gateway.ServerTLSCertFile, gateway.ServerTLSKeyFile = tt.certFile, tt.keyFile
+			if err := gateway.RunProxy(); err == nil {
+				t.Errorf("proxy started with invalid certificate")
+			}
+			if err := gateway.RunServer(); err == nil {
+				t.Errorf("server started with invalid certificate")
+			}
+		})
+	}
+}
+
+func TestDefaultListenAddrs(t *testing.T) {
+	gateway := NewAPIGateWay()
+	if gateway.ServerListenAddr != DefaultServerListenAddr {
+		t.Errorf("server addr %v, want %v", gateway.ServerListenAddr, DefaultServerListenAddr)
+	}
+	if gateway.ProxyListenAddr != DefaultProxyListenAddr {
+		t.Errorf("proxy addr %v, want %v", gateway.ProxyListenAddr, DefaultProxyListenAddr)
+	}
+}
+
+// stubDiscovery serve a fixed service and record the registry calls made through it
+type stubDiscovery struct {
+	Discovery // unused methods panic
+	mu        sync.Mutex
+	service   *Service
+	lookups   []string
+	created   []string
+}
+
+func (d *stubDiscovery) GetService(serviceName string) (*Service, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lookups = append(d.lookups, serviceName)
+	if serviceName != d.service.Name {
+		return nil, fmt.Errorf("service: %v %w", serviceName, ErrNotExist)
+	}
+	return d.service, nil
+}
+
+func (d *stubDiscovery) CreateService(service *Service) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.created = append(d.created, service.Name)
+	return nil
+}
+
+func TestInjectedDiscovery(t *testing.T) {
+	api := &API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "stub"), Path: "get"}
+	if err := normalizeAPI(api); err != nil {
+		t.Fatalf("normalize api: %v", err)
+	}
+	tests := []struct {
+		name    string
+		admin   bool
+		target  string
+		status  int
+		lookups []string
+		created []string
+	}{
+		{name: "proxied through discovery", target: "/stub/get", status: http.StatusOK, lookups: []string{"stub"}},
+		{name: "unknown service", target: "/other/get", status: http.StatusNotFound, lookups: []string{"other"}},
+		{name: "admin writes to discovery", admin: true, target: "/createService", status: http.StatusCreated, created: []string{"order"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discovery := &stubDiscovery{service: newTestService("stub", api)}
+			gateway := NewAPIGateWay(WithLogger(discardLogger), WithDiscovery(discovery))
+			if gateway.Discovery != discovery {
+				t.Fatalf("discovery not injected")
+			}
+			var rec *httptest.ResponseRecorder
+			if tt.admin {
+				rec = serveAdmin(gateway, http.MethodPost, tt.target, `{"name":"order"}`)
+			} else {
+				rec = serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if fmt.Sprint(discovery.lookups) != fmt.Sprint(tt.lookups) || fmt.Sprint(discovery.created) != fmt.Sprint(tt.created) {
+				t.Errorf("lookups %q created %q, want %q and %q", discovery.lookups, discovery.created, tt.lookups, tt.created)
+			}
+		})
+	}
+}
+
+func TestDefaultDiscovery(t *testing.T) {
+	gateway := NewAPIGateWay(WithLogger(discardLogger))
+	if _, ok := gateway.Discovery.(*cache); !ok {
+		t.Fatalf("default discovery %T, want the in-memory cache", gateway.Discovery)
+	}
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "cache"), Path: "get"}))
+	if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil)); rec.Body.String() != "cache" {
+		t.Errorf("got %d %q, want cache", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAliasResolution(t *testing.T) {
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("user",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "user"), Path: "get"}))
+	if err := gateway.Discovery.CreateAlias("account", "user"); err != nil {
+		t.Fatalf("create alias: %v", err)
+	}
+	if err := gateway.Discovery.CreateAlias("member", "account"); err != nil {
+		t.Fatalf("create chained alias: %v", err)
+	}
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "service", path: "/user/get"},
+		{name: "alias", path: "/account/get"},
+		{name: "alias chain", path: "/member/get"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, err := gateway.Discovery.GetService(strings.Split(tt.path, "/")[1])
+			if err != nil {
+				t.Fatalf("get service: %v", err)
+			}
+			if service.Name != "user" {
+				t.Errorf("resolved to %v, want user", service.Name)
+			}
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != "user" {
+				t.Errorf("got %d %q, want 200 from user backend", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAliasCollision(t *testing.T) {
+	tests := []struct {
+		name    string
+		alias   string
+		target  string
+		service string // service created after the aliases
+		wantErr error
+	}{
+		{name: "shadow service", alias: "order", target: "user"},
+		{name: "self", alias: "loop", target: "loop"},
+		{name: "cycle", alias: "account", target: "member"},
+		{name: "missing target", alias: "ghost", target: "nobody", wantErr: ErrNotExist},
+		{name: "empty", alias: "", target: "user"},
+		{name: "service shadow alias", service: "account"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user"), newTestService("order"))
+			if err := gateway.Discovery.CreateAlias("account", "user"); err != nil {
+				t.Fatalf("create alias: %v", err)
+			}
+			if err := gateway.Discovery.CreateAlias("member", "account"); err != nil {
+				t.Fatalf("create alias: %v", err)
+			}
+			var err error
+			if tt.service != "" {
+				err = gateway.Discovery.CreateService(newTestService(tt.service))
+			} else {
+				err = gateway.Discovery.CreateAlias(tt.alias, tt.target)
+			}
+			if err == nil {
+				t.Fatalf("collision accepted")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v, want %v", err, tt.wantErr)
+			}
+			if service, err := gateway.Discovery.GetService("account"); err != nil || service.Name != "user" {
+				t.Errorf("existing alias changed: %v %v", service, err)
+			}
+		})
+	}
+}
+
+func TestCreateAliasHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{name: "created", method: http.MethodPost, body: `{"alias":"account","target":"user"}`, status: http.StatusCreated},
+		{name: "missing target", method: http.MethodPost, body: `{"alias":"account","target":"nobody"}`, status: http.StatusNotFound},
+		{name: "shadow service", method: http.MethodPost, body: `{"alias":"user","target":"user"}`, status: http.StatusBadRequest},
+		{name: "malformed", method: http.MethodPost, body: `{`, status: http.StatusBadRequest},
+		{name: "method", method: http.MethodGet, status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user"))
+			rec := serveAdmin(gateway, tt.method, "/createAlias", tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateHandlersStatus(t *testing.T) {
+	const api = `{"service":"user","name":"get","httpMethod":"GET","host":"127.0.0.1:8080","path":"get"}`
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		result string // expected result, empty when an error is expected
+	}{
+		{name: "service created", method: http.MethodPost, target: "/createService", body: `{"name":"order"}`, status: http.StatusCreated, result: "success"},
+		{name: "service malformed", method: http.MethodPost, target: "/createService", body: `{"name":`, status: http.StatusBadRequest},
+		{name: "service invalid", method: http.MethodPost, target: "/createService", body: `{"name":""}`, status: http.StatusBadRequest},
+		{name: "service duplicate", method: http.MethodPost, target: "/createService", body: `{"name":"user"}`, status: http.StatusConflict},
+		{name: "service get", method: http.MethodGet, target: "/createService", status: http.StatusMethodNotAllowed},
+		{name: "service put", method: http.MethodPut, target: "/createService", body: `{"name":"order"}`, status: http.StatusMethodNotAllowed},
+		{name: "api created", method: http.MethodPost, target: "/createAPI", body: api, status: http.StatusCreated, result: "success"},
+		{name: "api malformed", method: http.MethodPost, target: "/createAPI", body: `[`, status: http.StatusBadRequest},
+		{name: "api invalid", method: http.MethodPost, target: "/createAPI", body: `{"service":"user","name":"get","httpMethod":"GET","path":"get"}`, status: http.StatusBadRequest},
+		{name: "api unknown service", method: http.MethodPost, target: "/createAPI", body: strings.Replace(api, `"user"`, `"nobody"`, 1), status: http.StatusNotFound},
+		{name: "api duplicate", method: http.MethodPost, target: "/createAPI", body: strings.Replace(api, `"get"`, `"list"`, 1), status: http.StatusConflict},
+		{name: "api get", method: http.MethodGet, target: "/createAPI", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user",
+				&API{Name: "list", HTTPMethod: http.MethodGet, Host: "127.0.0.1:8080", Path: "list"}))
+			rec := serveAdmin(gateway, tt.method, tt.target, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+				t.Errorf("Content-Type %q, want json", contentType)
+			}
+			if allow := rec.Header().Get("Allow"); (tt.status == http.StatusMethodNotAllowed) != (allow == http.MethodPost) {
+				t.Errorf("Allow %q for status %d", allow, rec.Code)
+			}
+			var result adminResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("body %q: %v", rec.Body.String(), err)
+			}
+			if result.Result != tt.result || (tt.result == "") == (result.Error == "") {
+				t.Errorf("body %+v, want result %q", result, tt.result)
+			}
+		})
+	}
+}
+
+// failingReader return data then fail as a connection dropped mid-body
+type failingReader struct {
+	data io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestAdminBodyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   func() io.Reader
+		status int
+	}{
+		{
+			name:   "service read error",
+			target: "/createService",
+			body:   func() io.Reader { return &failingReader{data: strings.NewReader(`{"name":"order"}`)} },
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "api read error",
+			target: "/createAPI",
+			body:   func() io.Reader { return &failingReader{data: strings.NewReader(`{"service":"order"`)} },
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "service oversized",
+			target: "/createService",
+			body: func() io.Reader {
+				return strings.NewReader(`{"name":"order","x":"` + strings.Repeat("a", maxAdminBodyBytes) + `"}`)
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "api oversized",
+			target: "/createAPI",
+			body:   func() io.Reader { return strings.NewReader(strings.Repeat(" ", maxAdminBodyBytes+1)) },
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "at the limit",
+			target: "/createService",
+			body: func() io.Reader {
+				return strings.NewReader(`{"name":"order"}` + strings.Repeat(" ", maxAdminBodyBytes-len(`{"name":"order"}`)))
+			},
+			status: http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			rec := httptest.NewRecorder()
+			gateway.ServerHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.target, tt.body()))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %.200s", rec.Code, tt.status, rec.Body.String())
+			}
+			_, err := gateway.Discovery.GetService("order")
+			if created := err == nil; created != (tt.status == http.StatusCreated) {
+				t.Errorf("service created %v for status %d", created, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHTTP10Clients(t *testing.T) {
+	gateway := newTestGateway(t)
+	var backendHost string
+	var mu sync.Mutex
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		backendHost = r.Host
+		mu.Unlock()
+		fmt.Fprint(w, "pong")
+	})
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "ping", HTTPMethod: http.MethodGet, Host: backend, Path: "ping"}))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+	tests := []struct {
+		name      string
+		request   string
+		host      string // received by backend, empty the backend address
+		keepAlive bool
+	}{
+		{name: "without host", request: "GET /svc/ping HTTP/1.0\r\n\r\n"},
+		{name: "with host", request: "GET /svc/ping HTTP/1.0\r\nHost: gateway.local\r\n\r\n", host: "gateway.local"},
+		{name: "keep-alive", request: "GET /svc/ping HTTP/1.0\r\nConnection: keep-alive\r\n\r\n", keepAlive: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+			for i := 0; i < 2; i++ {
+				if _, err := io.WriteString(conn, tt.request); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+				resp, err := http.ReadResponse(reader, nil)
+				if err != nil {
+					t.Fatalf("read response: %v", err)
+				}
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || string(body) != "pong" {
+					t.Fatalf("got %d %q, want 200 pong", resp.StatusCode, body)
+				}
+				mu.Lock()
+				host := backendHost
+				mu.Unlock()
+				want := tt.host
+				if want == "" {
+					want = backend
+				}
+				if host != want {
+					t.Errorf("backend got host %q, want %q", host, want)
+				}
+				if !tt.keepAlive {
+					if !resp.Close {
+						t.Errorf("connection kept open without keep-alive")
+					}
+					if _, err := reader.ReadByte(); err != io.EOF {
+						t.Errorf("read after response: %v, want EOF", err)
+					}
+					return
+				}
+				if resp.Close {
+					t.Fatalf("connection closed despite keep-alive")
+				}
+			}
+		})
+	}
+}
+
+func TestBackendScheme(t *testing.T) {
+	tests := []struct {
+		name          string
+		protocol      string
+		defaultScheme string
+		autoHTTPS     bool
+		host          string
+		want          string
+	}{
+		{name: "explicit protocol", protocol: "https", defaultScheme: "http", host: "10.0.0.1:8080", want: "https"},
+		{name: "empty protocol", defaultScheme: "http", host: "10.0.0.1:8080", want: "http"},
+		{name: "gateway default", defaultScheme: "https", host: "10.0.0.1:8080", want: "https"},
+		{name: "no default", host: "10.0.0.1:8080", want: "http"},
+		{name: "auto https on 443", defaultScheme: "http", autoHTTPS: true, host: "10.0.0.1:443", want: "https"},
+		{name: "auto https on other port", defaultScheme: "http", autoHTTPS: true, host: "10.0.0.1:8443", want: "http"},
+		{name: "auto https without port", defaultScheme: "http", autoHTTPS: true, host: "example.com", want: "http"},
+		{name: "protocol over auto https", protocol: "http", autoHTTPS: true, host: "10.0.0.1:443", want: "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			gateway.DefaultScheme = tt.defaultScheme
+			gateway.AutoHTTPS = tt.autoHTTPS
+			if got := gateway.backendScheme(&API{Protocol: tt.protocol}, tt.host); got != tt.want {
+				t.Errorf("scheme %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyProtocolProxied(t *testing.T) {
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "ping", HTTPMethod: http.MethodGet, Host: namedBackend(t, "pong"), Path: "ping"}))
+	rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/ping", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "pong" {
+		t.Errorf("got %d %q, want 200 pong", rec.Code, rec.Body.String())
+	}
+}
+
+// idempotentAPI return a fresh definition of the api re-registered by TestIdempotentCreate
+func idempotentAPI(host, secret string) *API {
+	return &API{
+		Name:       "get",
+		Service:    "user",
+		HTTPMethod: http.MethodGet,
+		Host:       host,
+		Path:       "users",
+		Auth:       &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: secret},
+	}
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		idempotent bool
+		host       string
+		secret     string
+		wantErr    bool
+	}{
+		{name: "identical strict", host: "10.0.0.1:80", secret: "s3cret", wantErr: true},
+		{name: "identical idempotent", idempotent: true, host: "10.0.0.1:80", secret: "s3cret"},
+		{name: "conflicting host", idempotent: true, host: "10.0.0.2:80", secret: "s3cret", wantErr: true},
+		{name: "rotated secret", idempotent: true, host: "10.0.0.1:80", secret: "r0tated", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" service", func(t *testing.T) {
+			var opts []CacheOption
+			if tt.idempotent {
+				opts = append(opts, WithIdempotentCreate())
+			}
+			discovery := NewCacheDiscovery(append(opts, WithCacheLogger(discardLogger))...)
+			if err := discovery.CreateService(newTestService("user", idempotentAPI("10.0.0.1:80", "s3cret"))); err != nil {
+				t.Fatalf("create service: %v", err)
+			}
+			err := discovery.CreateService(newTestService("user", idempotentAPI(tt.host, tt.secret)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("re-register error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrAlreadyExist) {
+				t.Errorf("error %v, want %v", err, ErrAlreadyExist)
+			}
+			service, _ := discovery.GetService("user")
+			if api := service.APIs["get"]; api.Host != "10.0.0.1:80" || api.Auth.Secret != "s3cret" {
+				t.Errorf("registered api changed to %v %v", api.Host, api.Auth.Secret)
+			}
+		})
+		t.Run(tt.name+" api", func(t *testing.T) {
+			var opts []CacheOption
+			if tt.idempotent {
+				opts = append(opts, WithIdempotentCreate())
+			}
+			discovery := NewCacheDiscovery(append(opts, WithCacheLogger(discardLogger))...)
+			if err := discovery.CreateService(newTestService("user")); err != nil {
+				t.Fatalf("create service: %v", err)
+			}
+			if err := discovery.CreateAPI(idempotentAPI("10.0.0.1:80", "s3cret")); err != nil {
+				t.Fatalf("create api: %v", err)
+			}
+			err := discovery.CreateAPI(idempotentAPI(tt.host, tt.secret))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("re-register error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrAlreadyExist) {
+				t.Errorf("error %v, want %v", err, ErrAlreadyExist)
+			}
+		})
+	}
+}
+
+func TestExpectContinueUpload(t *testing.T) {
+	tests := []struct {
+		name           string
+		answerContinue bool
+		wantExpect     string
+	}{
+		{name: "forwarded", wantExpect: "100-continue"},
+		{name: "answered by gateway", answerContinue: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				expect := r.Header.Get("Expect")
+				body, _ := ioutil.ReadAll(r.Body)
+				fmt.Fprintf(w, "%v|%s", expect, body)
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name: "upload", HTTPMethod: http.MethodPost, Host: backend, Path: "upload", AnswerContinue: tt.answerContinue,
+			}))
+			server := httptest.NewServer(gateway)
+			defer server.Close()
+			// without 100 Continue from the gateway the client would wait the whole timeout
+			client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 10 * time.Second}}
+			defer client.CloseIdleConnections()
+			payload := strings.Repeat("x", 64<<10)
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/svc/upload", strings.NewReader(payload))
+			req.Header.Set("Expect", "100-continue")
+			start := time.Now()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("upload took %v, 100 Continue not sent", elapsed)
+			}
+			if want := tt.wantExpect + "|" + payload; resp.StatusCode != http.StatusOK || string(body) != want {
+				t.Errorf("got %d with %d bytes, want 200 with Expect %q and the whole payload", resp.StatusCode, len(body), tt.wantExpect)
+			}
+		})
+	}
+}
+
+func TestCatchRemainder(t *testing.T) {
+	tests := []struct {
+		name   string
+		global bool
+		api    bool
+		path   string
+		status int
+		want   string // backend request uri
+	}{
+		{name: "exact path", path: "/svc/items", status: http.StatusOK, want: "/items"},
+		{name: "rejected by default", path: "/svc/items/42/tags", status: http.StatusNotFound},
+		{name: "caught per api", api: true, path: "/svc/items/42/tags", status: http.StatusOK, want: "/items/42/tags"},
+		{name: "caught globally", global: true, path: "/svc/items/42/tags", status: http.StatusOK, want: "/items/42/tags"},
+		{name: "trailing slash", api: true, path: "/svc/items/", status: http.StatusOK, want: "/items/"},
+		{name: "query kept", api: true, path: "/svc/items/42?full=1", status: http.StatusOK, want: "/items/42?full=1"},
+		{name: "escaping kept", api: true, path: "/svc/items/a%2Fb", status: http.StatusOK, want: "/items/a%2Fb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.RequestURI)
+			})
+			gateway := newTestGateway(t)
+			gateway.CatchRemainder = tt.global
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "items", HTTPMethod: http.MethodGet, Host: backend, Path: "items", CatchRemainder: tt.api}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK && rec.Body.String() != tt.want {
+				t.Errorf("backend got %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteService(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		allow  string
+	}{
+		{name: "delete", method: http.MethodDelete, target: "/deleteService?name=user", status: http.StatusOK},
+		{name: "post", method: http.MethodPost, target: "/deleteService", body: `{"name":"user"}`, status: http.StatusOK},
+		{name: "unknown", method: http.MethodDelete, target: "/deleteService?name=nope", status: http.StatusNotFound},
+		{name: "alias is not the service", method: http.MethodDelete, target: "/deleteService?name=account", status: http.StatusNotFound},
+		{name: "malformed body", method: http.MethodPost, target: "/deleteService", body: `{"name":`, status: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodGet, target: "/deleteService?name=user", status: http.StatusMethodNotAllowed, allow: "DELETE, POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "user"), Path: "get"}))
+			if err := gateway.Discovery.CreateAlias("account", "user"); err != nil {
+				t.Fatalf("create alias: %v", err)
+			}
+			rec := serveAdmin(gateway, tt.method, tt.target, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.allow {
+				t.Errorf("Allow %q, want %q", allow, tt.allow)
+			}
+			deleted := tt.status == http.StatusOK
+			for _, name := range []string{"user", "account"} {
+				_, err := gateway.Discovery.GetService(name)
+				if deleted != errors.Is(err, ErrNotExist) {
+					t.Errorf("get service %v after delete: %v", name, err)
+				}
+				status := http.StatusOK
+				if deleted {
+					status = http.StatusNotFound
+				}
+				if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/"+name+"/get", nil)); rec.Code != status {
+					t.Errorf("proxy %v status %d, want %d", name, rec.Code, status)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateAPIWithoutAPIs(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string // createService body
+	}{
+		{name: "apis missing", service: `{"name":"user"}`},
+		{name: "apis null", service: `{"name":"user","apis":null}`},
+		{name: "apis empty", service: `{"name":"user","apis":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			if rec := serveAdmin(gateway, http.MethodPost, "/createService", tt.service); rec.Code != http.StatusCreated {
+				t.Fatalf("create service status %d: %s", rec.Code, rec.Body.String())
+			}
+			service, err := gateway.Discovery.GetService("user")
+			if err != nil || service.APIs == nil {
+				t.Fatalf("service %+v %v, want an empty apis map", service, err)
+			}
+			body := fmt.Sprintf(`{"service":"user","name":"get","httpMethod":"GET","host":%q,"path":"get"}`, namedBackend(t, "user"))
+			if rec := serveAdmin(gateway, http.MethodPost, "/createAPI", body); rec.Code != http.StatusCreated {
+				t.Fatalf("create api status %d: %s", rec.Code, rec.Body.String())
+			}
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/user/get", nil)); rec.Body.String() != "user" {
+				t.Errorf("proxy got %d %q, want user", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateAPIUnknownService(t *testing.T) {
+	gateway := newTestGateway(t)
+	err := gateway.Discovery.CreateAPI(&API{Service: "nope", Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get"})
+	if !errors.Is(err, ErrNotExist) || !strings.Contains(err.Error(), "service: nope") {
+		t.Errorf("error %v, want service: nope not exist", err)
+	}
+}
+
+func TestAPIValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		api    func(api *API)
+		errSub string // expected in the error, empty when valid
+	}{
+		{name: "valid", api: func(api *API) {}},
+		{name: "https", api: func(api *API) { api.Protocol = "HTTPS" }},
+		{name: "lowercase method", api: func(api *API) { api.HTTPMethod = "post" }},
+		{name: "ipv6 host", api: func(api *API) { api.Host = "[::1]:8080" }},
+		{name: "domain host", api: func(api *API) { api.Host = "backend.internal" }},
+		{name: "unsupported protocol", api: func(api *API) { api.Protocol = "ftp" }, errSub: "protocol"},
+		{name: "unknown method", api: func(api *API) { api.HTTPMethod = "FETCH" }, errSub: "httpMethod"},
+		{name: "unknown method in list", api: func(api *API) { api.HTTPMethods = []string{"GET", "BREW"} }, errSub: "httpMethods"},
+		{name: "empty host", api: func(api *API) { api.Host = "" }, errSub: "host"},
+		{name: "host with scheme", api: func(api *API) { api.Host = "http://127.0.0.1:8080" }, errSub: "host"},
+		{name: "host with path", api: func(api *API) { api.Host = "127.0.0.1:8080/v1" }, errSub: "host"},
+		{name: "invalid port", api: func(api *API) { api.Host = "127.0.0.1:99999" }, errSub: "port"},
+		{name: "missing hostname", api: func(api *API) { api.Host = ":8080" }, errSub: "hostname"},
+		{name: "empty path", api: func(api *API) { api.Path = "" }, errSub: "path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{Service: "user", Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:8080", Path: "get"}
+			tt.api(api)
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user"))
+			err := gateway.Discovery.CreateAPI(api)
+			if tt.errSub == "" {
+				if err != nil {
+					t.Errorf("valid api rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+				t.Fatalf("error %v, want one naming %v", err, tt.errSub)
+			}
+			// the admin handler report the same error
+			data, _ := json.Marshal(api)
+			rec := serveAdmin(gateway, http.MethodPost, "/createAPI", string(data))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.errSub) {
+				t.Errorf("createAPI got %d %s, want 400 naming %v", rec.Code, rec.Body.String(), tt.errSub)
+			}
+		})
+	}
+}
+
+func TestUpdateAPI(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   func(newHost string) string
+		status int
+		want   string // backend answering the next request
+	}{
+		{
+			name:   "new host",
+			method: http.MethodPost,
+			body: func(host string) string {
+				return fmt.Sprintf(`{"name":"get","service":"user","httpMethod":"GET","host":%q,"path":"get"}`, host)
+			},
+			status: http.StatusOK,
+			want:   "new",
+		},
+		{
+			name:   "put",
+			method: http.MethodPut,
+			body: func(host string) string {
+				return fmt.Sprintf(`{"name":"get","service":"user","httpMethod":"GET","host":%q,"path":"get"}`, host)
+			},
+			status: http.StatusOK,
+			want:   "new",
+		},
+		{
+			name:   "through alias",
+			method: http.MethodPost,
+			body: func(host string) string {
+				return fmt.Sprintf(`{"name":"get","service":"account","httpMethod":"GET","host":%q,"path":"get"}`, host)
+			},
+			status: http.StatusOK,
+			want:   "new",
+		},
+		{
+			name:   "unknown service",
+			method: http.MethodPost,
+			body: func(host string) string {
+				return fmt.Sprintf(`{"name":"get","service":"nope","httpMethod":"GET","host":%q,"path":"get"}`, host)
+			},
+			status: http.StatusNotFound,
+			want:   "old",
+		},
+		{
+			name:   "unknown api",
+			method: http.MethodPost,
+			body: func(host string) string {
+				return fmt.Sprintf(`{"name":"nope","service":"user","httpMethod":"GET","host":%q,"path":"get"}`, host)
+			},
+			status: http.StatusNotFound,
+			want:   "old",
+		},
+		{
+			name:   "invalid api",
+			method: http.MethodPost,
+			body: func(host string) string {
+				return `{"name":"get","service":"user","httpMethod":"FETCH","host":"bad host","path":"get"}`
+			},
+			status: http.StatusBadRequest,
+			want:   "old",
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodGet,
+			body:   func(host string) string { return "" },
+			status: http.StatusMethodNotAllowed,
+			want:   "old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "old"), Path: "get"}))
+			if err := gateway.Discovery.CreateAlias("account", "user"); err != nil {
+				t.Fatalf("create alias: %v", err)
+			}
+			rec := serveAdmin(gateway, tt.method, "/updateAPI", tt.body(namedBackend(t, "new")))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			rec = serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/user/get", nil))
+			if rec.Body.String() != tt.want {
+				t.Errorf("request sent to %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteAPI(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{name: "delete", method: http.MethodDelete, target: "/deleteAPI?service=user&name=get", status: http.StatusOK},
+		{name: "post", method: http.MethodPost, target: "/deleteAPI", body: `{"service":"user","name":"get"}`, status: http.StatusOK},
+		{name: "through alias", method: http.MethodDelete, target: "/deleteAPI?service=account&name=get", status: http.StatusOK},
+		{name: "unknown service", method: http.MethodDelete, target: "/deleteAPI?service=nope&name=get", status: http.StatusNotFound},
+		{name: "unknown api", method: http.MethodDelete, target: "/deleteAPI?service=user&name=nope", status: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodPut, target: "/deleteAPI?service=user&name=get", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "get"), Path: "get"},
+				&API{Name: "list", HTTPMethod: http.MethodGet, Host: namedBackend(t, "list"), Path: "list"}))
+			if err := gateway.Discovery.CreateAlias("account", "user"); err != nil {
+				t.Fatalf("create alias: %v", err)
+			}
+			rec := serveAdmin(gateway, tt.method, tt.target, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			status := http.StatusOK
+			if tt.status == http.StatusOK {
+				status = http.StatusNotFound
+			}
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/user/get", nil)); rec.Code != status {
+				t.Errorf("deleted api status %d, want %d", rec.Code, status)
+			}
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/user/list", nil)); rec.Body.String() != "list" {
+				t.Errorf("other api answered %d %q", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestAPIWritesWhileProxying is meant for -race, apis are replaced while requests resolve them
+func TestAPIWritesWhileProxying(t *testing.T) {
+	gateway := newTestGateway(t)
+	backend := namedBackend(t, "ok")
+	newAPI := func(name string) *API {
+		return &API{Name: name, Service: "user", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}
+	}
+	mustCreateService(t, gateway, newTestService("user", newAPI("get")))
+	writes := []struct {
+		name  string
+		write func(i int) error
+	}{
+		{name: "update", write: func(int) error { return gateway.Discovery.UpdateAPI(newAPI("get")) }},
+		{name: "create", write: func(i int) error { return gateway.Discovery.CreateAPI(newAPI(fmt.Sprint("api", i))) }},
+		{name: "delete", write: func(i int) error { return gateway.Discovery.DeleteAPI("user", fmt.Sprint("api", i)) }},
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/user/get", nil)); rec.Code != http.StatusOK {
+					t.Errorf("status %d while apis are written", rec.Code)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		for _, w := range writes {
+			if err := w.write(i); err != nil {
+				t.Errorf("%v api: %v", w.name, err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestLookupRoute(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		api       string
+		remainder string
+		err       error
+	}{
+		{name: "service and api", path: "/users/api", api: "api"},
+		{name: "extra segments", path: "/users/api/123/orders", api: "api", remainder: "/123/orders"},
+		{name: "trailing slash", path: "/users/api/", api: "api", remainder: "/"},
+		{name: "service only", path: "/users", err: errUnknownService},
+		{name: "relative", path: "users/api", err: errUnknownService},
+		{name: "unknown service", path: "/orders/api", err: errUnknownService},
+		{name: "unknown api", path: "/users/list/1", err: errUnknownAPI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("users",
+				&API{Name: "api", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "v1/users"}))
+			rt, err := gateway.lookup(tt.path)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("lookup error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookup: %v", err)
+			}
+			if rt.service.Name != "users" || rt.api.Name != tt.api || rt.remainder != tt.remainder || rt.path != tt.path {
+				t.Errorf("route %v/%v remainder %q path %q, want users/%v remainder %q",
+					rt.service.Name, rt.api.Name, rt.remainder, rt.path, tt.api, tt.remainder)
+			}
+		})
+	}
+}
+
+func TestRemainderAppendedToAPIPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string // backend request uri
+	}{
+		{name: "two segments", path: "/users/api", want: "/v1/users"},
+		{name: "extra segments", path: "/users/api/123/orders", want: "/v1/users/123/orders"},
+		{name: "query string", path: "/users/api/123/orders?state=open&page=2", want: "/v1/users/123/orders?state=open&page=2"},
+		{name: "query without remainder", path: "/users/api?page=2", want: "/v1/users?page=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.RequestURI)
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("users",
+				&API{Name: "api", HTTPMethod: http.MethodGet, Host: backend, Path: "v1/users", CatchRemainder: true}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+				t.Errorf("backend got %d %q, want %q", rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDirector(t *testing.T) {
+	tests := []struct {
+		name     string
+		api      *API
+		target   string
+		expected string // request uri received by backend
+	}{
+		{name: "api path", api: &API{Path: "v1/users"}, target: "/svc/get", expected: "/v1/users"},
+		{name: "query kept", api: &API{Path: "users"}, target: "/svc/get?id=1", expected: "/users?id=1"},
+		{name: "api query first", api: &API{Path: "users?v=2"}, target: "/svc/get?id=1", expected: "/users?v=2&id=1"},
+		{name: "remainder", api: &API{Path: "users", CatchRemainder: true}, target: "/svc/get/42", expected: "/users/42"},
+		{name: "escaped remainder", api: &API{Path: "files", CatchRemainder: true}, target: "/svc/get/a%2Fb", expected: "/files/a%2Fb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 1)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				received <- r.RequestURI
+			})
+			tt.api.Name, tt.api.HTTPMethod, tt.api.Host = "get", http.MethodGet, backend
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", tt.api))
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.target, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := <-received; got != tt.expected {
+				t.Errorf("backend got %v, want %v", got, tt.expected)
+			}
+			// the director alone rewrite the request the same way
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			rt, err := gateway.lookup(req.URL.Path)
+			if err != nil {
+				t.Fatalf("lookup: %v", err)
+			}
+			rt.backend = backend
+			req = req.WithContext(context.WithValue(req.Context(), routeKey{}, rt))
+			gateway.director(req)
+			if req.URL.Scheme != "http" || req.URL.Host != backend || req.URL.RequestURI() != tt.expected {
+				t.Errorf("director rewrote %v, want http://%v%v", req.URL, backend, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBackendPathSingleSlash(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		remainder bool
+		target    string
+		expected  string
+	}{
+		{name: "relative", path: "users", target: "/svc/get", expected: "/users"},
+		{name: "absolute", path: "/users", target: "/svc/get", expected: "/users"},
+		{name: "nested", path: "/users/list", target: "/svc/get", expected: "/users/list"},
+		{name: "repeated slashes", path: "//users", target: "/svc/get", expected: "/users"},
+		{name: "absolute with query", path: "/users?v=1", target: "/svc/get", expected: "/users?v=1"},
+		{name: "absolute with remainder", path: "/users", remainder: true, target: "/svc/get/42", expected: "/users/42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 1)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				received <- r.RequestURI
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: tt.path, CatchRemainder: tt.remainder}))
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.target, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := <-received; got != tt.expected {
+				t.Errorf("backend got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDirectorWithoutRoute(t *testing.T) {
+	gateway := newTestGateway(t)
+	req := httptest.NewRequest(http.MethodGet, "/svc/get?id=1", nil)
+	gateway.director(req)
+	if req.URL.Host != "" || req.URL.RequestURI() != "/svc/get?id=1" {
+		t.Errorf("unresolved request rewritten to %v", req.URL)
+	}
+}
+
+func TestQueryForwarded(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiPath string
+		query   string
+		want    string // backend raw query
+	}{
+		{name: "client query", apiPath: "api", query: "foo=bar&x=1", want: "foo=bar&x=1"},
+		{name: "no query", apiPath: "api"},
+		{name: "escaping kept", apiPath: "api", query: "q=a%26b&empty=", want: "q=a%26b&empty="},
+		{name: "repeated keys kept", apiPath: "api", query: "id=1&id=2", want: "id=1&id=2"},
+		{name: "api query only", apiPath: "api?version=2", want: "version=2"},
+		{name: "merged", apiPath: "api?version=2", query: "foo=bar", want: "version=2&foo=bar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%v?%v", r.URL.Path, r.URL.RawQuery)
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "api", HTTPMethod: http.MethodGet, Host: backend, Path: tt.apiPath}))
+			target := "/svc/api"
+			if tt.query != "" {
+				target += "?" + tt.query
+			}
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, target, nil))
+			if want := "/api?" + tt.want; rec.Body.String() != want {
+				t.Errorf("backend got %q, want %q", rec.Body.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultRegionHeader carry the client country set by the edge, e.g. Cloudflare
+const DefaultRegionHeader = "CF-IPCountry"
+
+// GeoIPFunc resolve client ip to region (e.g. ISO country code), empty when unknown
+type GeoIPFunc func(ip net.IP) string
+
+// clientRegion derive client region from RegionHeader, fallback to GeoIP lookup of the
+// client ip behind trusted proxies
+func (gateway *APIGateway) clientRegion(req *http.Request) string {
+	if gateway.RegionHeader != "" {
+		if region := strings.TrimSpace(req.Header.Get(gateway.RegionHeader)); region != "" {
+			return strings.ToUpper(region)
+		}
+	}
+	if gateway.GeoIP == nil {
+		return ""
+	}
+	ip := gateway.clientIP(req)
+	if ip == nil {
+		return ""
+	}
+	return strings.ToUpper(gateway.GeoIP(ip))
+}
+
+// backendHost select backend host of api, prefer the healthy hosts mapped to client region
+// in round-robin, then backends matching request tags, then Hosts in round-robin, peek
+// leave the round-robin state as is
+func (gateway *APIGateway) backendHost(req *http.Request, api *API, peek bool) string {
+	if len(api.RegionHosts) > 0 {
+		region := gateway.clientRegion(req)
+		if hosts := api.RegionHosts[region]; len(hosts) > 0 {
+			if host := gateway.pickHost(api, api.regionBalancers[region], hosts, func(int) int { return 1 }, false, peek); host != "" {
+				return host
+			}
+		}
+	}
+	if host := gateway.taggedBackend(req, api, nil); host != "" {
+		return host
+	}
+	return gateway.roundRobinHost(api, peek)
+}
+
+// retryHost select the backend host of a retry as backendHost does, among the healthy
+// hosts not tried yet, empty when every healthy host was tried
+func (gateway *APIGateway) retryHost(req *http.Request, api *API, tried map[string]bool) string {
+	untried := func(hosts []string, weight func(i int) int) func(i int) int {
+		return func(i int) int {
+			if tried[hosts[i]] {
+				return 0
+			}
+			return weight(i)
+		}
+	}
+	if len(api.RegionHosts) > 0 {
+		region := gateway.clientRegion(req)
+		if hosts := api.RegionHosts[region]; len(hosts) > 0 {
+			if host := gateway.pickHost(api, api.regionBalancers[region], hosts, untried(hosts, func(int) int { return 1 }), false, false); host != "" {
+				return host
+			}
+		}
+	}
+	if host := gateway.taggedBackend(req, api, tried); host != "" {
+		return host
+	}
+	if len(api.Hosts) == 0 || api.balancer == nil {
+		return ""
+	}
+	return gateway.pickHost(api, api.balancer, api.Hosts, untried(api.Hosts, func(i int) int { return hostWeight(api, i) }), false, false)
+}
+
+// normalizeRegionHosts validate RegionHosts of api, uppercase region keys, drop empty host
+// lists and build the round-robin state of each region
+func normalizeRegionHosts(api *API) error {
+	api.regionBalancers = nil
+	if len(api.RegionHosts) == 0 {
+		return nil
+	}
+	normalized := make(map[string][]string, len(api.RegionHosts))
+	api.regionBalancers = make(map[string]*roundRobin, len(api.RegionHosts))
+	for region, hosts := range api.RegionHosts {
+		if len(hosts) == 0 {
+			continue
+		}
+		for _, host := range hosts {
+			if err := validateHost(host); err != nil {
+				return fmt.Errorf("api: %v region: %v %v", api.Name, region, err)
+			}
+		}
+		region = strings.ToUpper(strings.TrimSpace(region))
+		normalized[region] = hosts
+		api.regionBalancers[region] = &roundRobin{current: make([]int, len(hosts))}
+	}
+	api.RegionHosts = normalized
+	return nil
+}
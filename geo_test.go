@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// markDown take host out of rotation of api svc/api as a failing health check would
+func markDown(gateway *APIGateway, api, host string) {
+	gateway.health.probed("svc", api, host, errors.New("probe failed"), 1)
+}
+
+func TestRegionBackendSelection(t *testing.T) {
+	geoIP := func(ip net.IP) string {
+		if ip.Equal(net.ParseIP("203.0.113.7")) {
+			return "de"
+		}
+		return ""
+	}
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		forwarded  string // X-Forwarded-For
+		down       []string
+		want       map[string]int // picks per host over 4 requests
+	}{
+		{name: "region hosts in round-robin", header: "US", want: map[string]int{"10.0.1.1:80": 2, "10.0.1.2:80": 2}},
+		{name: "lower case region", header: " us ", want: map[string]int{"10.0.1.1:80": 2, "10.0.1.2:80": 2}},
+		{name: "other region", header: "DE", want: map[string]int{"10.0.2.1:80": 4}},
+		{name: "unmapped region", header: "FR", want: map[string]int{"10.0.0.1:80": 2, "10.0.0.2:80": 2}},
+		{name: "no region", want: map[string]int{"10.0.0.1:80": 2, "10.0.0.2:80": 2}},
+		{name: "geoip fallback", remoteAddr: "203.0.113.7:5000", want: map[string]int{"10.0.2.1:80": 4}},
+		{name: "geoip behind trusted proxy", remoteAddr: "192.0.2.1:5000", forwarded: "203.0.113.7", want: map[string]int{"10.0.2.1:80": 4}},
+		{name: "forwarded for of untrusted peer", remoteAddr: "198.51.100.1:5000", forwarded: "203.0.113.7", want: map[string]int{"10.0.0.1:80": 2, "10.0.0.2:80": 2}},
+		{name: "unhealthy region host skipped", header: "US", down: []string{"10.0.1.1:80"}, want: map[string]int{"10.0.1.2:80": 4}},
+		{
+			name:   "unhealthy region fall back to healthy hosts",
+			header: "US",
+			down:   []string{"10.0.1.1:80", "10.0.1.2:80", "10.0.0.1:80"},
+			want:   map[string]int{"10.0.0.2:80": 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			gateway.GeoIP = geoIP
+			if err := gateway.SetTrustedProxies([]string{"192.0.2.1"}); err != nil {
+				t.Fatal(err)
+			}
+			api := &API{
+				Name:       "api",
+				HTTPMethod: http.MethodGet,
+				Hosts:      []string{"10.0.0.1:80", "10.0.0.2:80"},
+				Path:       "api",
+				RegionHosts: map[string][]string{
+					"us": {"10.0.1.1:80", "10.0.1.2:80"},
+					"DE": {"10.0.2.1:80"},
+					"JP": {},
+				},
+			}
+			mustCreateService(t, gateway, newTestService("svc", api))
+			for _, host := range tt.down {
+				markDown(gateway, "api", host)
+			}
+			got := make(map[string]int)
+			for i := 0; i < 4; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/svc/api", nil)
+				if tt.header != "" {
+					req.Header.Set(DefaultRegionHeader, tt.header)
+				}
+				if tt.remoteAddr != "" {
+					req.RemoteAddr = tt.remoteAddr
+				}
+				if tt.forwarded != "" {
+					req.Header.Set("X-Forwarded-For", tt.forwarded)
+				}
+				got[gateway.backendHost(req, api, false)]++
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("picks %v, want %v", got, tt.want)
+			}
+			for host, n := range tt.want {
+				if got[host] != n {
+					t.Errorf("picks %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRegionHostsRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts map[string][]string
+	}{
+		{name: "empty host", hosts: map[string][]string{"US": {""}}},
+		{name: "url instead of host", hosts: map[string][]string{"US": {"http://10.0.1.1:80"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("svc", &API{
+				Name: "api", HTTPMethod: http.MethodGet, Host: "10.0.0.1:80", Path: "api", RegionHosts: tt.hosts,
+			}))
+			if err == nil {
+				t.Errorf("invalid region hosts accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// normalizeHeaders validate the header rewrites of api and canonicalize their names
+func normalizeHeaders(api *API) error {
+	var err error
+	if api.RequestHeaders, err = canonicalHeaders(api.RequestHeaders); err != nil {
+		return fmt.Errorf("api: %v requestHeaders %v", api.Name, err)
+	}
+	if api.ResponseHeaders, err = canonicalHeaders(api.ResponseHeaders); err != nil {
+		return fmt.Errorf("api: %v responseHeaders %v", api.Name, err)
+	}
+	return nil
+}
+
+// canonicalHeaders return headers keyed by canonical names, rejecting invalid names and values
+func canonicalHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("header name: %q invalid", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("header: %v value can not contain line breaks", name)
+		}
+		key := http.CanonicalHeaderKey(name)
+		if _, exist := canonical[key]; exist {
+			return nil, fmt.Errorf("header: %v duplicated", key)
+		}
+		canonical[key] = value
+	}
+	return canonical, nil
+}
+
+// validHeaderName report whether name is a RFC 7230 token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c >= 0x7f || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteHeaders set headers in header, an empty value delete the header
+func rewriteHeaders(header http.Header, headers map[string]string) {
+	for name, value := range headers {
+		if value == "" {
+			header.Del(name)
+			continue
+		}
+		header.Set(name, value)
+	}
+}
+
+// rewriteRequestHeaders apply RequestHeaders of api to the upstream request, Host rewrite the
+// host sent to backend
+func rewriteRequestHeaders(req *http.Request, api *API) {
+	if host, ok := api.RequestHeaders["Host"]; ok && host != "" {
+		req.Host = host
+	}
+	rewriteHeaders(req.Header, api.RequestHeaders)
+}
+
+// rewriteResponseHeaders apply ResponseHeaders of the api to backend response
+func rewriteResponseHeaders(resp *http.Response) {
+	rt := routeOf(resp.Request.Context())
+	if rt == nil {
+		return
+	}
+	rewriteHeaders(resp.Header, rt.api.ResponseHeaders)
+}
@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderRewrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  map[string]string
+		response map[string]string
+		sent     map[string]string // request headers sent by the client
+		backend  map[string]string // expected at the backend, empty value expect none
+		client   map[string]string // expected at the client, empty value expect none
+	}{
+		{
+			name:    "request header added",
+			request: map[string]string{"x-forwarded-host": "api.example.com"},
+			backend: map[string]string{"X-Forwarded-Host": "api.example.com"},
+		},
+		{
+			name:    "request header replaced",
+			request: map[string]string{"X-Tenant": "acme"},
+			sent:    map[string]string{"X-Tenant": "spoofed"},
+			backend: map[string]string{"X-Tenant": "acme"},
+		},
+		{
+			name:    "request header removed",
+			request: map[string]string{"Cookie": ""},
+			sent:    map[string]string{"Cookie": "session=1"},
+			backend: map[string]string{"Cookie": ""},
+		},
+		{
+			name:    "host rewritten",
+			request: map[string]string{"Host": "internal.example.com"},
+			backend: map[string]string{"Host": "internal.example.com"},
+		},
+		{
+			name:     "response header added",
+			response: map[string]string{"Strict-Transport-Security": "max-age=600"},
+			client:   map[string]string{"Strict-Transport-Security": "max-age=600", "Server": "backend/1.0"},
+		},
+		{
+			name:     "response header removed",
+			response: map[string]string{"server": "", "X-Powered-By": ""},
+			client:   map[string]string{"Server": "", "X-Powered-By": "", "X-Kept": "yes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan http.Header, 1)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				header := r.Header.Clone()
+				header.Set("Host", r.Host)
+				received <- header
+				w.Header().Set("Server", "backend/1.0")
+				w.Header().Set("X-Powered-By", "go")
+				w.Header().Set("X-Kept", "yes")
+				fmt.Fprint(w, "ok")
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name:            "get",
+				HTTPMethod:      http.MethodGet,
+				Host:            backend,
+				Path:            "get",
+				RequestHeaders:  tt.request,
+				ResponseHeaders: tt.response,
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+			for k, v := range tt.sent {
+				req.Header.Set(k, v)
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			header := <-received
+			for k, want := range tt.backend {
+				if got := header.Get(k); got != want {
+					t.Errorf("backend %v %q, want %q", k, got, want)
+				}
+			}
+			for k, want := range tt.client {
+				if got := rec.Header().Get(k); got != want {
+					t.Errorf("client %v %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderRewritesRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  map[string]string
+		response map[string]string
+	}{
+		{name: "invalid name", request: map[string]string{"X Bad": "1"}},
+		{name: "empty name", response: map[string]string{"": "1"}},
+		{name: "line break in value", response: map[string]string{"X-Injected": "a\r\nSet-Cookie: x"}},
+		{name: "duplicated after canonicalization", request: map[string]string{"x-tenant": "a", "X-Tenant": "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("svc", &API{
+				Name:            "get",
+				HTTPMethod:      http.MethodGet,
+				Host:            "127.0.0.1:1",
+				Path:            "get",
+				RequestHeaders:  tt.request,
+				ResponseHeaders: tt.response,
+			}))
+			if err == nil {
+				t.Errorf("invalid header rewrite accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// unhealthyAfter is the consecutive failures after which a backend is reported unhealthy
+const unhealthyAfter = 3
+
+// DefaultDrainPeriod is how long in-flight requests of an unhealthy backend may complete
+const DefaultDrainPeriod = 10 * time.Second
+
+// DefaultEjectionPeriod is how long an unhealthy backend is avoided before requests try it again
+const DefaultEjectionPeriod = 30 * time.Second
+
+// States of a backend
+const (
+	BackendHealthy  = "healthy"  // receive new requests
+	BackendDraining = "draining" // unhealthy, no new requests, in-flight ones may complete
+	BackendDown     = "down"     // unhealthy and drained, remaining requests were canceled
+)
+
+// BackendHealth is the passive health of a backend host observed from proxied requests,
+// and the active health from HealthCheck probes
+type BackendHealth struct {
+	Host                string    `json:"host"`
+	Healthy             bool      `json:"healthy"`
+	State               string    `json:"state"`
+	InFlight            int       `json:"inFlight"`
+	Requests            int64     `json:"requests"`
+	Failures            int64     `json:"failures"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastErrorAt         time.Time `json:"lastErrorAt,omitempty"`
+	// EjectedUntil is when an unhealthy backend get new requests again, one more failure
+	// then eject it for another period while a success make it healthy
+	EjectedUntil time.Time `json:"ejectedUntil,omitempty"`
+	// ProbeDown is set once consecutive ProbeFailures reach the threshold of the HealthCheck
+	// of any api probing the host, ProbeFailures is the highest count among those apis
+	ProbeDown     bool `json:"probeDown,omitempty"`
+	ProbeFailures int  `json:"probeFailures,omitempty"`
+}
+
+// backendState is the tracked state of a backend host
+type backendState struct {
+	BackendHealth
+	requests map[uint64]context.CancelFunc // in-flight requests
+	drain    *time.Timer                   // move to down once the drain period is over
+}
+
+// probeState is the active health of a host probed by the HealthCheck of one api
+type probeState struct {
+	host     string
+	failures int
+	down     bool
+}
+
+// healthTracker record outcome of upstream requests per backend host, a backend failing
+// consecutively is drained: new requests avoid it while in-flight ones may complete within
+// the drain period, after which they are canceled, probe outcomes are recorded per
+// service, api and host as each api has its own HealthCheck
+type healthTracker struct {
+	mu       sync.Mutex
+	backends map[string]*backendState
+	probes   map[string]*probeState
+	nextID   uint64
+}
+
+// backend return the state of host, must be called with lock held
+func (t *healthTracker) backend(host string) *backendState {
+	if t.backends == nil {
+		t.backends = make(map[string]*backendState)
+	}
+	state, exist := t.backends[host]
+	if !exist {
+		state = &backendState{
+			BackendHealth: BackendHealth{Host: host, State: BackendHealthy},
+			requests:      make(map[uint64]context.CancelFunc),
+		}
+		t.backends[host] = state
+	}
+	return state
+}
+
+// begin register an in-flight request to host, the returned context is canceled when the
+// backend is taken down, done must be called once the request completes
+func (t *healthTracker) begin(ctx context.Context, host string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.backend(host)
+	t.nextID++
+	id := t.nextID
+	state.requests[id] = cancel
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			delete(state.requests, id)
+			t.mu.Unlock()
+			cancel()
+		})
+	}
+}
+
+// observe record one upstream attempt, 5xx responses count as failures, the backend
+// starts draining for drainPeriod once it becomes unhealthy and is avoided for ejectionPeriod
+func (t *healthTracker) observe(host string, resp *http.Response, err error, drainPeriod, ejectionPeriod time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.backend(host)
+	state.Requests++
+	switch {
+	case err != nil:
+		state.LastError = err.Error()
+	case resp.StatusCode >= http.StatusInternalServerError:
+		state.LastError = resp.Status
+	default:
+		state.recover()
+		return
+	}
+	state.Failures++
+	state.ConsecutiveFailures++
+	state.LastErrorAt = time.Now()
+	if state.ConsecutiveFailures < unhealthyAfter {
+		return
+	}
+	// also a backend tried again after its ejection, which is still failing
+	state.EjectedUntil = state.LastErrorAt.Add(ejectionPeriod)
+	if state.State != BackendHealthy {
+		return
+	}
+	state.State = BackendDraining
+	state.drain = time.AfterFunc(drainPeriod, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if state.State != BackendDraining {
+			return
+		}
+		state.State = BackendDown
+		state.drain = nil
+		for _, cancel := range state.requests {
+			cancel()
+		}
+	})
+}
+
+// recover make the backend healthy again, must be called with lock held
+func (state *backendState) recover() {
+	state.ConsecutiveFailures = 0
+	state.EjectedUntil = time.Time{}
+	if state.drain != nil {
+		state.drain.Stop()
+		state.drain = nil
+	}
+	state.State = BackendHealthy
+}
+
+// available report whether the backend may receive new requests, an unhealthy one is
+// tried again once its ejection elapsed, must be called with lock held
+func (state *backendState) available(now time.Time) bool {
+	return state.State == BackendHealthy || !now.Before(state.EjectedUntil)
+}
+
+// probeKey is the key of the probe state of host for api of service
+func probeKey(service, api, host string) string {
+	return service + "/" + api + "/" + host
+}
+
+// probed record one active probe of host by api of service, the host is out of rotation
+// for that api after threshold consecutive failures and back after one success, which also
+// restore a host ejected by failed requests, return whether that changed
+func (t *healthTracker) probed(service, api, host string, err error, threshold int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.probes == nil {
+		t.probes = make(map[string]*probeState)
+	}
+	key := probeKey(service, api, host)
+	probe, exist := t.probes[key]
+	if !exist {
+		probe = &probeState{host: host}
+		t.probes[key] = probe
+	}
+	state := t.backend(host)
+	if err == nil {
+		probe.failures = 0
+		changed := probe.down || state.State != BackendHealthy
+		probe.down = false
+		state.recover()
+		return changed
+	}
+	probe.failures++
+	state.LastError = err.Error()
+	state.LastErrorAt = time.Now()
+	if probe.down || probe.failures < threshold {
+		return false
+	}
+	probe.down = true
+	return true
+}
+
+// pruneProbes drop the probe state of hosts no longer probed, keep hold the probed keys
+func (t *healthTracker) pruneProbes(keep map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.probes {
+		if !keep[key] {
+			delete(t.probes, key)
+		}
+	}
+}
+
+// healthy report whether host may receive new requests of api, unknown hosts are healthy
+func (t *healthTracker) healthy(api *API, host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if probe, exist := t.probes[probeKey(api.Service, api.Name, host)]; exist && probe.down {
+		return false
+	}
+	state, exist := t.backends[host]
+	return !exist || state.available(time.Now())
+}
+
+// snapshot return health of all observed backends ordered by host
+func (t *healthTracker) snapshot() []BackendHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	backends := make([]BackendHealth, 0, len(t.backends))
+	now := time.Now()
+	for _, state := range t.backends {
+		health := state.BackendHealth
+		health.InFlight = len(state.requests)
+		for _, probe := range t.probes {
+			if probe.host != state.Host {
+				continue
+			}
+			health.ProbeDown = health.ProbeDown || probe.down
+			if probe.failures > health.ProbeFailures {
+				health.ProbeFailures = probe.failures
+			}
+		}
+		health.Healthy = state.available(now) && !health.ProbeDown
+		backends = append(backends, health)
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].Host < backends[j].Host })
+	return backends
+}
+
+// trackedBody end the in-flight registration of a request once its response is consumed
+type trackedBody struct {
+	io.ReadCloser
+	done func()
+}
+
+// Close implements io.Closer
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// trackedConn is trackedBody of an upgraded connection, which stays writable
+type trackedConn struct {
+	io.ReadWriteCloser
+	done func()
+}
+
+// Close implements io.Closer
+func (c *trackedConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.done()
+	return err
+}
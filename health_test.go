@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// failTimes record n failed requests to host
+func failTimes(tracker *healthTracker, host string, n int, drainPeriod time.Duration) {
+	for i := 0; i < n; i++ {
+		tracker.observe(host, nil, errors.New("connection refused"), drainPeriod, time.Minute)
+	}
+}
+
+// hostState return the state of host in tracker
+func hostState(tracker *healthTracker, host string) string {
+	for _, backend := range tracker.snapshot() {
+		if backend.Host == host {
+			return backend.State
+		}
+	}
+	return ""
+}
+
+func TestHealthTrackerDrain(t *testing.T) {
+	const host = "10.0.0.1:80"
+	const drain = 50 * time.Millisecond
+	tests := []struct {
+		name     string
+		failures int
+		complete bool // the in-flight request completes within the drain period
+		recover  bool // a request succeeds while draining
+		state    string
+		canceled bool
+	}{
+		{name: "still healthy", failures: unhealthyAfter - 1, state: BackendHealthy},
+		{name: "completed while draining", failures: unhealthyAfter, complete: true, state: BackendDown},
+		{name: "canceled once drained", failures: unhealthyAfter, state: BackendDown, canceled: true},
+		{name: "recovered while draining", failures: unhealthyAfter, recover: true, state: BackendHealthy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &healthTracker{}
+			ctx, done := tracker.begin(context.Background(), host)
+			failTimes(tracker, host, tt.failures, drain)
+			if tt.failures >= unhealthyAfter {
+				if state := hostState(tracker, host); state != BackendDraining || tracker.healthy(&API{}, host) {
+					t.Fatalf("state %v healthy %v, want draining and avoided", state, tracker.healthy(&API{}, host))
+				}
+				if ctx.Err() != nil {
+					t.Fatalf("in-flight request canceled as soon as draining")
+				}
+			}
+			if tt.complete {
+				done()
+			}
+			if tt.recover {
+				tracker.observe(host, &http.Response{StatusCode: http.StatusOK}, nil, drain, time.Minute)
+			}
+			time.Sleep(2 * drain)
+			if state := hostState(tracker, host); state != tt.state {
+				t.Errorf("state %v, want %v", state, tt.state)
+			}
+			if canceled := ctx.Err() != nil && !tt.complete; canceled != tt.canceled {
+				t.Errorf("in-flight request canceled %v, want %v", canceled, tt.canceled)
+			}
+			done()
+		})
+	}
+}
+
+func TestDrainInFlightRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		drain  time.Duration
+		status int // of the request in flight when the backend turned unhealthy
+	}{
+		{name: "completes within drain period", drain: 5 * time.Second, status: http.StatusOK},
+		{name: "canceled after drain period", drain: 20 * time.Millisecond, status: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrived := make(chan struct{})
+			release := make(chan struct{})
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/fail" {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				close(arrived)
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+			})
+			defer close(release)
+			gateway := newTestGateway(t)
+			gateway.DrainPeriod = tt.drain
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "slow", HTTPMethod: http.MethodGet, Host: backend, Path: "slow"},
+				&API{Name: "fail", HTTPMethod: http.MethodGet, Host: backend, Path: "fail"}))
+			slow := make(chan int)
+			go func() {
+				slow <- serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/slow", nil)).Code
+			}()
+			<-arrived
+			for i := 0; i < unhealthyAfter; i++ {
+				serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/fail", nil))
+			}
+			if state := hostState(&gateway.health, backend); state != BackendDraining {
+				t.Fatalf("backend %v, want draining", state)
+			}
+			if tt.status == http.StatusOK {
+				release <- struct{}{}
+			}
+			select {
+			case status := <-slow:
+				if status != tt.status {
+					t.Errorf("in-flight request status %d, want %d", status, tt.status)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("in-flight request neither completed nor canceled")
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of HealthCheck
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultUnhealthyThreshold  = 3
+)
+
+// healthCheckTick is how often due probes are looked for
+const healthCheckTick = time.Second
+
+// HealthCheck actively probe every host of an api, including its weighted Backends,
+// RegionHosts and blue/green pools, a host failing UnhealthyThreshold probes in a row is
+// removed from rotation of that api until a probe passes again
+type HealthCheck struct {
+	// Path is probed with GET, 2xx and 3xx responses pass
+	Path string `json:"path"`
+	// IntervalSeconds between probes of a host, also bound each probe, default 10
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+	// UnhealthyThreshold is the consecutive failed probes taking a host out of rotation, default 3
+	UnhealthyThreshold int `json:"unhealthyThreshold,omitempty"`
+}
+
+// normalizeHealthCheck validate the health check of api and fill its defaults
+func normalizeHealthCheck(api *API) error {
+	check := api.HealthCheck
+	if check == nil {
+		return nil
+	}
+	if !strings.HasPrefix(check.Path, "/") {
+		return fmt.Errorf("api: %v health check path: %q should start with /", api.Name, check.Path)
+	}
+	if check.IntervalSeconds < 0 || check.UnhealthyThreshold < 0 {
+		return fmt.Errorf("api: %v health check intervalSeconds and unhealthyThreshold can not be negative", api.Name)
+	}
+	if check.IntervalSeconds == 0 {
+		check.IntervalSeconds = int(DefaultHealthCheckInterval / time.Second)
+	}
+	if check.UnhealthyThreshold == 0 {
+		check.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	return nil
+}
+
+// StartHealthChecks probe hosts of apis having a HealthCheck in background until ctx is
+// done, apis registered later are picked up, the discovery must be able to list services
+func (gateway *APIGateway) StartHealthChecks(ctx context.Context) error {
+	if _, ok := gateway.Discovery.(routeSnapshot); !ok {
+		return errDiscoveryNotListable
+	}
+	go gateway.runHealthChecks(ctx)
+	return nil
+}
+
+// runHealthChecks start the probes falling due every tick, it returns once ctx is done
+// and every probe started has returned
+func (gateway *APIGateway) runHealthChecks(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// next probe time of every api host
+	due := make(map[string]time.Time)
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+	for {
+		snapshot, ok := gateway.Discovery.(routeSnapshot)
+		if !ok {
+			gateway.logger().Errorf("health check stopped: %v", errDiscoveryNotListable)
+			return
+		}
+		services, _ := snapshot.snapshot()
+		now := time.Now()
+		probed := make(map[string]bool)
+		for _, service := range services {
+			for _, api := range service.APIs {
+				if api.HealthCheck == nil {
+					continue
+				}
+				interval := time.Duration(api.HealthCheck.IntervalSeconds) * time.Second
+				for _, host := range probedHosts(api) {
+					key := probeKey(service.Name, api.Name, host)
+					probed[key] = true
+					if now.Before(due[key]) {
+						continue
+					}
+					due[key] = now.Add(interval)
+					wg.Add(1)
+					go func(service *Service, api *API, host string) {
+						defer wg.Done()
+						gateway.probe(ctx, service, api, host)
+					}(service, api, host)
+				}
+			}
+		}
+		// apis and hosts removed since
+		for key := range due {
+			if !probed[key] {
+				delete(due, key)
+			}
+		}
+		gateway.health.pruneProbes(probed)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probedHosts return every backend host of api once: Hosts, weighted Backends, RegionHosts
+// and both blue/green pools
+func probedHosts(api *API) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	add := func(list ...string) {
+		for _, host := range list {
+			if host != "" && !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	add(api.Hosts...)
+	for i := range api.Backends {
+		add(api.Backends[i].Host)
+	}
+	regions := make([]string, 0, len(api.RegionHosts))
+	for region := range api.RegionHosts {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		add(api.RegionHosts[region]...)
+	}
+	add(api.BlueHosts...)
+	add(api.GreenHosts...)
+	return hosts
+}
+
+// probe check host of api once and record the outcome
+func (gateway *APIGateway) probe(ctx context.Context, service *Service, api *API, host string) {
+	check := api.HealthCheck
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(check.IntervalSeconds)*time.Second)
+	defer cancel()
+	err := gateway.probeHost(ctx, service, api, host)
+	if ctx.Err() == context.Canceled {
+		// stopped, not the host fault
+		return
+	}
+	if gateway.health.probed(service.Name, api.Name, host, err, check.UnhealthyThreshold) {
+		if err != nil {
+			gateway.logger().Warnf("health check: host: %v of api: %v removed from rotation: %v", host, api.Name, err)
+		} else {
+			gateway.logger().Infof("health check: host: %v of api: %v back in rotation", host, api.Name)
+		}
+	}
+}
+
+// probeHost send the health check request of api to host
+func (gateway *APIGateway) probeHost(ctx context.Context, service *Service, api *API, host string) error {
+	url := gateway.backendScheme(api, host) + "://" + host + api.HealthCheck.Path
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	transport := gateway.backendTransport()
+	if service.transport != nil {
+		transport = service.transport
+	}
+	resp, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health check: %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthBackend start a backend whose /health answer 200 while healthy is set, 503 otherwise
+func healthBackend(t *testing.T, healthy *int32, probes *int32) string {
+	t.Helper()
+	return newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			return
+		}
+		atomic.AddInt32(probes, 1)
+		if atomic.LoadInt32(healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func TestHealthCheckRotation(t *testing.T) {
+	var healthy, probes int32 = 1, 0
+	host := healthBackend(t, &healthy, &probes)
+	gateway := newTestGateway(t)
+	api := &API{Name: "get", HTTPMethod: http.MethodGet, Host: host, Path: "get",
+		HealthCheck: &HealthCheck{Path: "/health", UnhealthyThreshold: 2}}
+	service := newTestService("svc", api)
+	mustCreateService(t, gateway, service)
+	tests := []struct {
+		name       string
+		healthy    bool
+		ejected    bool // requests failed enough to eject the host before probing
+		probes     int
+		inRotation bool
+	}{
+		{name: "passing", healthy: true, probes: 1, inRotation: true},
+		{name: "failing below threshold", probes: 1, inRotation: true},
+		{name: "failing at threshold", probes: 1},
+		{name: "still failing", probes: 3},
+		{name: "back after one pass", healthy: true, probes: 1, inRotation: true},
+		{name: "pass restore ejected host", healthy: true, ejected: true, probes: 1, inRotation: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := int32(0)
+			if tt.healthy {
+				state = 1
+			}
+			atomic.StoreInt32(&healthy, state)
+			if tt.ejected {
+				failTimes(&gateway.health, host, unhealthyAfter, time.Minute)
+				if gateway.health.healthy(api, host) {
+					t.Fatalf("host not ejected by failed requests")
+				}
+			}
+			for i := 0; i < tt.probes; i++ {
+				gateway.probe(context.Background(), service, api, host)
+			}
+			if got := gateway.health.healthy(api, host); got != tt.inRotation {
+				t.Errorf("in rotation %v, want %v", got, tt.inRotation)
+			}
+		})
+	}
+}
+
+func TestHealthChecksStop(t *testing.T) {
+	var healthy, probes int32 = 0, 0
+	host := healthBackend(t, &healthy, &probes)
+	gateway := newTestGateway(t)
+	api := &API{Name: "get", HTTPMethod: http.MethodGet, Host: host, Path: "get",
+		HealthCheck: &HealthCheck{Path: "/health", IntervalSeconds: 1, UnhealthyThreshold: 1}}
+	mustCreateService(t, gateway, newTestService("svc", api))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		gateway.runHealthChecks(ctx)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for gateway.health.healthy(api, host) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if gateway.health.healthy(api, host) {
+		t.Fatalf("failing host still in rotation after %d probes", atomic.LoadInt32(&probes))
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("health checks still running after cancel")
+	}
+	seen := atomic.LoadInt32(&probes)
+	time.Sleep(healthCheckTick + 100*time.Millisecond)
+	if got := atomic.LoadInt32(&probes); got != seen {
+		t.Errorf("%d probes sent after health checks stopped", got-seen)
+	}
+}
+
+func TestHealthCheckPerAPI(t *testing.T) {
+	host := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	gateway := newTestGateway(t)
+	failing := &API{Name: "failing", HTTPMethod: http.MethodGet, Host: host, Path: "failing",
+		HealthCheck: &HealthCheck{Path: "/broken", UnhealthyThreshold: 1}}
+	passing := &API{Name: "passing", HTTPMethod: http.MethodGet, Host: host, Path: "passing",
+		HealthCheck: &HealthCheck{Path: "/ok", UnhealthyThreshold: 1}}
+	service := newTestService("svc", failing, passing)
+	mustCreateService(t, gateway, service)
+	tests := []struct {
+		name  string
+		probe *API
+		want  map[*API]bool // in rotation per api
+	}{
+		{name: "failing check", probe: failing, want: map[*API]bool{failing: false, passing: true}},
+		{name: "passing check keep other api down", probe: passing, want: map[*API]bool{failing: false, passing: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway.probe(context.Background(), service, tt.probe, host)
+			for api, want := range tt.want {
+				if got := gateway.health.healthy(api, host); got != want {
+					t.Errorf("api %v in rotation %v, want %v", api.Name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProbedHosts(t *testing.T) {
+	tests := []struct {
+		name string
+		api  *API
+		want []string
+	}{
+		{name: "hosts", api: &API{Hosts: []string{"a:80", "b:80"}}, want: []string{"a:80", "b:80"}},
+		{
+			name: "weighted backends",
+			api:  &API{Hosts: []string{"a:80"}, Backends: []Backend{{Host: "b:80"}, {Host: "a:80"}}},
+			want: []string{"a:80", "b:80"},
+		},
+		{
+			name: "region hosts",
+			api:  &API{Hosts: []string{"a:80"}, RegionHosts: map[string][]string{"us": {"c:80"}, "de": {"b:80", "a:80"}}},
+			want: []string{"a:80", "b:80", "c:80"},
+		},
+		{
+			name: "blue green pools",
+			api:  &API{Hosts: []string{"a:80"}, BlueHosts: []string{"a:80"}, GreenHosts: []string{"g:80"}},
+			want: []string{"a:80", "g:80"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := probedHosts(tt.api)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("probed hosts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthChecksPrune(t *testing.T) {
+	var healthy, probes int32 = 0, 0
+	host := healthBackend(t, &healthy, &probes)
+	gateway := newTestGateway(t)
+	api := &API{Name: "get", HTTPMethod: http.MethodGet, Host: host, Path: "get",
+		HealthCheck: &HealthCheck{Path: "/health", IntervalSeconds: 1, UnhealthyThreshold: 1}}
+	mustCreateService(t, gateway, newTestService("svc", api))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gateway.runHealthChecks(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for gateway.health.healthy(api, host) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if gateway.health.healthy(api, host) {
+		t.Fatalf("failing host still in rotation")
+	}
+	if err := gateway.Discovery.DeleteService("svc"); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for !gateway.health.healthy(api, host) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !gateway.health.healthy(api, host) {
+		t.Errorf("probe state of deleted api kept")
+	}
+}
@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseNets parse ip addresses and CIDRs, a plain address match itself only
+func parseNets(entries []string) ([]*net.IPNet, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("ip: %q invalid", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("cidr: %q invalid", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// containsIP report whether ip is in one of nets
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeIPFilter validate AllowIPs and DenyIPs of api and parse them
+func normalizeIPFilter(api *API) error {
+	var err error
+	if api.allowNets, err = parseNets(api.AllowIPs); err != nil {
+		return fmt.Errorf("api: %v allowIPs %v", api.Name, err)
+	}
+	if api.denyNets, err = parseNets(api.DenyIPs); err != nil {
+		return fmt.Errorf("api: %v denyIPs %v", api.Name, err)
+	}
+	return nil
+}
+
+// SetTrustedProxies configure the addresses or CIDRs of proxies in front of the gateway,
+// X-Forwarded-For is only honored on requests coming from them
+func (gateway *APIGateway) SetTrustedProxies(entries []string) error {
+	nets, err := parseNets(entries)
+	if err != nil {
+		return fmt.Errorf("trusted proxy %v", err)
+	}
+	gateway.trustedProxies = nets
+	return nil
+}
+
+// remoteIP return the address r comes from, nil if malformed
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// fromTrustedProxy report whether r comes from one of the trusted proxies
+func (gateway *APIGateway) fromTrustedProxy(r *http.Request) bool {
+	ip := remoteIP(r)
+	return ip != nil && containsIP(gateway.trustedProxies, ip)
+}
+
+// clientIP return the address of the client, X-Forwarded-For is walked from the right
+// while the hops are trusted proxies so that clients can not spoof it, nil if malformed
+func (gateway *APIGateway) clientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !containsIP(gateway.trustedProxies, ip) {
+		return ip
+	}
+	values := r.Header.Values("X-Forwarded-For")
+	for i := len(values) - 1; i >= 0; i-- {
+		hops := strings.Split(values[i], ",")
+		for j := len(hops) - 1; j >= 0; j-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[j]))
+			if hop == nil {
+				return nil
+			}
+			ip = hop
+			if !containsIP(gateway.trustedProxies, ip) {
+				return ip
+			}
+		}
+	}
+	return ip
+}
+
+// filterIP write 403 and return false when the client of api is denied or not allowed,
+// clients whose address can not be told are rejected by any list
+func (gateway *APIGateway) filterIP(w http.ResponseWriter, r *http.Request, api *API) bool {
+	if len(api.allowNets) == 0 && len(api.denyNets) == 0 {
+		return true
+	}
+	ip := gateway.clientIP(r)
+	if ip != nil && !containsIP(api.denyNets, ip) && (len(api.allowNets) == 0 || containsIP(api.allowNets, ip)) {
+		return true
+	}
+	if ip == nil {
+		gateway.writeError(w, r, http.StatusForbidden, "client ip unknown")
+		return false
+	}
+	gateway.writeError(w, r, http.StatusForbidden, fmt.Sprintf("client ip: %v not allowed", ip))
+	return false
+}
+
+// forwardedHeaders tell backends the scheme and host the client asked for in
+// X-Forwarded-Proto and X-Forwarded-Host, values set by trusted proxies are kept, the
+// proxy itself append the client address to X-Forwarded-For
+func (gateway *APIGateway) forwardedHeaders(req *http.Request) {
+	trusted := gateway.fromTrustedProxy(req)
+	if !trusted || req.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if !trusted || req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Del("X-Forwarded-Host")
+		if req.Host != "" {
+			req.Header.Set("X-Forwarded-Host", req.Host)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		trusted []string
+		remote  string
+		xff     []string
+		status  int
+	}{
+		{name: "no lists", remote: "203.0.113.9", status: http.StatusOK},
+		{name: "allowed cidr", allow: []string{"10.0.0.0/8"}, remote: "10.1.2.3", status: http.StatusOK},
+		{name: "allowed address", allow: []string{"192.0.2.1"}, remote: "192.0.2.1", status: http.StatusOK},
+		{name: "not allowed", allow: []string{"10.0.0.0/8"}, remote: "203.0.113.9", status: http.StatusForbidden},
+		{name: "denied", deny: []string{"203.0.113.0/24"}, remote: "203.0.113.9", status: http.StatusForbidden},
+		{name: "not denied", deny: []string{"203.0.113.0/24"}, remote: "198.51.100.1", status: http.StatusOK},
+		{name: "deny wins over allow", allow: []string{"10.0.0.0/8"}, deny: []string{"10.6.6.6"}, remote: "10.6.6.6", status: http.StatusForbidden},
+		{name: "ipv6", allow: []string{"fd00::/8"}, remote: "[fd00::1]", status: http.StatusOK},
+		{
+			name:   "spoofed forwarded for ignored",
+			allow:  []string{"10.0.0.0/8"},
+			remote: "203.0.113.9",
+			xff:    []string{"10.1.2.3"},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "spoofed forwarded for can not escape deny",
+			deny:   []string{"203.0.113.0/24"},
+			remote: "203.0.113.9",
+			xff:    []string{"198.51.100.1"},
+			status: http.StatusForbidden,
+		},
+		{
+			name:    "forwarded by trusted proxy",
+			allow:   []string{"10.0.0.0/8"},
+			trusted: []string{"192.0.2.0/24"},
+			remote:  "192.0.2.10",
+			xff:     []string{"10.1.2.3"},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "client spoofing behind trusted proxy",
+			allow:   []string{"10.0.0.0/8"},
+			trusted: []string{"192.0.2.0/24"},
+			remote:  "192.0.2.10",
+			xff:     []string{"10.1.2.3, 203.0.113.9"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "chain of trusted proxies",
+			allow:   []string{"10.0.0.0/8"},
+			trusted: []string{"192.0.2.0/24"},
+			remote:  "192.0.2.10",
+			xff:     []string{"10.1.2.3", "192.0.2.11"},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "malformed forwarded for",
+			deny:    []string{"203.0.113.0/24"},
+			trusted: []string{"192.0.2.0/24"},
+			remote:  "192.0.2.10",
+			xff:     []string{"not-an-ip"},
+			status:  http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+			})
+			gateway := newTestGateway(t)
+			if err := gateway.SetTrustedProxies(tt.trusted); err != nil {
+				t.Fatalf("trusted proxies: %v", err)
+			}
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get", AllowIPs: tt.allow, DenyIPs: tt.deny}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+			req.RemoteAddr = tt.remote + ":40000"
+			for _, value := range tt.xff {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if proxied := atomic.LoadInt32(&hits) > 0; proxied != (tt.status == http.StatusOK) {
+				t.Errorf("backend hit %v for status %d", proxied, rec.Code)
+			}
+		})
+	}
+}
+
+func TestIPFilterRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+	}{
+		{name: "invalid address", allow: []string{"10.0.0.256"}},
+		{name: "invalid cidr", deny: []string{"10.0.0.0/33"}},
+		{name: "hostname", allow: []string{"localhost"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get", AllowIPs: tt.allow, DenyIPs: tt.deny}))
+			if err == nil {
+				t.Errorf("invalid ip list accepted")
+			}
+		})
+	}
+	if err := newTestGateway(t).SetTrustedProxies([]string{"proxy.internal"}); err == nil {
+		t.Errorf("invalid trusted proxy accepted")
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		trusted  []string
+		tls      bool
+		sent     map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "direct client",
+			expected: map[string]string{"X-Forwarded-For": "192.0.2.1", "X-Forwarded-Proto": "http", "X-Forwarded-Host": "api.example.com"},
+		},
+		{
+			name:     "tls",
+			tls:      true,
+			expected: map[string]string{"X-Forwarded-Proto": "https"},
+		},
+		{
+			name:     "chain appended",
+			sent:     map[string]string{"X-Forwarded-For": "10.0.0.1, 10.0.0.2"},
+			expected: map[string]string{"X-Forwarded-For": "10.0.0.1, 10.0.0.2, 192.0.2.1"},
+		},
+		{
+			name:     "untrusted proto and host replaced",
+			sent:     map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example.com"},
+			expected: map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Host": "api.example.com"},
+		},
+		{
+			name:    "trusted proxy values kept",
+			trusted: []string{"192.0.2.0/24"},
+			sent:    map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"},
+			expected: map[string]string{
+				"X-Forwarded-For":   "203.0.113.9, 192.0.2.1",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "www.example.com",
+			},
+		},
+		{
+			name:     "trusted proxy without values",
+			trusted:  []string{"192.0.2.0/24"},
+			expected: map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Host": "api.example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan http.Header, 1)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header
+			})
+			gateway := newTestGateway(t)
+			if err := gateway.SetTrustedProxies(tt.trusted); err != nil {
+				t.Fatalf("trusted proxies: %v", err)
+			}
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+			req := httptest.NewRequest(http.MethodGet, "http://api.example.com/svc/get", nil)
+			req.RemoteAddr = "192.0.2.1:40000"
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.sent {
+				req.Header.Set(k, v)
+			}
+			if rec := serveProxy(gateway, req); rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			header := <-received
+			for k, want := range tt.expected {
+				if got := strings.Join(header.Values(k), ", "); got != want {
+					t.Errorf("%v %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIPFilterStage(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []string
+		status int // of an anonymous request from a denied ip
+	}{
+		{name: "default filter first", status: http.StatusForbidden},
+		{name: "auth before ip filter", stages: []string{StageAuth, StageIPFilter, StageRateLimit, StageConcurrency}, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			if tt.stages != nil {
+				if err := gateway.SetPipeline(tt.stages); err != nil {
+					t.Fatalf("set pipeline: %v", err)
+				}
+			}
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "get",
+				DenyIPs: []string{"192.0.2.0/24"},
+				Auth:    &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret},
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if rec := serveProxy(gateway, req); rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if err := gateway.SetPipeline([]string{StageAuth, StageRateLimit, StageConcurrency}); err == nil || !strings.Contains(err.Error(), StageIPFilter) {
+				t.Errorf("error %v, want ipFilter required", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotations of Ingress read by KubeDiscovery
+const (
+	// KubeServiceAnnotation name the gateway service of an Ingress, default the Ingress name
+	KubeServiceAnnotation = "go-gateway/service"
+	// KubeMethodAnnotation is the http method of the apis of an Ingress, default GET
+	KubeMethodAnnotation = "go-gateway/method"
+)
+
+// kubeRetryMax bound the backoff between failed list or watch of ingresses
+const kubeRetryMax = 30 * time.Second
+
+// ingress is the subset of networking.k8s.io/v1 Ingress used to build routes
+type ingress struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Rules []struct {
+			HTTP *struct {
+				Paths []struct {
+					Path    string `json:"path"`
+					Backend struct {
+						Service *struct {
+							Name string `json:"name"`
+							Port struct {
+								Number int    `json:"number"`
+								Name   string `json:"name"`
+							} `json:"port"`
+						} `json:"service"`
+					} `json:"backend"`
+				} `json:"paths"`
+			} `json:"http"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// ingressList is the response of listing ingresses
+type ingressList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []ingress `json:"items"`
+}
+
+// watchEvent is an event of watching ingresses
+type watchEvent struct {
+	Type   string          `json:"type"` // ADDED, MODIFIED, DELETED, BOOKMARK or ERROR
+	Object json.RawMessage `json:"object"`
+}
+
+// KubeDiscovery is a Discovery serving routes built from Kubernetes Ingress resources,
+// each Ingress is a service and each of its paths /{api}[/...] an api proxied to the
+// backend Kubernetes service, services created through the gateway api are kept and an
+// Ingress taking the name of one is skipped
+type KubeDiscovery struct {
+	*cache
+	config  *KubeConfig
+	client  *http.Client
+	managed map[string]*Service // services stored for ingresses, only touched by Watch
+}
+
+// NewKubeDiscovery create discovery watching ingresses described by config, routes are
+// loaded once Watch runs
+func NewKubeDiscovery(config *KubeConfig, opts ...CacheOption) (*KubeDiscovery, error) {
+	client, err := config.client()
+	if err != nil {
+		return nil, err
+	}
+	return &KubeDiscovery{
+		cache:   NewCacheDiscovery(opts...).(*cache),
+		config:  config,
+		client:  client,
+		managed: make(map[string]*Service),
+	}, nil
+}
+
+// Watch list ingresses and reconcile routes on every change until ctx is done, list and
+// watch failures (including RBAC denial) are logged and retried with backoff
+func (d *KubeDiscovery) Watch(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := d.listAndWatch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// watch closed by the server, reconnect at once
+			backoff = time.Second
+			continue
+		}
+		d.logger.Errorf("kubernetes ingress watch failed, retry in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > kubeRetryMax {
+			backoff = kubeRetryMax
+		}
+	}
+}
+
+// listAndWatch reconcile all ingresses then follow changes until the watch ends,
+// every change triggers a full relist so routes never drift from the cluster
+func (d *KubeDiscovery) listAndWatch(ctx context.Context) error {
+	list, err := d.list(ctx)
+	if err != nil {
+		return err
+	}
+	d.reconcile(list.Items)
+	resp, err := d.get(ctx, "?watch=1&allowWatchBookmarks=true&resourceVersion="+list.Metadata.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				// the server ends watches periodically
+				return nil
+			}
+			return fmt.Errorf("watch decode: %v", err)
+		}
+		switch event.Type {
+		case "BOOKMARK":
+			continue
+		case "ERROR":
+			// e.g. 410 Gone when the resource version is too old, relist
+			return fmt.Errorf("watch error: %s", event.Object)
+		}
+		list, err := d.list(ctx)
+		if err != nil {
+			return err
+		}
+		d.reconcile(list.Items)
+	}
+}
+
+// list fetch all ingresses
+func (d *KubeDiscovery) list(ctx context.Context) (*ingressList, error) {
+	resp, err := d.get(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list ingressList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode ingress list failed: %v", err)
+	}
+	return &list, nil
+}
+
+// get request the ingress collection with query
+func (d *KubeDiscovery) get(ctx context.Context, query string) (*http.Response, error) {
+	path := "/apis/networking.k8s.io/v1/ingresses"
+	if d.config.Namespace != "" {
+		path = "/apis/networking.k8s.io/v1/namespaces/" + d.config.Namespace + "/ingresses"
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(d.config.Server, "/")+path+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.config.authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes api denied with %v, the service account needs get, list and watch "+
+			"on ingresses.networking.k8s.io", resp.Status)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes api responded %v", resp.Status)
+	}
+}
+
+// reconcile replace services owned by ingresses with the ones built from items, they are
+// validated as services created through the gateway api, whose names they can not take
+func (d *KubeDiscovery) reconcile(items []ingress) {
+	services := make(map[string]*Service)
+	for _, item := range items {
+		service, err := d.ingressService(item)
+		if err == nil {
+			err = d.prepareService(service)
+		}
+		if err != nil {
+			d.logger.Warnf("ingress: %v/%v skipped: %v", item.Metadata.Namespace, item.Metadata.Name, err)
+			continue
+		}
+		if _, exist := services[service.Name]; exist {
+			d.logger.Warnf("ingress: %v/%v skipped: service: %v defined by another ingress",
+				item.Metadata.Namespace, item.Metadata.Name, service.Name)
+			continue
+		}
+		services[service.Name] = service
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// a service replaced or deleted through the gateway api is no longer owned by its ingress
+	for name, owned := range d.managed {
+		if d.store[name] != owned {
+			delete(d.managed, name)
+			continue
+		}
+		if _, exist := services[name]; !exist {
+			delete(d.store, name)
+			delete(d.managed, name)
+		}
+	}
+	for name, service := range services {
+		existing, exist := d.store[name]
+		if exist && d.managed[name] == nil {
+			d.logger.Warnf("ingress service: %v skipped: service %v", name, ErrAlreadyExist)
+			continue
+		}
+		if _, exist := d.aliases[name]; exist {
+			d.logger.Warnf("ingress service: %v skipped: collides with existing alias", name)
+			continue
+		}
+		// unchanged services keep their runtime state such as limiters and balancers
+		if exist && sameService(existing, service) {
+			continue
+		}
+		d.store[name] = service
+		d.managed[name] = service
+	}
+}
+
+// ingressService build the gateway service of an ingress, a path whose api name is taken
+// by an earlier path is logged and skipped
+func (d *KubeDiscovery) ingressService(item ingress) (*Service, error) {
+	name := item.Metadata.Name
+	if annotated := item.Metadata.Annotations[KubeServiceAnnotation]; annotated != "" {
+		name = annotated
+	}
+	method := strings.ToUpper(item.Metadata.Annotations[KubeMethodAnnotation])
+	if method == "" {
+		method = http.MethodGet
+	}
+	service := &Service{Name: name, APIs: make(map[string]*API)}
+	for _, rule := range item.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			backend := p.Backend.Service
+			if backend == nil {
+				continue
+			}
+			if backend.Port.Number == 0 {
+				return nil, fmt.Errorf("path: %v named port: %q unsupported, use port number", p.Path, backend.Port.Name)
+			}
+			path := strings.Trim(p.Path, "/")
+			apiName := strings.SplitN(path, "/", 2)[0]
+			if apiName == "" {
+				return nil, fmt.Errorf("path: %q should start with the api name", p.Path)
+			}
+			if _, exist := service.APIs[apiName]; exist {
+				d.logger.Warnf("ingress: %v/%v path: %v skipped: api: %v defined by another path",
+					item.Metadata.Namespace, item.Metadata.Name, p.Path, apiName)
+				continue
+			}
+			api := &API{
+				Name:           apiName,
+				Service:        name,
+				HTTPMethod:     method,
+				Host:           backend.Name + "." + item.Metadata.Namespace + ".svc:" + strconv.Itoa(backend.Port.Number),
+				Path:           path,
+				CatchRemainder: true,
+			}
+			service.APIs[apiName] = api
+		}
+	}
+	return service, nil
+}
@@ -0,0 +1,334 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// kubePath is a path of a test ingress routed to service:port
+type kubePath struct {
+	path    string
+	service string
+	port    int
+}
+
+// testIngress build an ingress of namespace prod through its JSON form
+func testIngress(t *testing.T, name string, annotations map[string]string, paths ...kubePath) ingress {
+	t.Helper()
+	var httpPaths []interface{}
+	for _, p := range paths {
+		httpPaths = append(httpPaths, map[string]interface{}{
+			"path": p.path,
+			"backend": map[string]interface{}{
+				"service": map[string]interface{}{"name": p.service, "port": map[string]interface{}{"number": p.port}},
+			},
+		})
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "namespace": "prod", "annotations": annotations},
+		"spec":     map[string]interface{}{"rules": []interface{}{map[string]interface{}{"http": map[string]interface{}{"paths": httpPaths}}}},
+	})
+	var item ingress
+	if err := json.Unmarshal(data, &item); err != nil {
+		t.Fatalf("ingress: %v", err)
+	}
+	return item
+}
+
+// newTestKubeDiscovery return a discovery of the api server at server logging nowhere
+func newTestKubeDiscovery(t *testing.T, server string) *KubeDiscovery {
+	t.Helper()
+	d, err := NewKubeDiscovery(&KubeConfig{Server: server, Token: "secret", Namespace: "prod"}, WithCacheLogger(discardLogger))
+	if err != nil {
+		t.Fatalf("kube discovery: %v", err)
+	}
+	return d
+}
+
+// apiHosts return api name to host of service name, nil when it does not exist
+func apiHosts(d *KubeDiscovery, name string) map[string]string {
+	service, err := d.GetService(name)
+	if err != nil {
+		return nil
+	}
+	hosts := make(map[string]string)
+	for _, api := range service.APIs {
+		hosts[api.Name] = api.Host
+	}
+	return hosts
+}
+
+func TestIngressService(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		paths       []kubePath
+		service     string
+		method      string
+		apis        map[string]string // api name to host:path
+		errSub      string
+		warn        string
+	}{
+		{
+			name:    "defaults",
+			paths:   []kubePath{{path: "/users", service: "user", port: 8080}, {path: "/orders/v2", service: "order", port: 80}},
+			service: "shop", method: http.MethodGet,
+			apis: map[string]string{"users": "user.prod.svc:8080 users", "orders": "order.prod.svc:80 orders/v2"},
+		},
+		{
+			name:        "annotated",
+			annotations: map[string]string{KubeServiceAnnotation: "store", KubeMethodAnnotation: "post"},
+			paths:       []kubePath{{path: "/users/", service: "user", port: 8080}},
+			service:     "store", method: http.MethodPost,
+			apis: map[string]string{"users": "user.prod.svc:8080 users"},
+		},
+		{
+			name:    "duplicate api path",
+			paths:   []kubePath{{path: "/users", service: "user", port: 8080}, {path: "/users/v2", service: "user-v2", port: 80}},
+			service: "shop", method: http.MethodGet,
+			apis: map[string]string{"users": "user.prod.svc:8080 users"},
+			warn: "path: /users/v2 skipped: api: users defined by another path",
+		},
+		{name: "named port", paths: []kubePath{{path: "/users", service: "user"}}, errSub: "named port"},
+		{name: "root path", paths: []kubePath{{path: "/", service: "user", port: 80}}, errSub: "api name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordLogger{}
+			d, err := NewKubeDiscovery(&KubeConfig{Server: "http://127.0.0.1:1", Token: "secret", Namespace: "prod"}, WithCacheLogger(logger))
+			if err != nil {
+				t.Fatalf("kube discovery: %v", err)
+			}
+			service, err := d.ingressService(testIngress(t, "shop", tt.annotations, tt.paths...))
+			if tt.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+					t.Fatalf("error %v, want one mentioning %q", err, tt.errSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ingress service: %v", err)
+			}
+			apis := make(map[string]string)
+			for name, api := range service.APIs {
+				if api.HTTPMethod != tt.method || api.Service != tt.service || !api.CatchRemainder {
+					t.Errorf("api %+v, want method %v service %v catching remainder", api, tt.method, tt.service)
+				}
+				apis[name] = api.Host + " " + api.Path
+			}
+			if service.Name != tt.service || !reflect.DeepEqual(apis, tt.apis) {
+				t.Errorf("service %v apis %v, want %v %v", service.Name, apis, tt.service, tt.apis)
+			}
+			if _, found := logger.find(LevelWarn, tt.warn); tt.warn != "" && !found {
+				t.Errorf("no warning %q logged", tt.warn)
+			}
+		})
+	}
+}
+
+func TestKubeReconcile(t *testing.T) {
+	d := newTestKubeDiscovery(t, "http://127.0.0.1:1")
+	if err := d.CreateService(newTestService("manual", &API{Name: "get", HTTPMethod: http.MethodGet, Host: "10.0.0.1:80", Path: "get"})); err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	if err := d.CreateAlias("legacy", "manual"); err != nil {
+		t.Fatalf("create alias: %v", err)
+	}
+	user := testIngress(t, "user", nil, kubePath{path: "/get", service: "user", port: 80})
+	d.reconcile([]ingress{
+		user,
+		testIngress(t, "manual", nil, kubePath{path: "/get", service: "intruder", port: 80}),
+		testIngress(t, "legacy", nil, kubePath{path: "/get", service: "intruder", port: 80}),
+		testIngress(t, "invalid", map[string]string{KubeMethodAnnotation: "FETCH"}, kubePath{path: "/get", service: "bad", port: 80}),
+		testIngress(t, "other", map[string]string{KubeServiceAnnotation: "user"}, kubePath{path: "/get", service: "duplicate", port: 80}),
+	})
+	if got := apiHosts(d, "user"); !reflect.DeepEqual(got, map[string]string{"get": "user.prod.svc:80"}) {
+		t.Errorf("ingress service %v", got)
+	}
+	if got := apiHosts(d, "manual"); !reflect.DeepEqual(got, map[string]string{"get": "10.0.0.1:80"}) {
+		t.Errorf("service created through the api overwritten: %v", got)
+	}
+	if _, exist := d.store["legacy"]; exist {
+		t.Errorf("ingress took the name of an alias")
+	}
+	if apiHosts(d, "invalid") != nil {
+		t.Errorf("invalid ingress service stored")
+	}
+	// ingress services are prepared as any created service
+	first, _ := d.GetService("user")
+	if api := first.APIs["get"]; api.balancer == nil || len(api.methods) != 1 {
+		t.Errorf("ingress api not prepared: %+v", api)
+	}
+
+	d.reconcile([]ingress{user})
+	if again, _ := d.GetService("user"); again != first {
+		t.Errorf("unchanged ingress replaced its service and lost its runtime state")
+	}
+	d.reconcile([]ingress{testIngress(t, "user", nil, kubePath{path: "/get", service: "user-v2", port: 80})})
+	if got := apiHosts(d, "user"); got["get"] != "user-v2.prod.svc:80" {
+		t.Errorf("modified ingress not applied: %v", got)
+	}
+	d.reconcile(nil)
+	if apiHosts(d, "user") != nil || apiHosts(d, "manual") == nil {
+		t.Errorf("deleted ingress service kept or api service dropped")
+	}
+
+	// a service recreated through the gateway api is no longer owned by the ingress
+	d.reconcile([]ingress{user})
+	if err := d.DeleteService("user"); err != nil {
+		t.Fatalf("delete service: %v", err)
+	}
+	if err := d.CreateService(newTestService("user", &API{Name: "get", HTTPMethod: http.MethodGet, Host: "10.0.0.2:80", Path: "get"})); err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	d.reconcile([]ingress{user})
+	d.reconcile(nil)
+	if got := apiHosts(d, "user"); got["get"] != "10.0.0.2:80" {
+		t.Errorf("service recreated through the api changed by ingress: %v", got)
+	}
+}
+
+// fakeIngressServer is a Kubernetes api server serving ingresses of namespace prod
+type fakeIngressServer struct {
+	mu      sync.Mutex
+	items   []ingress
+	version int
+	events  chan string // event types sent to watchers
+	denied  bool
+}
+
+func (s *fakeIngressServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	denied := s.denied
+	s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" || denied {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.URL.Path != "/apis/networking.k8s.io/v1/namespaces/prod/ingresses" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("watch") == "" {
+		s.mu.Lock()
+		list := ingressList{Items: s.items}
+		list.Metadata.ResourceVersion = fmt.Sprint(s.version)
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-s.events:
+			if !ok {
+				// the server ends the watch, the client relists and watches again
+				return
+			}
+			fmt.Fprintf(w, `{"type":%q,"object":{}}`+"\n", event)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// set replace the ingresses served
+func (s *fakeIngressServer) set(items ...ingress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = items
+	s.version++
+}
+
+// waitHosts poll until service name has apis hosts, nil wait for it to be gone
+func waitHosts(t *testing.T, d *KubeDiscovery, name string, hosts map[string]string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(apiHosts(d, name), hosts) {
+		if time.Now().After(deadline) {
+			t.Fatalf("service %v apis %v, want %v", name, apiHosts(d, name), hosts)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKubeWatch(t *testing.T) {
+	fake := &fakeIngressServer{events: make(chan string)}
+	fake.set(testIngress(t, "user", nil, kubePath{path: "/get", service: "user", port: 80}))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	d := newTestKubeDiscovery(t, server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Watch(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitHosts(t, d, "user", map[string]string{"get": "user.prod.svc:80"})
+
+	fake.set(testIngress(t, "user", nil, kubePath{path: "/get", service: "user-v2", port: 80}))
+	fake.events <- "BOOKMARK"
+	fake.events <- "MODIFIED"
+	waitHosts(t, d, "user", map[string]string{"get": "user-v2.prod.svc:80"})
+
+	fake.set(testIngress(t, "order", nil, kubePath{path: "/list", service: "order", port: 80}))
+	fake.events <- "DELETED"
+	waitHosts(t, d, "user", nil)
+	waitHosts(t, d, "order", map[string]string{"list": "order.prod.svc:80"})
+
+	// changes made while the watch is down are picked up by the relist on reconnect
+	fake.set()
+	close(fake.events)
+	waitHosts(t, d, "order", nil)
+}
+
+func TestKubeDenied(t *testing.T) {
+	fake := &fakeIngressServer{denied: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	_, err := newTestKubeDiscovery(t, server.URL).list(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "get, list and watch") {
+		t.Errorf("error %v, want one naming the missing permissions", err)
+	}
+}
+
+func TestKubeWatchEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string // sent by the watch before it ends
+		wantErr bool
+	}{
+		{name: "closed by server"},
+		{name: "closed after event", body: `{"type":"BOOKMARK","object":{}}` + "\n"},
+		{name: "malformed event", body: "{not json\n", wantErr: true},
+		{name: "truncated event", body: `{"type":"MODIFIED"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("watch") == "" {
+					fmt.Fprint(w, `{"metadata":{"resourceVersion":"1"},"items":[]}`)
+					return
+				}
+				fmt.Fprint(w, tt.body)
+			})
+			err := newTestKubeDiscovery(t, "http://"+server).listAndWatch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
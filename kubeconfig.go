@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// in-cluster service account files
+const (
+	serviceAccountDir       = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	serviceAccountTokenFile = serviceAccountDir + "token"
+)
+
+// KubeConfig define how to reach the Kubernetes API
+type KubeConfig struct {
+	Server    string // e.g. https://10.0.0.1:443
+	Token     string // bearer token
+	TokenFile string // bearer token file read on every request, for rotated service account tokens
+	CAData    []byte // PEM CA verifying the API server, default system roots
+	CertData  []byte // PEM client certificate
+	KeyData   []byte // PEM client key
+	Insecure  bool   // skip API server certificate verification
+	Namespace string // watch ingresses of this namespace, empty watch all namespaces
+}
+
+// InClusterKubeConfig build config from the service account mounted into the pod,
+// the gateway watches its own namespace
+func InClusterKubeConfig() (*KubeConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in kubernetes cluster, KUBERNETES_SERVICE_HOST/PORT not set")
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("read service account ca failed: %v", err)
+	}
+	if _, err := os.Stat(serviceAccountTokenFile); err != nil {
+		return nil, fmt.Errorf("read service account token failed: %v", err)
+	}
+	namespace, _ := ioutil.ReadFile(serviceAccountDir + "namespace")
+	return &KubeConfig{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountTokenFile,
+		CAData:    ca,
+		Namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// kubeconfigFile is the JSON form of kubeconfig, as printed by
+// kubectl config view --minify --flatten -o json
+type kubeconfigFile struct {
+	CurrentContext string `json:"current-context"`
+	Contexts       []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster   string `json:"cluster"`
+			User      string `json:"user"`
+			Namespace string `json:"namespace"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Clusters []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server   string `json:"server"`
+			CAData   []byte `json:"certificate-authority-data"`
+			CAFile   string `json:"certificate-authority"`
+			Insecure bool   `json:"insecure-skip-tls-verify"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token     string `json:"token"`
+			TokenFile string `json:"tokenFile"`
+			CertData  []byte `json:"client-certificate-data"`
+			KeyData   []byte `json:"client-key-data"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+// LoadKubeConfig load the current context of a kubeconfig in JSON form, convert a YAML
+// kubeconfig with: kubectl config view --minify --flatten -o json
+func LoadKubeConfig(path string) (*KubeConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file kubeconfigFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("kubeconfig: %v should be JSON: %v", path, err)
+	}
+	config := &KubeConfig{}
+	var cluster, user string
+	for _, c := range file.Contexts {
+		if c.Name == file.CurrentContext {
+			cluster, user, config.Namespace = c.Context.Cluster, c.Context.User, c.Context.Namespace
+		}
+	}
+	if cluster == "" {
+		return nil, fmt.Errorf("kubeconfig: %v current context: %q not found", path, file.CurrentContext)
+	}
+	for _, c := range file.Clusters {
+		if c.Name != cluster {
+			continue
+		}
+		config.Server, config.CAData, config.Insecure = c.Cluster.Server, c.Cluster.CAData, c.Cluster.Insecure
+		if c.Cluster.CAFile != "" {
+			if config.CAData, err = ioutil.ReadFile(c.Cluster.CAFile); err != nil {
+				return nil, fmt.Errorf("kubeconfig: %v read ca failed: %v", path, err)
+			}
+		}
+	}
+	if config.Server == "" {
+		return nil, fmt.Errorf("kubeconfig: %v cluster: %q has no server", path, cluster)
+	}
+	for _, u := range file.Users {
+		if u.Name == user {
+			config.Token, config.TokenFile = u.User.Token, u.User.TokenFile
+			config.CertData, config.KeyData = u.User.CertData, u.User.KeyData
+		}
+	}
+	return config, nil
+}
+
+// client build http client talking to the API server
+func (config *KubeConfig) client() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure}
+	if len(config.CAData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(config.CAData) {
+			return nil, fmt.Errorf("kubernetes ca has no certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.CertData) > 0 {
+		cert, err := tls.X509KeyPair(config.CertData, config.KeyData)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes client certificate invalid: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// authorize set the bearer token on request to the API server
+func (config *KubeConfig) authorize(req *http.Request) error {
+	token := config.Token
+	if config.TokenFile != "" {
+		data, err := ioutil.ReadFile(config.TokenFile)
+		if err != nil {
+			return fmt.Errorf("read token file failed: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
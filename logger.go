@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receive the logs of the gateway, its discoveries and certificate reloaders,
+// implement it to forward them to another logging library
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// LogLevel is the severity of a log line
+type LogLevel int
+
+// Log levels from the most verbose
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String implements fmt.Stringer
+func (level LogLevel) String() string {
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+	return levelNames[level]
+}
+
+// ParseLogLevel parse debug, info, warn or error
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("log level: %q unsupported, should be one of %v", s, strings.Join(levelNames, ", "))
+}
+
+// stdLogger write lines at or above min to a standard library logger, prefixed with
+// their level
+type stdLogger struct {
+	logger *log.Logger // nil use the standard logger of package log
+	min    LogLevel
+}
+
+// NewStdLogger return Logger writing lines at or above min to logger, nil logger write
+// to the standard logger of package log
+func NewStdLogger(logger *log.Logger, min LogLevel) Logger {
+	return &stdLogger{logger: logger, min: min}
+}
+
+// defaultLogger is used when no Logger is configured
+var defaultLogger = NewStdLogger(nil, LevelInfo)
+
+// orDefaultLogger return logger, defaultLogger if nil
+func orDefaultLogger(logger Logger) Logger {
+	if logger == nil {
+		return defaultLogger
+	}
+	return logger
+}
+
+func (l *stdLogger) output(level LogLevel, format string, args []interface{}) {
+	if level < l.min {
+		return
+	}
+	line := strings.ToUpper(level.String()) + " " + fmt.Sprintf(format, args...)
+	// callers of Debugf and the like are reported by Lshortfile
+	if l.logger == nil {
+		log.Output(3, line)
+		return
+	}
+	l.logger.Output(3, line)
+}
+
+// Debugf implements Logger
+func (l *stdLogger) Debugf(format string, args ...interface{}) { l.output(LevelDebug, format, args) }
+
+// Infof implements Logger
+func (l *stdLogger) Infof(format string, args ...interface{}) { l.output(LevelInfo, format, args) }
+
+// Warnf implements Logger
+func (l *stdLogger) Warnf(format string, args ...interface{}) { l.output(LevelWarn, format, args) }
+
+// Errorf implements Logger
+func (l *stdLogger) Errorf(format string, args ...interface{}) { l.output(LevelError, format, args) }
+
+// logger return the Logger of the gateway, defaultLogger if none is set
+func (gateway *APIGateway) logger() Logger {
+	return orDefaultLogger(gateway.Logger)
+}
+
+// WithLogger send the logs of the gateway to logger instead of the standard logger
+func WithLogger(logger Logger) Option {
+	return func(gateway *APIGateway) {
+		gateway.Logger = logger
+	}
+}
+
+// WithCacheLogger send the logs of the discovery to logger instead of the standard logger
+func WithCacheLogger(logger Logger) CacheOption {
+	return func(c *cache) {
+		c.logger = orDefaultLogger(logger)
+	}
+}
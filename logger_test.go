@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNotFoundLogged(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{name: "unknown service", path: "/missing/get", expected: "unknown service"},
+		{name: "unknown api", path: "/svc/missing", expected: "unknown api"},
+		{name: "remainder not caught", path: "/svc/get/extra", expected: "does not catch remainder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordLogger{}
+			gateway := newTestGateway(t, WithLogger(logger))
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get"}))
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(DefaultRequestIDHeader, "not-found-id")
+			if rec := serveProxy(gateway, req); rec.Code != http.StatusNotFound {
+				t.Fatalf("status %d, want 404", rec.Code)
+			}
+			line, ok := logger.find(LevelInfo, tt.expected)
+			if !ok {
+				t.Fatalf("no info line with %q in %q", tt.expected, logger.lines)
+			}
+			if !strings.Contains(line, "not-found-id") {
+				t.Errorf("line %q misses the request id", line)
+			}
+			for _, level := range []LogLevel{LevelWarn, LevelError} {
+				if line, ok := logger.find(level, ""); ok {
+					t.Errorf("not found route logged %q at %v", line, level)
+				}
+			}
+		})
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		min      LogLevel
+		expected []string
+	}{
+		{name: "debug", min: LevelDebug, expected: []string{"DEBUG d", "INFO i", "WARN w", "ERROR e"}},
+		{name: "info", min: LevelInfo, expected: []string{"INFO i", "WARN w", "ERROR e"}},
+		{name: "error", min: LevelError, expected: []string{"ERROR e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := NewStdLogger(log.New(&out, "", 0), tt.min)
+			logger.Debugf("%v", "d")
+			logger.Infof("%v", "i")
+			logger.Warnf("%v", "w")
+			logger.Errorf("%v", "e")
+			if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("lines %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected LogLevel
+		wantErr  bool
+	}{
+		{input: "debug", expected: LevelDebug},
+		{input: " Warn ", expected: LevelWarn},
+		{input: "ERROR", expected: LevelError},
+		{input: "trace", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			level, err := ParseLogLevel(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && level != tt.expected {
+				t.Errorf("level %v, want %v", level, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds in seconds of request duration histogram
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// exemplar link an observation to the trace it was made in
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+// histogram count observations per bucket, keeping the latest traced exemplar of each bucket
+type histogram struct {
+	counts    []uint64 // per bucket, last one is +Inf
+	exemplars []*exemplar
+	sum       float64
+	count     uint64
+}
+
+// observe add value, traceID may be empty
+func (h *histogram) observe(value float64, traceID string) {
+	i := sort.SearchFloat64s(durationBuckets, value)
+	h.counts[i]++
+	h.sum += value
+	h.count++
+	if traceID != "" {
+		h.exemplars[i] = &exemplar{traceID: traceID, value: value, at: time.Now()}
+	}
+}
+
+// metricsKey identify a histogram series
+type metricsKey struct {
+	service string
+	api     string
+}
+
+// statusKey identify a request counter series, status codes are bounded so are the series
+type statusKey struct {
+	metricsKey
+	code int
+}
+
+// requestMetrics record duration of proxied requests per api, requests per api and status
+// and backend errors per api, requests not resolved to an api are counted with empty labels
+type requestMetrics struct {
+	mu            sync.Mutex
+	duration      map[metricsKey]*histogram
+	requests      map[statusKey]uint64
+	backendErrors map[metricsKey]uint64
+}
+
+// count record the status answered to a request routed to api of service
+func (m *requestMetrics) count(service, api string, code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests == nil {
+		m.requests = make(map[statusKey]uint64)
+	}
+	m.requests[statusKey{metricsKey{service: service, api: api}, code}]++
+}
+
+// backendError record a request to api of service failing to get a backend response
+func (m *requestMetrics) backendError(service, api string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.backendErrors == nil {
+		m.backendErrors = make(map[metricsKey]uint64)
+	}
+	m.backendErrors[metricsKey{service: service, api: api}]++
+}
+
+// observe record the duration of a request proxied to api of service
+func (m *requestMetrics) observe(service, api string, duration time.Duration, traceID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.duration == nil {
+		m.duration = make(map[metricsKey]*histogram)
+	}
+	key := metricsKey{service: service, api: api}
+	h, exist := m.duration[key]
+	if !exist {
+		h = &histogram{
+			counts:    make([]uint64, len(durationBuckets)+1),
+			exemplars: make([]*exemplar, len(durationBuckets)+1),
+		}
+		m.duration[key] = h
+	}
+	h.observe(duration.Seconds(), traceID)
+}
+
+// writeOpenMetrics write the metrics in OpenMetrics text format, bucket samples carry
+// the trace id exemplar when one was observed
+func (m *requestMetrics) writeOpenMetrics(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writeRequests(w)
+	m.writeBackendErrors(w)
+	keys := make([]metricsKey, 0, len(m.duration))
+	for key := range m.duration {
+		keys = append(keys, key)
+	}
+	sortMetricsKeys(keys)
+	const name = "gateway_request_duration_seconds"
+	fmt.Fprintf(w, "# TYPE %v histogram\n# UNIT %v seconds\n# HELP %v Duration of proxied requests.\n", name, name, name)
+	for _, key := range keys {
+		h := m.duration[key]
+		labels := fmt.Sprintf("service=%q,api=%q", key.service, key.api)
+		var cumulative uint64
+		for i, count := range h.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(durationBuckets) {
+				le = strconv.FormatFloat(durationBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%v_bucket{%v,le=%q} %d", name, labels, le, cumulative)
+			if e := h.exemplars[i]; e != nil {
+				fmt.Fprintf(w, " # {trace_id=%q} %v %.3f", e.traceID,
+					strconv.FormatFloat(e.value, 'g', -1, 64), float64(e.at.UnixNano())/1e9)
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%v_sum{%v} %v\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%v_count{%v} %d\n", name, labels, h.count)
+	}
+	fmt.Fprintln(w, "# EOF")
+}
+
+// writeRequests write the request counters per api and status code
+func (m *requestMetrics) writeRequests(w io.Writer) {
+	keys := make([]statusKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].metricsKey != keys[j].metricsKey {
+			return lessMetricsKey(keys[i].metricsKey, keys[j].metricsKey)
+		}
+		return keys[i].code < keys[j].code
+	})
+	const name = "gateway_requests"
+	fmt.Fprintf(w, "# TYPE %v counter\n# HELP %v Requests answered by the gateway.\n", name, name)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v_total{service=%q,api=%q,code=\"%d\"} %d\n", name, key.service, key.api, key.code, m.requests[key])
+	}
+}
+
+// writeBackendErrors write the counters of requests failing to get a backend response
+func (m *requestMetrics) writeBackendErrors(w io.Writer) {
+	keys := make([]metricsKey, 0, len(m.backendErrors))
+	for key := range m.backendErrors {
+		keys = append(keys, key)
+	}
+	sortMetricsKeys(keys)
+	const name = "gateway_backend_errors"
+	fmt.Fprintf(w, "# TYPE %v counter\n# HELP %v Proxied requests failing to get a backend response.\n", name, name)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v_total{service=%q,api=%q} %d\n", name, key.service, key.api, m.backendErrors[key])
+	}
+}
+
+// sortMetricsKeys sort keys by service then api
+func sortMetricsKeys(keys []metricsKey) {
+	sort.Slice(keys, func(i, j int) bool { return lessMetricsKey(keys[i], keys[j]) })
+}
+
+func lessMetricsKey(a, b metricsKey) bool {
+	if a.service != b.service {
+		return a.service < b.service
+	}
+	return a.api < b.api
+}
+
+// Metrics handle http request to expose metrics in OpenMetrics text format
+func (gateway *APIGateway) Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	gateway.metrics.writeOpenMetrics(w)
+}
+
+// countRequest count the status answered to request once ServeHTTP returns
+func (gateway *APIGateway) countRequest(rec *responseRecorder, entry *accessEntry) {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	gateway.metrics.count(entry.service, entry.api, status)
+}
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHistogramExemplars(t *testing.T) {
+	h := &histogram{counts: make([]uint64, len(durationBuckets)+1), exemplars: make([]*exemplar, len(durationBuckets)+1)}
+	h.observe(0.003, "")
+	h.observe(0.004, "trace-a")
+	h.observe(0.2, "trace-b")
+	h.observe(0.3, "trace-c")
+	h.observe(20, "")
+	tests := []struct {
+		bucket  int
+		traceID string // latest exemplar, empty when none
+	}{
+		{bucket: 0, traceID: "trace-a"},
+		{bucket: 1},
+		{bucket: 5, traceID: "trace-b"},
+		{bucket: 6, traceID: "trace-c"},
+		{bucket: len(durationBuckets)},
+	}
+	for _, tt := range tests {
+		e := h.exemplars[tt.bucket]
+		got := ""
+		if e != nil {
+			got = e.traceID
+		}
+		if got != tt.traceID {
+			t.Errorf("bucket %d exemplar %q, want %q", tt.bucket, got, tt.traceID)
+		}
+	}
+	if h.count != 5 || h.counts[0] != 2 {
+		t.Errorf("count %d first bucket %d, want 5 and 2", h.count, h.counts[0])
+	}
+}
+
+func TestMetricsExemplars(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		traceparent string
+		exemplar    bool
+	}{
+		{name: "traced", traceparent: "00-" + traceID + "-00f067aa0ba902b7-01", exemplar: true},
+		{name: "not traced"},
+		{name: "invalid traceparent", traceparent: "00-" + strings.Repeat("0", 32) + "-00f067aa0ba902b7-01"},
+	}
+	exemplarLine := regexp.MustCompile(`^gateway_request_duration_seconds_bucket\{service="svc",api="get",le="[^"]+"\} 1 # \{trace_id="` + traceID + `"\} [0-9.e-]+ [0-9]+\.[0-9]{3}$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "get"}))
+			req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			serveProxy(gateway, req)
+			rec := serveAdmin(gateway, http.MethodGet, "/metrics", "")
+			if !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/openmetrics-text") || !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+				t.Fatalf("not openmetrics: %v\n%s", rec.Header().Get("Content-Type"), rec.Body.String())
+			}
+			var exemplars []string
+			for _, line := range strings.Split(rec.Body.String(), "\n") {
+				if strings.Contains(line, "# {") {
+					exemplars = append(exemplars, line)
+				}
+			}
+			if !tt.exemplar {
+				if len(exemplars) != 0 {
+					t.Errorf("exemplars without trace: %q", exemplars)
+				}
+				return
+			}
+			// the exemplar sits on the bucket the observation fell in, the first counting it
+			if len(exemplars) != 1 || !exemplarLine.MatchString(exemplars[0]) {
+				t.Errorf("exemplars %q, want one on the observed bucket", exemplars)
+			}
+		})
+	}
+}
+
+func TestMetricsMethodNotAllowed(t *testing.T) {
+	rec := serveAdmin(newTestGateway(t), http.MethodPost, "/metrics", "")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("got %d Allow %q, want 405 Allow GET", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "get"},
+		&API{Name: "down", HTTPMethod: http.MethodGet, Host: freeAddr(t), Path: "down"}))
+	for _, target := range []string{"/svc/get", "/svc/get", "/svc/get?x=1", "/svc/down", "/svc/unknown/1", "/svc/unknown/2"} {
+		serveProxy(gateway, httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	serveProxy(gateway, httptest.NewRequest(http.MethodPost, "/svc/get", nil))
+	body := serveAdmin(gateway, http.MethodGet, "/metrics", "").Body.String()
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "requests per api and status", line: `gateway_requests_total{service="svc",api="get",code="200"} 3`},
+		{name: "method not allowed", line: `gateway_requests_total{service="svc",api="get",code="405"} 1`},
+		{name: "backend down", line: `gateway_requests_total{service="svc",api="down",code="502"} 1`},
+		{name: "unresolved paths share a series", line: `gateway_requests_total{service="",api="",code="404"} 2`},
+		{name: "backend errors", line: `gateway_backend_errors_total{service="svc",api="down"} 1`},
+		{name: "durations per api", line: `gateway_request_duration_seconds_count{service="svc",api="get"} 3`},
+		{name: "durations bucket", line: `gateway_request_duration_seconds_bucket{service="svc",api="get",le="+Inf"} 3`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.line+"\n") {
+				t.Errorf("metrics miss %q:\n%s", tt.line, body)
+			}
+		})
+	}
+	if strings.Contains(body, "unknown") || strings.Contains(body, "/svc/") {
+		t.Errorf("metrics labelled by path:\n%s", body)
+	}
+}
@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+const (
+	// maxMirrorBodyBytes bound the request body copied to the mirror, requests with larger
+	// bodies are not mirrored
+	maxMirrorBodyBytes = 1 << 20
+	// maxMirrorsInFlight bound the mirror requests in progress, requests arriving while a
+	// slow mirror holds all of them are not mirrored
+	maxMirrorsInFlight = 256
+	// defaultMirrorTimeout bound mirror requests of apis without TimeoutMs
+	defaultMirrorTimeout = 30 * time.Second
+)
+
+// normalizeMirror validate MirrorHost of api
+func normalizeMirror(api *API) error {
+	if api.MirrorHost == "" {
+		return nil
+	}
+	if err := validateHost(api.MirrorHost); err != nil {
+		return fmt.Errorf("api: %v mirrorHost %v", api.Name, err)
+	}
+	if api.Streaming {
+		return fmt.Errorf("api: %v streaming api can not be mirrored", api.Name)
+	}
+	return nil
+}
+
+// mirrorProxy return the proxy sending mirror requests, built on first use
+func (gateway *APIGateway) mirrorProxy() *httputil.ReverseProxy {
+	gateway.mirrorOnce.Do(func() {
+		gateway.mirrors = &httputil.ReverseProxy{
+			Director:  gateway.director,
+			Transport: &serviceTransport{base: gateway.backendTransport()},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				gateway.logger().Warnf("request: %v mirror to %v failed: %v", requestID(r.Context()), r.URL.Host, err)
+			},
+		}
+		gateway.mirrorSlots = make(chan struct{}, maxMirrorsInFlight)
+	})
+	return gateway.mirrors
+}
+
+// mirror send a copy of r to the MirrorHost of its api in the background, the mirror
+// response is discarded, the body of r is buffered so that both requests get it whole and
+// r is returned with the buffered body
+func (gateway *APIGateway) mirror(r *http.Request, rt *route) *http.Request {
+	api := rt.api
+	if api.MirrorHost == "" || rt.upgrade {
+		return r
+	}
+	var data []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		data, err = ioutil.ReadAll(io.LimitReader(r.Body, maxMirrorBodyBytes+1))
+		if err != nil || len(data) > maxMirrorBodyBytes {
+			// the backend still get the whole body, or the read error
+			r.Body = &replayedBody{Reader: io.MultiReader(bytes.NewReader(data), r.Body), Closer: r.Body}
+			gateway.logger().Debugf("request: %v not mirrored, body too large or unreadable", requestID(r.Context()))
+			return r
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(data))
+		// the buffered body can be sent again by retries
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+		r.ContentLength = int64(len(data))
+		r.TransferEncoding = nil
+	}
+	proxy := gateway.mirrorProxy()
+	select {
+	case gateway.mirrorSlots <- struct{}{}:
+	default:
+		gateway.logger().Warnf("request: %v not mirrored, %d mirror requests in flight", requestID(r.Context()), maxMirrorsInFlight)
+		return r
+	}
+	timeout := defaultMirrorTimeout
+	if api.TimeoutMs > 0 {
+		timeout = time.Duration(api.TimeoutMs) * time.Millisecond
+	}
+	// the mirror outlive the client request and must not touch its access log entry
+	mirrorRoute := *rt
+	mirrorRoute.backend = api.MirrorHost
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx = withRoute(context.WithValue(ctx, requestIDKey{}, requestID(r.Context())), &mirrorRoute)
+	req := r.Clone(ctx)
+	req.Body = http.NoBody
+	if data != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	}
+	go func() {
+		defer func() {
+			<-gateway.mirrorSlots
+			cancel()
+			// the proxy abort handlers whose response body fails to copy
+			if p := recover(); p != nil && p != http.ErrAbortHandler {
+				panic(p)
+			}
+		}()
+		proxy.ServeHTTP(discardResponseWriter{header: make(http.Header)}, req)
+	}()
+	return r
+}
+
+// replayedBody read the part of a body already consumed before the rest of it
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// discardResponseWriter drop the mirror response
+type discardResponseWriter struct {
+	header http.Header
+}
+
+// Header implements http.ResponseWriter
+func (w discardResponseWriter) Header() http.Header { return w.header }
+
+// Write implements http.ResponseWriter
+func (w discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// WriteHeader implements http.ResponseWriter
+func (w discardResponseWriter) WriteHeader(int) {}
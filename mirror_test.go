@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	large := strings.Repeat("x", maxMirrorBodyBytes+1)
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		mirrorDown bool
+		mirrored   bool
+	}{
+		{name: "without body", method: http.MethodGet, mirrored: true},
+		{name: "with body", method: http.MethodPost, body: `{"name":"ann"}`, mirrored: true},
+		{name: "body too large", method: http.MethodPost, body: large},
+		{name: "mirror unreachable", method: http.MethodPost, body: "hello", mirrorDown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primaryBodies := make(chan string, 1)
+			primary := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				primaryBodies <- string(body)
+				w.Write([]byte("primary"))
+			})
+			mirrorBodies := make(chan string, 1)
+			mirror := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				mirrorBodies <- r.Method + " " + r.URL.Path + " " + string(body)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("mirror"))
+			})
+			if tt.mirrorDown {
+				mirror = freeAddr(t)
+			}
+			logger := &recordLogger{}
+			gateway := newTestGateway(t, WithLogger(logger))
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "api", HTTPMethod: tt.method, Host: primary, Path: "api", MirrorHost: mirror}))
+			rec := serveProxy(gateway, httptest.NewRequest(tt.method, "/svc/api", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusOK || rec.Body.String() != "primary" {
+				t.Errorf("client got %d %q, want the primary response", rec.Code, rec.Body.String())
+			}
+			if body := <-primaryBodies; body != tt.body {
+				t.Errorf("primary got a %d bytes body, want %d", len(body), len(tt.body))
+			}
+			select {
+			case got := <-mirrorBodies:
+				if want := tt.method + " /api " + tt.body; !tt.mirrored || got != want {
+					t.Errorf("mirror got %.64q, want mirrored %v %.64q", got, tt.mirrored, want)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.mirrored {
+					t.Errorf("mirror did not receive the request")
+				}
+			}
+			if tt.mirrorDown {
+				deadline := time.Now().Add(5 * time.Second)
+				for {
+					if _, ok := logger.find(LevelWarn, "mirror to "+mirror+" failed"); ok {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Fatalf("mirror failure not logged: %q", logger.lines)
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}
+		})
+	}
+}
+
+func TestMirrorRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		api  *API
+	}{
+		{name: "invalid host", api: &API{Name: "api", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "api", MirrorHost: "http://mirror/"}},
+		{name: "streaming api", api: &API{Name: "api", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "api", MirrorHost: "127.0.0.1:2", Streaming: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			if err := gateway.Discovery.CreateService(newTestService("svc", tt.api)); err == nil {
+				t.Errorf("api accepted")
+			}
+		})
+	}
+}
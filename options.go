@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// gatewayMethods are the methods the gateway proxies, advertised on OPTIONS * and OPTIONS /
+const gatewayMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// answerOptions answer OPTIONS probes at the gateway when AnswerOptions is enabled, OPTIONS *
+// and OPTIONS / get the gateway methods and resolved routes the method of their api, return
+// whether the request is answered. net/http answers OPTIONS * before the handler when
+// serving directly, so it only reaches here when the gateway is embedded behind other code
+func (gateway *APIGateway) answerOptions(w http.ResponseWriter, r *http.Request) bool {
+	if !gateway.AnswerOptions || r.Method != http.MethodOptions {
+		return false
+	}
+	if r.RequestURI == "*" || r.URL.Path == "*" || r.URL.Path == "/" {
+		w.Header().Set("Allow", gatewayMethods)
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+	rt, err := gateway.lookup(r.URL.Path)
+	if err != nil {
+		// unknown routes still get 404
+		return false
+	}
+	if rt.api.CORS != nil && isPreflight(r) {
+		// preflights need the CORS headers of the api
+		return false
+	}
+	allow := gatewayMethods
+	if len(rt.api.methods) > 0 {
+		allow = strings.Join(rt.api.methods, ", ") + ", OPTIONS"
+	}
+	w.Header().Set("Allow", allow)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// acceptedMethods return HTTPMethod and HTTPMethods of api upper-cased without duplicates
+func acceptedMethods(api *API) []string {
+	var methods []string
+	for _, method := range append([]string{api.HTTPMethod}, api.HTTPMethods...) {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			continue
+		}
+		duplicated := false
+		for _, m := range methods {
+			duplicated = duplicated || m == method
+		}
+		if !duplicated {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// checkMethod reject requests whose method is not one of the methods of their api with 405,
+// the comparison ignore case and an api without methods accept any, OPTIONS not answered by
+// the gateway is still forwarded, return false when the response has been written
+func (gateway *APIGateway) checkMethod(w http.ResponseWriter, r *http.Request, api *API) bool {
+	if len(api.methods) == 0 || r.Method == http.MethodOptions {
+		return true
+	}
+	for _, method := range api.methods {
+		if strings.EqualFold(r.Method, method) {
+			return true
+		}
+	}
+	expected := strings.Join(api.methods, ", ")
+	w.Header().Set("Allow", expected)
+	gateway.writeError(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("method: %v not allowed", r.Method),
+		fmt.Sprintf("api: %v expect method: %v", api.Name, expected))
+	return false
+}
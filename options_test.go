@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAnswerOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled bool
+		target   string
+		origin   string // sent with Access-Control-Request-Method to make a preflight
+		status   int
+		allow    string
+		proxied  bool
+	}{
+		{name: "asterisk", target: "*", status: http.StatusNoContent, allow: gatewayMethods},
+		{name: "root", target: "/", status: http.StatusNoContent, allow: gatewayMethods},
+		{name: "api", target: "/svc/get", status: http.StatusNoContent, allow: "GET, OPTIONS"},
+		{name: "api with several methods", target: "/svc/write", status: http.StatusNoContent, allow: "POST, PUT, OPTIONS"},
+		{name: "api accepting any method", target: "/svc/any", status: http.StatusNoContent, allow: gatewayMethods},
+		{name: "unknown route", target: "/svc/nope", status: http.StatusNotFound},
+		{name: "cors preflight left to cors", target: "/svc/cors", origin: "https://app.example.com", status: http.StatusNoContent},
+		{name: "plain options on cors api", target: "/svc/cors", status: http.StatusNoContent, allow: "GET, OPTIONS"},
+		{name: "disabled asterisk", disabled: true, target: "*", status: http.StatusNotFound},
+		{name: "disabled api proxied", disabled: true, target: "/svc/any", status: http.StatusOK, proxied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+			})
+			gateway := newTestGateway(t)
+			gateway.AnswerOptions = !tt.disabled
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"},
+				&API{Name: "write", HTTPMethod: "post", HTTPMethods: []string{"PUT", "POST"}, Host: backend, Path: "write"},
+				&API{Name: "any", Host: backend, Path: "any"},
+				&API{Name: "cors", HTTPMethod: http.MethodGet, Host: backend, Path: "cors",
+					CORS: &CORS{AllowedOrigins: []string{"https://app.example.com"}}}))
+			req := httptest.NewRequest(http.MethodOptions, tt.target, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow %q, want %q", got, tt.allow)
+			}
+			if tt.origin != "" && rec.Header().Get("Access-Control-Allow-Origin") != tt.origin {
+				t.Errorf("preflight answered without cors headers: %v", rec.Header())
+			}
+			if proxied := atomic.LoadInt32(&hits) > 0; proxied != tt.proxied {
+				t.Errorf("proxied %v, want %v", proxied, tt.proxied)
+			}
+		})
+	}
+}
+
+func TestMethodMismatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered string
+		method     string
+		status     int
+	}{
+		{name: "matching", registered: http.MethodPost, method: http.MethodPost, status: http.StatusOK},
+		{name: "mismatched", registered: http.MethodPost, method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{name: "registered lower case", registered: "post", method: http.MethodPost, status: http.StatusOK},
+		{name: "requested lower case", registered: http.MethodPost, method: "post", status: http.StatusOK},
+		{name: "lower case mismatched", registered: "post", method: "get", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "create", HTTPMethod: tt.registered, Host: backend, Path: "create"}))
+			rec := serveProxy(gateway, httptest.NewRequest(tt.method, "/svc/create", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				if atomic.LoadInt32(&hits) != 1 {
+					t.Errorf("backend hit %d times, want 1", hits)
+				}
+				return
+			}
+			if atomic.LoadInt32(&hits) != 0 {
+				t.Errorf("mismatched method reached the backend")
+			}
+			if allow := rec.Header().Get("Allow"); allow != http.MethodPost {
+				t.Errorf("Allow %q, want %q", allow, http.MethodPost)
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("error body %q: %v", rec.Body.String(), err)
+			}
+			if !strings.Contains(strings.Join(resp.Details, "\n"), http.MethodPost) {
+				t.Errorf("details %q do not name the expected method", resp.Details)
+			}
+		})
+	}
+}
+
+func TestMultipleMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		methods []string
+		request string
+		status  int
+		allow   string
+	}{
+		{name: "get allowed", methods: []string{"GET", "POST"}, request: http.MethodGet, status: http.StatusOK},
+		{name: "post allowed", methods: []string{"GET", "POST"}, request: http.MethodPost, status: http.StatusOK},
+		{name: "delete rejected", methods: []string{"GET", "POST"}, request: http.MethodDelete, status: http.StatusMethodNotAllowed, allow: "GET, POST"},
+		{name: "single field merged", method: "put", methods: []string{"get"}, request: http.MethodPut, status: http.StatusOK},
+		{name: "merged allow", method: "PUT", methods: []string{"GET", "put"}, request: http.MethodPatch, status: http.StatusMethodNotAllowed, allow: "PUT, GET"},
+		{name: "any method", request: http.MethodDelete, status: http.StatusOK},
+		{name: "any custom method", request: "PROPFIND", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				received = r.Method
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "items", HTTPMethod: tt.method, HTTPMethods: tt.methods, Host: backend, Path: "items"}))
+			rec := serveProxy(gateway, httptest.NewRequest(tt.request, "/svc/items", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.allow {
+				t.Errorf("Allow %q, want %q", allow, tt.allow)
+			}
+			if tt.status == http.StatusOK && received != tt.request {
+				t.Errorf("backend got %q, want %q", received, tt.request)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Names of the stages run on a request after it is resolved to an api
+const (
+	StageIPFilter    = "ipFilter"    // client ip allow and deny lists of apis, 403 when not allowed
+	StageCORS        = "cors"        // cors headers of apis with cors, preflights are answered here
+	StageAuth        = "auth"        // jwt or api key authentication of apis with auth, 401 when rejected
+	StageRateLimit   = "rateLimit"   // per-api token bucket, 429 when exceeded
+	StageValidate    = "validate"    // request body JSON Schema validation, 400 on mismatch
+	StageConcurrency = "concurrency" // gateway and per-api in-flight limits, 503 when full
+)
+
+// DefaultPipeline reject denied client ips first, answer cors preflights next as they carry
+// no credentials, authenticate
+// requests next so anonymous clients can not use up the rate of an api, reject over-rate requests before reading their body, and validate bodies
+// before taking a concurrency slot so invalid requests never hold one
+var DefaultPipeline = []string{StageIPFilter, StageCORS, StageAuth, StageRateLimit, StageValidate, StageConcurrency}
+
+// requiredStages protect backends and can not be left out of the pipeline
+var requiredStages = []string{StageRateLimit, StageConcurrency}
+
+// neededStages report for the stages which may be left out whether api rely on them, a
+// pipeline leaving out a stage a registered api relies on is rejected, and requests to
+// apis registered later relying on it are refused
+var neededStages = map[string]func(api *API) bool{
+	StageIPFilter: func(api *API) bool { return len(api.allowNets) > 0 || len(api.denyNets) > 0 },
+	StageCORS:     func(api *API) bool { return api.CORS != nil },
+	StageAuth:     func(api *API) bool { return api.Auth != nil },
+	StageValidate: func(api *API) bool { return api.schema != nil },
+}
+
+// pipelineStage handle a request resolved to rt, return false when the request is
+// answered, done is called once the request is proxied and may be nil
+type pipelineStage func(gateway *APIGateway, w http.ResponseWriter, r *http.Request, rt *route) (ok bool, done func())
+
+// pipelineStages map stage name to its implementation
+var pipelineStages = map[string]pipelineStage{
+	StageIPFilter: func(gateway *APIGateway, w http.ResponseWriter, r *http.Request, rt *route) (bool, func()) {
+		return gateway.filterIP(w, r, rt.api), nil
+	},
+	StageCORS: func(gateway *APIGateway, w http.ResponseWriter, r *http.Request, rt *route) (bool, func()) {
+		return !gateway.handleCORS(w, r, rt.api), nil
+	},
+	StageAuth: func(gateway *APIGateway, w http.ResponseWriter, r *http.Request, rt *route) (bool, func()) {
+		return gateway.authenticate(w, r, rt.service, rt.api), nil
+	},
+	StageRateLimit: func(gateway *APIGateway, w http.ResponseWriter, r *http.Request, rt *route) (bool, func()) {
+		return gateway.allowRate(w, r, rt.api), nil
+	},
+	StageValidate: func(gateway *APIGateway, w http.ResponseWriter, r *http.Request, rt *route) (bool, func()) {
+		return rt.api.schema == nil || gateway.validateRequest(w, r, rt.api), nil
+	},
+	StageConcurrency: func(gateway *APIGateway, w http.ResponseWriter, r *http.Request, rt *route) (bool, func()) {
+		return gateway.acquireConcurrency(w, r, rt.api)
+	},
+}
+
+// SetPipeline configure the order of stages run on resolved requests, every stage may
+// appear once, rateLimit and concurrency can not be omitted and neither can the stages
+// registered apis rely on, such as ipFilter, cors, auth or validate for apis with ip
+// lists, cors, auth or a request schema
+func (gateway *APIGateway) SetPipeline(names []string) error {
+	stages := make([]pipelineStage, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		stage, exist := pipelineStages[name]
+		if !exist {
+			return fmt.Errorf("pipeline stage: %v unknown", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("pipeline stage: %v duplicated", name)
+		}
+		seen[name] = true
+		stages = append(stages, stage)
+	}
+	for _, name := range requiredStages {
+		if !seen[name] {
+			return fmt.Errorf("pipeline stage: %v required", name)
+		}
+	}
+	var omitted []string
+	for name := range neededStages {
+		if !seen[name] {
+			omitted = append(omitted, name)
+		}
+	}
+	sort.Strings(omitted)
+	if lister, ok := gateway.Discovery.(routeSnapshot); ok {
+		services, _ := lister.snapshot()
+		for _, service := range services {
+			for _, api := range service.APIs {
+				if name := missingStage(omitted, api); name != "" {
+					return fmt.Errorf("pipeline stage: %v required by api: %v/%v", name, service.Name, api.Name)
+				}
+			}
+		}
+	}
+	gateway.pipeline = stages
+	gateway.pipelineNames = append([]string(nil), names...)
+	gateway.pipelineOmitted = omitted
+	return nil
+}
+
+// missingStage return the first stage of omitted api rely on, empty when none
+func missingStage(omitted []string, api *API) string {
+	for _, name := range omitted {
+		if neededStages[name](api) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Pipeline return the names of stages run on resolved requests in order
+func (gateway *APIGateway) Pipeline() []string {
+	return append([]string(nil), gateway.pipelineNames...)
+}
+
+// runPipeline run the stages in order, return false when a stage answered the request,
+// the returned done must be called after proxying either way, requests to apis relying on
+// a stage left out get 500 rather than skipping it
+func (gateway *APIGateway) runPipeline(w http.ResponseWriter, r *http.Request, rt *route) (bool, func()) {
+	if name := missingStage(gateway.pipelineOmitted, rt.api); name != "" {
+		gateway.logger().Errorf("request: %v api: %v relies on pipeline stage: %v left out", requestID(r.Context()), rt.api.Name, name)
+		gateway.writeError(w, r, http.StatusInternalServerError, "api not served by the gateway pipeline")
+		return false, func() {}
+	}
+	var dones []func()
+	done := func() {
+		for i := len(dones) - 1; i >= 0; i-- {
+			dones[i]()
+		}
+	}
+	for _, stage := range gateway.pipeline {
+		ok, stageDone := stage(gateway, w, r, rt)
+		if stageDone != nil {
+			dones = append(dones, stageDone)
+		}
+		if !ok {
+			return false, done
+		}
+	}
+	return true, done
+}
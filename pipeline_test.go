@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPipelineOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []string
+		status int // answer to a request every stage would reject
+	}{
+		{name: "default", status: http.StatusTooManyRequests},
+		{name: "validate first", stages: []string{StageValidate, StageRateLimit, StageConcurrency}, status: http.StatusBadRequest},
+		{name: "concurrency first", stages: []string{StageConcurrency, StageValidate, StageRateLimit}, status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrived := make(chan struct{})
+			release := make(chan struct{})
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				close(arrived)
+				<-release
+			})
+			gateway := newTestGateway(t)
+			if tt.stages != nil {
+				if err := gateway.SetPipeline(tt.stages); err != nil {
+					t.Fatalf("set pipeline: %v", err)
+				}
+			}
+			mustCreateService(t, gateway, newTestService("user", &API{
+				Name: "create", HTTPMethod: http.MethodPost, Host: backend, Path: "users",
+				RequestSchema: json.RawMessage(testUserSchema),
+				RateLimit:     0.001, Burst: 1,
+				MaxConcurrent: 1,
+			}))
+			// the first request takes the only token and the only slot
+			held := make(chan int)
+			go func() {
+				rec := serveProxy(gateway, httptest.NewRequest(http.MethodPost, "/user/create", strings.NewReader(`{"name":"ann","age":1}`)))
+				held <- rec.Code
+			}()
+			<-arrived
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodPost, "/user/create", strings.NewReader(`{"name":1}`)))
+			close(release)
+			if code := <-held; code != http.StatusOK {
+				t.Fatalf("held request status %d", code)
+			}
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSetPipeline(t *testing.T) {
+	if got := newTestGateway(t).Pipeline(); !reflect.DeepEqual(got, DefaultPipeline) {
+		t.Errorf("default pipeline %v, want %v", got, DefaultPipeline)
+	}
+	tests := []struct {
+		name   string
+		stages []string
+		errSub string
+	}{
+		{name: "reordered", stages: []string{StageConcurrency, StageRateLimit, StageValidate}},
+		{name: "validate omitted without schema", stages: []string{StageRateLimit, StageConcurrency}},
+		{name: "unknown stage", stages: []string{StageRateLimit, "cache", StageConcurrency}, errSub: "unknown"},
+		{name: "duplicated stage", stages: []string{StageRateLimit, StageRateLimit, StageConcurrency}, errSub: "duplicated"},
+		{name: "rate limit omitted", stages: []string{StageValidate, StageConcurrency}, errSub: "required"},
+		{name: "concurrency omitted", stages: []string{StageRateLimit}, errSub: "required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.SetPipeline(tt.stages)
+			if tt.errSub == "" {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				if got := gateway.Pipeline(); !reflect.DeepEqual(got, tt.stages) {
+					t.Errorf("pipeline %v, want %v", got, tt.stages)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+				t.Errorf("error %v, want one mentioning %q", err, tt.errSub)
+			}
+			if got := gateway.Pipeline(); !reflect.DeepEqual(got, DefaultPipeline) {
+				t.Errorf("rejected pipeline changed the order to %v", got)
+			}
+		})
+	}
+}
+
+func TestPipelineNeededStage(t *testing.T) {
+	schemaAPI := func() *API {
+		return &API{Name: "create", HTTPMethod: http.MethodPost, Host: namedBackend(t, "created"), Path: "users", RequestSchema: json.RawMessage(testUserSchema)}
+	}
+	tests := []struct {
+		name   string
+		stages []string
+		before bool // the api is registered before the pipeline is set
+		errSub string
+		status int
+	}{
+		{name: "validate kept", stages: []string{StageRateLimit, StageConcurrency, StageValidate}, before: true, status: http.StatusBadRequest},
+		{name: "validate omitted with schema api", stages: []string{StageRateLimit, StageConcurrency}, before: true, errSub: "validate required by api: user/create", status: http.StatusBadRequest},
+		{name: "schema api registered after omitting validate", stages: []string{StageRateLimit, StageConcurrency}, status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			if tt.before {
+				mustCreateService(t, gateway, newTestService("user", schemaAPI()))
+			}
+			err := gateway.SetPipeline(tt.stages)
+			if tt.errSub == "" && err != nil {
+				t.Fatalf("set pipeline: %v", err)
+			}
+			if tt.errSub != "" && (err == nil || !strings.Contains(err.Error(), tt.errSub)) {
+				t.Fatalf("error %v, want one mentioning %q", err, tt.errSub)
+			}
+			if !tt.before {
+				mustCreateService(t, gateway, newTestService("user", schemaAPI()))
+			}
+			// an invalid body must never reach the backend unchecked
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodPost, "/user/create", strings.NewReader(`{"name":1}`)))
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthStage(t *testing.T) {
+	token := signJWT(t, "HS256", map[string]interface{}{"sub": "42"}, hs256(testJWTSecret))
+	tests := []struct {
+		name   string
+		stages []string
+		status int // of an authenticated request once an anonymous one was answered
+	}{
+		{name: "default authenticate first", status: http.StatusOK},
+		{name: "rate limit before auth", stages: []string{StageRateLimit, StageAuth, StageValidate, StageConcurrency}, status: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			if tt.stages != nil {
+				if err := gateway.SetPipeline(tt.stages); err != nil {
+					t.Fatalf("set pipeline: %v", err)
+				}
+			}
+			mustCreateService(t, gateway, newTestService("user", &API{
+				Name: "me", HTTPMethod: http.MethodGet, Host: namedBackend(t, "me"), Path: "me",
+				Auth:      &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret},
+				RateLimit: 0.001, Burst: 1,
+			}))
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/user/me", nil)); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("anonymous request status %d", rec.Code)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/user/me", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			if rec := serveProxy(gateway, req); rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("user", &API{
+		Name: "me", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "me",
+		Auth: &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret},
+	}))
+	if err := gateway.SetPipeline([]string{StageRateLimit, StageValidate, StageConcurrency}); err == nil || !strings.Contains(err.Error(), "auth required by api: user/me") {
+		t.Errorf("error %v, want auth required", err)
+	}
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket limit request rate, tokens refill at rate per second up to burst
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket create a full bucket, burst default to the rate rounded up
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	capacity := float64(burst)
+	if capacity <= 0 {
+		capacity = math.Max(1, math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: capacity, tokens: capacity, last: time.Now()}
+}
+
+// take try to take one token, return the remaining tokens and the wait until next token if rejected
+func (b *tokenBucket) take(now time.Time) (bool, int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// allowRate consult the api rate limiter, write 429 and return false when exceeded
+func (gateway *APIGateway) allowRate(w http.ResponseWriter, r *http.Request, api *API) bool {
+	if api.limiter == nil {
+		return true
+	}
+	ok, remaining, wait := api.limiter.take(time.Now())
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(api.limiter.burst)))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if ok {
+		return true
+	}
+	gateway.throttle(w, r, http.StatusTooManyRequests, "rate limit exceeded", wait)
+	return false
+}
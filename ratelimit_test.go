@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurstThenSustained(t *testing.T) {
+	type step struct {
+		at       time.Duration // since the bucket was created
+		takes    int
+		allowed  int
+		wantWait time.Duration // wait reported by the first rejection, zero skip the check
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{
+			name: "burst then sustained", rate: 10, burst: 5,
+			steps: []step{
+				{at: 0, takes: 6, allowed: 5, wantWait: 100 * time.Millisecond},
+				{at: 100 * time.Millisecond, takes: 2, allowed: 1},
+				{at: 300 * time.Millisecond, takes: 3, allowed: 2},
+				// idle time refill no more than the burst
+				{at: 10 * time.Second, takes: 10, allowed: 5},
+			},
+		},
+		{
+			name: "default burst", rate: 2.5,
+			steps: []step{
+				{at: 0, takes: 4, allowed: 3, wantWait: 400 * time.Millisecond},
+				{at: time.Second, takes: 3, allowed: 2},
+			},
+		},
+		{
+			name: "slow rate", rate: 0.5, burst: 1,
+			steps: []step{
+				{at: 0, takes: 2, allowed: 1, wantWait: 2 * time.Second},
+				{at: time.Second, takes: 1, allowed: 0},
+				{at: 2 * time.Second, takes: 1, allowed: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := newTokenBucket(tt.rate, tt.burst)
+			start := bucket.last
+			for _, s := range tt.steps {
+				allowed := 0
+				var wait time.Duration
+				for i := 0; i < s.takes; i++ {
+					ok, _, w := bucket.take(start.Add(s.at))
+					if ok {
+						allowed++
+					} else if wait == 0 {
+						wait = w
+					}
+				}
+				if allowed != s.allowed {
+					t.Errorf("at %v allowed %d of %d, want %d", s.at, allowed, s.takes, s.allowed)
+				}
+				if s.wantWait > 0 && (wait < s.wantWait-time.Millisecond || wait > s.wantWait+time.Millisecond) {
+					t.Errorf("at %v wait %v, want %v", s.at, wait, s.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitPerAPI(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		requests int
+		allowed  int
+	}{
+		{name: "limited api", target: "/svc/limited", requests: 10, allowed: 3},
+		{name: "unlimited api", target: "/svc/open", requests: 10, allowed: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := namedBackend(t, "ok")
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "limited", HTTPMethod: http.MethodGet, Host: backend, Path: "limited", RateLimit: 1, Burst: 3},
+				&API{Name: "open", HTTPMethod: http.MethodGet, Host: backend, Path: "open"}))
+			// the other api spend its own tokens, not the ones of tt.target
+			for i := 0; i < 5; i++ {
+				serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/open", nil))
+			}
+			allowed := 0
+			for i := 0; i < tt.requests; i++ {
+				rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.target, nil))
+				switch rec.Code {
+				case http.StatusOK:
+					allowed++
+				case http.StatusTooManyRequests:
+					if seconds, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || seconds < 1 {
+						t.Errorf("Retry-After %q, want whole seconds", rec.Header().Get("Retry-After"))
+					}
+				default:
+					t.Fatalf("status %d", rec.Code)
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d, want %d", allowed, tt.requests, tt.allowed)
+			}
+		})
+	}
+}
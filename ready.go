@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readyTimeout bound the discovery check of Readyz
+const readyTimeout = 2 * time.Second
+
+// Pinger is implemented by discoveries backed by a remote registry, Readyz report the
+// gateway unready while Ping fail
+type Pinger interface {
+	// Ping check the registry is reachable
+	Ping(ctx context.Context) error
+}
+
+// SetReady mark the gateway ready to take traffic once its config is loaded, it is only
+// reported ready once RunServer and RunProxy have bound their listeners too, Shutdown
+// mark it unready again
+func (gateway *APIGateway) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&gateway.ready, v)
+}
+
+// Ready report whether SetReady(true) was called, both listeners are bound and the
+// gateway is not shutting down
+func (gateway *APIGateway) Ready() bool {
+	return atomic.LoadInt32(&gateway.ready) == 1 && gateway.ServerAddr() != nil && gateway.ProxyAddr() != nil
+}
+
+// Healthz handle liveness probes, it answers 200 as long as the server is up
+func (gateway *APIGateway) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, adminResult{Result: "ok"})
+}
+
+// Readyz handle readiness probes, it answers 503 until the gateway is marked ready and
+// listening, and while its discovery is unreachable
+func (gateway *APIGateway) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !gateway.Ready() {
+		writeAdminError(w, http.StatusServiceUnavailable, "not ready")
+		return
+	}
+	if pinger, ok := gateway.Discovery.(Pinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := pinger.Ping(ctx); err != nil {
+			writeAdminError(w, http.StatusServiceUnavailable, fmt.Sprintf("discovery unreachable: %v", err))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, adminResult{Result: "ok"})
+}
+
+// Ping implements Pinger
+func (d *RedisDiscovery) Ping(ctx context.Context) error {
+	_, err := d.client.Do(ctx, "PING")
+	return err
+}
+
+// Ping implements Pinger
+func (d *ConsulDiscovery) Ping(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, d.address+"/v1/status/leader", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if d.token != "" {
+		req.Header.Set("X-Consul-Token", d.token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul query failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul query failed: %v", resp.Status)
+	}
+	return nil
+}
+
+// Ping implements Pinger
+func (d *KubeDiscovery) Ping(ctx context.Context) error {
+	resp, err := d.get(ctx, "?limit=1")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
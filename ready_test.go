@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestProbes(t *testing.T) {
+	client := newFakeRedis()
+	gateway := newTestGateway(t, WithDiscovery(newTestRedisDiscovery(t, client, DefaultRedisPrefix)))
+	gateway.ServerListenAddr = "127.0.0.1:0"
+	gateway.ProxyListenAddr = "127.0.0.1:0"
+	listen := func() {
+		go gateway.RunServer()
+		go gateway.RunProxy()
+		waitAddr(t, gateway.ServerAddr)
+		waitAddr(t, gateway.ProxyAddr)
+	}
+	// steps run in order on the same gateway
+	tests := []struct {
+		name    string
+		step    func()
+		healthz int
+		readyz  int
+	}{
+		{name: "before ready", step: func() {}, healthz: http.StatusOK, readyz: http.StatusServiceUnavailable},
+		{name: "ready before listening", step: func() { gateway.SetReady(true) }, healthz: http.StatusOK, readyz: http.StatusServiceUnavailable},
+		{name: "listening", step: listen, healthz: http.StatusOK, readyz: http.StatusOK},
+		{name: "discovery unreachable", step: func() { client.err = errors.New("connection refused") }, healthz: http.StatusOK, readyz: http.StatusServiceUnavailable},
+		{name: "discovery back", step: func() { client.err = nil }, healthz: http.StatusOK, readyz: http.StatusOK},
+		{name: "marked unready", step: func() { gateway.SetReady(false) }, healthz: http.StatusOK, readyz: http.StatusServiceUnavailable},
+		{
+			name: "shutting down",
+			step: func() {
+				gateway.SetReady(true)
+				gateway.Shutdown(context.Background())
+			},
+			healthz: http.StatusOK,
+			readyz:  http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.step()
+			if rec := serveAdmin(gateway, http.MethodGet, "/healthz", ""); rec.Code != tt.healthz {
+				t.Errorf("healthz %d, want %d", rec.Code, tt.healthz)
+			}
+			rec := serveAdmin(gateway, http.MethodGet, "/readyz", "")
+			if rec.Code != tt.readyz {
+				t.Errorf("readyz %d, want %d: %s", rec.Code, tt.readyz, rec.Body.String())
+			}
+			var result adminResult
+			mustDecode(t, rec.Body.Bytes(), &result)
+			if (rec.Code == http.StatusOK) != (result.Result == "ok" && result.Error == "") {
+				t.Errorf("readyz body %+v for status %d", result, rec.Code)
+			}
+		})
+	}
+}
+
+func TestConsulPing(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "leader elected", status: http.StatusOK},
+		{name: "no leader", status: http.StatusInternalServerError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/status/leader" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(tt.status)
+			})
+			d, err := NewConsulDiscovery(server, "")
+			if err != nil {
+				t.Fatalf("consul discovery: %v", err)
+			}
+			if err := d.Ping(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("ping error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,491 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRedisPrefix namespace the keys of the registry stored in redis
+const DefaultRedisPrefix = "go-gateway:"
+
+// DefaultRedisInterval is how often RedisDiscovery pick up changes made by other gateways
+const DefaultRedisInterval = 2 * time.Second
+
+// redisTimeout bound one read or write of the registry
+const redisTimeout = 5 * time.Second
+
+// redisMaxAttempts bound the retries of a write losing the race to other gateways
+const redisMaxAttempts = 10
+
+// redisConflictBackoff is the base of the jittered backoff before retrying a lost write
+const redisConflictBackoff = 5 * time.Millisecond
+
+// errRegistryUnavailable is wrapped by writes failing to reach redis
+var errRegistryUnavailable = errors.New("registry unavailable")
+
+// redisCommitScript apply the write commands in ARGV[2:] (each prefixed by its argument
+// count) only when the registry version KEYS[1] is still ARGV[1], return the new version
+// or false when another gateway wrote first
+const redisCommitScript = `
+if (redis.call('GET', KEYS[1]) or '0') ~= ARGV[1] then
+	return false
+end
+local i = 2
+while i <= #ARGV do
+	local n = tonumber(ARGV[i])
+	redis.call(unpack(ARGV, i + 1, i + n))
+	i = i + n + 1
+end
+return redis.call('INCR', KEYS[1])
+`
+
+// RedisDiscovery is a Discovery storing the registry in redis so that it survives restarts
+// and is shared by every gateway using the same prefix, each service is a json value
+// under {prefix}service:{name}. Requests are served from a local mirror refreshed by
+// Watch, writes re-validate against the registry in redis and commit only if no other
+// gateway wrote in between, retrying otherwise
+type RedisDiscovery struct {
+	*cache
+	client  RedisClient
+	prefix  string
+	opts    []CacheOption
+	writeMu sync.Mutex        // serialize writes of this gateway
+	raw     map[string]string // stored json of mirrored services, guarded by cache.mu
+	version string            // registry version mirrored, guarded by cache.mu
+}
+
+// redisRegistry is the registry read from redis
+type redisRegistry struct {
+	version  string
+	services map[string]string            // service name to stored json
+	aliases  map[string]string            // alias to target
+	apiKeys  map[string]map[string]string // service name to api key digest to key name
+}
+
+// NewRedisDiscovery create discovery storing the registry in redis under keys starting
+// with prefix, the registry is loaded once before returning
+func NewRedisDiscovery(client RedisClient, prefix string, opts ...CacheOption) (*RedisDiscovery, error) {
+	d := &RedisDiscovery{
+		cache:  NewCacheDiscovery(opts...).(*cache),
+		client: client,
+		prefix: prefix,
+		opts:   opts,
+		raw:    make(map[string]string),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := d.Sync(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Watch sync the mirror every interval until ctx is done, failures are logged and the
+// mirror is kept
+func (d *RedisDiscovery) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRedisInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		syncCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+		if err := d.Sync(syncCtx); err != nil && ctx.Err() == nil {
+			d.logger.Errorf("redis registry sync failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// Sync load the registry when its version changed, services whose stored json did not
+// change keep their state such as rate limiters and circuit breakers
+func (d *RedisDiscovery) Sync(ctx context.Context) error {
+	version, err := d.loadVersion(ctx)
+	if err != nil {
+		return err
+	}
+	d.mu.RLock()
+	unchanged := version == d.version
+	d.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	reg, err := d.load(ctx)
+	if err != nil {
+		return err
+	}
+	fresh, _ := d.build(reg, false)
+	d.install(reg, fresh)
+	return nil
+}
+
+// CreateService implements Discovery
+func (d *RedisDiscovery) CreateService(service *Service) error {
+	return d.update(func(c *cache) error { return c.CreateService(service) })
+}
+
+// CreateAPI implements Discovery, concurrent creations on the same service never lose apis
+func (d *RedisDiscovery) CreateAPI(api *API) error {
+	return d.update(func(c *cache) error { return c.CreateAPI(api) })
+}
+
+// CreateAlias implements Discovery
+func (d *RedisDiscovery) CreateAlias(alias, target string) error {
+	return d.update(func(c *cache) error { return c.CreateAlias(alias, target) })
+}
+
+// DeleteService implements Discovery
+func (d *RedisDiscovery) DeleteService(serviceName string) error {
+	return d.update(func(c *cache) error { return c.DeleteService(serviceName) })
+}
+
+// UpdateAPI implements Discovery
+func (d *RedisDiscovery) UpdateAPI(api *API) error {
+	return d.update(func(c *cache) error { return c.UpdateAPI(api) })
+}
+
+// DeleteAPI implements Discovery
+func (d *RedisDiscovery) DeleteAPI(serviceName, apiName string) error {
+	return d.update(func(c *cache) error { return c.DeleteAPI(serviceName, apiName) })
+}
+
+// CreateAPIKey implements Discovery
+func (d *RedisDiscovery) CreateAPIKey(key *APIKey) error {
+	return d.update(func(c *cache) error { return c.CreateAPIKey(key) })
+}
+
+// DeleteAPIKey implements Discovery
+func (d *RedisDiscovery) DeleteAPIKey(serviceName, key string) error {
+	return d.update(func(c *cache) error { return c.DeleteAPIKey(serviceName, key) })
+}
+
+// update apply op to the registry read from redis and commit the changes it made, op runs
+// again on the newer registry when another gateway committed first
+func (d *RedisDiscovery) update(op func(c *cache) error) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	for attempt := 0; attempt < redisMaxAttempts; attempt++ {
+		if attempt > 0 && !sleepBackoff(ctx, redisBackoff(attempt)) {
+			break
+		}
+		reg, err := d.load(ctx)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errRegistryUnavailable, err)
+		}
+		scratch, err := d.build(reg, true)
+		if err != nil {
+			return err
+		}
+		if err := op(scratch); err != nil {
+			return err
+		}
+		next, commands, err := d.diff(reg, scratch)
+		if err != nil {
+			return err
+		}
+		if len(commands) == 0 {
+			d.install(reg, scratch)
+			return nil
+		}
+		version, committed, err := d.commit(ctx, reg.version, commands)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errRegistryUnavailable, err)
+		}
+		if !committed {
+			continue
+		}
+		next.version = version
+		d.install(next, scratch)
+		return nil
+	}
+	return fmt.Errorf("redis registry: write lost to other gateways %d times", redisMaxAttempts)
+}
+
+// redisBackoff return the full jitter backoff before the attempt-th write attempt
+func redisBackoff(attempt int) time.Duration {
+	backoff := redisConflictBackoff << uint(attempt)
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+func (d *RedisDiscovery) versionKey() string            { return d.prefix + "version" }
+func (d *RedisDiscovery) servicesKey() string           { return d.prefix + "services" }
+func (d *RedisDiscovery) serviceKey(name string) string { return d.prefix + "service:" + name }
+func (d *RedisDiscovery) aliasesKey() string            { return d.prefix + "aliases" }
+func (d *RedisDiscovery) apiKeysKey(name string) string { return d.prefix + "apikeys:" + name }
+
+// loadVersion read the registry version, 0 before the first write
+func (d *RedisDiscovery) loadVersion(ctx context.Context) (string, error) {
+	reply, err := d.client.Do(ctx, "GET", d.versionKey())
+	if err != nil {
+		return "", fmt.Errorf("redis registry: read version failed: %v", err)
+	}
+	if reply == nil {
+		return "0", nil
+	}
+	return redisString(reply), nil
+}
+
+// load read the registry, the version is read first so the data is at least that recent
+func (d *RedisDiscovery) load(ctx context.Context) (*redisRegistry, error) {
+	version, err := d.loadVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reg := &redisRegistry{
+		version:  version,
+		services: make(map[string]string),
+		aliases:  make(map[string]string),
+		apiKeys:  make(map[string]map[string]string),
+	}
+	reply, err := d.client.Do(ctx, "SMEMBERS", d.servicesKey())
+	if err != nil {
+		return nil, fmt.Errorf("redis registry: read services failed: %v", err)
+	}
+	names := redisStrings(reply)
+	if len(names) > 0 {
+		keys := make([]string, 0, len(names)+1)
+		keys = append(keys, "MGET")
+		for _, name := range names {
+			keys = append(keys, d.serviceKey(name))
+		}
+		reply, err := d.client.Do(ctx, keys...)
+		if err != nil {
+			return nil, fmt.Errorf("redis registry: read services failed: %v", err)
+		}
+		values, _ := reply.([]interface{})
+		for i, name := range names {
+			// a service deleted after SMEMBERS is gone
+			if i < len(values) && values[i] != nil {
+				reg.services[name] = redisString(values[i])
+			}
+		}
+	}
+	if reply, err = d.client.Do(ctx, "HGETALL", d.aliasesKey()); err != nil {
+		return nil, fmt.Errorf("redis registry: read aliases failed: %v", err)
+	}
+	reg.aliases = redisHash(reply)
+	for name := range reg.services {
+		if reply, err = d.client.Do(ctx, "HGETALL", d.apiKeysKey(name)); err != nil {
+			return nil, fmt.Errorf("redis registry: read api keys of service: %v failed: %v", name, err)
+		}
+		if keys := redisHash(reply); len(keys) > 0 {
+			reg.apiKeys[name] = keys
+		}
+	}
+	return reg, nil
+}
+
+// build decode reg into a standalone cache, strict fail on the first invalid service
+// otherwise invalid services are logged and left out
+func (d *RedisDiscovery) build(reg *redisRegistry, strict bool) (*cache, error) {
+	c := NewCacheDiscovery(d.opts...).(*cache)
+	for name, raw := range reg.services {
+		var service Service
+		err := json.Unmarshal([]byte(raw), &service)
+		if err == nil {
+			err = c.CreateService(&service)
+		}
+		if err != nil {
+			err = fmt.Errorf("redis registry: service: %v invalid: %v", name, err)
+			if strict {
+				return nil, err
+			}
+			d.logger.Warnf("%v", err)
+		}
+	}
+	for alias, target := range reg.aliases {
+		c.aliases[alias] = target
+	}
+	for name, keys := range reg.apiKeys {
+		c.apiKeys[name] = make(map[string]string, len(keys))
+		for digest, keyName := range keys {
+			c.apiKeys[name][digest] = keyName
+		}
+	}
+	return c, nil
+}
+
+// diff return the registry of scratch with the redis commands turning reg into it
+func (d *RedisDiscovery) diff(reg *redisRegistry, scratch *cache) (*redisRegistry, [][]string, error) {
+	next := &redisRegistry{
+		services: make(map[string]string, len(scratch.store)),
+		aliases:  scratch.aliases,
+		apiKeys:  scratch.apiKeys,
+	}
+	var commands [][]string
+	for name, service := range scratch.store {
+		data, err := encodeService(service)
+		if err != nil {
+			return nil, nil, fmt.Errorf("redis registry: encode service: %v failed: %v", name, err)
+		}
+		next.services[name] = data
+		if reg.services[name] != data {
+			commands = append(commands, []string{"SET", d.serviceKey(name), data}, []string{"SADD", d.servicesKey(), name})
+		}
+	}
+	for name := range reg.services {
+		if _, exist := scratch.store[name]; !exist {
+			commands = append(commands, []string{"DEL", d.serviceKey(name)}, []string{"SREM", d.servicesKey(), name})
+		}
+	}
+	if !equalStringMaps(reg.aliases, scratch.aliases) {
+		commands = append(commands, hashCommands(d.aliasesKey(), scratch.aliases)...)
+	}
+	names := make(map[string]bool)
+	for name := range reg.apiKeys {
+		names[name] = true
+	}
+	for name := range scratch.apiKeys {
+		names[name] = true
+	}
+	for name := range names {
+		if !equalStringMaps(reg.apiKeys[name], scratch.apiKeys[name]) {
+			commands = append(commands, hashCommands(d.apiKeysKey(name), scratch.apiKeys[name])...)
+		}
+	}
+	return next, commands, nil
+}
+
+// commit run commands atomically if the registry is still at version, return the new version
+func (d *RedisDiscovery) commit(ctx context.Context, version string, commands [][]string) (string, bool, error) {
+	args := []string{"EVAL", redisCommitScript, "1", d.versionKey(), version}
+	for _, command := range commands {
+		args = append(args, strconv.Itoa(len(command)))
+		args = append(args, command...)
+	}
+	reply, err := d.client.Do(ctx, args...)
+	if err != nil {
+		return "", false, fmt.Errorf("redis registry: commit failed: %v", err)
+	}
+	if reply == nil {
+		return "", false, nil
+	}
+	return redisString(reply), true, nil
+}
+
+// install replace the mirror with reg, services keep their mirrored object when their
+// stored json is unchanged, otherwise the object decoded in fresh is used
+func (d *RedisDiscovery) install(reg *redisRegistry, fresh *cache) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	store := make(map[string]*Service, len(reg.services))
+	raw := make(map[string]string, len(reg.services))
+	for name, data := range reg.services {
+		if old, exist := d.store[name]; exist && d.raw[name] == data {
+			store[name], raw[name] = old, data
+			continue
+		}
+		if service, exist := fresh.store[name]; exist {
+			store[name], raw[name] = service, data
+			continue
+		}
+		// invalid in redis, keep serving the last valid definition
+		if old, exist := d.store[name]; exist {
+			store[name], raw[name] = old, d.raw[name]
+		}
+	}
+	d.store, d.raw = store, raw
+	d.aliases, d.apiKeys = fresh.aliases, fresh.apiKeys
+	d.version = reg.version
+}
+
+// storedAuth is Auth without its redacting MarshalJSON, the secret must be stored
+type storedAuth Auth
+
+// storedAPI encode the auth secret of api in clear
+type storedAPI struct {
+	*API
+	Auth *storedAuth `json:"auth,omitempty"`
+}
+
+// storedService encode service with the auth secrets of its apis
+type storedService struct {
+	*Service
+	APIs map[string]*storedAPI `json:"apis"`
+}
+
+// encodeService return the json stored for service
+func encodeService(service *Service) (string, error) {
+	stored := storedService{Service: service, APIs: make(map[string]*storedAPI, len(service.APIs))}
+	for name, api := range service.APIs {
+		stored.APIs[name] = &storedAPI{API: api, Auth: (*storedAuth)(api.Auth)}
+	}
+	data, err := json.Marshal(stored)
+	return string(data), err
+}
+
+// encodeAPI return the json of api with its auth secret, as stored within its service
+func encodeAPI(api *API) (string, error) {
+	data, err := json.Marshal(storedAPI{API: api, Auth: (*storedAuth)(api.Auth)})
+	return string(data), err
+}
+
+// hashCommands return the commands replacing the redis hash key with values
+func hashCommands(key string, values map[string]string) [][]string {
+	commands := [][]string{{"DEL", key}}
+	if len(values) == 0 {
+		return commands
+	}
+	hset := []string{"HSET", key}
+	for field, value := range values {
+		hset = append(hset, field, value)
+	}
+	return append(commands, hset)
+}
+
+// equalStringMaps report whether a and b hold the same entries, nil equal empty
+func equalStringMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, exist := b[k]; !exist || other != v {
+			return false
+		}
+	}
+	return true
+}
+
+// redisString format a string or integer reply
+func redisString(reply interface{}) string {
+	switch reply := reply.(type) {
+	case string:
+		return reply
+	case int64:
+		return strconv.FormatInt(reply, 10)
+	}
+	return ""
+}
+
+// redisStrings return the items of an array reply
+func redisStrings(reply interface{}) []string {
+	items, _ := reply.([]interface{})
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		values = append(values, redisString(item))
+	}
+	return values
+}
+
+// redisHash return the field values of a HGETALL reply
+func redisHash(reply interface{}) map[string]string {
+	items := redisStrings(reply)
+	hash := make(map[string]string, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		hash[items[i]] = items[i+1]
+	}
+	return hash
+}
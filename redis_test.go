@@ -0,0 +1,319 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is an in-memory RedisClient implementing the commands used by RedisDiscovery,
+// EVAL runs the commit script atomically
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+	hashes  map[string]map[string]string
+	evals   int   // commits attempted
+	err     error // returned by every command when set
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		strings: make(map[string]string),
+		sets:    make(map[string]map[string]bool),
+		hashes:  make(map[string]map[string]string),
+	}
+}
+
+func (r *fakeRedis) Do(ctx context.Context, args ...string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if args[0] == "EVAL" {
+		r.evals++
+		// EVAL script 1 versionKey version {n command...}...
+		key, version := args[3], args[4]
+		current, exist := r.strings[key]
+		if !exist {
+			current = "0"
+		}
+		if current != version {
+			return nil, nil
+		}
+		for i := 5; i < len(args); {
+			n, _ := strconv.Atoi(args[i])
+			if _, err := r.run(args[i+1 : i+1+n]); err != nil {
+				return nil, err
+			}
+			i += n + 1
+		}
+		return r.run([]string{"INCR", key})
+	}
+	return r.run(args)
+}
+
+func (r *fakeRedis) run(args []string) (interface{}, error) {
+	switch args[0] {
+	case "PING":
+		return "PONG", nil
+	case "GET":
+		if value, exist := r.strings[args[1]]; exist {
+			return value, nil
+		}
+		return nil, nil
+	case "SET":
+		r.strings[args[1]] = args[2]
+		return "OK", nil
+	case "INCR":
+		n, _ := strconv.ParseInt(r.strings[args[1]], 10, 64)
+		n++
+		r.strings[args[1]] = strconv.FormatInt(n, 10)
+		return n, nil
+	case "MGET":
+		values := make([]interface{}, 0, len(args)-1)
+		for _, key := range args[1:] {
+			if value, exist := r.strings[key]; exist {
+				values = append(values, value)
+			} else {
+				values = append(values, nil)
+			}
+		}
+		return values, nil
+	case "DEL":
+		for _, key := range args[1:] {
+			delete(r.strings, key)
+			delete(r.sets, key)
+			delete(r.hashes, key)
+		}
+		return int64(len(args) - 1), nil
+	case "SADD":
+		if r.sets[args[1]] == nil {
+			r.sets[args[1]] = make(map[string]bool)
+		}
+		for _, member := range args[2:] {
+			r.sets[args[1]][member] = true
+		}
+		return int64(len(args) - 2), nil
+	case "SREM":
+		for _, member := range args[2:] {
+			delete(r.sets[args[1]], member)
+		}
+		return int64(len(args) - 2), nil
+	case "SMEMBERS":
+		members := make([]string, 0, len(r.sets[args[1]]))
+		for member := range r.sets[args[1]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		values := make([]interface{}, len(members))
+		for i, member := range members {
+			values[i] = member
+		}
+		return values, nil
+	case "HSET":
+		if r.hashes[args[1]] == nil {
+			r.hashes[args[1]] = make(map[string]string)
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			r.hashes[args[1]][args[i]] = args[i+1]
+		}
+		return int64((len(args) - 2) / 2), nil
+	case "HGETALL":
+		values := []interface{}{}
+		for field, value := range r.hashes[args[1]] {
+			values = append(values, field, value)
+		}
+		return values, nil
+	}
+	return nil, RedisError("ERR unknown command " + args[0])
+}
+
+// newTestRedisDiscovery return a discovery of client under prefix logging nowhere
+func newTestRedisDiscovery(t *testing.T, client RedisClient, prefix string) *RedisDiscovery {
+	t.Helper()
+	d, err := NewRedisDiscovery(client, prefix, WithCacheLogger(discardLogger))
+	if err != nil {
+		t.Fatalf("redis discovery: %v", err)
+	}
+	return d
+}
+
+func TestRedisConcurrentCreateAPI(t *testing.T) {
+	tests := []struct {
+		name     string
+		gateways int
+		apis     int
+	}{
+		{name: "one gateway", gateways: 1, apis: 20},
+		{name: "gateways racing", gateways: 3, apis: 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeRedis()
+			gateways := make([]*RedisDiscovery, tt.gateways)
+			for i := range gateways {
+				gateways[i] = newTestRedisDiscovery(t, client, DefaultRedisPrefix)
+			}
+			if err := gateways[0].CreateService(newTestService("svc")); err != nil {
+				t.Fatalf("create service: %v", err)
+			}
+			var wg sync.WaitGroup
+			errs := make(chan error, tt.apis)
+			for i := 0; i < tt.apis; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- gateways[i%len(gateways)].CreateAPI(&API{
+						Service:    "svc",
+						Name:       fmt.Sprintf("api%d", i),
+						HTTPMethod: http.MethodGet,
+						Host:       "127.0.0.1:8080",
+						Path:       "get",
+					})
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Errorf("create api: %v", err)
+				}
+			}
+			// every gateway and a fresh one see every api
+			fresh := newTestRedisDiscovery(t, client, DefaultRedisPrefix)
+			for i, d := range append(gateways, fresh) {
+				if err := d.Sync(context.Background()); err != nil {
+					t.Fatalf("sync: %v", err)
+				}
+				service, err := d.GetService("svc")
+				if err != nil {
+					t.Fatalf("gateway %d get service: %v", i, err)
+				}
+				if len(service.APIs) != tt.apis {
+					t.Errorf("gateway %d has %d apis, want %d", i, len(service.APIs), tt.apis)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisRegistryShared(t *testing.T) {
+	client := newFakeRedis()
+	first := newTestRedisDiscovery(t, client, DefaultRedisPrefix)
+	auth := &Auth{Mode: "jwt", Algorithm: "HS256", Secret: testJWTSecret}
+	if err := first.CreateService(newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:8080", Path: "get", Auth: auth})); err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	if err := first.CreateAlias("alias", "svc"); err != nil {
+		t.Fatalf("create alias: %v", err)
+	}
+	if err := first.CreateAPIKey(&APIKey{Service: "svc", Key: aliceKey, Name: "alice"}); err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tests := []struct {
+		name   string
+		prefix string
+		shared bool
+	}{
+		{name: "same prefix", prefix: DefaultRedisPrefix, shared: true},
+		{name: "other prefix", prefix: "other:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestRedisDiscovery(t, client, tt.prefix)
+			service, err := d.GetService("alias")
+			if !tt.shared {
+				if err == nil {
+					t.Errorf("service visible under prefix %v", tt.prefix)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("get service by alias: %v", err)
+			}
+			if got := service.APIs["get"].Auth; got == nil || got.Secret != testJWTSecret {
+				t.Errorf("auth %+v, want secret kept", got)
+			}
+			if !d.ValidAPIKey("svc", aliceKey) || d.ValidAPIKey("svc", bobKey) {
+				t.Errorf("api keys not shared")
+			}
+		})
+	}
+}
+
+func TestRedisWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func(d *RedisDiscovery) error
+		wantErr error
+		commits int
+	}{
+		{
+			name:    "duplicate service",
+			op:      func(d *RedisDiscovery) error { return d.CreateService(newTestService("svc")) },
+			wantErr: ErrAlreadyExist,
+		},
+		{
+			name: "api of unknown service",
+			op: func(d *RedisDiscovery) error {
+				return d.CreateAPI(&API{Service: "nope", Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get"})
+			},
+			wantErr: ErrNotExist,
+		},
+		{
+			name:    "delete unknown api",
+			op:      func(d *RedisDiscovery) error { return d.DeleteAPI("svc", "nope") },
+			wantErr: ErrNotExist,
+		},
+		{
+			name:    "delete service",
+			op:      func(d *RedisDiscovery) error { return d.DeleteService("svc") },
+			commits: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeRedis()
+			d := newTestRedisDiscovery(t, client, DefaultRedisPrefix)
+			if err := d.CreateService(newTestService("svc")); err != nil {
+				t.Fatalf("create service: %v", err)
+			}
+			before := client.evals
+			if err := tt.op(d); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if commits := client.evals - before; commits != tt.commits {
+				t.Errorf("%d commits, want %d", commits, tt.commits)
+			}
+		})
+	}
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	client := newFakeRedis()
+	d := newTestRedisDiscovery(t, client, DefaultRedisPrefix)
+	if err := d.CreateService(newTestService("svc")); err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	client.err = errors.New("connection refused")
+	if err := d.CreateService(newTestService("other")); !errors.Is(err, errRegistryUnavailable) {
+		t.Errorf("write error %v, want registry unavailable", err)
+	}
+	if err := d.Sync(context.Background()); err == nil {
+		t.Errorf("sync succeeded without redis")
+	}
+	if _, err := d.GetService("svc"); err != nil {
+		t.Errorf("mirror lost while redis is down: %v", err)
+	}
+	if _, err := NewRedisDiscovery(client, DefaultRedisPrefix); err == nil {
+		t.Errorf("discovery created without redis")
+	}
+}
@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisClient run one redis command, replies are decoded as string (simple and bulk
+// strings), int64, []interface{}, nil for null replies and RedisError for error replies
+type RedisClient interface {
+	Do(ctx context.Context, args ...string) (interface{}, error)
+}
+
+// RedisError is an error reply of redis
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+// redisDialTimeout bound connecting to redis when ctx has no deadline
+const redisDialTimeout = 5 * time.Second
+
+// redisConn is a RedisClient speaking RESP over one connection, commands are serialized
+// and the connection is redialed after network errors
+type redisConn struct {
+	mu       sync.Mutex
+	address  string
+	password string
+	db       int
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// NewRedisClient return a RedisClient of the redis server at address, authenticating with
+// password when not empty and selecting db, the connection is opened on first command
+func NewRedisClient(address, password string, db int) RedisClient {
+	return &redisConn{address: address, password: password, db: db}
+}
+
+// Do implements RedisClient
+func (c *redisConn) Do(ctx context.Context, args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(ctx, args)
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// the stream may be out of sync, never reuse it
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// dial connect to redis then authenticate and select db
+func (c *redisConn) dial(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return fmt.Errorf("redis: %v dial failed: %v", c.address, err)
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	if c.password != "" {
+		_, err = c.roundTrip(ctx, []string{"AUTH", c.password})
+	}
+	if err == nil && c.db != 0 {
+		_, err = c.roundTrip(ctx, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if err != nil {
+		conn.Close()
+		c.conn = nil
+		return fmt.Errorf("redis: %v %v", c.address, err)
+	}
+	return nil
+}
+
+// roundTrip write command args and read its reply
+func (c *redisConn) roundTrip(ctx context.Context, args []string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	c.conn.SetDeadline(deadline)
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.reader)
+}
+
+// readRedisReply decode one RESP2 reply
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply: %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, RedisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			// an error reply inside an array is kept as an item
+			item, err := readRedisReply(r)
+			var redisErr RedisError
+			if errors.As(err, &redisErr) {
+				item, err = redisErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type: %q", kind)
+}
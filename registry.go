@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ServiceListing is a registered service answered by /listServices and /getService with
+// the aliases leading to it
+type ServiceListing struct {
+	*Service
+	Aliases []string `json:"aliases"`
+}
+
+// ListServices return a deep copy of all services ordered by name, callers may mutate it
+// freely, auth secrets are kept while runtime state such as limiters and transports is not
+// copied
+func (c *cache) ListServices() []*Service {
+	listings := c.listServices()
+	copies := make([]*Service, 0, len(listings))
+	for _, listing := range listings {
+		copies = append(copies, listing.Service)
+	}
+	return copies
+}
+
+// serviceLister is implemented by discoveries able to copy services with their aliases
+// while holding their lock
+type serviceLister interface {
+	// listServices copy all services ordered by name
+	listServices() []ServiceListing
+	// copyService copy the service name, aliases are resolved
+	copyService(name string) (ServiceListing, error)
+}
+
+// listServices implements serviceLister
+func (c *cache) listServices() []ServiceListing {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	listings := make([]ServiceListing, 0, len(c.store))
+	for name, service := range c.store {
+		copied, err := cloneService(service)
+		if err != nil {
+			c.logger.Errorf("list service: %v skipped: %v", name, err)
+			continue
+		}
+		listings = append(listings, ServiceListing{Service: copied, Aliases: c.aliasesOf(name)})
+	}
+	sort.Slice(listings, func(i, j int) bool { return listings[i].Name < listings[j].Name })
+	return listings
+}
+
+// copyService implements serviceLister
+func (c *cache) copyService(name string) (ServiceListing, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	resolved := c.resolve(name)
+	service, exist := c.store[resolved]
+	if !exist {
+		return ServiceListing{}, fmt.Errorf("service: %v %w", name, ErrNotExist)
+	}
+	copied, err := cloneService(service)
+	if err != nil {
+		return ServiceListing{}, err
+	}
+	return ServiceListing{Service: copied, Aliases: c.aliasesOf(resolved)}, nil
+}
+
+// aliasesOf return the sorted aliases leading to service name, must be called with lock held
+func (c *cache) aliasesOf(name string) []string {
+	aliases := []string{}
+	for alias := range c.aliases {
+		if c.resolve(alias) == name {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// cloneService deep copy the registered definition of service through its stored json
+// form, which keep the auth secrets that admin responses redact
+func cloneService(service *Service) (*Service, error) {
+	data, err := encodeService(service)
+	if err != nil {
+		return nil, err
+	}
+	var copied Service
+	if err := json.Unmarshal([]byte(data), &copied); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+// ListServices handle http request to list all registered services with their apis and
+// aliases
+func (gateway *APIGateway) ListServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	if lister, ok := gateway.Discovery.(serviceLister); ok {
+		writeJSON(w, http.StatusOK, lister.listServices())
+		return
+	}
+	// other discoveries do not tell their aliases
+	services := gateway.Discovery.ListServices()
+	listings := make([]ServiceListing, 0, len(services))
+	for _, service := range services {
+		listings = append(listings, ServiceListing{Service: service, Aliases: []string{}})
+	}
+	writeJSON(w, http.StatusOK, listings)
+}
+
+// GetService handle http request to get the service given by query parameter name with
+// its aliases, aliases are resolved
+func (gateway *APIGateway) GetService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if lister, ok := gateway.Discovery.(serviceLister); ok {
+		listing, err := lister.copyService(name)
+		if errors.Is(err, ErrNotExist) {
+			writeAdminError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, listing)
+		return
+	}
+	service, err := gateway.Discovery.GetService(name)
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	copied, err := cloneService(service)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ServiceListing{Service: copied, Aliases: []string{}})
+}
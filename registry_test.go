@@ -0,0 +1,187 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newRegistryTestGateway return a gateway with services user, aliased as account, and order
+func newRegistryTestGateway(t *testing.T) *APIGateway {
+	t.Helper()
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway,
+		newTestService("user",
+			&API{Name: "get", HTTPMethod: http.MethodGet, Host: namedBackend(t, "user"), Path: "get"},
+			&API{Name: "create", HTTPMethod: http.MethodPost, Host: "127.0.0.1:1", Path: "create"}),
+		newTestService("order", &API{Name: "list", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "list"}))
+	if err := gateway.Discovery.CreateAlias("account", "user"); err != nil {
+		t.Fatalf("create alias: %v", err)
+	}
+	return gateway
+}
+
+// listedService is the json shape of a listed service, only the fields checked
+type listedService struct {
+	Name    string                     `json:"name"`
+	APIs    map[string]json.RawMessage `json:"apis"`
+	Aliases []string                   `json:"aliases"`
+}
+
+func TestRegistryHandlers(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+		want   []listedService // apis only by name
+	}{
+		{
+			name:   "list",
+			method: http.MethodGet,
+			target: "/listServices",
+			status: http.StatusOK,
+			want: []listedService{
+				{Name: "order", Aliases: []string{}, APIs: map[string]json.RawMessage{"list": nil}},
+				{Name: "user", Aliases: []string{"account"}, APIs: map[string]json.RawMessage{"create": nil, "get": nil}},
+			},
+		},
+		{
+			name:   "get",
+			method: http.MethodGet,
+			target: "/getService?name=user",
+			status: http.StatusOK,
+			want:   []listedService{{Name: "user", Aliases: []string{"account"}, APIs: map[string]json.RawMessage{"create": nil, "get": nil}}},
+		},
+		{
+			name:   "get through alias",
+			method: http.MethodGet,
+			target: "/getService?name=account",
+			status: http.StatusOK,
+			want:   []listedService{{Name: "user", Aliases: []string{"account"}, APIs: map[string]json.RawMessage{"create": nil, "get": nil}}},
+		},
+		{name: "get unknown", method: http.MethodGet, target: "/getService?name=nope", status: http.StatusNotFound},
+		{name: "list wrong method", method: http.MethodPost, target: "/listServices", status: http.StatusMethodNotAllowed},
+		{name: "get wrong method", method: http.MethodDelete, target: "/getService?name=user", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newRegistryTestGateway(t)
+			rec := serveAdmin(gateway, tt.method, tt.target, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				var body adminResult
+				mustDecode(t, rec.Body.Bytes(), &body)
+				if body.Error == "" {
+					t.Errorf("error body %q without error", rec.Body.String())
+				}
+				return
+			}
+			var got []listedService
+			if tt.target == "/listServices" {
+				mustDecode(t, rec.Body.Bytes(), &got)
+			} else {
+				var one listedService
+				mustDecode(t, rec.Body.Bytes(), &one)
+				got = []listedService{one}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d services, want %d: %s", len(got), len(tt.want), rec.Body.String())
+			}
+			for i, service := range got {
+				want := tt.want[i]
+				if service.Name != want.Name || !reflect.DeepEqual(service.Aliases, want.Aliases) ||
+					!reflect.DeepEqual(apiNames(service.APIs), apiNames(want.APIs)) {
+					t.Errorf("service %+v, want %+v", service, want)
+				}
+				for name, api := range service.APIs {
+					var fields map[string]interface{}
+					mustDecode(t, api, &fields)
+					if fields["name"] != name || fields["service"] != want.Name {
+						t.Errorf("api %v listed as %s", name, api)
+					}
+				}
+			}
+		})
+	}
+}
+
+// apiNames return the sorted keys of apis
+func apiNames(apis map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(apis))
+	for name := range apis {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestListServicesDeepCopy(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(services []*Service)
+	}{
+		{name: "rename service", mutate: func(services []*Service) { services[1].Name = "renamed" }},
+		{name: "change host", mutate: func(services []*Service) { services[1].APIs["get"].Host = "127.0.0.1:1" }},
+		{name: "delete api", mutate: func(services []*Service) { delete(services[1].APIs, "get") }},
+		{name: "replace apis", mutate: func(services []*Service) { services[1].APIs = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newRegistryTestGateway(t)
+			services := gateway.Discovery.ListServices()
+			if len(services) != 2 || services[1].Name != "user" {
+				t.Fatalf("listed %d services", len(services))
+			}
+			tt.mutate(services)
+			service, err := gateway.Discovery.GetService("user")
+			if err != nil {
+				t.Fatalf("get service: %v", err)
+			}
+			if service.Name != "user" || len(service.APIs) != 2 {
+				t.Errorf("registered service changed to %v with %d apis", service.Name, len(service.APIs))
+			}
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/user/get", nil)); rec.Body.String() != "user" {
+				t.Errorf("proxy answered %d %q", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestListServicesAuthSecret(t *testing.T) {
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc", &API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get",
+		Auth: &Auth{Mode: AuthJWT, Algorithm: "HS256", Secret: testJWTSecret}}))
+	tests := []struct {
+		name   string
+		secret func() string
+		want   string
+	}{
+		{
+			name:   "kept by ListServices",
+			secret: func() string { return gateway.Discovery.ListServices()[0].APIs["get"].Auth.Secret },
+			want:   testJWTSecret,
+		},
+		{
+			name: "redacted by listServices endpoint",
+			secret: func() string {
+				var listings []ServiceListing
+				mustDecode(t, serveAdmin(gateway, http.MethodGet, "/listServices", "").Body.Bytes(), &listings)
+				return listings[0].APIs["get"].Auth.Secret
+			},
+			want: redactedSecret,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.secret(); got != tt.want {
+				t.Errorf("secret %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// MaxBodyBytesCeiling is the hard ceiling of every request body limit
+const MaxBodyBytesCeiling = 1 << 30
+
+// errRequestTooLarge is returned when client request body exceeds the configured size
+var errRequestTooLarge = errors.New("request body too large")
+
+// validateMaxBodyBytes check a request body limit is within [0, MaxBodyBytesCeiling]
+func validateMaxBodyBytes(limit int64) error {
+	if limit < 0 {
+		return fmt.Errorf("maxBodyBytes can not be negative")
+	}
+	if limit > MaxBodyBytesCeiling {
+		return fmt.Errorf("maxBodyBytes: %v exceed ceiling %v", limit, MaxBodyBytesCeiling)
+	}
+	return nil
+}
+
+// maxBodyBytes return the request body limit of route, the api limit override the
+// service limit which override the gateway MaxBodyBytes, zero means unlimited
+func (gateway *APIGateway) maxBodyBytes(rt *route) int64 {
+	if rt.api.MaxBodyBytes > 0 {
+		return rt.api.MaxBodyBytes
+	}
+	if rt.service.MaxBodyBytes > 0 {
+		return rt.service.MaxBodyBytes
+	}
+	return gateway.MaxBodyBytes
+}
+
+// limitRequest enforce the request body limit of the resolved route, a declared
+// over-large body is rejected at once, return false when the response has been written
+func (gateway *APIGateway) limitRequest(w http.ResponseWriter, r *http.Request, rt *route) bool {
+	limit := gateway.maxBodyBytes(rt)
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > limit {
+		gateway.writeError(w, r, http.StatusRequestEntityTooLarge, errRequestTooLarge.Error())
+		return false
+	}
+	r.Body = &limitedRequestBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), left: limit}
+	return true
+}
+
+// limitedRequestBody report errRequestTooLarge once the client sent more than the limit,
+// http.MaxBytesReader also close the client connection then
+type limitedRequestBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *limitedRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	if err != nil && err != io.EOF && b.left <= 0 {
+		err = errRequestTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRequestBodyLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		gatewayMax int64
+		serviceMax int64
+		apiMax     int64
+		size       int
+		chunked    bool // client send the body without Content-Length
+		status     int
+	}{
+		{name: "unlimited", size: 4096, status: http.StatusOK},
+		{name: "under gateway limit", gatewayMax: 100, size: 100, status: http.StatusOK},
+		{name: "over gateway limit", gatewayMax: 100, size: 101, status: http.StatusRequestEntityTooLarge},
+		{name: "chunked over gateway limit", gatewayMax: 100, size: 4096, chunked: true, status: http.StatusRequestEntityTooLarge},
+		{name: "service above gateway", gatewayMax: 100, serviceMax: 1000, size: 500, status: http.StatusOK},
+		{name: "chunked service above gateway", gatewayMax: 100, serviceMax: 1000, size: 500, chunked: true, status: http.StatusOK},
+		{name: "service below gateway", gatewayMax: 1000, serviceMax: 100, size: 500, status: http.StatusRequestEntityTooLarge},
+		{name: "chunked service below gateway", gatewayMax: 1000, serviceMax: 100, size: 500, chunked: true, status: http.StatusRequestEntityTooLarge},
+		{name: "api overrides service", gatewayMax: 100, serviceMax: 200, apiMax: 1000, size: 500, status: http.StatusOK},
+		{name: "api below service", serviceMax: 1000, apiMax: 100, size: 500, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received int64
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				atomic.StoreInt64(&received, int64(len(body)))
+			})
+			gateway := newTestGateway(t)
+			gateway.MaxBodyBytes = tt.gatewayMax
+			service := newTestService("svc", &API{
+				Name: "upload", HTTPMethod: http.MethodPost, Host: backend, Path: "upload", MaxBodyBytes: tt.apiMax,
+			})
+			service.MaxBodyBytes = tt.serviceMax
+			mustCreateService(t, gateway, service)
+			server := httptest.NewServer(gateway)
+			defer server.Close()
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/svc/upload", strings.NewReader(strings.Repeat("x", tt.size)))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("post: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := atomic.LoadInt64(&received); tt.status == http.StatusOK && got != int64(tt.size) {
+				t.Errorf("backend received %d bytes, want %d", got, tt.size)
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytesValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		serviceMax int64
+		apiMax     int64
+		wantErr    bool
+	}{
+		{name: "at ceiling", serviceMax: MaxBodyBytesCeiling, apiMax: MaxBodyBytesCeiling},
+		{name: "service over ceiling", serviceMax: MaxBodyBytesCeiling + 1, wantErr: true},
+		{name: "api over ceiling", apiMax: MaxBodyBytesCeiling + 1, wantErr: true},
+		{name: "service negative", serviceMax: -1, wantErr: true},
+		{name: "api negative", apiMax: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			service := newTestService("svc", &API{
+				Name: "upload", HTTPMethod: http.MethodPost, Host: "127.0.0.1:1", Path: "upload", MaxBodyBytes: tt.apiMax,
+			})
+			service.MaxBodyBytes = tt.serviceMax
+			err := gateway.Discovery.CreateService(service)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("create service error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIBodyLimitNotProxied(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		size    int
+		status  int
+		proxied bool
+	}{
+		{name: "over api limit", target: "/svc/limited", size: 65, status: http.StatusRequestEntityTooLarge},
+		{name: "at api limit", target: "/svc/limited", size: 64, status: http.StatusOK, proxied: true},
+		{name: "empty body", target: "/svc/limited", status: http.StatusOK, proxied: true},
+		{name: "zero means no limit", target: "/svc/open", size: 1 << 20, status: http.StatusOK, proxied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				ioutil.ReadAll(r.Body)
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "limited", HTTPMethod: http.MethodPost, Host: backend, Path: "upload", MaxBodyBytes: 64},
+				&API{Name: "open", HTTPMethod: http.MethodPost, Host: backend, Path: "upload"}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(strings.Repeat("x", tt.size))))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if proxied := atomic.LoadInt32(&hits) > 0; proxied != tt.proxied {
+				t.Errorf("backend called %v, want %v", proxied, tt.proxied)
+			}
+			if tt.status == http.StatusRequestEntityTooLarge && !strings.Contains(rec.Body.String(), `"status":413`) {
+				t.Errorf("error body %q, want the gateway json error", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheHeader tell clients whether a response of a caching api was served from the cache
+const CacheHeader = "X-Cache"
+
+const (
+	// maxCachedBodyBytes bound the body of a cached response, larger responses are not cached
+	maxCachedBodyBytes = 1 << 20
+	// maxCachedResponses bound the responses cached per api
+	maxCachedResponses = 1024
+)
+
+// cachedResponse is a 200 response of a GET request kept until expires
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+	public  bool // may be served to requests carrying credentials
+}
+
+// responseCache keep backend responses of an api by request uri
+type responseCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+// newResponseCache create cache keeping responses for ttl
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: make(map[string]*cachedResponse)}
+}
+
+// get return the unexpired response of key, nil if none
+func (c *responseCache) get(key string, now time.Time) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, exist := c.entries[key]
+	if !exist {
+		return nil
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// put keep header and body as the response of key, expired responses are dropped when the
+// cache is full and nothing is kept if it is still full
+func (c *responseCache) put(key string, header http.Header, body []byte, public bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exist := c.entries[key]; !exist && len(c.entries) >= maxCachedResponses {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedResponses {
+			return
+		}
+	}
+	c.entries[key] = &cachedResponse{header: header, body: body, stored: now, expires: now.Add(c.ttl), public: public}
+}
+
+// normalizeResponseCache validate CacheTTLSeconds of api and build its cache
+func normalizeResponseCache(api *API) error {
+	if api.CacheTTLSeconds < 0 {
+		return fmt.Errorf("api: %v cacheTTLSeconds can not be negative", api.Name)
+	}
+	if api.CacheTTLSeconds > 0 && api.Streaming {
+		return fmt.Errorf("api: %v streaming api can not be cached", api.Name)
+	}
+	api.responses = nil
+	if api.CacheTTLSeconds > 0 {
+		api.responses = newResponseCache(time.Duration(api.CacheTTLSeconds) * time.Second)
+	}
+	return nil
+}
+
+// serveCached answer GET requests of caching apis from the cache and return true on hit,
+// on miss the route is marked so that a cacheable response is stored, clients sending
+// Cache-Control no-store bypass the cache and no-cache skip the lookup only, requests
+// carrying credentials only share responses the backend marked public (RFC 7234 3.2)
+func (gateway *APIGateway) serveCached(w http.ResponseWriter, r *http.Request, rt *route) bool {
+	cache := rt.api.responses
+	if cache == nil || r.Method != http.MethodGet || rt.upgrade {
+		return false
+	}
+	w.Header().Set(CacheHeader, "MISS")
+	if headerHasToken(r.Header, "Cache-Control", "no-store") {
+		return false
+	}
+	key := r.URL.RequestURI()
+	rt.cacheKey = key
+	rt.cacheCredentials = rt.api.Auth != nil || r.Header.Get("Authorization") != ""
+	if headerHasToken(r.Header, "Cache-Control", "no-cache") {
+		return false
+	}
+	now := time.Now()
+	entry := cache.get(key, now)
+	if entry == nil || (rt.cacheCredentials && !entry.public) {
+		return false
+	}
+	resp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         r.Proto,
+		ProtoMajor:    r.ProtoMajor,
+		ProtoMinor:    r.ProtoMinor,
+		Header:        entry.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(entry.body)),
+		ContentLength: int64(len(entry.body)),
+		Request:       r,
+	}
+	resp.Header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+	resp.Header.Set("Age", strconv.Itoa(int(now.Sub(entry.stored)/time.Second)))
+	// a hit is answered as the backend response would be
+	gateway.prepareResponse(resp)
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(CacheHeader, "HIT")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, resp.Body)
+	resp.Body.Close()
+	return true
+}
+
+// cacheResponse store the response of a request marked by serveCached once its body is read,
+// only complete 200 responses backends allow to share are stored
+func cacheResponse(resp *http.Response) {
+	rt := routeOf(resp.Request.Context())
+	if rt == nil || rt.cacheKey == "" || rt.streaming() || !cacheable(resp) {
+		return
+	}
+	public := sharedWithCredentials(resp)
+	if rt.cacheCredentials && !public {
+		return
+	}
+	limit := int64(maxCachedBodyBytes)
+	// a body reaching the response limit may be truncated
+	if max := maxResponseBytes(rt); max > 0 && max <= limit {
+		limit = max - 1
+	}
+	if resp.ContentLength > limit {
+		return
+	}
+	cache, key, header := rt.api.responses, rt.cacheKey, resp.Header.Clone()
+	resp.Body = &cachingBody{body: resp.Body, limit: limit, length: resp.ContentLength, store: func(body []byte) {
+		cache.put(key, header, body, public, time.Now())
+	}}
+}
+
+// cacheable report whether resp may be shared by all clients of the api
+func cacheable(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || len(resp.Trailer) > 0 {
+		return false
+	}
+	if headerHasToken(resp.Header, "Cache-Control", "no-store") || headerHasToken(resp.Header, "Cache-Control", "private") {
+		return false
+	}
+	// the body may depend on request headers the cache key ignores
+	return len(resp.Header["Set-Cookie"]) == 0 && len(resp.Header["Vary"]) == 0 &&
+		resp.Header.Get("Content-Encoding") == ""
+}
+
+// sharedWithCredentials report whether the backend allow resp to be shared although its
+// request carried credentials, with Cache-Control public, must-revalidate or s-maxage
+func sharedWithCredentials(resp *http.Response) bool {
+	for _, value := range resp.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name := strings.ToLower(strings.TrimSpace(directive))
+			if i := strings.IndexByte(name, '='); i >= 0 {
+				name = strings.TrimSpace(name[:i])
+			}
+			switch name {
+			case "public", "must-revalidate", "s-maxage":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cachingBody copy the body read by the client, store is called with it once it is read
+// to the end within limit
+type cachingBody struct {
+	body   io.ReadCloser
+	buf    bytes.Buffer
+	limit  int64
+	length int64 // Content-Length, -1 if unknown
+	store  func(body []byte)
+	done   bool // stored or given up
+}
+
+// Read implements io.Reader
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.done {
+		return n, err
+	}
+	if int64(b.buf.Len()+n) > b.limit {
+		b.done = true
+		b.buf = bytes.Buffer{}
+		return n, err
+	}
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.done = true
+		if b.length < 0 || int64(b.buf.Len()) == b.length {
+			b.store(b.buf.Bytes())
+		}
+	} else if err != nil {
+		b.done = true
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *cachingBody) Close() error {
+	return b.body.Close()
+}
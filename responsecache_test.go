@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cacheRequest is a request sent to a caching api
+type cacheRequest struct {
+	method string // default GET
+	target string
+	header map[string]string
+}
+
+func TestResponseCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  cacheRequest
+		second cacheRequest
+		cache  string // X-Cache of the second response
+		hits   int32
+	}{
+		{name: "hit", first: cacheRequest{target: "/svc/get"}, second: cacheRequest{target: "/svc/get"}, cache: "HIT", hits: 1},
+		{name: "keyed by query", first: cacheRequest{target: "/svc/get?id=1"}, second: cacheRequest{target: "/svc/get?id=2"}, cache: "MISS", hits: 2},
+		{
+			name:   "client no-store",
+			first:  cacheRequest{target: "/svc/get", header: map[string]string{"Cache-Control": "no-store"}},
+			second: cacheRequest{target: "/svc/get"},
+			cache:  "MISS",
+			hits:   2,
+		},
+		{
+			name:   "client no-cache refresh",
+			first:  cacheRequest{target: "/svc/get"},
+			second: cacheRequest{target: "/svc/get", header: map[string]string{"Cache-Control": "no-cache"}},
+			cache:  "MISS",
+			hits:   2,
+		},
+		{name: "error not cached", first: cacheRequest{target: "/svc/get?status=500"}, second: cacheRequest{target: "/svc/get?status=500"}, cache: "MISS", hits: 2},
+		{name: "backend no-store", first: cacheRequest{target: "/svc/get?cc=no-store"}, second: cacheRequest{target: "/svc/get?cc=no-store"}, cache: "MISS", hits: 2},
+		{name: "backend private", first: cacheRequest{target: "/svc/get?cc=private"}, second: cacheRequest{target: "/svc/get?cc=private"}, cache: "MISS", hits: 2},
+		{name: "cookie not cached", first: cacheRequest{target: "/svc/get?cookie=1"}, second: cacheRequest{target: "/svc/get?cookie=1"}, cache: "MISS", hits: 2},
+		{
+			name:   "credentials not shared",
+			first:  cacheRequest{target: "/svc/get", header: map[string]string{"Authorization": "Bearer a"}},
+			second: cacheRequest{target: "/svc/get", header: map[string]string{"Authorization": "Bearer b"}},
+			cache:  "MISS",
+			hits:   2,
+		},
+		{
+			name:   "anonymous response not served to credentials",
+			first:  cacheRequest{target: "/svc/get"},
+			second: cacheRequest{target: "/svc/get", header: map[string]string{"Authorization": "Bearer b"}},
+			cache:  "MISS",
+			hits:   2,
+		},
+		{
+			name:   "public shared with credentials",
+			first:  cacheRequest{target: "/svc/get?cc=public", header: map[string]string{"Authorization": "Bearer a"}},
+			second: cacheRequest{target: "/svc/get?cc=public", header: map[string]string{"Authorization": "Bearer b"}},
+			cache:  "HIT",
+			hits:   1,
+		},
+		{name: "post not cached", first: cacheRequest{method: http.MethodPost, target: "/svc/get"}, second: cacheRequest{method: http.MethodPost, target: "/svc/get"}, hits: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&hits, 1)
+				query := r.URL.Query()
+				if cc := query.Get("cc"); cc != "" {
+					w.Header().Set("Cache-Control", cc)
+				}
+				if query.Get("cookie") != "" {
+					w.Header().Set("Set-Cookie", "session=1")
+				}
+				if query.Get("status") != "" {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+				fmt.Fprintf(w, "response %d", n)
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name:            "get",
+				HTTPMethods:     []string{http.MethodGet, http.MethodPost},
+				Host:            backend,
+				Path:            "get",
+				CacheTTLSeconds: 60,
+			}))
+			send := func(cr cacheRequest) *httptest.ResponseRecorder {
+				method := cr.method
+				if method == "" {
+					method = http.MethodGet
+				}
+				req := httptest.NewRequest(method, cr.target, nil)
+				for k, v := range cr.header {
+					req.Header.Set(k, v)
+				}
+				return serveProxy(gateway, req)
+			}
+			first := send(tt.first)
+			second := send(tt.second)
+			if got := second.Header().Get(CacheHeader); got != tt.cache {
+				t.Errorf("%v %q, want %q", CacheHeader, got, tt.cache)
+			}
+			if got := atomic.LoadInt32(&hits); got != tt.hits {
+				t.Errorf("backend hit %d times, want %d", got, tt.hits)
+			}
+			if tt.cache == "HIT" {
+				if second.Body.String() != first.Body.String() || second.Header().Get("Age") == "" {
+					t.Errorf("hit %q Age %q, want %q with Age", second.Body.String(), second.Header().Get("Age"), first.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	now := time.Now()
+	cache := newResponseCache(time.Minute)
+	cache.put("/svc/get", http.Header{}, []byte("cached"), false, now)
+	tests := []struct {
+		name  string
+		at    time.Time
+		found bool
+	}{
+		{name: "fresh", at: now, found: true},
+		{name: "before ttl", at: now.Add(time.Minute - time.Millisecond), found: true},
+		{name: "at ttl", at: now.Add(time.Minute)},
+		{name: "evicted once expired", at: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if found := cache.get("/svc/get", tt.at) != nil; found != tt.found {
+				t.Errorf("found %v, want %v", found, tt.found)
+			}
+		})
+	}
+}
+
+func TestResponseCacheRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		api  *API
+	}{
+		{name: "negative ttl", api: &API{CacheTTLSeconds: -1}},
+		{name: "streaming", api: &API{CacheTTLSeconds: 10, Streaming: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.api.Name, tt.api.HTTPMethod, tt.api.Host, tt.api.Path = "get", http.MethodGet, "127.0.0.1:1", "get"
+			gateway := newTestGateway(t)
+			if err := gateway.Discovery.CreateService(newTestService("svc", tt.api)); err == nil {
+				t.Errorf("invalid cache config accepted")
+			}
+		})
+	}
+}
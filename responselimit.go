@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// errResponseTooLarge is returned when backend response exceeds the configured size
+var errResponseTooLarge = errors.New("backend response too large")
+
+// maxResponseBytes return the response size limit of route, zero means unlimited,
+// streaming apis are never limited
+func maxResponseBytes(rt *route) int64 {
+	if rt.streaming() {
+		return 0
+	}
+	if rt.api.MaxResponseBytes > 0 {
+		return rt.api.MaxResponseBytes
+	}
+	return rt.service.MaxResponseBytes
+}
+
+// limitResponse enforce the response size limit of the resolved route, used as ModifyResponse
+func (gateway *APIGateway) limitResponse(resp *http.Response) error {
+	rt := routeOf(resp.Request.Context())
+	if rt == nil {
+		return nil
+	}
+	limit := maxResponseBytes(rt)
+	if limit <= 0 {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		if !rt.api.TruncateResponse {
+			resp.Body.Close()
+			return errResponseTooLarge
+		}
+		// length is unknown after truncated
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+	}
+	resp.Body = &limitedBody{
+		body:     resp.Body,
+		left:     limit,
+		truncate: rt.api.TruncateResponse,
+		id:       requestID(resp.Request.Context()),
+		logger:   gateway.logger(),
+	}
+	return nil
+}
+
+// limitedBody read backend response body up to limit bytes, then either end the body
+// (truncate) or fail so that the proxy aborts the client connection
+type limitedBody struct {
+	body     io.ReadCloser
+	left     int64
+	truncate bool
+	id       string // request id for logging
+	logger   Logger
+}
+
+// Read implements io.Reader
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		// probe one byte to tell an exactly sized body from an over-large one
+		var probe [1]byte
+		n, err := b.body.Read(probe[:])
+		if n == 0 {
+			return 0, err
+		}
+		if b.truncate {
+			b.logger.Warnf("request: %v backend response truncated at size limit", b.id)
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("request: %v %w", b.id, errResponseTooLarge)
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.body.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
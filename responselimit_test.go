@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseSizeLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		serviceMax int64
+		apiMax     int64
+		truncate   bool
+		streaming  bool
+		size       int
+		chunked    bool // backend answer without Content-Length
+		status     int
+		body       int  // body length received by the client
+		aborted    bool // client sees the connection fail while reading the body
+	}{
+		{name: "unlimited", size: 4096, status: http.StatusOK, body: 4096},
+		{name: "under limit", apiMax: 100, size: 99, status: http.StatusOK, body: 99},
+		{name: "exactly limit", apiMax: 100, size: 100, chunked: true, status: http.StatusOK, body: 100},
+		{name: "declared length over limit", apiMax: 100, size: 101, status: http.StatusBadGateway},
+		{name: "chunked over limit aborted", apiMax: 100, size: 4096, chunked: true, status: http.StatusOK, aborted: true},
+		{name: "truncated", apiMax: 100, truncate: true, size: 4096, status: http.StatusOK, body: 100},
+		{name: "chunked truncated", apiMax: 100, truncate: true, size: 4096, chunked: true, status: http.StatusOK, body: 100},
+		{name: "service limit", serviceMax: 100, size: 101, status: http.StatusBadGateway},
+		{name: "api overrides service", serviceMax: 100, apiMax: 200, size: 150, status: http.StatusOK, body: 150},
+		{name: "streaming exempt", apiMax: 100, streaming: true, size: 4096, chunked: true, status: http.StatusOK, body: 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if !tt.chunked {
+					w.Header().Set("Content-Length", fmt.Sprint(tt.size))
+				}
+				w.Write([]byte(strings.Repeat("x", tt.size)))
+			})
+			gateway := newTestGateway(t)
+			service := newTestService("svc", &API{
+				Name: "big", HTTPMethod: http.MethodGet, Host: backend, Path: "big",
+				MaxResponseBytes: tt.apiMax, TruncateResponse: tt.truncate, Streaming: tt.streaming,
+			})
+			service.MaxResponseBytes = tt.serviceMax
+			mustCreateService(t, gateway, service)
+			server := httptest.NewServer(gateway)
+			defer server.Close()
+			resp, err := http.Get(server.URL + "/svc/big")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if tt.aborted {
+				if err == nil {
+					t.Errorf("over-large response of %d bytes completed", len(body))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if tt.status == http.StatusOK && len(body) != tt.body {
+				t.Errorf("body length %d, want %d", len(body), tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultRetryBackoff is the base wait before the first retry, doubled for each next one
+const DefaultRetryBackoff = 50 * time.Millisecond
+
+// maxRetryBackoff cap the wait between retries
+const maxRetryBackoff = 2 * time.Second
+
+// DefaultRetryBudget is the default fraction of requests that may be retries
+const DefaultRetryBudget = 0.2
+
+// retryBudgetMaxTokens bound the retries saved up while backends are healthy
+const retryBudgetMaxTokens = 10
+
+// retryBudget cap retries to a fraction of requests across the gateway, every request
+// deposits ratio tokens and every retry withdraws one, so a backend outage can not
+// turn into a retry storm
+type retryBudget struct {
+	mu         sync.Mutex
+	ratio      float64
+	tokens     float64
+	retries    int64 // retries allowed
+	suppressed int64 // retries skipped because budget exhausted
+}
+
+// RetryBudgetStats is a snapshot of the gateway retry budget
+type RetryBudgetStats struct {
+	Ratio           float64 `json:"ratio"`
+	Tokens          float64 `json:"tokens"`
+	MaxTokens       float64 `json:"maxTokens"`
+	RetriesTotal    int64   `json:"retriesTotal"`
+	SuppressedTotal int64   `json:"suppressedTotal"`
+}
+
+// newRetryBudget create a full budget allowing ratio of requests to be retries
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, tokens: retryBudgetMaxTokens}
+}
+
+// deposit credit the budget for a new request
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetMaxTokens {
+		b.tokens = retryBudgetMaxTokens
+	}
+}
+
+// withdraw report whether a retry is allowed and charge it to the budget
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		b.suppressed++
+		return false
+	}
+	b.tokens--
+	b.retries++
+	return true
+}
+
+// stats return the budget snapshot
+func (b *retryBudget) stats() RetryBudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return RetryBudgetStats{
+		Ratio:           b.ratio,
+		Tokens:          b.tokens,
+		MaxTokens:       retryBudgetMaxTokens,
+		RetriesTotal:    b.retries,
+		SuppressedTotal: b.suppressed,
+	}
+}
+
+// retryBudget return the gateway retry budget, created on first use
+func (gateway *APIGateway) retryBudget() *retryBudget {
+	gateway.retryOnce.Do(func() {
+		gateway.retries = newRetryBudget(gateway.RetryBudget)
+	})
+	return gateway.retries
+}
+
+// maxRetryDrainBytes bound the body of a retried response read so that its connection can
+// be reused, larger bodies close the connection
+const maxRetryDrainBytes = 4096
+
+// retryTransport retry upstream requests failed before a response was received, or
+// answered with a retryable status, only for apis configured with Retries and requests
+// which can be replayed, each retry go to another healthy host of the api when there is one
+type retryTransport struct {
+	next    http.RoundTripper
+	gateway *APIGateway
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	budget := t.gateway.retryBudget()
+	budget.deposit()
+	rt := routeOf(req.Context())
+	if rt == nil {
+		return t.next.RoundTrip(req)
+	}
+	buffered := t.gateway.bufferResponse(rt)
+	resp, err := t.attempt(req, rt, buffered)
+	if !replayable(req, rt.api) {
+		return resp, err
+	}
+	tried := map[string]bool{req.URL.Host: true}
+	for attempt := 0; attempt < rt.api.Retries; attempt++ {
+		// nothing reached the client yet, whether the response is streamed or buffered
+		if err == nil && !retryableResponse(rt.api, resp) {
+			break
+		}
+		if req.Context().Err() != nil {
+			break
+		}
+		if !budget.withdraw() {
+			t.gateway.logger().Warnf("request: %v retry skipped, retry budget exhausted", requestID(req.Context()))
+			break
+		}
+		if !sleepBackoff(req.Context(), retryBackoff(rt.api, attempt)) {
+			break
+		}
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				break
+			}
+			req.Body = body
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxRetryDrainBytes))
+			resp.Body.Close()
+		}
+		t.reroute(req, rt, tried)
+		resp, err = t.attempt(req, rt, buffered)
+	}
+	return resp, err
+}
+
+// reroute point req to a healthy host of its api not tried yet, req keeps its host when
+// there is none, the host is then marked tried
+func (t *retryTransport) reroute(req *http.Request, rt *route, tried map[string]bool) {
+	host := t.gateway.retryHost(req, rt.api, tried)
+	if host == "" {
+		return
+	}
+	tried[host] = true
+	rt.backend = host
+	req.URL.Scheme = t.gateway.backendScheme(rt.api, host)
+	req.URL.Host = host
+	if entry, ok := req.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.backend = host
+	}
+}
+
+// retryBackoff return the wait before retry attempt (0-based) of api, doubling from
+// RetryBackoffMs up to maxRetryBackoff with full jitter so that clients retrying at
+// once do not hit the backend in lockstep
+func retryBackoff(api *API, attempt int) time.Duration {
+	backoff := DefaultRetryBackoff
+	if api.RetryBackoffMs > 0 {
+		backoff = time.Duration(api.RetryBackoffMs) * time.Millisecond
+	}
+	for i := 0; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// sleepBackoff wait for d, return false when ctx is done first
+func sleepBackoff(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// attempt send upstream request once, reading the whole response when buffered
+func (t *retryTransport) attempt(req *http.Request, rt *route, buffered bool) (*http.Response, error) {
+	ctx, done := t.gateway.health.begin(req.Context(), req.URL.Host)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if errors.Is(err, errRequestTooLarge) {
+		// the client is at fault, not the backend
+		done()
+		return nil, err
+	}
+	t.gateway.health.observe(req.URL.Host, resp, err, t.gateway.DrainPeriod, t.gateway.EjectionPeriod)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	// the proxy need a writable body to tunnel upgraded connections
+	if conn, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = &trackedConn{ReadWriteCloser: conn, done: done}
+		return resp, nil
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: done}
+	// events are sent as they arrive, the proxy flush them at once
+	rt.eventStream = isEventStream(resp.Header)
+	if !buffered || rt.eventStream {
+		return resp, nil
+	}
+	if err := readResponse(resp, maxResponseBytes(rt)); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// replayable report whether the request can be sent again: its body is absent or can be
+// obtained again, and its method is idempotent unless the api retries any method
+func replayable(req *http.Request, api *API) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if api.RetryNonIdempotent {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
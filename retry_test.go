@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBudgetWithdraw(t *testing.T) {
+	tests := []struct {
+		name     string
+		ratio    float64
+		deposits int
+		withdraw int
+		allowed  int
+	}{
+		{name: "full budget", ratio: 0.2, withdraw: 12, allowed: retryBudgetMaxTokens},
+		{name: "capped refill", ratio: 1, deposits: 100, withdraw: 12, allowed: retryBudgetMaxTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := newRetryBudget(tt.ratio)
+			for i := 0; i < tt.deposits; i++ {
+				budget.deposit()
+			}
+			allowed := 0
+			for i := 0; i < tt.withdraw; i++ {
+				if budget.withdraw() {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d retries, want %d", allowed, tt.allowed)
+			}
+			stats := budget.stats()
+			if stats.RetriesTotal != int64(tt.allowed) || stats.SuppressedTotal != int64(tt.withdraw-tt.allowed) {
+				t.Errorf("stats %+v", stats)
+			}
+		})
+	}
+	budget := newRetryBudget(0.5)
+	for budget.withdraw() {
+	}
+	budget.deposit()
+	if budget.withdraw() {
+		t.Errorf("retry allowed with half a token")
+	}
+	budget.deposit()
+	if !budget.withdraw() {
+		t.Errorf("retry refused after two deposits of half a token")
+	}
+}
+
+func TestRetryBudgetSustainedFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		ratio    float64
+		requests int
+		retries  int
+	}{
+		{name: "default budget", ratio: DefaultRetryBudget, requests: 50, retries: 3},
+		{name: "no budget beyond saved tokens", ratio: 0, requests: 20, retries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int64
+			// every attempt fails before a response is received
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&hits, 1)
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			})
+			gateway := newTestGateway(t)
+			gateway.RetryBudget = tt.ratio
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "flaky", HTTPMethod: http.MethodGet, Host: backend, Path: "flaky", Retries: tt.retries, RetryBackoffMs: 1}))
+			for i := 0; i < tt.requests; i++ {
+				rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/flaky", nil))
+				if rec.Code != http.StatusBadGateway {
+					t.Fatalf("status %d, want 502", rec.Code)
+				}
+			}
+			var stats struct {
+				RetryBudget RetryBudgetStats `json:"retryBudget"`
+			}
+			rec := serveAdmin(gateway, http.MethodGet, "/stats", "")
+			if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+				t.Fatalf("decode stats %q: %v", rec.Body.String(), err)
+			}
+			budget := stats.RetryBudget
+			bound := math.Floor(retryBudgetMaxTokens + tt.ratio*float64(tt.requests) + 1e-9)
+			if float64(budget.RetriesTotal) > bound {
+				t.Errorf("%d retries, budget allows at most %v", budget.RetriesTotal, bound)
+			}
+			if budget.SuppressedTotal == 0 || budget.Tokens >= 1 {
+				t.Errorf("budget not exhausted: %+v", budget)
+			}
+			if got := atomic.LoadInt64(&hits); got != int64(tt.requests)+budget.RetriesTotal {
+				t.Errorf("backend hit %d times, want %d requests and %d retries", got, tt.requests, budget.RetriesTotal)
+			}
+		})
+	}
+}
+
+func TestRetryFlakyBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		api      API
+		method   string
+		body     string
+		failWith int // status of the failed attempts, zero drop the connection
+		status   int
+		hits     int32
+	}{
+		{name: "connection errors then success", api: API{Retries: 2}, status: http.StatusOK, hits: 3},
+		{name: "not enough retries", api: API{Retries: 1}, status: http.StatusBadGateway, hits: 2},
+		{name: "no retries", status: http.StatusBadGateway, hits: 1},
+		{name: "buffered 503 retried", api: API{Retries: 2, ResponseMode: ResponseBuffered}, failWith: http.StatusServiceUnavailable, status: http.StatusOK, hits: 3},
+		{name: "streamed 503 retried", api: API{Retries: 2, ResponseMode: ResponseStreamed}, failWith: http.StatusServiceUnavailable, status: http.StatusOK, hits: 3},
+		{name: "500 not retried by default", api: API{Retries: 2, ResponseMode: ResponseBuffered}, failWith: http.StatusInternalServerError, status: http.StatusInternalServerError, hits: 1},
+		{
+			name:     "configured retry status",
+			api:      API{Retries: 2, ResponseMode: ResponseBuffered, RetryStatuses: []int{http.StatusInternalServerError}},
+			failWith: http.StatusInternalServerError,
+			status:   http.StatusOK,
+			hits:     3,
+		},
+		{name: "put retried", api: API{Retries: 2}, method: http.MethodPut, status: http.StatusOK, hits: 3},
+		{name: "post not retried", api: API{Retries: 2}, method: http.MethodPost, status: http.StatusBadGateway, hits: 1},
+		{name: "post retried when allowed", api: API{Retries: 2, RetryNonIdempotent: true}, method: http.MethodPost, status: http.StatusOK, hits: 3},
+		{name: "body not replayable", api: API{Retries: 2}, method: http.MethodPut, body: "data", status: http.StatusBadGateway, hits: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&hits, 1) > 2 {
+					w.Write([]byte("ok"))
+					return
+				}
+				if tt.failWith != 0 {
+					w.WriteHeader(tt.failWith)
+					return
+				}
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			})
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			api := tt.api
+			api.Name, api.HTTPMethod, api.Host, api.Path, api.RetryBackoffMs = "flaky", method, backend, "flaky", 1
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", &api))
+			req := httptest.NewRequest(method, "/svc/flaky", nil)
+			if tt.body != "" {
+				req = httptest.NewRequest(method, "/svc/flaky", strings.NewReader(tt.body))
+			}
+			rec := serveProxy(gateway, req)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+			if got := atomic.LoadInt32(&hits); got != tt.hits {
+				t.Errorf("backend hit %d times, want %d", got, tt.hits)
+			}
+		})
+	}
+}
+
+func TestRetryOtherHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		failWith int // status of the failing host, zero drop the connection
+		api      API
+		hits     []int32 // of the failing hosts then the healthy one
+	}{
+		{name: "connection error", api: API{Retries: 1}, hits: []int32{1, 1}},
+		{name: "streamed 503", failWith: http.StatusServiceUnavailable, api: API{Retries: 1}, hits: []int32{1, 1}},
+		{name: "buffered 503", failWith: http.StatusServiceUnavailable, api: API{Retries: 1, ResponseMode: ResponseBuffered}, hits: []int32{1, 1}},
+		{name: "every failing host tried", failWith: http.StatusServiceUnavailable, api: API{Retries: 2}, hits: []int32{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := make([]int32, len(tt.hits))
+			var hosts []string
+			for i := range tt.hits {
+				i := i
+				hosts = append(hosts, newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&hits[i], 1)
+					if i == len(tt.hits)-1 {
+						w.Write([]byte("ok"))
+						return
+					}
+					if tt.failWith != 0 {
+						w.WriteHeader(tt.failWith)
+						return
+					}
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+				}))
+			}
+			api := tt.api
+			api.Name, api.HTTPMethod, api.Hosts, api.Path, api.RetryBackoffMs = "flaky", http.MethodGet, hosts, "flaky", 1
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", &api))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/flaky", nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+				t.Errorf("got %d %q, want the healthy host response", rec.Code, rec.Body.String())
+			}
+			for i := range hits {
+				if got := atomic.LoadInt32(&hits[i]); got != tt.hits[i] {
+					t.Errorf("host %d hit %d times, want %d", i, got, tt.hits[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		backoffMs int
+		attempt   int
+		max       time.Duration
+	}{
+		{name: "default base", attempt: 0, max: DefaultRetryBackoff},
+		{name: "configured base", backoffMs: 10, attempt: 0, max: 10 * time.Millisecond},
+		{name: "doubling", backoffMs: 10, attempt: 3, max: 80 * time.Millisecond},
+		{name: "capped", backoffMs: 10, attempt: 20, max: maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{RetryBackoffMs: tt.backoffMs}
+			var longest time.Duration
+			for i := 0; i < 200; i++ {
+				d := retryBackoff(api, tt.attempt)
+				if d < 0 || d > tt.max {
+					t.Fatalf("backoff %v out of [0, %v]", d, tt.max)
+				}
+				if d > longest {
+					longest = d
+				}
+			}
+			// full jitter spread the waits over the whole range
+			if longest < tt.max/2 {
+				t.Errorf("longest backoff %v of 200, want close to %v", longest, tt.max)
+			}
+		})
+	}
+}
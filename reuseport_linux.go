@@ -0,0 +1,22 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package gateway
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT on linux, syscall package does not export it on every arch
+const soReusePort = 0xf
+
+// reusePortControl enable SO_REUSEPORT so that a new process can bind the same address
+// while the old one drains, the kernel balances new connections between the listeners
+func reusePortControl(network, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
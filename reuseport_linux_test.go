@@ -0,0 +1,53 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package gateway
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReusePortListeners(t *testing.T) {
+	tests := []struct {
+		name      string
+		reusePort bool
+		wantErr   bool
+	}{
+		{name: "reuse port", reusePort: true},
+		{name: "exclusive", reusePort: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			gateway.ReusePort = tt.reusePort
+			first, err := gateway.listen("127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("first listen: %v", err)
+			}
+			defer first.Close()
+			second, err := gateway.listen(first.Addr().String())
+			if tt.wantErr {
+				if err == nil {
+					second.Close()
+					t.Fatalf("second listener bound without SO_REUSEPORT")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("second listen: %v", err)
+			}
+			defer second.Close()
+			if second.Addr().String() != first.Addr().String() {
+				t.Errorf("second bound %v, want %v", second.Addr(), first.Addr())
+			}
+			// the old listener closing leave the new one serving
+			first.Close()
+			conn, err := net.Dial("tcp", second.Addr().String())
+			if err != nil {
+				t.Fatalf("dial after handoff: %v", err)
+			}
+			conn.Close()
+		})
+	}
+}
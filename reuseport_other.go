@@ -0,0 +1,14 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+// +build !linux mips mipsle mips64 mips64le
+
+package gateway
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// reusePortControl SO_REUSEPORT listener handoff is only supported on linux
+func reusePortControl(network, address string, conn syscall.RawConn) error {
+	return fmt.Errorf("listen %v: SO_REUSEPORT not supported on this platform", address)
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RewriteRule replace the backend path matching Pattern with Replacement, which may refer
+// to capture groups as $1 or ${name}
+type RewriteRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// pathRewrite is a compiled RewriteRule
+type pathRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// normalizeRewrites compile the RewriteRules of api
+func normalizeRewrites(api *API) error {
+	api.rewrites = nil
+	for i, rule := range api.RewriteRules {
+		if rule.Pattern == "" {
+			return fmt.Errorf("api: %v rewrite rule %d pattern can not be empty", api.Name, i)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("api: %v rewrite rule %d pattern invalid: %v", api.Name, i, err)
+		}
+		api.rewrites = append(api.rewrites, pathRewrite{pattern: pattern, replacement: rule.Replacement})
+	}
+	return nil
+}
+
+// rewritePath apply the first rewrite rule of api matching backend path p, p is returned
+// as is when none matches
+func rewritePath(api *API, p string) string {
+	for _, rewrite := range api.rewrites {
+		if !rewrite.pattern.MatchString(p) {
+			continue
+		}
+		p = rewrite.pattern.ReplaceAllString(p, rewrite.replacement)
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		return p
+	}
+	return p
+}
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRewriteRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []RewriteRule
+		target   string
+		expected string // request uri received by backend
+	}{
+		{name: "capture group", rules: []RewriteRule{{Pattern: "^/v1/(.*)$", Replacement: "/internal/$1"}}, target: "/svc/get/foo", expected: "/internal/foo"},
+		{name: "named group", rules: []RewriteRule{{Pattern: "^/v1/users/(?P<id>[0-9]+)$", Replacement: "/people/${id}/profile"}}, target: "/svc/get/users/42", expected: "/people/42/profile"},
+		{name: "no match", rules: []RewriteRule{{Pattern: "^/v2/(.*)$", Replacement: "/internal/$1"}}, target: "/svc/get/foo", expected: "/v1/foo"},
+		{
+			name: "first match wins",
+			rules: []RewriteRule{
+				{Pattern: "^/v1/admin/(.*)$", Replacement: "/admin/$1"},
+				{Pattern: "^/v1/(.*)$", Replacement: "/internal/$1"},
+			},
+			target:   "/svc/get/admin/x",
+			expected: "/admin/x",
+		},
+		{name: "leading slash added", rules: []RewriteRule{{Pattern: "^/v1/(.*)$", Replacement: "$1"}}, target: "/svc/get/foo", expected: "/foo"},
+		{name: "query kept", rules: []RewriteRule{{Pattern: "^/v1/(.*)$", Replacement: "/internal/$1"}}, target: "/svc/get/foo?page=2", expected: "/internal/foo?page=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 1)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				received <- r.RequestURI
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "v1", CatchRemainder: true, RewriteRules: tt.rules}))
+			if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.target, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := <-received; got != tt.expected {
+				t.Errorf("backend got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRewriteRulesRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []RewriteRule
+		errSub string
+	}{
+		{name: "invalid regex", rules: []RewriteRule{{Pattern: "^/v1/(.*$", Replacement: "/$1"}}, errSub: "rewrite rule 0 pattern invalid"},
+		{name: "empty pattern", rules: []RewriteRule{{Pattern: "^/a$", Replacement: "/b"}, {Replacement: "/b"}}, errSub: "rewrite rule 1 pattern can not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc"))
+			err := gateway.Discovery.CreateAPI(&API{Service: "svc", Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "v1", RewriteRules: tt.rules})
+			if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+				t.Errorf("error %v, want %q", err, tt.errSub)
+			}
+		})
+	}
+}
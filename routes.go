@@ -0,0 +1,173 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RouteEntry describe one entry of the routing table
+type RouteEntry struct {
+	Pattern     string              `json:"pattern"`               // matched request path
+	Priority    int                 `json:"priority"`              // alias hops taken to reach the service, 0 is direct
+	Service     string              `json:"service"`               // resolved service name
+	API         string              `json:"api"`                   // api name
+	Methods     []string            `json:"methods"`               // allowed http methods, empty allow any
+	Backend     string              `json:"backend"`               // default backend host
+	Hosts       []string            `json:"hosts,omitempty"`       // hosts shared in round-robin
+	RegionHosts map[string][]string `json:"regionHosts,omitempty"` // region preferred backend hosts
+	Via         string              `json:"via,omitempty"`         // alias the route is reached through
+}
+
+// routeSnapshot is implemented by discoveries able to enumerate services and aliases
+type routeSnapshot interface {
+	snapshot() (services []*Service, aliases map[string]string)
+}
+
+// snapshot copy services and aliases of cache
+func (c *cache) snapshot() ([]*Service, map[string]string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	services := make([]*Service, 0, len(c.store))
+	for _, service := range c.store {
+		copied := *service
+		copied.APIs = make(map[string]*API, len(service.APIs))
+		for name, api := range service.APIs {
+			copied.APIs[name] = api
+		}
+		services = append(services, &copied)
+	}
+	aliases := make(map[string]string, len(c.aliases))
+	for alias, target := range c.aliases {
+		aliases[alias] = target
+	}
+	return services, aliases
+}
+
+// routeTable build the routing table in the order requests are resolved
+func routeTable(services []*Service, aliases map[string]string) []RouteEntry {
+	byName := make(map[string]*Service, len(services))
+	for _, service := range services {
+		byName[service.Name] = service
+	}
+	var entries []RouteEntry
+	addRoutes := func(name, via string, priority int, service *Service) {
+		for _, api := range service.APIs {
+			entries = append(entries, RouteEntry{
+				Pattern:     fmt.Sprintf("/%v/%v", name, api.Name),
+				Priority:    priority,
+				Service:     service.Name,
+				API:         api.Name,
+				Methods:     append([]string{}, api.methods...),
+				Backend:     api.Host,
+				Hosts:       api.Hosts,
+				RegionHosts: api.RegionHosts,
+				Via:         via,
+			})
+		}
+	}
+	for _, service := range services {
+		addRoutes(service.Name, "", 0, service)
+	}
+	for alias := range aliases {
+		// follow the alias chain the same way discovery resolves it
+		target, hops := alias, 0
+		for {
+			next, exist := aliases[target]
+			if !exist || hops > len(aliases) {
+				break
+			}
+			target = next
+			hops++
+		}
+		if service, exist := byName[target]; exist {
+			addRoutes(alias, alias, hops, service)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Priority != entries[j].Priority {
+			return entries[i].Priority < entries[j].Priority
+		}
+		return entries[i].Pattern < entries[j].Pattern
+	})
+	return entries
+}
+
+// Routes handle http request to show the routing table
+func (gateway *APIGateway) Routes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	lister, ok := gateway.Discovery.(routeSnapshot)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": errDiscoveryNotListable.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, routeTable(lister.snapshot()))
+}
+
+// RouteTestResult describe where a request path would be proxied, Error tell why it would not
+type RouteTestResult struct {
+	Path    string `json:"path"`
+	Service string `json:"service,omitempty"` // resolved service name
+	API     string `json:"api,omitempty"`     // resolved api name
+	Backend string `json:"backend,omitempty"` // backend host the request would be sent to
+	URL     string `json:"url,omitempty"`     // upstream url after rewrites
+	Error   string `json:"error,omitempty"`
+}
+
+// RouteTest handle http request to resolve the path query parameter as the proxy would,
+// without proxying, headers and cookies of the request take part in region, tag and sticky
+// routing, the backend a real request would get next is reported while the balancers keep
+// their state
+func (gateway *APIGateway) RouteTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("http method %v not support", r.Method))
+		return
+	}
+	path := r.URL.Query().Get("path")
+	result := RouteTestResult{Path: path}
+	fail := func(status int, format string, args ...interface{}) {
+		result.Error = fmt.Sprintf(format, args...)
+		writeJSON(w, status, result)
+	}
+	target, err := url.Parse(path)
+	if path == "" || err != nil || !strings.HasPrefix(target.Path, "/") || target.Host != "" {
+		fail(http.StatusBadRequest, "path: %q should be a request path such as /{service}/{api}", path)
+		return
+	}
+	rt, err := gateway.lookup(target.Path)
+	if err != nil {
+		fail(http.StatusNotFound, "%v", err)
+		return
+	}
+	api := rt.api
+	result.Service, result.API = rt.service.Name, api.Name
+	if rt.remainder != "" && !gateway.catchRemainder(api) {
+		fail(http.StatusNotFound, "api: %v does not catch remainder: %v", api.Name, rt.remainder)
+		return
+	}
+	if apiPath, _ := api.backendPath(); (rt.remainder != "" || len(api.rewrites) > 0) &&
+		!pathAllowed(rt.service.AllowedPaths, strings.TrimPrefix(rewritePath(api, "/"+apiPath+rt.remainder), "/")) {
+		fail(http.StatusForbidden, "path: %v not allowed", target.Path)
+		return
+	}
+	req := r.Clone(r.Context())
+	req.URL = target
+	req.RequestURI = target.RequestURI()
+	rt.backend, _ = gateway.stickyHost(req, api, true)
+	if rt.backend == "" {
+		fail(http.StatusServiceUnavailable, "api: %v has no backend", api.Name)
+		return
+	}
+	result.Backend = rt.backend
+	// the upstream url is built exactly as for proxied requests
+	gateway.backendURL(req.URL, rt)
+	result.URL = req.URL.String()
+	writeJSON(w, http.StatusOK, result)
+}
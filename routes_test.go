@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRouteTable(t *testing.T) {
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway,
+		newTestService("user",
+			&API{Name: "get", HTTPMethod: http.MethodGet, Host: "10.0.0.1:80", Path: "get"},
+			&API{Name: "create", HTTPMethods: []string{"post", "put"}, Hosts: []string{"10.0.0.2:80", "10.0.0.3:80"}, Path: "create"}),
+		newTestService("order", &API{Name: "list", Host: "10.0.0.4:80", Path: "list"}))
+	// member alias account, which must exist first
+	for _, alias := range [][2]string{{"account", "user"}, {"member", "account"}} {
+		if err := gateway.Discovery.CreateAlias(alias[0], alias[1]); err != nil {
+			t.Fatalf("create alias: %v", err)
+		}
+	}
+	rec := serveAdmin(gateway, http.MethodGet, "/routes", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var entries []RouteEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	type route struct {
+		Pattern  string
+		Priority int
+		Service  string
+		Methods  []string
+		Backend  string
+		Via      string
+	}
+	want := []route{
+		{Pattern: "/order/list", Service: "order", Methods: []string{}, Backend: "10.0.0.4:80"},
+		{Pattern: "/user/create", Service: "user", Methods: []string{"POST", "PUT"}, Backend: "10.0.0.2:80"},
+		{Pattern: "/user/get", Service: "user", Methods: []string{"GET"}, Backend: "10.0.0.1:80"},
+		{Pattern: "/account/create", Priority: 1, Service: "user", Methods: []string{"POST", "PUT"}, Backend: "10.0.0.2:80", Via: "account"},
+		{Pattern: "/account/get", Priority: 1, Service: "user", Methods: []string{"GET"}, Backend: "10.0.0.1:80", Via: "account"},
+		{Pattern: "/member/create", Priority: 2, Service: "user", Methods: []string{"POST", "PUT"}, Backend: "10.0.0.2:80", Via: "member"},
+		{Pattern: "/member/get", Priority: 2, Service: "user", Methods: []string{"GET"}, Backend: "10.0.0.1:80", Via: "member"},
+	}
+	got := make([]route, 0, len(entries))
+	for _, entry := range entries {
+		got = append(got, route{entry.Pattern, entry.Priority, entry.Service, entry.Methods, entry.Backend, entry.Via})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("routes\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestRoutesMethodNotAllowed(t *testing.T) {
+	rec := serveAdmin(newTestGateway(t), http.MethodPost, "/routes", "")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("got %d Allow %q, want 405 Allow GET", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestRouteTest(t *testing.T) {
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("user",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: "10.0.0.1:80", Path: "v1/get"},
+		&API{Name: "files", HTTPMethod: http.MethodGet, Host: "10.0.0.2:80", Path: "files", CatchRemainder: true}))
+	if err := gateway.Discovery.CreateAlias("account", "user"); err != nil {
+		t.Fatalf("create alias: %v", err)
+	}
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected RouteTestResult // Error is only checked to be set for failures
+	}{
+		{
+			name:     "resolvable",
+			path:     "/user/get?id=1",
+			status:   http.StatusOK,
+			expected: RouteTestResult{Service: "user", API: "get", Backend: "10.0.0.1:80", URL: "http://10.0.0.1:80/v1/get?id=1"},
+		},
+		{
+			name:     "remainder",
+			path:     "/user/files/a/b.txt",
+			status:   http.StatusOK,
+			expected: RouteTestResult{Service: "user", API: "files", Backend: "10.0.0.2:80", URL: "http://10.0.0.2:80/files/a/b.txt"},
+		},
+		{
+			name:     "alias",
+			path:     "/account/get",
+			status:   http.StatusOK,
+			expected: RouteTestResult{Service: "user", API: "get", Backend: "10.0.0.1:80", URL: "http://10.0.0.1:80/v1/get"},
+		},
+		{name: "unknown service", path: "/order/list", status: http.StatusNotFound},
+		{name: "unknown api", path: "/user/list", status: http.StatusNotFound},
+		{name: "remainder not caught", path: "/user/get/extra", status: http.StatusNotFound, expected: RouteTestResult{Service: "user", API: "get"}},
+		{name: "missing path", path: "", status: http.StatusBadRequest},
+		{name: "relative path", path: "user/get", status: http.StatusBadRequest},
+		{name: "absolute url", path: "http://example.com/user/get", status: http.StatusBadRequest},
+		{name: "malformed escape", path: "/user/%zz", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAdmin(gateway, http.MethodGet, "/routeTest?path="+url.QueryEscape(tt.path), "")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			var result RouteTestResult
+			mustDecode(t, rec.Body.Bytes(), &result)
+			if (result.Error != "") != (tt.status != http.StatusOK) {
+				t.Errorf("error %q for status %d", result.Error, rec.Code)
+			}
+			result.Error = ""
+			tt.expected.Path = tt.path
+			if result != tt.expected {
+				t.Errorf("result %+v, want %+v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRouteTestKeepBalancer(t *testing.T) {
+	first, second := namedBackend(t, "first"), namedBackend(t, "second")
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Hosts: []string{first, second}, Path: "get"}))
+	// each step either ask routeTest or proxy a request, expected is the backend reported or reached
+	tests := []struct {
+		name     string
+		proxy    bool
+		expected string
+	}{
+		{name: "route test", expected: first},
+		{name: "route test again", expected: first},
+		{name: "proxied", proxy: true, expected: "first"},
+		{name: "route test after proxying", expected: second},
+		{name: "proxied next", proxy: true, expected: "second"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.proxy {
+				if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil)); rec.Body.String() != tt.expected {
+					t.Errorf("proxied to %q, want %q", rec.Body.String(), tt.expected)
+				}
+				return
+			}
+			var result RouteTestResult
+			mustDecode(t, serveAdmin(gateway, http.MethodGet, "/routeTest?path=/svc/get", "").Body.Bytes(), &result)
+			if result.Backend != tt.expected {
+				t.Errorf("backend %q, want %q", result.Backend, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRouteTestMethodNotAllowed(t *testing.T) {
+	rec := serveAdmin(newTestGateway(t), http.MethodPost, "/routeTest?path=/svc/get", "")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("got %d Allow %q, want 405 Allow GET", rec.Code, rec.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,437 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxValidateBodyBytes bound the request body buffered for schema validation
+const maxValidateBodyBytes = 10 << 20
+
+// validateRequest validate request body against api schema and re-attach the body for proxying,
+// return false when the response has been written
+func (gateway *APIGateway) validateRequest(w http.ResponseWriter, r *http.Request, api *API) bool {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxValidateBodyBytes+1))
+	r.Body.Close()
+	if errors.Is(err, errRequestTooLarge) {
+		gateway.writeError(w, r, http.StatusRequestEntityTooLarge, errRequestTooLarge.Error())
+		return false
+	}
+	if err != nil {
+		gateway.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("read request body failed: %v", err))
+		return false
+	}
+	if len(data) > maxValidateBodyBytes {
+		gateway.writeError(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+		return false
+	}
+	if details := api.schema.validateBody(data); len(details) > 0 {
+		gateway.writeError(w, r, http.StatusBadRequest, "request body does not match schema", details...)
+		return false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	// the buffered body can be sent again by retries
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.ContentLength = int64(len(data))
+	r.TransferEncoding = nil
+	return true
+}
+
+// jsonSchema is a compiled subset of JSON Schema (draft 7) used to validate request bodies,
+// supported keywords: type, enum, const, properties, required, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, allOf, anyOf, oneOf, not
+type jsonSchema struct {
+	Types                []string
+	Enum                 []interface{}
+	Const                *interface{}
+	Properties           map[string]*jsonSchema
+	Required             []string
+	AdditionalProperties *jsonSchema
+	NoAdditional         bool
+	Items                *jsonSchema
+	MinItems             *int
+	MaxItems             *int
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	Minimum              *float64
+	Maximum              *float64
+	ExclusiveMinimum     *float64
+	ExclusiveMaximum     *float64
+	AllOf                []*jsonSchema
+	AnyOf                []*jsonSchema
+	OneOf                []*jsonSchema
+	Not                  *jsonSchema
+	reject               bool // schema false
+}
+
+// compileRequestSchema compile api RequestSchema which is an inline schema object
+// or a string referencing a schema file
+func compileRequestSchema(raw json.RawMessage) (*jsonSchema, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if raw[0] == '"' {
+		var path string
+		if err := json.Unmarshal(raw, &path); err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read schema file failed: %v", err)
+		}
+		raw = data
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshal schema failed: %v", err)
+	}
+	return compileSchema(doc, "#")
+}
+
+// compileSchema compile decoded schema document at location
+func compileSchema(doc interface{}, location string) (*jsonSchema, error) {
+	switch v := doc.(type) {
+	case bool:
+		return &jsonSchema{reject: !v}, nil
+	case map[string]interface{}:
+		return compileSchemaObject(v, location)
+	}
+	return nil, fmt.Errorf("schema %v: must be an object or boolean", location)
+}
+
+func compileSchemaObject(doc map[string]interface{}, location string) (*jsonSchema, error) {
+	schema := &jsonSchema{}
+	var err error
+	if t, exist := doc["type"]; exist {
+		switch v := t.(type) {
+		case string:
+			schema.Types = []string{v}
+		case []interface{}:
+			for _, item := range v {
+				name, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("schema %v/type: must be string or array of strings", location)
+				}
+				schema.Types = append(schema.Types, name)
+			}
+		default:
+			return nil, fmt.Errorf("schema %v/type: must be string or array of strings", location)
+		}
+		for _, name := range schema.Types {
+			switch name {
+			case "null", "boolean", "object", "array", "number", "integer", "string":
+			default:
+				return nil, fmt.Errorf("schema %v/type: unknown type %v", location, name)
+			}
+		}
+	}
+	if e, exist := doc["enum"]; exist {
+		values, ok := e.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("schema %v/enum: must be an array", location)
+		}
+		schema.Enum = values
+	}
+	if c, exist := doc["const"]; exist {
+		schema.Const = &c
+	}
+	if p, exist := doc["properties"]; exist {
+		props, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("schema %v/properties: must be an object", location)
+		}
+		schema.Properties = make(map[string]*jsonSchema, len(props))
+		for name, sub := range props {
+			if schema.Properties[name], err = compileSchema(sub, location+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r, exist := doc["required"]; exist {
+		names, ok := r.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("schema %v/required: must be an array of strings", location)
+		}
+		for _, item := range names {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("schema %v/required: must be an array of strings", location)
+			}
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	if a, exist := doc["additionalProperties"]; exist {
+		if allowed, ok := a.(bool); ok {
+			schema.NoAdditional = !allowed
+		} else if schema.AdditionalProperties, err = compileSchema(a, location+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if i, exist := doc["items"]; exist {
+		if schema.Items, err = compileSchema(i, location+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, target := range map[string]**int{
+		"minItems": &schema.MinItems, "maxItems": &schema.MaxItems,
+		"minLength": &schema.MinLength, "maxLength": &schema.MaxLength,
+	} {
+		if v, exist := doc[keyword]; exist {
+			n, ok := v.(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("schema %v/%v: must be a non-negative integer", location, keyword)
+			}
+			count := int(n)
+			*target = &count
+		}
+	}
+	for keyword, target := range map[string]**float64{
+		"minimum": &schema.Minimum, "maximum": &schema.Maximum,
+		"exclusiveMinimum": &schema.ExclusiveMinimum, "exclusiveMaximum": &schema.ExclusiveMaximum,
+	} {
+		if v, exist := doc[keyword]; exist {
+			n, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("schema %v/%v: must be a number", location, keyword)
+			}
+			*target = &n
+		}
+	}
+	if p, exist := doc["pattern"]; exist {
+		expr, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("schema %v/pattern: must be a string", location)
+		}
+		if schema.Pattern, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("schema %v/pattern: %v", location, err)
+		}
+	}
+	for keyword, target := range map[string]*[]*jsonSchema{
+		"allOf": &schema.AllOf, "anyOf": &schema.AnyOf, "oneOf": &schema.OneOf,
+	} {
+		if v, exist := doc[keyword]; exist {
+			subs, ok := v.([]interface{})
+			if !ok || len(subs) == 0 {
+				return nil, fmt.Errorf("schema %v/%v: must be a non-empty array", location, keyword)
+			}
+			for i, sub := range subs {
+				compiled, err := compileSchema(sub, fmt.Sprintf("%v/%v/%d", location, keyword, i))
+				if err != nil {
+					return nil, err
+				}
+				*target = append(*target, compiled)
+			}
+		}
+	}
+	if n, exist := doc["not"]; exist {
+		if schema.Not, err = compileSchema(n, location+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// validateBody validate json body against schema, return the violations found
+func (schema *jsonSchema) validateBody(body []byte) []string {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []string{fmt.Sprintf("invalid json: %v", err)}
+	}
+	return schema.validate(value, "")
+}
+
+// validate value at JSON pointer path
+func (schema *jsonSchema) validate(value interface{}, path string) []string {
+	if schema.reject {
+		return []string{fmt.Sprintf("%v: not allowed", pointer(path))}
+	}
+	var errs []string
+	if len(schema.Types) > 0 && !matchType(value, schema.Types) {
+		return []string{fmt.Sprintf("%v: expected %v, got %v", pointer(path), strings.Join(schema.Types, " or "), typeOf(value))}
+	}
+	if schema.Const != nil && !jsonEqual(value, *schema.Const) {
+		errs = append(errs, fmt.Sprintf("%v: must be %v", pointer(path), encode(*schema.Const)))
+	}
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, candidate := range schema.Enum {
+			if jsonEqual(value, candidate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%v: must be one of %v", pointer(path), encode(schema.Enum)))
+		}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		errs = append(errs, schema.validateObject(v, path)...)
+	case []interface{}:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			errs = append(errs, fmt.Sprintf("%v: must have at least %d items", pointer(path), *schema.MinItems))
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			errs = append(errs, fmt.Sprintf("%v: must have at most %d items", pointer(path), *schema.MaxItems))
+		}
+		if schema.Items != nil {
+			for i, item := range v {
+				errs = append(errs, schema.Items.validate(item, fmt.Sprintf("%v/%d", path, i))...)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			errs = append(errs, fmt.Sprintf("%v: must be at least %d characters", pointer(path), *schema.MinLength))
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			errs = append(errs, fmt.Sprintf("%v: must be at most %d characters", pointer(path), *schema.MaxLength))
+		}
+		if schema.Pattern != nil && !schema.Pattern.MatchString(v) {
+			errs = append(errs, fmt.Sprintf("%v: must match pattern %v", pointer(path), schema.Pattern))
+		}
+	case float64:
+		if schema.Minimum != nil && v < *schema.Minimum {
+			errs = append(errs, fmt.Sprintf("%v: must be >= %v", pointer(path), *schema.Minimum))
+		}
+		if schema.Maximum != nil && v > *schema.Maximum {
+			errs = append(errs, fmt.Sprintf("%v: must be <= %v", pointer(path), *schema.Maximum))
+		}
+		if schema.ExclusiveMinimum != nil && v <= *schema.ExclusiveMinimum {
+			errs = append(errs, fmt.Sprintf("%v: must be > %v", pointer(path), *schema.ExclusiveMinimum))
+		}
+		if schema.ExclusiveMaximum != nil && v >= *schema.ExclusiveMaximum {
+			errs = append(errs, fmt.Sprintf("%v: must be < %v", pointer(path), *schema.ExclusiveMaximum))
+		}
+	}
+	for _, sub := range schema.AllOf {
+		errs = append(errs, sub.validate(value, path)...)
+	}
+	if len(schema.AnyOf) > 0 {
+		matched := false
+		for _, sub := range schema.AnyOf {
+			if len(sub.validate(value, path)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, fmt.Sprintf("%v: must match at least one schema of anyOf", pointer(path)))
+		}
+	}
+	if len(schema.OneOf) > 0 {
+		matched := 0
+		for _, sub := range schema.OneOf {
+			if len(sub.validate(value, path)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			errs = append(errs, fmt.Sprintf("%v: must match exactly one schema of oneOf, matched %d", pointer(path), matched))
+		}
+	}
+	if schema.Not != nil && len(schema.Not.validate(value, path)) == 0 {
+		errs = append(errs, fmt.Sprintf("%v: must not match schema of not", pointer(path)))
+	}
+	return errs
+}
+
+func (schema *jsonSchema) validateObject(object map[string]interface{}, path string) []string {
+	var errs []string
+	for _, name := range schema.Required {
+		if _, exist := object[name]; !exist {
+			errs = append(errs, fmt.Sprintf("%v: missing required property %v", pointer(path), name))
+		}
+	}
+	// validate in stable order so details are reproducible
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		childPath := path + "/" + escapePointer(name)
+		if sub, exist := schema.Properties[name]; exist {
+			errs = append(errs, sub.validate(object[name], childPath)...)
+			continue
+		}
+		if schema.NoAdditional {
+			errs = append(errs, fmt.Sprintf("%v: additional property not allowed", pointer(childPath)))
+		} else if schema.AdditionalProperties != nil {
+			errs = append(errs, schema.AdditionalProperties.validate(object[name], childPath)...)
+		}
+	}
+	return errs
+}
+
+// matchType report whether value is one of the json types
+func matchType(value interface{}, types []string) bool {
+	actual := typeOf(value)
+	for _, name := range types {
+		if name == actual {
+			return true
+		}
+		if name == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf return the json type name of decoded value
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	}
+	return "unknown"
+}
+
+// jsonEqual compare decoded json values
+func jsonEqual(a, b interface{}) bool {
+	return encode(a) == encode(b)
+}
+
+func encode(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func escapePointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
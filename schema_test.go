@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testUserSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 8},
+		"age": {"type": "integer", "minimum": 0},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "maxItems": 2}
+	}
+}`
+
+func TestRequestSchemaValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		detail string // expected in the error details
+	}{
+		{name: "valid", body: `{"name":"ann","age":30}`, status: http.StatusOK},
+		{name: "valid optional fields", body: `{"name":"ann","age":0,"role":"admin","tags":["a","b"]}`, status: http.StatusOK},
+		{name: "missing required", body: `{"name":"ann"}`, status: http.StatusBadRequest, detail: "age"},
+		{name: "wrong type", body: `{"name":"ann","age":"thirty"}`, status: http.StatusBadRequest, detail: "age"},
+		{name: "not integer", body: `{"name":"ann","age":1.5}`, status: http.StatusBadRequest, detail: "age"},
+		{name: "below minimum", body: `{"name":"ann","age":-1}`, status: http.StatusBadRequest, detail: "age"},
+		{name: "too long", body: `{"name":"annabellee","age":1}`, status: http.StatusBadRequest, detail: "name"},
+		{name: "not in enum", body: `{"name":"ann","age":1,"role":"root"}`, status: http.StatusBadRequest, detail: "role"},
+		{name: "pattern", body: `{"name":"ann","age":1,"tags":["A1"]}`, status: http.StatusBadRequest, detail: "tags"},
+		{name: "too many items", body: `{"name":"ann","age":1,"tags":["a","b","c"]}`, status: http.StatusBadRequest, detail: "tags"},
+		{name: "additional property", body: `{"name":"ann","age":1,"admin":true}`, status: http.StatusBadRequest, detail: "admin"},
+		{name: "malformed json", body: `{"name":`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			var received string
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				body, _ := ioutil.ReadAll(r.Body)
+				received = string(body)
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("user", &API{
+				Name:          "create",
+				HTTPMethod:    http.MethodPost,
+				Host:          backend,
+				Path:          "users",
+				RequestSchema: json.RawMessage(testUserSchema),
+			}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodPost, "/user/create", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				if received != tt.body {
+					t.Errorf("backend got %q, want %q", received, tt.body)
+				}
+				return
+			}
+			if atomic.LoadInt32(&hits) != 0 {
+				t.Errorf("invalid payload reached the backend")
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("error body %q: %v", rec.Body.String(), err)
+			}
+			if tt.detail != "" && !strings.Contains(strings.Join(resp.Details, "\n"), tt.detail) {
+				t.Errorf("details %q do not mention %v", resp.Details, tt.detail)
+			}
+		})
+	}
+}
+
+func TestRequestSchemaRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{name: "malformed", schema: `{"type":`},
+		{name: "unknown type", schema: `{"type": "date"}`},
+		{name: "bad pattern", schema: `{"type": "string", "pattern": "("}`},
+		{name: "missing file", schema: `"/nonexistent/schema.json"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("user", &API{
+				Name:          "create",
+				HTTPMethod:    http.MethodPost,
+				Host:          "127.0.0.1:1",
+				Path:          "users",
+				RequestSchema: json.RawMessage(tt.schema),
+			}))
+			if err == nil {
+				t.Errorf("invalid schema accepted")
+			}
+		})
+	}
+}
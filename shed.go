@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// shedWindow only latencies observed within window count to p99
+	shedWindow = 10 * time.Second
+	// shedRecompute bound how often p99 is recomputed
+	shedRecompute = 100 * time.Millisecond
+	// shedMaxSamples bound the samples kept in window
+	shedMaxSamples = 2048
+	// shedMaxRate always let some requests through to measure recovery
+	shedMaxRate = 0.9
+)
+
+// latencySample is a latency observed at a time
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// loadShedder reject a fraction of new requests while recent p99 latency exceeds the SLA
+type loadShedder struct {
+	sla      time.Duration
+	mu       sync.Mutex
+	samples  []latencySample
+	next     int
+	computed time.Time
+	p99      time.Duration
+	rate     float64
+	random   *rand.Rand
+}
+
+// newLoadShedder create load shedder for latency SLA
+func newLoadShedder(sla time.Duration) *loadShedder {
+	return &loadShedder{sla: sla, random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// observe record the latency of a proxied request
+func (s *loadShedder) observe(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sample := latencySample{at: time.Now(), latency: latency}
+	if len(s.samples) < shedMaxSamples {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[s.next] = sample
+		s.next = (s.next + 1) % shedMaxSamples
+	}
+}
+
+// shed report whether the new request should be rejected
+func (s *loadShedder) shed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.computed) >= shedRecompute {
+		s.recompute(now)
+	}
+	return s.rate > 0 && s.random.Float64() < s.rate
+}
+
+// recompute p99 from samples in window and move the shed rate towards the overshoot
+// of the SLA, must be called with lock held
+func (s *loadShedder) recompute(now time.Time) {
+	s.computed = now
+	latencies := make([]time.Duration, 0, len(s.samples))
+	for _, sample := range s.samples {
+		if now.Sub(sample.at) <= shedWindow {
+			latencies = append(latencies, sample.latency)
+		}
+	}
+	s.p99 = 0
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		s.p99 = latencies[(len(latencies)*99)/100]
+	}
+	target := 0.0
+	if s.p99 > s.sla {
+		target = 1 - float64(s.sla)/float64(s.p99)
+		if target > shedMaxRate {
+			target = shedMaxRate
+		}
+	}
+	// smooth the rate so shedding does not flap between all and nothing
+	s.rate = (s.rate + target) / 2
+	if s.rate < 0.01 {
+		s.rate = 0
+	}
+}
+
+// current return the current shed rate and recent p99 latency
+func (s *loadShedder) current() (float64, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate, s.p99
+}
+
+// shedder return the load shedder, nil when LatencySLA is disabled
+func (gateway *APIGateway) shedder() *loadShedder {
+	if gateway.LatencySLA <= 0 {
+		return nil
+	}
+	gateway.shedOnce.Do(func() {
+		gateway.loadShedder = newLoadShedder(gateway.LatencySLA)
+	})
+	return gateway.loadShedder
+}
+
+// ShedRate return the fraction of requests currently rejected by load shedding
+func (gateway *APIGateway) ShedRate() float64 {
+	shedder := gateway.shedder()
+	if shedder == nil {
+		return 0
+	}
+	rate, _ := shedder.current()
+	return rate
+}
+
+// shedLoad write 503 and return true when the request is shed
+func (gateway *APIGateway) shedLoad(w http.ResponseWriter, r *http.Request) bool {
+	shedder := gateway.shedder()
+	if shedder == nil || !shedder.shed() {
+		return false
+	}
+	gateway.throttle(w, r, http.StatusServiceUnavailable, "gateway overloaded, request shed", 0)
+	return true
+}
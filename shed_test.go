@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadShedderLatencySpikes(t *testing.T) {
+	sla := 100 * time.Millisecond
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		rounds    int // recomputations with the same samples
+		age       time.Duration
+		minRate   float64
+		maxRate   float64
+	}{
+		{name: "within sla", latencies: repeatLatency(50*time.Millisecond, 200), rounds: 10, maxRate: 0},
+		{name: "spike of 4x sla", latencies: repeatLatency(400*time.Millisecond, 200), rounds: 1, minRate: 0.37, maxRate: 0.38},
+		{name: "sustained 4x sla", latencies: repeatLatency(400*time.Millisecond, 200), rounds: 20, minRate: 0.74, maxRate: 0.75},
+		{name: "extreme spike capped", latencies: repeatLatency(10*time.Second, 200), rounds: 20, minRate: 0.89, maxRate: shedMaxRate},
+		{name: "outliers below p99", latencies: append(repeatLatency(10*time.Millisecond, 199), time.Second), rounds: 10, maxRate: 0},
+		{name: "spike aged out", latencies: repeatLatency(400*time.Millisecond, 200), rounds: 10, age: shedWindow + time.Second, maxRate: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shedder := newLoadShedder(sla)
+			for _, latency := range tt.latencies {
+				shedder.observe(latency)
+			}
+			now := time.Now().Add(tt.age)
+			for i := 0; i < tt.rounds; i++ {
+				shedder.mu.Lock()
+				shedder.recompute(now)
+				shedder.mu.Unlock()
+			}
+			rate, _ := shedder.current()
+			if rate < tt.minRate || rate > tt.maxRate {
+				t.Errorf("shed rate %.3f, want within [%v, %v]", rate, tt.minRate, tt.maxRate)
+			}
+		})
+	}
+}
+
+// repeatLatency return n samples of latency
+func repeatLatency(latency time.Duration, n int) []time.Duration {
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		latencies[i] = latency
+	}
+	return latencies
+}
+
+func TestShedLoadResponses(t *testing.T) {
+	gateway := newTestGateway(t)
+	gateway.LatencySLA = 10 * time.Millisecond
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "api", HTTPMethod: http.MethodGet, Host: namedBackend(t, "ok"), Path: "api"}))
+	shedder := gateway.shedder()
+	for _, latency := range repeatLatency(time.Second, 100) {
+		shedder.observe(latency)
+	}
+	shedder.mu.Lock()
+	for i := 0; i < 20; i++ {
+		shedder.recompute(time.Now())
+	}
+	// keep the rate until the test is done
+	shedder.computed = time.Now().Add(time.Hour)
+	shedder.mu.Unlock()
+
+	shed := 0
+	for i := 0; i < 200; i++ {
+		rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/api", nil))
+		switch rec.Code {
+		case http.StatusOK:
+		case http.StatusServiceUnavailable:
+			shed++
+			if rec.Header().Get("Retry-After") == "" {
+				t.Fatalf("shed response without Retry-After")
+			}
+		default:
+			t.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+	// the rate is capped at 90%, some requests always pass to measure recovery
+	if shed < 140 || shed > 195 {
+		t.Errorf("%d of 200 requests shed, want about %v", shed, shedMaxRate)
+	}
+	if rate := gateway.ShedRate(); rate < 0.89 {
+		t.Errorf("shed rate %v, want about %v", rate, shedMaxRate)
+	}
+}
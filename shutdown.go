@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShutdownTimeout bound graceful shutdown before remaining connections are closed
+const DefaultShutdownTimeout = 30 * time.Second
+
+// serve handle connections of listener with handler until Shutdown
+func (gateway *APIGateway) serve(listener net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler}
+	gateway.serversMu.Lock()
+	gateway.servers = append(gateway.servers, server)
+	gateway.serversMu.Unlock()
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stop accepting connections of both servers and wait for in-flight requests,
+// servers are shut down concurrently so that they share the deadline of ctx, connections
+// still open once ctx is done are forcibly closed, the gateway is marked unready
+func (gateway *APIGateway) Shutdown(ctx context.Context) error {
+	// readiness probes fail while connections drain
+	gateway.SetReady(false)
+	gateway.serversMu.Lock()
+	servers := gateway.servers
+	gateway.servers = nil
+	gateway.serversMu.Unlock()
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *http.Server) {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+		}(i, server)
+	}
+	wg.Wait()
+	var firstErr error
+	for i, err := range errs {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			gateway.logger().Warnf("shutdown %v, force closing with %d requests in flight", err, atomic.LoadInt64(&gateway.inFlight))
+			err = servers[i].Close()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
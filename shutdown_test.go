@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		hang       bool
+		forced     bool
+		clientFail bool
+	}{
+		{name: "drained in time"},
+		{name: "hanging backend force closed", hang: true, forced: true, clientFail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrived := make(chan struct{}, 1)
+			release := make(chan struct{})
+			defer close(release)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				arrived <- struct{}{}
+				if tt.hang {
+					<-release
+					return
+				}
+				time.Sleep(50 * time.Millisecond)
+				fmt.Fprint(w, "done")
+			})
+			logger := &recordLogger{}
+			gateway := newTestGateway(t, WithLogger(logger), WithProxyAddr("127.0.0.1:0"))
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "slow", HTTPMethod: http.MethodGet, Host: backend, Path: "slow"}))
+			errs := make(chan error, 1)
+			go func() { errs <- gateway.RunProxy() }()
+			addr := waitAddr(t, gateway.ProxyAddr)
+			clientErr := make(chan error, 1)
+			go func() {
+				resp, err := http.Get(fmt.Sprintf("http://%v/svc/slow", addr))
+				if err == nil {
+					resp.Body.Close()
+				}
+				clientErr <- err
+			}()
+			<-arrived
+			if n := atomic.LoadInt64(&gateway.inFlight); n != 1 {
+				t.Fatalf("%d requests in flight, want 1", n)
+			}
+
+			const timeout = 200 * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			start := time.Now()
+			gateway.Shutdown(ctx)
+			if elapsed := time.Since(start); elapsed > timeout+time.Second {
+				t.Errorf("shutdown took %v, bound %v", elapsed, timeout)
+			}
+			if err := <-errs; err != nil {
+				t.Errorf("run: %v", err)
+			}
+			if _, forced := logger.find(LevelWarn, "force closing with 1 requests in flight"); forced != tt.forced {
+				t.Errorf("forced close logged %v, want %v", forced, tt.forced)
+			}
+			if err := <-clientErr; (err != nil) != tt.clientFail {
+				t.Errorf("client error %v, want failure %v", err, tt.clientFail)
+			}
+		})
+	}
+}
+
+func TestShutdownDrainsInFlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay time.Duration // backend answer after it
+	}{
+		{name: "fast"},
+		{name: "slow", delay: 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrived := make(chan struct{}, 1)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				arrived <- struct{}{}
+				time.Sleep(tt.delay)
+				fmt.Fprint(w, "done")
+			})
+			gateway := newTestGateway(t, WithServerAddr("127.0.0.1:0"), WithProxyAddr("127.0.0.1:0"))
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "slow", HTTPMethod: http.MethodGet, Host: backend, Path: "slow"}))
+			errs := make(chan error, 2)
+			go func() { errs <- gateway.RunServer() }()
+			go func() { errs <- gateway.RunProxy() }()
+			server := waitAddr(t, gateway.ServerAddr)
+			proxy := waitAddr(t, gateway.ProxyAddr)
+			type result struct {
+				status int
+				body   string
+				err    error
+			}
+			results := make(chan result, 1)
+			go func() {
+				resp, err := http.Get(fmt.Sprintf("http://%v/svc/slow", proxy))
+				if err != nil {
+					results <- result{err: err}
+					return
+				}
+				defer resp.Body.Close()
+				body, err := ioutil.ReadAll(resp.Body)
+				results <- result{status: resp.StatusCode, body: string(body), err: err}
+			}()
+			<-arrived
+			if err := gateway.Shutdown(context.Background()); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+			for i := 0; i < 2; i++ {
+				if err := <-errs; err != nil {
+					t.Errorf("run: %v", err)
+				}
+			}
+			if got := <-results; got.err != nil || got.status != http.StatusOK || got.body != "done" {
+				t.Errorf("in-flight request got %d %q %v, want 200 done", got.status, got.body, got.err)
+			}
+			for _, addr := range []net.Addr{server, proxy} {
+				if resp, err := http.Get(fmt.Sprintf("http://%v/healthz", addr)); err == nil {
+					resp.Body.Close()
+					t.Errorf("%v still accept requests after shutdown", addr)
+				}
+			}
+		})
+	}
+}
+
+func TestShutdownConcurrent(t *testing.T) {
+	tests := []struct {
+		name        string
+		hangingLast bool // the hanging server is registered after the draining one
+	}{
+		{name: "hanging server first"},
+		{name: "hanging server last", hangingLast: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			defer close(release)
+			arrived := make(chan struct{}, 2)
+			hanging := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				arrived <- struct{}{}
+				<-release
+			})
+			draining := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				arrived <- struct{}{}
+				time.Sleep(100 * time.Millisecond)
+				fmt.Fprint(w, "done")
+			})
+			handlers := []http.Handler{hanging, draining}
+			if tt.hangingLast {
+				handlers = []http.Handler{draining, hanging}
+			}
+			gateway := newTestGateway(t)
+			addrs := make([]net.Addr, len(handlers))
+			for i, handler := range handlers {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("listen: %v", err)
+				}
+				addrs[i] = listener.Addr()
+				go gateway.serve(listener, handler)
+				// register servers in order
+				waitAddr(t, func() net.Addr {
+					gateway.serversMu.Lock()
+					defer gateway.serversMu.Unlock()
+					if len(gateway.servers) == i+1 {
+						return listener.Addr()
+					}
+					return nil
+				})
+			}
+			drainingAddr, hangingAddr := addrs[1], addrs[0]
+			if tt.hangingLast {
+				drainingAddr, hangingAddr = addrs[0], addrs[1]
+			}
+			results := make(chan error, 1)
+			go func() {
+				resp, err := http.Get(fmt.Sprintf("http://%v/", drainingAddr))
+				if err == nil {
+					resp.Body.Close()
+				}
+				results <- err
+			}()
+			go func() {
+				if resp, err := http.Get(fmt.Sprintf("http://%v/", hangingAddr)); err == nil {
+					resp.Body.Close()
+				}
+			}()
+			<-arrived
+			<-arrived
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			stopped := make(chan struct{})
+			go func() {
+				gateway.Shutdown(ctx)
+				close(stopped)
+			}()
+			if err := <-results; err != nil {
+				t.Errorf("draining request failed: %v", err)
+			}
+			// the hanging server still hold shutdown, none accepts new connections
+			time.Sleep(50 * time.Millisecond)
+			for _, addr := range addrs {
+				if conn, err := net.Dial("tcp", addr.String()); err == nil {
+					conn.Close()
+					t.Errorf("%v still accept connections during shutdown", addr)
+				}
+			}
+			<-stopped
+		})
+	}
+}
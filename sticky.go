@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Sticky pin each client to one backend host of the api, set either Cookie or Header
+type Sticky struct {
+	// Cookie carry the host a client is pinned to, new clients get a host as usual and the
+	// cookie on the response, clients are moved when their host is gone or unhealthy
+	Cookie string `json:"cookie,omitempty"`
+	// Header carry a client key such as a user id hashed to one of Hosts, a host leaving
+	// only move the keys it served
+	Header string `json:"header,omitempty"`
+}
+
+// normalizeSticky validate the Sticky settings of api
+func normalizeSticky(api *API) error {
+	sticky := api.Sticky
+	if sticky == nil {
+		return nil
+	}
+	if (sticky.Cookie == "") == (sticky.Header == "") {
+		return fmt.Errorf("api: %v sticky should set one of cookie and header", api.Name)
+	}
+	if sticky.Cookie != "" && strings.ContainsAny(sticky.Cookie, " \t\r\n;,=\"") {
+		return fmt.Errorf("api: %v sticky cookie: %q invalid", api.Name, sticky.Cookie)
+	}
+	sticky.Header = http.CanonicalHeaderKey(strings.TrimSpace(sticky.Header))
+	return nil
+}
+
+// hostToken identify host in sticky cookies without revealing its address
+func hostToken(host string) string {
+	h := fnv.New64a()
+	h.Write([]byte(host))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// stickyBackend pick the backend host of the request, the pinned one for sticky apis,
+// otherwise as backendHost does
+func (gateway *APIGateway) stickyBackend(w http.ResponseWriter, req *http.Request, api *API) string {
+	host, pin := gateway.stickyHost(req, api, false)
+	if !pin {
+		return host
+	}
+	// scoped to the api so that apis of other hosts do not overwrite it
+	path := "/"
+	if segments := strings.SplitN(req.URL.Path, "/", 4); len(segments) >= 3 {
+		path = "/" + segments[1] + "/" + segments[2]
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     api.Sticky.Cookie,
+		Value:    hostToken(host),
+		Path:     path,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return host
+}
+
+// stickyHost return the backend host of the request and whether the client should be
+// pinned to it with the sticky cookie, peek leave the round-robin state as is
+func (gateway *APIGateway) stickyHost(req *http.Request, api *API, peek bool) (string, bool) {
+	sticky := api.Sticky
+	if sticky == nil {
+		return gateway.backendHost(req, api, peek), false
+	}
+	if sticky.Header != "" {
+		if key := req.Header.Get(sticky.Header); key != "" {
+			if host := gateway.hashedHost(api, key); host != "" {
+				return host, false
+			}
+		}
+		return gateway.backendHost(req, api, peek), false
+	}
+	if cookie, err := req.Cookie(sticky.Cookie); err == nil {
+		if host := gateway.pinnedHost(api, cookie.Value); host != "" {
+			return host, false
+		}
+	}
+	host := gateway.backendHost(req, api, peek)
+	return host, host != ""
+}
+
+// pinnedHost return the healthy host of api whose token is token, empty when none
+func (gateway *APIGateway) pinnedHost(api *API, token string) string {
+	var found string
+	visit := func(host string) {
+		if found == "" && hostToken(host) == token && gateway.health.healthy(api, host) {
+			found = host
+		}
+	}
+	for i, host := range api.Hosts {
+		if hostWeight(api, i) > 0 {
+			visit(host)
+		}
+	}
+	for i := range api.Backends {
+		if api.Backends[i].weight() > 0 {
+			visit(api.Backends[i].Host)
+		}
+	}
+	for _, hosts := range api.RegionHosts {
+		for _, host := range hosts {
+			visit(host)
+		}
+	}
+	return found
+}
+
+// hashedHost map key to one of the healthy Hosts of api by rendezvous hashing, the host
+// ranking highest for the key wins so that a host leaving only move its own keys, empty
+// when no host is healthy
+func (gateway *APIGateway) hashedHost(api *API, key string) string {
+	var best string
+	var bestScore uint64
+	for i, host := range api.Hosts {
+		if hostWeight(api, i) == 0 || !gateway.health.healthy(api, host) {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(host))
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best, bestScore = host, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stickyGateway return a gateway with api svc/get sticky as sticky over three backends
+// answering their name, and the backend hosts by name
+func stickyGateway(t *testing.T, sticky *Sticky) (*APIGateway, map[string]string) {
+	t.Helper()
+	hosts := map[string]string{}
+	var list []string
+	for _, name := range []string{"a", "b", "c"} {
+		hosts[name] = namedBackend(t, name)
+		list = append(list, hosts[name])
+	}
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Hosts: list, Path: "get", Sticky: sticky}))
+	return gateway, hosts
+}
+
+// stickyCookie return the sticky cookie set by rec, nil if none
+func stickyCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range (&http.Response{Header: rec.Header()}).Cookies() {
+		if cookie.Name == "backend" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestStickyCookie(t *testing.T) {
+	gateway, hosts := stickyGateway(t, &Sticky{Cookie: "backend"})
+	var pinned string // backend name the client is pinned to
+	var cookie string // sticky cookie value sent by the client
+	tests := []struct {
+		name    string
+		setup   func()
+		stays   bool // the client should land on the backend it is pinned to
+		moved   bool // the client should land on another backend than before
+		setsPin bool // the response should pin the client
+	}{
+		{name: "new client", setsPin: true},
+		{name: "pinned", stays: true},
+		{name: "pinned again", stays: true},
+		{name: "unknown token", setup: func() { cookie = "unknown" }, setsPin: true},
+		{
+			name:    "pinned host unhealthy",
+			setup:   func() { gateway.health.probed("svc", "get", hosts[pinned], errors.New("down"), 1) },
+			moved:   true,
+			setsPin: true,
+		},
+		{name: "pinned after move", stays: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+				if cookie != "" {
+					req.AddCookie(&http.Cookie{Name: "backend", Value: cookie})
+				}
+				rec := serveProxy(gateway, req)
+				got := rec.Body.String()
+				set := stickyCookie(rec)
+				if i == 0 {
+					if tt.moved && got == pinned {
+						t.Fatalf("client stayed on unhealthy backend %v", got)
+					}
+					if tt.stays && got != pinned {
+						t.Fatalf("client moved from %v to %v", pinned, got)
+					}
+					if (set != nil) != tt.setsPin {
+						t.Fatalf("sticky cookie %v, want set %v", set, tt.setsPin)
+					}
+					if set != nil {
+						if set.Value != hostToken(hosts[got]) || set.Path != "/svc/get" || !set.HttpOnly {
+							t.Errorf("sticky cookie %+v does not pin %v", set, got)
+						}
+						cookie = set.Value
+					}
+					pinned = got
+					continue
+				}
+				if got != pinned || set != nil {
+					t.Errorf("request %d reached %v with cookie %v, want %v without", i, got, set, pinned)
+				}
+			}
+		})
+	}
+}
+
+func TestStickyHeader(t *testing.T) {
+	gateway, hosts := stickyGateway(t, &Sticky{Header: "x-user-id"})
+	keys := []string{"alice", "bob", "carol", "dave", "erin", "frank"}
+	send := func(key string) string {
+		req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+		req.Header.Set("X-User-Id", key)
+		rec := serveProxy(gateway, req)
+		if stickyCookie(rec) != nil {
+			t.Errorf("header sticky api set a cookie")
+		}
+		return rec.Body.String()
+	}
+	assigned := map[string]string{}
+	for _, key := range keys {
+		assigned[key] = send(key)
+	}
+	tests := []struct {
+		name string
+		down string // backend marked unhealthy before the requests
+	}{
+		{name: "repeated keys"},
+		{name: "host removed", down: assigned["alice"]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.down != "" {
+				gateway.health.probed("svc", "get", hosts[tt.down], errors.New("down"), 1)
+			}
+			for _, key := range keys {
+				for i := 0; i < 3; i++ {
+					got := send(key)
+					switch {
+					case assigned[key] == tt.down && got == tt.down:
+						t.Errorf("key %v stayed on unhealthy backend %v", key, got)
+					case assigned[key] != tt.down && got != assigned[key]:
+						t.Errorf("key %v moved from %v to %v", key, assigned[key], got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStickyRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		sticky *Sticky
+	}{
+		{name: "neither cookie nor header", sticky: &Sticky{}},
+		{name: "both cookie and header", sticky: &Sticky{Cookie: "backend", Header: "X-User-Id"}},
+		{name: "invalid cookie name", sticky: &Sticky{Cookie: "back end"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get", Sticky: tt.sticky}))
+			if err == nil {
+				t.Errorf("sticky %+v accepted", tt.sticky)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultStreamIdleTimeout close streaming connections no data flowed through for it
+const DefaultStreamIdleTimeout = 5 * time.Minute
+
+// idleKey carry the *idleTimer of a streaming request in its context
+type idleKey struct{}
+
+// idleTimer cancel a streaming request once no data flowed for timeout, every
+// read or write on the stream push the deadline back
+type idleTimer struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// touch push the idle deadline back after data flowed
+func (t *idleTimer) touch() {
+	t.mu.Lock()
+	t.timer.Reset(t.timeout)
+	t.mu.Unlock()
+}
+
+// stop release the timer once the stream is done
+func (t *idleTimer) stop() {
+	t.mu.Lock()
+	t.timer.Stop()
+	t.mu.Unlock()
+}
+
+// isUpgrade report whether r ask to switch protocols, e.g. to websocket
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+// isEventStream report whether header describe a server-sent events body
+func isEventStream(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// streaming report whether the request of route is a stream, either the api is streaming,
+// the request is upgraded such as websocket or the backend answered with server-sent events,
+// the latter is only known once the response headers arrive
+func (rt *route) streaming() bool {
+	return rt.api.Streaming || rt.upgrade || rt.eventStream
+}
+
+// streamIdleTimeout return the idle timeout of route, zero when it is not streaming
+func (gateway *APIGateway) streamIdleTimeout(rt *route) time.Duration {
+	if !rt.streaming() {
+		return 0
+	}
+	api := rt.api
+	if api.IdleTimeoutMs > 0 {
+		return time.Duration(api.IdleTimeoutMs) * time.Millisecond
+	}
+	return gateway.StreamIdleTimeout
+}
+
+// withIdleTimeout bound the idle time of streaming requests instead of their total
+// duration, the request is canceled when nothing flows for the api idle timeout
+func (gateway *APIGateway) withIdleTimeout(r *http.Request, rt *route) (*http.Request, func()) {
+	timeout := gateway.streamIdleTimeout(rt)
+	if timeout <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	idle := &idleTimer{timeout: timeout}
+	idle.timer = time.AfterFunc(timeout, cancel)
+	ctx = context.WithValue(ctx, idleKey{}, idle)
+	return r.WithContext(ctx), func() {
+		idle.stop()
+		cancel()
+	}
+}
+
+// watchIdle make the body of a streaming response push the idle deadline back on
+// every read, upgraded connections such as websocket also on every write
+func watchIdle(resp *http.Response) {
+	idle, ok := resp.Request.Context().Value(idleKey{}).(*idleTimer)
+	if !ok {
+		return
+	}
+	idle.touch()
+	// the proxy need a writable body to tunnel upgraded connections
+	if conn, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = &idleConn{ReadWriteCloser: conn, idle: idle}
+		return
+	}
+	resp.Body = &idleBody{ReadCloser: resp.Body, idle: idle}
+}
+
+// idleBody push the idle deadline back when response data flows
+type idleBody struct {
+	io.ReadCloser
+	idle *idleTimer
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.idle.touch()
+	}
+	return n, err
+}
+
+// idleConn push the idle deadline back when data flows either way of an upgraded connection
+type idleConn struct {
+	io.ReadWriteCloser
+	idle *idleTimer
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.idle.touch()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	if n > 0 {
+		c.idle.touch()
+	}
+	return n, err
+}
@@ -0,0 +1,274 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		api       API
+		eventType bool          // backend answer text/event-stream
+		interval  time.Duration // between events
+		events    int
+		stall     bool // backend stop sending after the first event
+		want      int  // events received by the client
+	}{
+		{name: "active stream outlives idle timeout", api: API{Streaming: true, IdleTimeoutMs: 100}, interval: 20 * time.Millisecond, events: 15, want: 15},
+		{name: "idle stream closed", api: API{Streaming: true, IdleTimeoutMs: 100}, events: 3, stall: true, want: 1},
+		{name: "event stream outlives idle timeout", api: API{Streaming: true, IdleTimeoutMs: 100}, eventType: true, interval: 20 * time.Millisecond, events: 15, want: 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.eventType {
+					w.Header().Set("Content-Type", "text/event-stream")
+				}
+				for i := 0; i < tt.events; i++ {
+					fmt.Fprintf(w, "data: %d\n\n", i)
+					w.(http.Flusher).Flush()
+					if tt.stall {
+						select {
+						case <-r.Context().Done():
+							return
+						case <-time.After(5 * time.Second):
+						}
+					}
+					time.Sleep(tt.interval)
+				}
+			})
+			gateway := newTestGateway(t)
+			api := tt.api
+			api.Name, api.HTTPMethod, api.Host, api.Path = "events", http.MethodGet, backend, "events"
+			mustCreateService(t, gateway, newTestService("svc", &api))
+			server := httptest.NewServer(gateway)
+			defer server.Close()
+			start := time.Now()
+			resp, err := http.Get(server.URL + "/svc/events")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if got := strings.Count(string(body), "data: "); got != tt.want {
+				t.Errorf("received %d events, want %d: %q", got, tt.want, body)
+			}
+			if tt.stall && time.Since(start) > 2*time.Second {
+				t.Errorf("idle stream closed after %v", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestEventStreamFlushed(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		streaming   bool
+		mode        string // response mode of the gateway
+	}{
+		{name: "event stream", contentType: "text/event-stream"},
+		{name: "event stream with charset", contentType: "text/event-stream; charset=utf-8"},
+		{name: "event stream with buffered responses", contentType: "text/event-stream", mode: ResponseBuffered},
+		{name: "streaming api", contentType: "text/plain", streaming: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the backend send the next event only once the client got the previous one
+			received := make(chan struct{})
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				for i := 0; i < 3; i++ {
+					fmt.Fprintf(w, "data: %d\n\n", i)
+					w.(http.Flusher).Flush()
+					select {
+					case <-received:
+					case <-time.After(5 * time.Second):
+						return
+					}
+				}
+			})
+			gateway := newTestGateway(t)
+			gateway.ResponseMode = tt.mode
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "events", HTTPMethod: http.MethodGet, Host: backend, Path: "events", Streaming: tt.streaming}))
+			server := httptest.NewServer(gateway)
+			defer server.Close()
+			resp, err := http.Get(server.URL + "/svc/events")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer resp.Body.Close()
+			reader := bufio.NewReader(resp.Body)
+			for i := 0; i < 3; i++ {
+				lines := make(chan string, 1)
+				go func() {
+					line, _ := reader.ReadString('\n')
+					reader.ReadString('\n')
+					lines <- line
+				}()
+				select {
+				case line := <-lines:
+					if want := fmt.Sprintf("data: %d\n", i); line != want {
+						t.Fatalf("event %q, want %q", line, want)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("event %d not received before the next one was sent", i)
+				}
+				received <- struct{}{}
+			}
+		})
+	}
+}
+
+// websocketAccept return the Sec-WebSocket-Accept answering key (RFC 6455 4.2.2)
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// writeFrame write a final text frame of payload shorter than 126 bytes, masked by clients
+func writeFrame(w io.Writer, payload []byte, masked bool) error {
+	frame := []byte{0x81, byte(len(payload))}
+	if !masked {
+		frame = append(frame, payload...)
+		_, err := w.Write(frame)
+		return err
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame[1] |= 0x80
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame read a frame of payload shorter than 126 bytes, unmasking it
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	masked, n := header[1]&0x80 != 0, int(header[1]&0x7f)
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		if masked {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return payload, nil
+}
+
+// websocketEcho is a websocket backend echoing every frame, refusing upgrades when refuse
+func websocketEcho(t *testing.T, refuse bool) string {
+	t.Helper()
+	return newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if refuse || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "upgrade required", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n",
+			websocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+		for {
+			payload, err := readFrame(rw)
+			if err != nil {
+				return
+			}
+			if err := writeFrame(conn, payload, false); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestWebSocketProxy(t *testing.T) {
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	tests := []struct {
+		name     string
+		api      API
+		refuse   bool
+		status   int
+		messages []string
+	}{
+		{name: "round trip", status: http.StatusSwitchingProtocols, messages: []string{"hello", "world", strings.Repeat("x", 100)}},
+		{name: "compressing api", api: API{Compress: true}, status: http.StatusSwitchingProtocols, messages: []string{"hello"}},
+		{name: "api timeout does not apply", api: API{TimeoutMs: 50}, status: http.StatusSwitchingProtocols, messages: []string{"hello", "after timeout"}},
+		{name: "backend refuse upgrade", refuse: true, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := tt.api
+			api.Name, api.HTTPMethod, api.Host, api.Path = "ws", http.MethodGet, websocketEcho(t, tt.refuse), "ws"
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", &api))
+			server := httptest.NewServer(gateway)
+			defer server.Close()
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			fmt.Fprintf(conn, "GET /svc/ws HTTP/1.1\r\nHost: gateway\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+				"Sec-WebSocket-Key: %v\r\nSec-WebSocket-Version: 13\r\n\r\n", key)
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("read handshake: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusSwitchingProtocols {
+				return
+			}
+			if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+				t.Fatalf("handshake headers %v", resp.Header)
+			}
+			for i, message := range tt.messages {
+				if i > 0 && tt.api.TimeoutMs > 0 {
+					time.Sleep(time.Duration(tt.api.TimeoutMs) * 2 * time.Millisecond)
+				}
+				if err := writeFrame(conn, []byte(message), true); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+				echo, err := readFrame(br)
+				if err != nil {
+					t.Fatalf("read message %d: %v", i, err)
+				}
+				if string(echo) != message {
+					t.Errorf("echo %q, want %q", echo, message)
+				}
+			}
+		})
+	}
+}
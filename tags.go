@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultTagHeader carry request tags as comma separated key=value pairs, e.g. version=beta
+const DefaultTagHeader = "X-Route-Tags"
+
+// Backend is an extra backend host of api carrying free-form tags
+type Backend struct {
+	Host string            `json:"host"`           // ip:port or domain
+	Tags map[string]string `json:"tags,omitempty"` // e.g. {"version": "beta"}
+	// Weight share traffic among matched backends, default 1, zero never picked
+	Weight *int `json:"weight,omitempty"`
+}
+
+// weight return the backend weight, default 1
+func (backend *Backend) weight() int {
+	if backend.Weight == nil {
+		return 1
+	}
+	return *backend.Weight
+}
+
+// validateBackends check backends of api, a route whose backends all weigh zero would
+// silently blackhole traffic so it is rejected at registration
+func validateBackends(api *API) error {
+	total := 0
+	for _, backend := range api.Backends {
+		if err := validateHost(backend.Host); err != nil {
+			return fmt.Errorf("api: %v backend %v", api.Name, err)
+		}
+		for key := range backend.Tags {
+			if key == "" {
+				return fmt.Errorf("api: %v backend: %v tag key can not be empty", api.Name, backend.Host)
+			}
+		}
+		if backend.weight() < 0 {
+			return fmt.Errorf("api: %v backend: %v weight: %v can not be negative", api.Name, backend.Host, backend.weight())
+		}
+		total += backend.weight()
+	}
+	if len(api.Backends) > 0 && total == 0 {
+		return fmt.Errorf("api: %v at least one backend should have positive weight", api.Name)
+	}
+	return nil
+}
+
+// validateHost check host is ip, domain or either with port, without scheme or path
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("host can not be empty")
+	}
+	if strings.ContainsAny(host, "/ \t?#@") {
+		return fmt.Errorf("host: %q should be ip:port or domain without scheme or path", host)
+	}
+	name := host
+	if strings.Contains(host, ":") {
+		h, port, err := net.SplitHostPort(host)
+		if err != nil {
+			return fmt.Errorf("host: %q malformed: %v", host, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("host: %q port: %q invalid", host, port)
+		}
+		name = h
+	}
+	if name == "" {
+		return fmt.Errorf("host: %q has no hostname", host)
+	}
+	return nil
+}
+
+// requestTags parse tags of request from TagHeader
+func (gateway *APIGateway) requestTags(req *http.Request) map[string]string {
+	if gateway.TagHeader == "" {
+		return nil
+	}
+	var tags map[string]string
+	for _, value := range req.Header.Values(gateway.TagHeader) {
+		for _, pair := range strings.Split(value, ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				continue
+			}
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return tags
+}
+
+// taggedBackend pick a healthy backend of api carrying all request tags by weight, skipping
+// tried hosts, empty when the request has no tags or none matches so that the default Host
+// is used
+func (gateway *APIGateway) taggedBackend(req *http.Request, api *API, tried map[string]bool) string {
+	if !api.TagRouting || len(api.Backends) == 0 {
+		return ""
+	}
+	tags := gateway.requestTags(req)
+	if len(tags) == 0 {
+		return ""
+	}
+	var matched []*Backend
+	total := 0
+	for i := range api.Backends {
+		backend := &api.Backends[i]
+		if backend.weight() > 0 && !tried[backend.Host] && hasTags(backend.Tags, tags) && gateway.health.healthy(api, backend.Host) {
+			matched = append(matched, backend)
+			total += backend.weight()
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	// weighted random pick
+	n := rand.Intn(total)
+	for _, backend := range matched {
+		if n < backend.weight() {
+			return backend.Host
+		}
+		n -= backend.weight()
+	}
+	return ""
+}
+
+// hasTags report whether have contains every key=value of want
+func hasTags(have, want map[string]string) bool {
+	for key, value := range want {
+		if v, exist := have[key]; !exist || v != value {
+			return false
+		}
+	}
+	return true
+}
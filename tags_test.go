@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTagRouting(t *testing.T) {
+	tests := []struct {
+		name       string
+		tagRouting bool
+		headers    []string
+		down       string   // backend marked unhealthy
+		want       string   // every pick unless any is set
+		any        []string // matched backends sharing traffic, each picked at least once
+	}{
+		{name: "tag matched", tagRouting: true, headers: []string{"region=eu"}, want: "beta-eu"},
+		{name: "several backends matched", tagRouting: true, headers: []string{"version=beta"}, any: []string{"beta", "beta-eu"}},
+		{name: "all tags matched", tagRouting: true, headers: []string{"version=beta, region=eu"}, want: "beta-eu"},
+		{name: "tags across header values", tagRouting: true, headers: []string{"version=beta", "region=eu"}, want: "beta-eu"},
+		{name: "no tags", tagRouting: true, want: "stable"},
+		{name: "no backend matches", tagRouting: true, headers: []string{"version=gamma"}, want: "stable"},
+		{name: "partial match", tagRouting: true, headers: []string{"version=beta,region=us"}, want: "stable"},
+		{name: "malformed tags ignored", tagRouting: true, headers: []string{"beta,=x"}, want: "stable"},
+		{name: "matched backend unhealthy", tagRouting: true, headers: []string{"region=eu"}, down: "beta-eu", want: "stable"},
+		{name: "tag routing disabled", headers: []string{"version=beta"}, want: "stable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts := map[string]string{}
+			for _, name := range []string{"stable", "beta", "beta-eu"} {
+				hosts[name] = namedBackend(t, name)
+			}
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name: "get", HTTPMethod: http.MethodGet, Host: hosts["stable"], Path: "get",
+				TagRouting: tt.tagRouting,
+				Backends: []Backend{
+					{Host: hosts["beta"], Tags: map[string]string{"version": "beta"}},
+					{Host: hosts["beta-eu"], Tags: map[string]string{"version": "beta", "region": "eu"}},
+				},
+			}))
+			if tt.down != "" {
+				markDown(gateway, "get", hosts[tt.down])
+			}
+			picks := make(map[string]int)
+			for i := 0; i < 40; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+				for _, value := range tt.headers {
+					req.Header.Add(DefaultTagHeader, value)
+				}
+				picks[serveProxy(gateway, req).Body.String()]++
+			}
+			if len(tt.any) == 0 && picks[tt.want] != 40 {
+				t.Errorf("routed to %v, want %q", picks, tt.want)
+			}
+			for _, name := range tt.any {
+				if picks[name] == 0 || len(picks) != len(tt.any) {
+					t.Errorf("routed to %v, want shared by %v", picks, tt.any)
+					break
+				}
+			}
+		})
+	}
+}
+
+// weight return a pointer to backend weight w
+func weight(w int) *int {
+	return &w
+}
+
+func TestBackendWeightsValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []Backend
+		errSub   string // expected in the registration error, empty when accepted
+	}{
+		{name: "default weights", backends: []Backend{{Host: "10.0.0.1:80"}, {Host: "10.0.0.2:80"}}},
+		{name: "some zero weights", backends: []Backend{{Host: "10.0.0.1:80", Weight: weight(0)}, {Host: "10.0.0.2:80", Weight: weight(3)}}},
+		{name: "all zero weights", backends: []Backend{{Host: "10.0.0.1:80", Weight: weight(0)}, {Host: "10.0.0.2:80", Weight: weight(0)}}, errSub: "positive weight"},
+		{name: "negative weight", backends: []Backend{{Host: "10.0.0.1:80", Weight: weight(-1)}, {Host: "10.0.0.2:80", Weight: weight(5)}}, errSub: "can not be negative"},
+		{name: "host with scheme", backends: []Backend{{Host: "http://10.0.0.1:80"}}, errSub: "without scheme"},
+		{name: "host with path", backends: []Backend{{Host: "10.0.0.1:80/api"}}, errSub: "without scheme or path"},
+		{name: "bad port", backends: []Backend{{Host: "10.0.0.1:99999"}}, errSub: "port"},
+		{name: "empty host", backends: []Backend{{Host: ""}}, errSub: "can not be empty"},
+		{name: "no hostname", backends: []Backend{{Host: ":80"}}, errSub: "no hostname"},
+		{name: "empty tag key", backends: []Backend{{Host: "10.0.0.1:80", Tags: map[string]string{"": "x"}}}, errSub: "tag key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			err := gateway.Discovery.CreateService(newTestService("svc", &API{
+				Name: "get", HTTPMethod: http.MethodGet, Host: "10.0.0.9:80", Path: "get", TagRouting: true, Backends: tt.backends,
+			}))
+			if tt.errSub == "" {
+				if err != nil {
+					t.Errorf("rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+				t.Errorf("error %v, want one mentioning %q", err, tt.errSub)
+			}
+		})
+	}
+}
+
+func TestBackendWeightsShareTraffic(t *testing.T) {
+	hosts := map[string]string{}
+	for _, name := range []string{"stable", "heavy", "light", "off"} {
+		hosts[name] = namedBackend(t, name)
+	}
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc", &API{
+		Name: "get", HTTPMethod: http.MethodGet, Host: hosts["stable"], Path: "get", TagRouting: true,
+		Backends: []Backend{
+			{Host: hosts["heavy"], Tags: map[string]string{"version": "beta"}, Weight: weight(9)},
+			{Host: hosts["light"], Tags: map[string]string{"version": "beta"}, Weight: weight(1)},
+			{Host: hosts["off"], Tags: map[string]string{"version": "beta"}, Weight: weight(0)},
+		},
+	}))
+	picks := make(map[string]int)
+	const requests = 1000
+	for i := 0; i < requests; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+		req.Header.Set(DefaultTagHeader, "version=beta")
+		picks[serveProxy(gateway, req).Body.String()]++
+	}
+	if picks["off"] != 0 || picks["stable"] != 0 {
+		t.Errorf("zero weight or fallback picked: %v", picks)
+	}
+	// 900 expected, far outside chance with 1000 picks
+	if picks["heavy"] < 800 || picks["light"] < 50 {
+		t.Errorf("picks %v do not follow weights 9:1", picks)
+	}
+}
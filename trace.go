@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// traceIDKey is the context key of the trace id
+type traceIDKey struct{}
+
+// withTraceID store the trace id of W3C traceparent header in request context,
+// so that metrics can link to the trace, requests without valid header are unchanged
+func withTraceID(r *http.Request) *http.Request {
+	id := parseTraceparent(r.Header.Get("traceparent"))
+	if id == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), traceIDKey{}, id))
+}
+
+// TraceIDFromContext return the trace id of the request, empty when it is not traced
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// parseTraceparent return the trace id of traceparent: {version}-{trace-id}-{parent-id}-{flags}
+func parseTraceparent(value string) string {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ""
+	}
+	id := strings.ToLower(parts[1])
+	if !isHex(id) || id == strings.Repeat("0", 32) {
+		return ""
+	}
+	return id
+}
+
+// isHex report whether s only contains lowercase hex digits
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Tracer start a span around every proxied request, adapt an OpenTelemetry tracer to
+// export gateway spans, the gateway itself depends on no tracing library
+type Tracer interface {
+	// Start a span named name as a child of the span in ctx, return ctx holding the span
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Inject the trace context of ctx into the headers of the backend request,
+	// e.g. with the W3C traceparent propagator
+	Inject(ctx context.Context, header http.Header)
+}
+
+// Span is a span started by Tracer
+type Span interface {
+	// SetAttribute record key and value on the span
+	SetAttribute(key string, value interface{})
+	// SetError mark the span failed with description
+	SetError(description string)
+	// End finish the span
+	End()
+}
+
+// span attribute keys recorded on proxied request spans
+const (
+	SpanAttrService    = "gateway.service"
+	SpanAttrAPI        = "gateway.api"
+	SpanAttrBackend    = "server.address"
+	SpanAttrMethod     = "http.request.method"
+	SpanAttrStatusCode = "http.response.status_code"
+)
+
+// noopTracer is the Tracer used when APIGateway.Tracer is nil, client traceparent is then
+// forwarded unchanged
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopTracer) Inject(ctx context.Context, header http.Header) {}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) SetError(description string) {}
+
+func (noopSpan) End() {}
+
+// tracer return the configured tracer, a no-op one by default
+func (gateway *APIGateway) tracer() Tracer {
+	if gateway.Tracer == nil {
+		return noopTracer{}
+	}
+	return gateway.Tracer
+}
+
+// startSpan start the span of a request routed to rt, named {service}/{api} so that span
+// names stay bounded whatever the request path
+func (gateway *APIGateway) startSpan(r *http.Request, rt *route) (*http.Request, Span) {
+	ctx, span := gateway.tracer().Start(r.Context(), rt.service.Name+"/"+rt.api.Name)
+	span.SetAttribute(SpanAttrService, rt.service.Name)
+	span.SetAttribute(SpanAttrAPI, rt.api.Name)
+	span.SetAttribute(SpanAttrMethod, r.Method)
+	return r.WithContext(ctx), span
+}
+
+// endSpan record the backend and status answered to the request then end span
+func endSpan(span Span, rt *route, status int) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if rt.backend != "" {
+		span.SetAttribute(SpanAttrBackend, rt.backend)
+	}
+	span.SetAttribute(SpanAttrStatusCode, status)
+	if status >= http.StatusInternalServerError {
+		span.SetError(http.StatusText(status))
+	}
+	span.End()
+}
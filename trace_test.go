@@ -0,0 +1,148 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordSpan keep what was recorded on a span
+type recordSpan struct {
+	name       string
+	attributes map[string]interface{}
+	err        string
+	ended      bool
+}
+
+func (s *recordSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *recordSpan) SetError(description string)                { s.err = description }
+func (s *recordSpan) End()                                       { s.ended = true }
+
+// recordTracer record the spans it starts in memory and inject their name in X-Span
+type recordTracer struct {
+	mu    sync.Mutex
+	spans []*recordSpan
+}
+
+type recordSpanKey struct{}
+
+func (tr *recordTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordSpan{name: name, attributes: make(map[string]interface{})}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.spans = append(tr.spans, span)
+	return context.WithValue(ctx, recordSpanKey{}, span), span
+}
+
+func (tr *recordTracer) Inject(ctx context.Context, header http.Header) {
+	if span, ok := ctx.Value(recordSpanKey{}).(*recordSpan); ok {
+		header.Set("X-Span", span.name)
+	}
+}
+
+func TestTracerSpans(t *testing.T) {
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Got-Span", r.Header.Get("X-Span"))
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	tests := []struct {
+		name       string
+		target     string
+		spans      int
+		spanName   string
+		status     int
+		backend    string
+		err        string
+		propagated bool
+	}{
+		{name: "proxied", target: "/svc/get", spans: 1, spanName: "svc/get", status: http.StatusOK, backend: backend, propagated: true},
+		{name: "query kept out of name", target: "/svc/get?id=42", spans: 1, spanName: "svc/get", status: http.StatusOK, backend: backend, propagated: true},
+		{name: "backend error", target: "/svc/get?fail=1", spans: 1, spanName: "svc/get", status: http.StatusInternalServerError, backend: backend, err: "Internal Server Error", propagated: true},
+		{name: "unknown api not traced", target: "/svc/missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &recordTracer{}
+			gateway := newTestGateway(t)
+			gateway.Tracer = tracer
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if len(tracer.spans) != tt.spans {
+				t.Fatalf("%d spans, want %d", len(tracer.spans), tt.spans)
+			}
+			if tt.spans == 0 {
+				return
+			}
+			span := tracer.spans[0]
+			if span.name != tt.spanName || !span.ended || span.err != tt.err {
+				t.Errorf("span %q ended %v error %q, want %q ended error %q", span.name, span.ended, span.err, tt.spanName, tt.err)
+			}
+			expected := map[string]interface{}{
+				SpanAttrService:    "svc",
+				SpanAttrAPI:        "get",
+				SpanAttrMethod:     http.MethodGet,
+				SpanAttrBackend:    tt.backend,
+				SpanAttrStatusCode: tt.status,
+			}
+			for key, want := range expected {
+				if got := span.attributes[key]; got != want {
+					t.Errorf("attribute %v %v, want %v", key, got, want)
+				}
+			}
+			if propagated := rec.Header().Get("X-Got-Span") == tt.spanName; propagated != tt.propagated {
+				t.Errorf("backend got span %q, want propagated %v", rec.Header().Get("X-Got-Span"), tt.propagated)
+			}
+		})
+	}
+}
+
+func TestNoopTracerForwardTraceparent(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	received := make(chan string, 1)
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+	})
+	gateway := newTestGateway(t)
+	mustCreateService(t, gateway, newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+	req := httptest.NewRequest(http.MethodGet, "/svc/get", nil)
+	req.Header.Set("traceparent", traceparent)
+	if rec := serveProxy(gateway, req); rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if got := <-received; got != traceparent {
+		t.Errorf("backend got traceparent %q, want %q", got, traceparent)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const id = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "valid", value: "00-" + id + "-00f067aa0ba902b7-01", expected: id},
+		{name: "uppercase", value: "00-" + strings.ToUpper(id) + "-00f067aa0ba902b7-01", expected: id},
+		{name: "future version with extra fields", value: "01-" + id + "-00f067aa0ba902b7-01-extra", expected: id},
+		{name: "empty"},
+		{name: "invalid version", value: "ff-" + id + "-00f067aa0ba902b7-01"},
+		{name: "all zero trace id", value: "00-" + strings.Repeat("0", 32) + "-00f067aa0ba902b7-01"},
+		{name: "short trace id", value: "00-" + id[:30] + "-00f067aa0ba902b7-01"},
+		{name: "not hex", value: "00-" + strings.Repeat("z", 32) + "-00f067aa0ba902b7-01"},
+		{name: "missing flags", value: "00-" + id + "-00f067aa0ba902b7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTraceparent(tt.value); got != tt.expected {
+				t.Errorf("trace id %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
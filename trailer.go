@@ -0,0 +1,40 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+)
+
+// trailerBody copy request trailers received from the client to the upstream request once
+// the body is fully read, the proxy clones the request before trailer values arrive so the
+// upstream request would otherwise announce the trailers but send them empty
+type trailerBody struct {
+	io.ReadCloser
+	src http.Header // trailer of client request, filled when body reaches EOF
+	dst http.Header // trailer of upstream request, set by director
+}
+
+// Read implements io.Reader
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF && b.dst != nil {
+		for key, values := range b.src {
+			b.dst[key] = values
+		}
+	}
+	return n, err
+}
+
+// forwardRequestTrailer wrap client request body so its trailers reach the backend
+func forwardRequestTrailer(r *http.Request) {
+	if len(r.Trailer) > 0 && r.Body != nil {
+		r.Body = &trailerBody{ReadCloser: r.Body, src: r.Trailer}
+	}
+}
+
+// bindRequestTrailer point the wrapped body of upstream request at its trailer
+func bindRequestTrailer(req *http.Request) {
+	if body, ok := req.Body.(*trailerBody); ok {
+		body.dst = req.Trailer
+	}
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTrailerPassthrough(t *testing.T) {
+	tests := []struct {
+		name    string
+		trailer http.Header // sent by the client after the request body
+		te      string
+		want    string // request trailer and TE seen by the backend
+	}{
+		{name: "response trailers only", want: "|"},
+		{name: "request trailers", trailer: http.Header{"X-Checksum": {"abc"}}, want: "abc|"},
+		{name: "te trailers", te: "trailers", want: "|trailers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+				w.Header().Set("Trailer", "Grpc-Status")
+				w.Header().Set("X-Seen", r.Trailer.Get("X-Checksum")+"|"+r.Header.Get("Te"))
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, "streamed body")
+				w.(http.Flusher).Flush()
+				w.Header().Set("Grpc-Status", "0")
+				w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+			})
+			gateway := newTestGateway(t)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "call", HTTPMethod: http.MethodPost, Host: backend, Path: "call"}))
+			server := httptest.NewServer(gateway)
+			defer server.Close()
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/svc/call", ioutil.NopCloser(strings.NewReader("payload")))
+			req.ContentLength = -1
+			req.Trailer = tt.trailer
+			if tt.te != "" {
+				req.Header.Set("TE", tt.te)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("post: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if string(body) != "streamed body" {
+				t.Errorf("body %q", body)
+			}
+			if seen := resp.Header.Get("X-Seen"); seen != tt.want {
+				t.Errorf("backend saw %q, want %q", seen, tt.want)
+			}
+			if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+				t.Errorf("declared trailer %q, want %q", got, "0")
+			}
+			if got := resp.Trailer.Get("Grpc-Message"); got != "ok" {
+				t.Errorf("undeclared trailer %q, want %q", got, "ok")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// maxTransformBodyBytes bound the bodies read into memory for transformers
+const maxTransformBodyBytes = 8 << 20
+
+// errTransformFailed is returned when a response transformer fails, the client gets 502
+var errTransformFailed = errors.New("backend response transform failed")
+
+// RequestTransformer rewrite the body of a request before it is proxied, r must not be
+// modified, an error answer the client with 400
+type RequestTransformer func(r *http.Request, body []byte) ([]byte, error)
+
+// ResponseTransformer rewrite the body of a backend response before it is sent to the
+// client, resp headers may be modified, an error answer the client with 502
+type ResponseTransformer func(resp *http.Response, body []byte) ([]byte, error)
+
+// WithRequestTransformer make transform usable as name by the apis proxied through the
+// gateway, a later transformer of the same name replaces it, name and transform can not
+// be empty
+func WithRequestTransformer(name string, transform RequestTransformer) Option {
+	if name == "" || transform == nil {
+		panic("gateway: request transformer name and func can not be empty")
+	}
+	return func(gateway *APIGateway) {
+		if gateway.requestTransformers == nil {
+			gateway.requestTransformers = make(map[string]RequestTransformer)
+		}
+		gateway.requestTransformers[name] = transform
+	}
+}
+
+// WithResponseTransformer make transform usable as name by the apis proxied through the
+// gateway, a later transformer of the same name replaces it, name and transform can not
+// be empty
+func WithResponseTransformer(name string, transform ResponseTransformer) Option {
+	if name == "" || transform == nil {
+		panic("gateway: response transformer name and func can not be empty")
+	}
+	return func(gateway *APIGateway) {
+		if gateway.responseTransformers == nil {
+			gateway.responseTransformers = make(map[string]ResponseTransformer)
+		}
+		gateway.responseTransformers[name] = transform
+	}
+}
+
+// normalizeTransformers check the transformers named by api can run, the names are
+// resolved by the gateway proxying the api
+func normalizeTransformers(api *API) error {
+	if len(api.RequestTransformers) == 0 && len(api.ResponseTransformers) == 0 {
+		return nil
+	}
+	if api.Streaming {
+		return fmt.Errorf("api: %v streaming api can not transform bodies", api.Name)
+	}
+	for _, names := range [][]string{api.RequestTransformers, api.ResponseTransformers} {
+		for _, name := range names {
+			if name == "" {
+				return fmt.Errorf("api: %v transformer name can not be empty", api.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// transformRequest run the request transformers of the api on the request body, return
+// false when the response has been written
+func (gateway *APIGateway) transformRequest(w http.ResponseWriter, r *http.Request, rt *route) bool {
+	names := rt.api.RequestTransformers
+	if len(names) == 0 || rt.upgrade || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	transformers := make([]RequestTransformer, 0, len(names))
+	for _, name := range names {
+		transform, exist := gateway.requestTransformers[name]
+		if !exist {
+			gateway.logger().Errorf("api: %v request transformer: %v not registered", rt.api.Name, name)
+			gateway.writeError(w, r, http.StatusInternalServerError, "request transformer not registered")
+			return false
+		}
+		transformers = append(transformers, transform)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxTransformBodyBytes+1))
+	r.Body.Close()
+	if errors.Is(err, errRequestTooLarge) || len(data) > maxTransformBodyBytes {
+		gateway.writeError(w, r, http.StatusRequestEntityTooLarge, errRequestTooLarge.Error())
+		return false
+	}
+	if err != nil {
+		gateway.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("read request body failed: %v", err))
+		return false
+	}
+	for _, transform := range transformers {
+		if data, err = transform(r, data); err != nil {
+			gateway.writeError(w, r, http.StatusBadRequest, "request body transform failed", err.Error())
+			return false
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	// the buffered body can be sent again by retries
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.ContentLength = int64(len(data))
+	r.TransferEncoding = nil
+	return true
+}
+
+// transformResponse run the response transformers of the api on the backend response body,
+// used as ModifyResponse, the backend is asked for an identity or gzip body the transport
+// decodes so that transformers see plain bytes
+func (gateway *APIGateway) transformResponse(resp *http.Response) error {
+	rt := routeOf(resp.Request.Context())
+	if rt == nil || len(rt.api.ResponseTransformers) == 0 || rt.streaming() ||
+		resp.Request.Method == http.MethodHead || !bodyAllowed(resp.StatusCode) {
+		return nil
+	}
+	transformers := make([]ResponseTransformer, 0, len(rt.api.ResponseTransformers))
+	for _, name := range rt.api.ResponseTransformers {
+		transform, exist := gateway.responseTransformers[name]
+		if !exist {
+			resp.Body.Close()
+			return fmt.Errorf("%w: response transformer: %v not registered", errTransformFailed, name)
+		}
+		transformers = append(transformers, transform)
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		resp.Body.Close()
+		return fmt.Errorf("%w: body encoded with %v", errTransformFailed, encoding)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTransformBodyBytes+1))
+	resp.Body.Close()
+	if err != nil {
+		return wrapDecompressError(resp, err)
+	}
+	if len(data) > maxTransformBodyBytes {
+		return fmt.Errorf("%w: body exceed %d bytes", errTransformFailed, maxTransformBodyBytes)
+	}
+	for _, transform := range transformers {
+		if data, err = transform(resp, data); err != nil {
+			return fmt.Errorf("%w: %v", errTransformFailed, err)
+		}
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	// the client gets trailers only on a chunked response
+	if len(resp.Trailer) == 0 {
+		resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	// the validator of the backend body does not match the transformed one
+	resp.Header.Del("Etag")
+	return nil
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// redactField return a transformer dropping field from a JSON object body
+func redactField(field string) func(body []byte) ([]byte, error) {
+	return func(body []byte) ([]byte, error) {
+		var object map[string]interface{}
+		if err := json.Unmarshal(body, &object); err != nil {
+			return nil, err
+		}
+		delete(object, field)
+		return json.Marshal(object)
+	}
+}
+
+func TestResponseTransformer(t *testing.T) {
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"v1"`)
+		if r.URL.Query().Get("text") != "" {
+			w.Write([]byte("not json"))
+			return
+		}
+		w.Write([]byte(`{"name":"ann","password":"secret","token":"t0k3n"}`))
+	})
+	options := []Option{
+		WithResponseTransformer("redactPassword", func(resp *http.Response, body []byte) ([]byte, error) {
+			return redactField("password")(body)
+		}),
+		WithResponseTransformer("redactToken", func(resp *http.Response, body []byte) ([]byte, error) {
+			return redactField("token")(body)
+		}),
+	}
+	tests := []struct {
+		name         string
+		transformers []string
+		target       string
+		status       int
+		expected     string
+	}{
+		{name: "redact field", transformers: []string{"redactPassword"}, target: "/svc/get", status: http.StatusOK, expected: `{"name":"ann","token":"t0k3n"}`},
+		{name: "chained", transformers: []string{"redactPassword", "redactToken"}, target: "/svc/get", status: http.StatusOK, expected: `{"name":"ann"}`},
+		{name: "transform failed", transformers: []string{"redactPassword"}, target: "/svc/get?text=1", status: http.StatusBadGateway},
+		{name: "not registered", transformers: []string{"missing"}, target: "/svc/get", status: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t, options...)
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get", ResponseTransformers: tt.transformers,
+			}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if strings.Contains(rec.Body.String(), "secret") {
+				t.Errorf("redacted field reached the client: %s", rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if rec.Body.String() != tt.expected {
+				t.Errorf("body %s, want %s", rec.Body.String(), tt.expected)
+			}
+			if length := rec.Header().Get("Content-Length"); length != strconv.Itoa(len(tt.expected)) {
+				t.Errorf("Content-Length %q, want %d", length, len(tt.expected))
+			}
+			if etag := rec.Header().Get("Etag"); etag != "" {
+				t.Errorf("backend Etag %v kept on the transformed body", etag)
+			}
+		})
+	}
+}
+
+func TestRequestTransformer(t *testing.T) {
+	options := []Option{
+		WithRequestTransformer("redactPassword", func(r *http.Request, body []byte) ([]byte, error) {
+			return redactField("password")(body)
+		}),
+		WithRequestTransformer("reject", func(r *http.Request, body []byte) ([]byte, error) {
+			return nil, errors.New("rejected")
+		}),
+	}
+	tests := []struct {
+		name         string
+		transformers []string
+		body         string
+		status       int
+		expected     string // body received by the backend, empty when not proxied
+	}{
+		{name: "redact field", transformers: []string{"redactPassword"}, body: `{"name":"ann","password":"secret"}`, status: http.StatusOK, expected: `{"name":"ann"}`},
+		{name: "transform failed", transformers: []string{"redactPassword"}, body: "not json", status: http.StatusBadRequest},
+		{name: "rejected", transformers: []string{"reject"}, body: `{}`, status: http.StatusBadRequest},
+		{name: "not registered", transformers: []string{"missing"}, body: `{}`, status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 1)
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				received <- fmt.Sprintf("%d %s", r.ContentLength, body)
+			})
+			gateway := newTestGateway(t, options...)
+			mustCreateService(t, gateway, newTestService("svc", &API{
+				Name: "create", HTTPMethod: http.MethodPost, Host: backend, Path: "create", RequestTransformers: tt.transformers,
+			}))
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodPost, "/svc/create", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			select {
+			case got := <-received:
+				if want := fmt.Sprintf("%d %s", len(tt.expected), tt.expected); tt.expected == "" || got != want {
+					t.Errorf("backend got %q, want %q", got, want)
+				}
+			default:
+				if tt.expected != "" {
+					t.Errorf("request not proxied")
+				}
+			}
+		})
+	}
+}
+
+func TestTransformersRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		api  *API
+	}{
+		{name: "streaming api", api: &API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get", Streaming: true, ResponseTransformers: []string{"redact"}}},
+		{name: "empty request transformer", api: &API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get", RequestTransformers: []string{""}}},
+		{name: "empty response transformer", api: &API{Name: "get", HTTPMethod: http.MethodGet, Host: "127.0.0.1:1", Path: "get", ResponseTransformers: []string{""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := newTestGateway(t).Discovery.CreateService(newTestService("svc", tt.api)); err == nil {
+				t.Errorf("api accepted")
+			}
+		})
+	}
+}
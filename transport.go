@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// Defaults of the connection pool to backends
+const (
+	DefaultMaxIdleConns        = 256
+	DefaultMaxIdleConnsPerHost = 64
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// backendTransport return the transport reaching backends of services without their own,
+// built from the idle connection settings on first use unless Transport is set
+func (gateway *APIGateway) backendTransport() http.RoundTripper {
+	gateway.transportOnce.Do(func() {
+		if gateway.Transport != nil {
+			gateway.transport = gateway.Transport
+			return
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = gateway.MaxIdleConns
+		transport.MaxIdleConnsPerHost = gateway.MaxIdleConnsPerHost
+		transport.IdleConnTimeout = gateway.IdleConnTimeout
+		gateway.transport = transport
+	})
+	return gateway.transport
+}
+
+// reverseProxy return the proxy shared by requests of rt, streaming routes flush every
+// write while the others let the proxy buffer
+func (gateway *APIGateway) reverseProxy(rt *route) *httputil.ReverseProxy {
+	gateway.proxyOnce.Do(func() {
+		gateway.proxy = &httputil.ReverseProxy{
+			Director:       gateway.director,
+			Transport:      &retryTransport{next: &serviceTransport{base: gateway.backendTransport()}, gateway: gateway},
+			ErrorHandler:   gateway.proxyError,
+			ModifyResponse: gateway.modifyResponse,
+		}
+		streamingProxy := *gateway.proxy
+		streamingProxy.FlushInterval = -1
+		gateway.streamingProxy = &streamingProxy
+	})
+	if rt.streaming() {
+		return gateway.streamingProxy
+	}
+	return gateway.proxy
+}
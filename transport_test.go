@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTransport count the round trips passed on to next
+type countingTransport struct {
+	next  http.RoundTripper
+	calls int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.next.RoundTrip(r)
+}
+
+func TestBackendTransport(t *testing.T) {
+	backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	tests := []struct {
+		name      string
+		configure func(gateway *APIGateway) *countingTransport
+		idle      int
+		perHost   int
+		timeout   time.Duration
+	}{
+		{
+			name:      "defaults",
+			configure: func(*APIGateway) *countingTransport { return nil },
+			idle:      DefaultMaxIdleConns,
+			perHost:   DefaultMaxIdleConnsPerHost,
+			timeout:   DefaultIdleConnTimeout,
+		},
+		{
+			name: "tuned pool",
+			configure: func(gateway *APIGateway) *countingTransport {
+				gateway.MaxIdleConns, gateway.MaxIdleConnsPerHost, gateway.IdleConnTimeout = 10, 2, time.Second
+				return nil
+			},
+			idle:    10,
+			perHost: 2,
+			timeout: time.Second,
+		},
+		{
+			name: "custom transport",
+			configure: func(gateway *APIGateway) *countingTransport {
+				transport := &countingTransport{next: http.DefaultTransport}
+				gateway.Transport = transport
+				return transport
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			custom := tt.configure(gateway)
+			mustCreateService(t, gateway, newTestService("svc",
+				&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend, Path: "get"}))
+			for i := 0; i < 3; i++ {
+				if rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/get", nil)); rec.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+				}
+			}
+			if custom != nil {
+				if calls := atomic.LoadInt32(&custom.calls); calls != 3 {
+					t.Errorf("custom transport got %d round trips, want 3", calls)
+				}
+				return
+			}
+			transport, ok := gateway.backendTransport().(*http.Transport)
+			if !ok {
+				t.Fatalf("transport %T, want *http.Transport", gateway.backendTransport())
+			}
+			if transport == http.DefaultTransport {
+				t.Errorf("default transport shared")
+			}
+			if transport.MaxIdleConns != tt.idle || transport.MaxIdleConnsPerHost != tt.perHost || transport.IdleConnTimeout != tt.timeout {
+				t.Errorf("pool %d/%d/%v, want %d/%d/%v", transport.MaxIdleConns, transport.MaxIdleConnsPerHost,
+					transport.IdleConnTimeout, tt.idle, tt.perHost, tt.timeout)
+			}
+		})
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }))
+	defer backend.Close()
+	gateway := NewAPIGateWay(WithLogger(discardLogger), WithDiscovery(NewCacheDiscovery(WithCacheLogger(discardLogger))))
+	if err := gateway.Discovery.CreateService(newTestService("svc",
+		&API{Name: "get", HTTPMethod: http.MethodGet, Host: backend.Listener.Addr().String(), Path: "get"})); err != nil {
+		b.Fatalf("create service: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		gateway.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/svc/get", nil))
+		if rec.Code != http.StatusOK {
+			b.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestReverseProxyShared(t *testing.T) {
+	gateway := newTestGateway(t)
+	tests := []struct {
+		name      string
+		first     *route
+		second    *route
+		same      bool
+		streaming bool
+	}{
+		{name: "buffered routes", first: &route{api: &API{}}, second: &route{api: &API{}}, same: true},
+		{name: "streaming routes", first: &route{api: &API{Streaming: true}}, second: &route{api: &API{}, upgrade: true}, same: true, streaming: true},
+		{name: "buffered and streaming", first: &route{api: &API{}}, second: &route{api: &API{}, eventStream: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := gateway.reverseProxy(tt.first), gateway.reverseProxy(tt.second)
+			if (first == second) != tt.same {
+				t.Errorf("proxies shared %v, want %v", first == second, tt.same)
+			}
+			if streaming := first.FlushInterval < 0; streaming != tt.streaming {
+				t.Errorf("first proxy flushes every write %v, want %v", streaming, tt.streaming)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// UpstreamTLS define the tls client config used to reach backends of a service
+type UpstreamTLS struct {
+	CertFile   string `json:"certFile,omitempty"`   // client certificate presented to backends
+	KeyFile    string `json:"keyFile,omitempty"`    // private key of client certificate
+	CAFile     string `json:"caFile,omitempty"`     // CA verifying backend certificates, default system roots
+	ServerName string `json:"serverName,omitempty"` // override server name verified on backend certificate
+}
+
+// newTransport build dedicated transport of service backends, certificate reloads are
+// logged to logger
+func (config *UpstreamTLS) newTransport(logger Logger) (*http.Transport, error) {
+	tlsConfig := &tls.Config{ServerName: config.ServerName}
+	if config.CertFile != "" || config.KeyFile != "" {
+		reloader, err := NewCertReloader(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate failed: %v", err)
+		}
+		reloader.Logger = logger
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// loadCertPool read the PEM certificates of a CA file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read ca file failed: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ca file: %v has no certificate", file)
+	}
+	return pool, nil
+}
+
+// serviceTransport dispatch upstream request to the dedicated transport of its service
+type serviceTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *serviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt := routeOf(req.Context()); rt != nil && rt.service.transport != nil {
+		return rt.service.transport.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
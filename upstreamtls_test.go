@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamClientCertificate(t *testing.T) {
+	ca := newTestCA(t, "backend ca")
+	other := newTestCA(t, "other ca")
+	dir := tempDir(t)
+	serverCert, _, _ := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "backend"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}})
+	_, clientCert, clientKey := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}})
+	_, otherCert, otherKey := other.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}})
+	caFile := writeTestFile(t, dir, "ca.crt", ca.pem)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	tests := []struct {
+		name   string
+		config *UpstreamTLS
+		status int
+		body   string
+	}{
+		{
+			name:   "client certificate presented",
+			config: &UpstreamTLS{CertFile: writeTestFile(t, dir, "client.crt", clientCert), KeyFile: writeTestFile(t, dir, "client.key", clientKey), CAFile: caFile},
+			status: http.StatusOK,
+			body:   "gateway",
+		},
+		{name: "no client certificate", config: &UpstreamTLS{CAFile: caFile}, status: http.StatusBadGateway},
+		{
+			name:   "certificate of another ca",
+			config: &UpstreamTLS{CertFile: writeTestFile(t, dir, "other.crt", otherCert), KeyFile: writeTestFile(t, dir, "other.key", otherKey), CAFile: caFile},
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "backend not trusted",
+			config: &UpstreamTLS{CertFile: writeTestFile(t, dir, "client2.crt", clientCert), KeyFile: writeTestFile(t, dir, "client2.key", clientKey)},
+			status: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			service := newTestService("svc", &API{
+				Name: "whoami", Protocol: "https", HTTPMethod: http.MethodGet, Host: backend.Listener.Addr().String(), Path: "whoami",
+			})
+			service.UpstreamTLS = tt.config
+			mustCreateService(t, gateway, service)
+			rec := serveProxy(gateway, httptest.NewRequest(http.MethodGet, "/svc/whoami", nil))
+			if rec.Code != tt.status || (tt.body != "" && rec.Body.String() != tt.body) {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestUpstreamTLSRejected(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		name   string
+		config *UpstreamTLS
+	}{
+		{name: "missing certificate", config: &UpstreamTLS{CertFile: "/nonexistent/client.crt", KeyFile: "/nonexistent/client.key"}},
+		{name: "key without certificate", config: &UpstreamTLS{KeyFile: writeTestFile(t, dir, "client.key", []byte("key"))}},
+		{name: "ca without certificates", config: &UpstreamTLS{CAFile: writeTestFile(t, dir, "ca.crt", []byte("not pem"))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t)
+			service := newTestService("svc")
+			service.UpstreamTLS = tt.config
+			if err := gateway.Discovery.CreateService(service); err == nil {
+				t.Errorf("invalid upstream tls accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// user agent modes supported by UserAgent.Mode
+const (
+	// UserAgentSet replace client User-Agent with the gateway one
+	UserAgentSet = "set"
+	// UserAgentAppend append the gateway User-Agent to the client one
+	UserAgentAppend = "append"
+)
+
+// UserAgent define the User-Agent sent on upstream requests
+type UserAgent struct {
+	Value          string `json:"value"`                    // gateway user agent, e.g. go-gateway/1.2
+	Mode           string `json:"mode,omitempty"`           // set or append, default set
+	OriginalHeader string `json:"originalHeader,omitempty"` // header keeping the client User-Agent, empty not keep
+}
+
+// validate check user agent config
+func (ua *UserAgent) validate() error {
+	switch ua.Mode {
+	case "", UserAgentSet, UserAgentAppend:
+	default:
+		return fmt.Errorf("user agent mode: %v unsupported, should be set or append", ua.Mode)
+	}
+	return nil
+}
+
+// apply rewrite User-Agent of the upstream request header
+func (ua *UserAgent) apply(header http.Header) {
+	if ua == nil || ua.Value == "" {
+		return
+	}
+	original := header.Get("User-Agent")
+	if ua.OriginalHeader != "" && original != "" {
+		header.Set(ua.OriginalHeader, original)
+	}
+	if ua.Mode == UserAgentAppend && original != "" {
+		header.Set("User-Agent", original+" "+ua.Value)
+		return
+	}
+	header.Set("User-Agent", ua.Value)
+}
@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamUserAgent(t *testing.T) {
+	tests := []struct {
+		name         string
+		gateway      *UserAgent
+		service      *UserAgent
+		client       string
+		wantAgent    string
+		wantOriginal string
+	}{
+		{name: "untouched", client: "curl/7.64.1", wantAgent: "curl/7.64.1"},
+		{name: "set", gateway: &UserAgent{Value: "go-gateway/1.2"}, client: "curl/7.64.1", wantAgent: "go-gateway/1.2"},
+		{name: "set without client agent", gateway: &UserAgent{Value: "go-gateway/1.2"}, wantAgent: "go-gateway/1.2"},
+		{
+			name:      "append",
+			gateway:   &UserAgent{Value: "go-gateway/1.2", Mode: UserAgentAppend},
+			client:    "curl/7.64.1",
+			wantAgent: "curl/7.64.1 go-gateway/1.2",
+		},
+		{
+			name:      "append without client agent",
+			gateway:   &UserAgent{Value: "go-gateway/1.2", Mode: UserAgentAppend},
+			wantAgent: "go-gateway/1.2",
+		},
+		{
+			name:         "preserve original",
+			gateway:      &UserAgent{Value: "go-gateway/1.2", OriginalHeader: "X-Original-User-Agent"},
+			client:       "curl/7.64.1",
+			wantAgent:    "go-gateway/1.2",
+			wantOriginal: "curl/7.64.1",
+		},
+		{
+			name:      "service override",
+			gateway:   &UserAgent{Value: "go-gateway/1.2"},
+			service:   &UserAgent{Value: "billing/3", Mode: UserAgentAppend},
+			client:    "curl/7.64.1",
+			wantAgent: "curl/7.64.1 billing/3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%v|%v", r.Header.Get("User-Agent"), r.Header.Get("X-Original-User-Agent"))
+			})
+			gateway := newTestGateway(t)
+			gateway.UserAgent = tt.gateway
+			service := newTestService("svc", &API{Name: "ua", HTTPMethod: http.MethodGet, Host: backend, Path: "ua"})
+			service.UserAgent = tt.service
+			mustCreateService(t, gateway, service)
+			req := httptest.NewRequest(http.MethodGet, "/svc/ua", nil)
+			req.Header.Set("User-Agent", tt.client)
+			rec := serveProxy(gateway, req)
+			if want := tt.wantAgent + "|" + tt.wantOriginal; rec.Body.String() != want {
+				t.Errorf("backend got %q, want %q", rec.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestUserAgentModeRejected(t *testing.T) {
+	gateway := newTestGateway(t)
+	service := newTestService("svc")
+	service.UserAgent = &UserAgent{Value: "go-gateway/1.2", Mode: "prepend"}
+	if err := gateway.Discovery.CreateService(service); err == nil {
+		t.Errorf("unsupported mode accepted")
+	}
+}